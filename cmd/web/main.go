@@ -7,14 +7,21 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/DmitryZzz/bookings/internal/config"
 	"github.com/DmitryZzz/bookings/internal/driver"
+	"github.com/DmitryZzz/bookings/internal/emails"
 	"github.com/DmitryZzz/bookings/internal/handlers"
 	"github.com/DmitryZzz/bookings/internal/helpers"
+	"github.com/DmitryZzz/bookings/internal/icalsync"
+	"github.com/DmitryZzz/bookings/internal/mailer"
 	"github.com/DmitryZzz/bookings/internal/models"
 	"github.com/DmitryZzz/bookings/internal/render"
+	"github.com/DmitryZzz/bookings/internal/repository/dbrepo"
+	"github.com/DmitryZzz/bookings/internal/scheduler"
+	"github.com/DmitryZzz/bookings/internal/webhooks"
 	"github.com/alexedwards/scs/v2"
 )
 
@@ -22,8 +29,15 @@ const portNumber = ":8080"
 
 var app config.AppConfig
 var session *scs.SessionManager
+var guestSession *scs.SessionManager
 var infoLog *log.Logger
 var errorLog *log.Logger
+var icalSyncInterval time.Duration
+var webhookInterval time.Duration
+var mailWorkers int
+var mailConfig mailer.Config
+var mailInterval time.Duration
+var scheduleInterval time.Duration
 
 func main() {
 	db, err := run()
@@ -32,10 +46,24 @@ func main() {
 	}
 	defer db.SQL.Close()
 
-	defer close(app.MailChan)
+	repo := dbrepo.NewPostgresRepo(db.SQL, &app)
 
-	fmt.Println("Starting mail listener...")
-	listenForMail()
+	mailTransport, err := mailer.NewMailer(mailConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println("Starting mail workers...")
+	mailer.New(&app, repo, mailTransport, mailWorkers).Start(mailInterval)
+
+	fmt.Println("Starting guest message scheduler...")
+	scheduler.New(&app, repo).Start(scheduleInterval)
+
+	fmt.Println("Starting calendar import...")
+	icalsync.New(&app, repo).Start(icalSyncInterval)
+
+	fmt.Println("Starting webhook dispatcher...")
+	webhooks.New(&app, repo).Start(webhookInterval)
 
 	fmt.Println("Starting application on port", portNumber)
 	srv := &http.Server{
@@ -65,19 +93,37 @@ func run() (*driver.DB, error) {
 	dbPass := flag.String("dbpass", "", "Database password")
 	dbPort := flag.String("dbport", "5432", "Database port")
 	dbSSL := flag.String("dbssl", "disable", "Database ssl settings (disable, prefer, require")
+	secret := flag.String("secret", "", "Secret key used to sign calendar feed and other links")
+	siteURL := flag.String("siteurl", "http://localhost:8080", "Public address of the site, used in links in email")
+	mailFrom := flag.String("mailfrom", "me@here.com", "Address the site's mail is sent from")
+	uploadDir := flag.String("uploads", "./uploads", "Directory to store uploaded room photos in")
+	flag.DurationVar(&icalSyncInterval, "icalinterval", 15*time.Minute, "How often to import external calendars")
+	flag.DurationVar(&webhookInterval, "webhookinterval", 10*time.Second, "How often to send queued webhooks")
+	flag.IntVar(&mailWorkers, "mailworkers", 2, "Number of workers sending mail from the outbox")
+	flag.DurationVar(&mailInterval, "mailinterval", 5*time.Second, "How often to check the mail outbox")
+	flag.DurationVar(&scheduleInterval, "scheduleinterval", 15*time.Minute, "How often to check for scheduled guest messages")
+	flag.StringVar(&mailConfig.Backend, "mailer", "smtp", "Mail backend (smtp, sendmail, maildir, memory)")
+	flag.StringVar(&mailConfig.Host, "smtphost", "localhost", "SMTP host")
+	flag.IntVar(&mailConfig.Port, "smtpport", 1025, "SMTP port")
+	flag.StringVar(&mailConfig.Username, "smtpuser", "", "SMTP username")
+	flag.StringVar(&mailConfig.Password, "smtppass", "", "SMTP password")
+	flag.StringVar(&mailConfig.Encryption, "smtpencryption", "none", "SMTP encryption (none, starttls, tls)")
+	flag.StringVar(&mailConfig.SendmailPath, "sendmail", "/usr/sbin/sendmail", "Path to the sendmail binary")
+	flag.StringVar(&mailConfig.MaildirPath, "maildir", "./tmp/maildir", "Maildir to write mail to")
 
 	flag.Parse()
 
-	if *dbName == "" || *dbUser == "" {
+	if *dbName == "" || *dbUser == "" || *secret == "" {
 		fmt.Println("Missing reqired flags")
 		os.Exit(1)
 	}
 
-	mailChan := make(chan models.MailData)
-	app.MailChan = mailChan
-
 	app.InProduction = *inProduction
 	app.UseCache = *useCache
+	app.Secret = *secret
+	app.SiteURL = strings.TrimRight(*siteURL, "/")
+	app.MailFrom = *mailFrom
+	app.UploadDir = *uploadDir
 
 	infoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	app.InfoLog = infoLog
@@ -93,6 +139,15 @@ func run() (*driver.DB, error) {
 
 	app.Session = session
 
+	guestSession = scs.New()
+	guestSession.Lifetime = 24 * time.Hour
+	guestSession.Cookie.Name = "guest_session"
+	guestSession.Cookie.Persist = true
+	guestSession.Cookie.SameSite = http.SameSiteLaxMode
+	guestSession.Cookie.Secure = app.InProduction
+
+	app.GuestSession = guestSession
+
 	// connect to database
 	log.Println("Connecting to database...")
 	connectionString := fmt.Sprintf("host=%s port=%s dbname=%s user=%s password=%s sslmode=%s", *dbHost, *dbPort, *dbName, *dbUser, *dbPass, *dbSSL)
@@ -109,9 +164,17 @@ func run() (*driver.DB, error) {
 	}
 	app.TemplateCache = tc
 
+	etc, err := emails.CreateTemplateCache()
+	if err != nil {
+		log.Fatal("Cannot create email template cache:", err.Error())
+		return nil, err
+	}
+	app.EmailTemplateCache = etc
+
 	repo := handlers.NewRepo(&app, db)
 	handlers.NewHandlers(repo)
 	render.NewRenderer(&app)
+	emails.NewRenderer(&app, repo.DB)
 	helpers.NewHelpers(&app)
 
 	return db, nil
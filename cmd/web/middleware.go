@@ -2,7 +2,9 @@ package main
 
 import (
 	"net/http"
+	"regexp"
 
+	"github.com/DmitryZzz/bookings/internal/handlers"
 	"github.com/DmitryZzz/bookings/internal/helpers"
 	"github.com/justinas/nosurf"
 )
@@ -18,6 +20,33 @@ func NoSurf(next http.Handler) http.Handler {
 	return csrfHandler
 }
 
+// photoUploadPath matches the address photos of a room are uploaded to
+var photoUploadPath = regexp.MustCompile(`^/admin/rooms/[0-9]+/photos$`)
+
+// LimitUploads limits the size of photo uploads and parses them with a small
+// memory budget. It has to run before NoSurf, which reads the whole form to
+// check the CSRF token.
+func LimitUploads(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !photoUploadPath.MatchString(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.ContentLength > handlers.MaxPhotoUploadSize {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, handlers.MaxPhotoUploadSize)
+		err := r.ParseMultipartForm(handlers.PhotoUploadMemory)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 // SessionLoad loads and saves the session on every request
 func SessionLoad(next http.Handler) http.Handler {
 	return session.LoadAndSave(next)
@@ -32,3 +61,23 @@ func Auth(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// GuestSessionLoad loads and saves the guest session, which holds a guest's
+// account login apart from the staff session
+func GuestSessionLoad(next http.Handler) http.Handler {
+	return guestSession.LoadAndSave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(helpers.WithGuestSession(r.Context())))
+	}))
+}
+
+// GuestAuth sends guests who aren't logged in to their account to the guest login
+func GuestAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !helpers.IsGuestAuthenticated(r) {
+			session.Put(r.Context(), "error", "Log in to your account first")
+			http.Redirect(w, r, "/account/login", http.StatusSeeOther)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"mime/multipart"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/DmitryZzz/bookings/internal/handlers"
 )
 
 func TestNoSurf(t *testing.T) {
@@ -30,4 +36,65 @@ func TestSessionLoad(t *testing.T) {
 	default:
 		t.Error(fmt.Sprintf("type is not http.Handler, but is %T", v))
 	}
-}
\ No newline at end of file
+}
+func TestGuestSessionLoad(t *testing.T) {
+	var myH myHandler
+
+	h := GuestSessionLoad(&myH)
+
+	switch v := h.(type) {
+	case http.Handler:
+		// do nothing
+	default:
+		t.Error(fmt.Sprintf("type is not http.Handler, but is %T", v))
+	}
+}
+
+func TestLimitUploads(t *testing.T) {
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+	_ = mw.WriteField("csrf_token", "x")
+	mw.Close()
+
+	tests := []struct {
+		name     string
+		path     string
+		size     int64
+		parsed   bool
+		expected int
+	}{
+		{"upload", "/admin/rooms/1/photos", int64(body.Len()), true, http.StatusOK},
+		{"too-large", "/admin/rooms/1/photos", handlers.MaxPhotoUploadSize + 1, false, http.StatusRequestEntityTooLarge},
+		{"other-page", "/admin/rooms/1", handlers.MaxPhotoUploadSize + 1, false, http.StatusOK},
+	}
+
+	for _, e := range tests {
+		var parsed bool
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			parsed = r.MultipartForm != nil
+		})
+
+		req := httptest.NewRequest("POST", e.path, bytes.NewReader(body.Bytes()))
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		req.ContentLength = e.size
+		rr := httptest.NewRecorder()
+		LimitUploads(next).ServeHTTP(rr, req)
+
+		if rr.Code != e.expected {
+			t.Errorf("%s: got code %d, wanted %d", e.name, rr.Code, e.expected)
+		}
+		if parsed != e.parsed {
+			t.Errorf("%s: got parsed %t, wanted %t", e.name, parsed, e.parsed)
+		}
+	}
+
+	// a body longer than it claims is cut off at the limit
+	req := httptest.NewRequest("POST", "/admin/rooms/1/photos", strings.NewReader(strings.Repeat("x", int(handlers.MaxPhotoUploadSize)+1)))
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.ContentLength = -1
+	rr := httptest.NewRecorder()
+	LimitUploads(&myHandler{}).ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("unlimited body: got code %d, wanted %d", rr.Code, http.StatusBadRequest)
+	}
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/DmitryZzz/bookings/internal/config"
 	"github.com/DmitryZzz/bookings/internal/handlers"
+	"github.com/DmitryZzz/bookings/internal/photos"
 	"github.com/go-chi/chi/v5"
 )
 
@@ -12,13 +13,18 @@ func routes(app *config.AppConfig) http.Handler {
 
 	mux := chi.NewRouter()
 
+	mux.Use(LimitUploads)
 	mux.Use(NoSurf)
 	mux.Use(SessionLoad)
 
 	mux.Get("/", handlers.Repo.Home)
 	mux.Get("/about", handlers.Repo.About)
-	mux.Get("/generals-quarters", handlers.Repo.Generals)
-	mux.Get("/majors-suite", handlers.Repo.Majors)
+	mux.Get("/rooms", handlers.Repo.Rooms)
+	mux.Get("/rooms/{slug}", handlers.Repo.Room)
+	mux.Get("/rooms/{slug}/reviews.json", handlers.Repo.RoomReviewsJSON)
+	// the rooms had hand-written pages at these addresses
+	mux.Handle("/generals-quarters", http.RedirectHandler("/rooms/generals-quarters", http.StatusMovedPermanently))
+	mux.Handle("/majors-suite", http.RedirectHandler("/rooms/majors-suite", http.StatusMovedPermanently))
 
 	mux.Get("/search-availability", handlers.Repo.Availability)
 	mux.Post("/search-availability", handlers.Repo.PostAvailability)
@@ -27,18 +33,55 @@ func routes(app *config.AppConfig) http.Handler {
 	mux.Get("/book-room", handlers.Repo.BookRoom)
 
 	mux.Get("/contact", handlers.Repo.Contact)
+	mux.Post("/contact", handlers.Repo.PostContact)
 
-	mux.Get("/make-reservation", handlers.Repo.Reservation)
-	mux.Post("/make-reservation", handlers.Repo.PostReservation)
 	mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
 
 	mux.Get("/user/login", handlers.Repo.ShowLogin)
 	mux.Post("/user/login", handlers.Repo.PostShowLogin)
+	mux.Post("/user/login/link", handlers.Repo.PostLoginLink)
+	mux.Get("/user/login/link/{id}", handlers.Repo.LoginWithLink)
 	mux.Get("/user/logout", handlers.Repo.Logout)
 
+	// guests' account pages, and the booking form that fills in their details
+	// and books on their account, have the guest session
+	mux.Group(func(mux chi.Router) {
+		mux.Use(GuestSessionLoad)
+
+		mux.Get("/make-reservation", handlers.Repo.Reservation)
+		mux.Post("/make-reservation", handlers.Repo.PostReservation)
+
+		mux.Get("/account/register", handlers.Repo.AccountRegister)
+		mux.Post("/account/register", handlers.Repo.PostAccountRegister)
+		mux.Get("/account/verify/{id}", handlers.Repo.AccountVerify)
+		mux.Get("/account/login", handlers.Repo.AccountLogin)
+		mux.Post("/account/login", handlers.Repo.PostAccountLogin)
+		mux.Post("/account/login/link", handlers.Repo.PostAccountLoginLink)
+		mux.Get("/account/login/link/{id}", handlers.Repo.AccountLoginWithLink)
+		mux.Get("/account/logout", handlers.Repo.AccountLogout)
+
+		mux.Group(func(mux chi.Router) {
+			mux.Use(GuestAuth)
+			mux.Get("/account", handlers.Repo.Account)
+			mux.Post("/account/details", handlers.Repo.PostAccountDetails)
+			mux.Get("/account/bookings/{id}", handlers.Repo.AccountBooking)
+			mux.Post("/account/bookings/{id}", handlers.Repo.PostAccountBooking)
+			mux.Post("/account/bookings/{id}/cancel", handlers.Repo.PostAccountCancelBooking)
+		})
+	})
+
+	mux.Get("/ical/property.ics", handlers.Repo.ICalPropertyFeed)
+	mux.Get("/ical/rooms/{id}.ics", handlers.Repo.ICalRoomFeed)
+	mux.Get("/ical/reservations/{id}.ics", handlers.Repo.ICalReservationFeed)
+
+	mux.Get("/reviews/{id}", handlers.Repo.Review)
+	mux.Post("/reviews/{id}", handlers.Repo.PostReview)
+
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
 
+	mux.Handle(photos.URLPrefix+"/*", http.StripPrefix(photos.URLPrefix, photos.FileServer(app.UploadDir)))
+
 	mux.Route("/admin", func(mux chi.Router) {
 		//mux.Use(Auth)
 		mux.Get("/dashboard", handlers.Repo.AdminDashboard)
@@ -47,11 +90,87 @@ func routes(app *config.AppConfig) http.Handler {
 		mux.Get("/reservations-all", handlers.Repo.AdminAllReservations)
 		mux.Get("/reservations-calendar", handlers.Repo.AdminReservationsCalendar)
 		mux.Post("/reservations-calendar", handlers.Repo.AdminPostReservationsCalendar)
+		mux.Get("/api/calendar", handlers.Repo.AdminCalendarJSON)
+		mux.Get("/timeline", handlers.Repo.AdminTimeline)
+		mux.Post("/timeline/move", handlers.Repo.AdminPostTimelineMove)
 		mux.Get("/process-reservation/{src}/{id}/do", handlers.Repo.AdminProcessReservation)
 		mux.Get("/delete-reservation/{src}/{id}/do", handlers.Repo.AdminDeleteReservation)
 
 		mux.Get("/reservations/{src}/{id}/show", handlers.Repo.AdminShowReservation)
 		mux.Post("/reservations/{src}/{id}", handlers.Repo.AdminPostShowReservation)
+		mux.Post("/reservations/{src}/{id}/tags", handlers.Repo.AdminPostReservationTags)
+		mux.Post("/reservations/{src}/{id}/notes", handlers.Repo.AdminPostReservationNote)
+		mux.Get("/reservations/{src}/{id}/notes/{noteID}/delete", handlers.Repo.AdminDeleteReservationNote)
+
+		mux.Get("/calendar-feeds", handlers.Repo.AdminCalendarFeeds)
+		mux.Post("/calendar-feeds/reset", handlers.Repo.AdminPostResetCalendarFeed)
+		mux.Get("/ical-feeds", handlers.Repo.AdminICalFeeds)
+		mux.Post("/ical-feeds", handlers.Repo.AdminPostICalFeed)
+		mux.Get("/ical-feeds/{id}/delete", handlers.Repo.AdminDeleteICalFeed)
+
+		mux.Get("/webhooks", handlers.Repo.AdminWebhooks)
+		mux.Post("/webhooks", handlers.Repo.AdminPostWebhook)
+		mux.Get("/webhooks/{id}/delete", handlers.Repo.AdminDeleteWebhook)
+		mux.Get("/webhooks/deliveries", handlers.Repo.AdminWebhookDeliveries)
+		mux.Get("/webhooks/deliveries/{id}/retry", handlers.Repo.AdminRetryWebhookDelivery)
+
+		mux.Get("/mail", handlers.Repo.AdminMail)
+		mux.Get("/mail/{id}/resend", handlers.Repo.AdminResendMail)
+
+		mux.Get("/email-templates", handlers.Repo.AdminEmailTemplates)
+		mux.Get("/email-templates/{name}", handlers.Repo.AdminEmailTemplate)
+		mux.Post("/email-templates/{name}", handlers.Repo.AdminPostEmailTemplate)
+		mux.Post("/email-templates/{name}/preview", handlers.Repo.AdminPreviewEmailTemplate)
+		mux.Post("/email-templates/{name}/test", handlers.Repo.AdminTestEmailTemplate)
+		mux.Get("/email-templates/{name}/reset", handlers.Repo.AdminResetEmailTemplate)
+
+		mux.Get("/guest-messages", handlers.Repo.AdminGuestMessages)
+		mux.Post("/guest-messages", handlers.Repo.AdminPostGuestMessage)
+		mux.Get("/guest-messages/{id}/pause", handlers.Repo.AdminPauseGuestMessage)
+		mux.Get("/guest-messages/{id}/resume", handlers.Repo.AdminResumeGuestMessage)
+		mux.Get("/guest-messages/{id}/delete", handlers.Repo.AdminDeleteGuestMessage)
+
+		mux.Get("/rooms", handlers.Repo.AdminRooms)
+		mux.Get("/rooms/{id}", handlers.Repo.AdminRoom)
+		mux.Post("/rooms/{id}", handlers.Repo.AdminPostRoom)
+		mux.Get("/rooms/{id}/delete", handlers.Repo.AdminDeleteRoom)
+		mux.Post("/rooms/{id}/photos", handlers.Repo.AdminPostRoomPhotos)
+		mux.Post("/rooms/{id}/photos/order", handlers.Repo.AdminOrderRoomPhotos)
+		mux.Get("/rooms/{id}/photos/{photoID}/delete", handlers.Repo.AdminDeleteRoomPhoto)
+
+		mux.Get("/reviews", handlers.Repo.AdminReviews)
+		mux.Get("/reviews/{id}/approve", handlers.Repo.AdminApproveReview)
+		mux.Get("/reviews/{id}/hide", handlers.Repo.AdminHideReview)
+		mux.Post("/reviews/{id}/reply", handlers.Repo.AdminReplyReview)
+
+		mux.Get("/enquiries", handlers.Repo.AdminEnquiries)
+		mux.Get("/enquiries/{id}", handlers.Repo.AdminEnquiry)
+		mux.Post("/enquiries/{id}/reply", handlers.Repo.AdminReplyEnquiry)
+		mux.Post("/enquiries/{id}/link", handlers.Repo.AdminLinkEnquiry)
+		mux.Get("/enquiries/{id}/archive", handlers.Repo.AdminArchiveEnquiry)
+		mux.Get("/enquiries/{id}/unarchive", handlers.Repo.AdminUnarchiveEnquiry)
+
+		mux.Get("/guests", handlers.Repo.AdminGuests)
+		mux.Get("/guests/{id}", handlers.Repo.AdminGuest)
+		mux.Post("/guests/{id}", handlers.Repo.AdminPostGuest)
+		mux.Post("/guests/{id}/merge", handlers.Repo.AdminMergeGuests)
+
+		mux.Get("/blocks", handlers.Repo.AdminBlocks)
+		mux.Get("/blocks/{id}", handlers.Repo.AdminBlock)
+		mux.Post("/blocks/{id}", handlers.Repo.AdminPostBlock)
+		mux.Get("/blocks/{id}/delete", handlers.Repo.AdminDeleteBlock)
+
+		mux.Get("/restrictions", handlers.Repo.AdminRestrictions)
+		mux.Get("/restrictions/{id}", handlers.Repo.AdminRestriction)
+		mux.Post("/restrictions/{id}", handlers.Repo.AdminPostRestriction)
+		mux.Get("/restrictions/{id}/delete", handlers.Repo.AdminDeleteRestriction)
+
+		mux.Get("/audit", handlers.Repo.AdminAuditLog)
+
+		mux.Get("/trash", handlers.Repo.AdminTrash)
+		mux.Get("/trash/reservations/{id}/restore", handlers.Repo.AdminRestoreReservation)
+		mux.Get("/trash/blocks/{id}/restore", handlers.Repo.AdminRestoreBlock)
+		mux.Get("/trash/nights/{id}/restore", handlers.Repo.AdminRestoreNight)
 	})
 	
 	return mux
@@ -15,6 +15,8 @@ require (
 	github.com/jackc/pgx/v4 v4.14.1
 	github.com/xhit/go-simple-mail/v2 v2.10.0
 	golang.org/x/crypto v0.0.0-20210817164053-32db794688a5
+	golang.org/x/image v0.0.0-20211028202545-6944b10bf410
+	golang.org/x/net v0.0.0-20211112202133-69e39bad7dc2
 )
 
 require (
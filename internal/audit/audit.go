@@ -0,0 +1,60 @@
+// Package audit writes entries to the audit log. Handlers record the changes
+// staff and guests make, and the background jobs, which import calendar feeds,
+// queue scheduled messages and send mail and webhook deliveries, record theirs
+// as the system.
+//
+// An entry is written after the change it records, in a statement of its own.
+// When it can't be written the change still stands and the failure is only
+// logged, so the log can miss changes made while the database was failing.
+// Mail and webhook deliveries are recorded when they are sent rather than when
+// they are queued, since queueing follows a change that is recorded already,
+// and the jobs' own bookkeeping, such as a feed's last sync status, isn't
+// recorded at all.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/DmitryZzz/bookings/internal/models"
+)
+
+// Writer is where audit entries are written
+type Writer interface {
+	InsertAuditEntry(e models.AuditEntry) (int, error)
+}
+
+// Record writes e with the record as json before and after the change. Either
+// can be nil.
+func Record(db Writer, e models.AuditEntry, before, after interface{}) error {
+	e.Before = JSON(before)
+	e.After = JSON(after)
+
+	_, err := db.InsertAuditEntry(e)
+	if err != nil {
+		return fmt.Errorf("can't write audit entry %s %s %d: %w", e.Action, e.Entity, e.EntityID, err)
+	}
+	return nil
+}
+
+// System records a change made by a background job rather than a person
+func System(db Writer, action, entity string, entityID int, before, after interface{}) error {
+	return Record(db, models.AuditEntry{
+		Actor:    models.ActorSystem,
+		Action:   action,
+		Entity:   entity,
+		EntityID: entityID,
+	}, before, after)
+}
+
+// JSON returns v as indented json, or "" for nil
+func JSON(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return ""
+	}
+	return string(out)
+}
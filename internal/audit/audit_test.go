@@ -0,0 +1,43 @@
+package audit
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/DmitryZzz/bookings/internal/models"
+)
+
+type fakeWriter struct {
+	entries []models.AuditEntry
+	err     error
+}
+
+func (f *fakeWriter) InsertAuditEntry(e models.AuditEntry) (int, error) {
+	f.entries = append(f.entries, e)
+	return len(f.entries), f.err
+}
+
+func TestSystem(t *testing.T) {
+	w := &fakeWriter{}
+	err := System(w, "import", models.AuditRoomRestriction, 4, nil, models.RoomRestriction{ID: 4, RoomID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := w.entries[0]
+	if e.Actor != models.ActorSystem || e.UserID != 0 || e.Action != "import" || e.EntityID != 4 {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	if e.Before != "" || !strings.Contains(e.After, `"RoomID": 1`) {
+		t.Errorf("unexpected before %q and after %q", e.Before, e.After)
+	}
+}
+
+func TestRecordFails(t *testing.T) {
+	w := &fakeWriter{err: errors.New("database is down")}
+	err := Record(w, models.AuditEntry{Action: "delete", Entity: models.AuditBlock, EntityID: 2}, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "delete block 2") {
+		t.Errorf("expected an error naming the entry, got %v", err)
+	}
+}
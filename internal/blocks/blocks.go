@@ -0,0 +1,177 @@
+package blocks
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DmitryZzz/bookings/internal/models"
+)
+
+// MaxNights is the longest range a block can span, so a typo in a year can't
+// create thousands of rows
+const MaxNights = 731
+
+// Range is the nights from Start up to, not including, End
+type Range struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Nights returns how many nights the range covers
+func (r Range) Nights() int {
+	return int(r.End.Sub(r.Start).Hours()/24 + 0.5)
+}
+
+// Conflict is part of a block that couldn't be blocked because a reservation
+// already has those nights
+type Conflict struct {
+	RoomID        int
+	ReservationID int
+	Range
+}
+
+// Ranges returns the nights a block covers: its whole range, or for a repeating
+// block each run of nights falling on one of its weekdays
+func Ranges(b models.Block) []Range {
+	if !b.Repeats() {
+		return []Range{{Start: b.StartDate, End: b.EndDate}}
+	}
+
+	days := make(map[time.Weekday]bool)
+	for _, d := range b.Weekdays {
+		days[d] = true
+	}
+
+	var ranges []Range
+	for d := b.StartDate; d.Before(b.EndDate); d = d.AddDate(0, 0, 1) {
+		if !days[d.Weekday()] {
+			continue
+		}
+		if n := len(ranges); n > 0 && ranges[n-1].End.Equal(d) {
+			// consecutive days, e.g. a weekend, are one range
+			ranges[n-1].End = d.AddDate(0, 0, 1)
+			continue
+		}
+		ranges = append(ranges, Range{Start: d, End: d.AddDate(0, 0, 1)})
+	}
+	return ranges
+}
+
+// Plan works out the room restrictions, of the block's restriction type, to save
+// for a block. booked holds the existing restrictions of each room over the
+// block's dates; nights that one of their reservations already has are left out
+// and returned as conflicts.
+func Plan(b models.Block, booked map[int][]models.RoomRestriction) ([]models.RoomRestriction, []Conflict) {
+	var restrictions []models.RoomRestriction
+	var conflicts []Conflict
+
+	for _, roomID := range b.RoomIDs {
+		for _, r := range Ranges(b) {
+			var free *Range
+			var taken *Conflict
+
+			for d := r.Start; d.Before(r.End); d = d.AddDate(0, 0, 1) {
+				next := d.AddDate(0, 0, 1)
+				resID := reservationOn(booked[roomID], d)
+
+				if resID == 0 {
+					if taken != nil {
+						conflicts = append(conflicts, *taken)
+						taken = nil
+					}
+					if free == nil {
+						free = &Range{Start: d}
+					}
+					free.End = next
+					continue
+				}
+
+				if free != nil {
+					restrictions = append(restrictions, restriction(b, roomID, *free))
+					free = nil
+				}
+				if taken != nil && taken.ReservationID != resID {
+					conflicts = append(conflicts, *taken)
+					taken = nil
+				}
+				if taken == nil {
+					taken = &Conflict{RoomID: roomID, ReservationID: resID, Range: Range{Start: d}}
+				}
+				taken.End = next
+			}
+
+			if free != nil {
+				restrictions = append(restrictions, restriction(b, roomID, *free))
+			}
+			if taken != nil {
+				conflicts = append(conflicts, *taken)
+			}
+		}
+	}
+
+	return restrictions, conflicts
+}
+
+// reservationOn returns the id of the reservation that has the night of day, or 0
+func reservationOn(restrictions []models.RoomRestriction, day time.Time) int {
+	for _, r := range restrictions {
+		if r.ReservationID > 0 && !day.Before(r.StartDate) && day.Before(r.EndDate) {
+			return r.ReservationID
+		}
+	}
+	return 0
+}
+
+func restriction(b models.Block, roomID int, r Range) models.RoomRestriction {
+	return models.RoomRestriction{
+		StartDate:     r.Start,
+		EndDate:       r.End,
+		RoomID:        roomID,
+		RestrictionID: b.RestrictionID,
+		BlockID:       b.ID,
+	}
+}
+
+// FormatWeekdays stores weekdays as their numbers, e.g. "1,3" for Monday and Wednesday
+func FormatWeekdays(days []time.Weekday) string {
+	var parts []string
+	for _, d := range days {
+		parts = append(parts, strconv.Itoa(int(d)))
+	}
+	return strings.Join(parts, ",")
+}
+
+// ParseWeekdays reads weekdays stored by FormatWeekdays, sorted and without repeats.
+// Anything that isn't a day of the week is skipped.
+func ParseWeekdays(s string) []time.Weekday {
+	seen := make(map[time.Weekday]bool)
+	var days []time.Weekday
+	for _, part := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || n < 0 || n > 6 || seen[time.Weekday(n)] {
+			continue
+		}
+		seen[time.Weekday(n)] = true
+		days = append(days, time.Weekday(n))
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i] < days[j] })
+	return days
+}
+
+// Describe says when a block applies, e.g. "every Monday and Friday"
+func Describe(b models.Block) string {
+	if !b.Repeats() {
+		return "every night"
+	}
+
+	var names []string
+	for _, d := range b.Weekdays {
+		names = append(names, d.String())
+	}
+	if len(names) == 1 {
+		return "every " + names[0]
+	}
+	return "every " + strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
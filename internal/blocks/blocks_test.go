@@ -0,0 +1,133 @@
+package blocks
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DmitryZzz/bookings/internal/models"
+)
+
+func day(d int) time.Time {
+	// November 2026 starts on a Sunday
+	return time.Date(2026, 11, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestRanges(t *testing.T) {
+	b := models.Block{StartDate: day(2), EndDate: day(6)}
+	got := Ranges(b)
+	if len(got) != 1 || !got[0].Start.Equal(day(2)) || !got[0].End.Equal(day(6)) || got[0].Nights() != 4 {
+		t.Errorf("unexpected ranges %v", got)
+	}
+
+	// every Monday in November
+	b = models.Block{StartDate: day(1), EndDate: day(30), Weekdays: []time.Weekday{time.Monday}}
+	got = Ranges(b)
+	if len(got) != 4 {
+		t.Fatalf("expected 4 Mondays, got %d", len(got))
+	}
+	for _, r := range got {
+		if r.Start.Weekday() != time.Monday || r.Nights() != 1 {
+			t.Errorf("unexpected range %v", r)
+		}
+	}
+
+	// weekends are one range each
+	b = models.Block{StartDate: day(1), EndDate: day(15), Weekdays: []time.Weekday{time.Saturday, time.Sunday}}
+	got = Ranges(b)
+	expected := []Range{
+		{day(1), day(2)},
+		{day(7), day(9)},
+		{day(14), day(15)},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, expected %v", got, expected)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	b := models.Block{ID: 7, RestrictionID: 4, StartDate: day(2), EndDate: day(10), RoomIDs: []int{1, 2}}
+	booked := map[int][]models.RoomRestriction{
+		1: {
+			// a reservation for the nights of the 4th and 5th
+			{StartDate: day(4), EndDate: day(6), RoomID: 1, ReservationID: 11, RestrictionID: 1},
+			// and one arriving the day the block ends
+			{StartDate: day(10), EndDate: day(12), RoomID: 1, ReservationID: 12, RestrictionID: 1},
+			// an older block doesn't conflict
+			{StartDate: day(7), EndDate: day(8), RoomID: 1, RestrictionID: 2},
+		},
+	}
+
+	restrictions, conflicts := Plan(b, booked)
+
+	type span struct {
+		room       int
+		start, end int
+	}
+	var got []span
+	for _, r := range restrictions {
+		if r.BlockID != 7 || r.RestrictionID != 4 {
+			t.Errorf("unexpected restriction %+v", r)
+		}
+		got = append(got, span{r.RoomID, r.StartDate.Day(), r.EndDate.Day()})
+	}
+	expected := []span{{1, 2, 4}, {1, 6, 10}, {2, 2, 10}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, expected %v", got, expected)
+	}
+
+	if len(conflicts) != 1 {
+		t.Fatalf("expected 1 conflict, got %v", conflicts)
+	}
+	c := conflicts[0]
+	if c.RoomID != 1 || c.ReservationID != 11 || !c.Start.Equal(day(4)) || !c.End.Equal(day(6)) {
+		t.Errorf("unexpected conflict %+v", c)
+	}
+}
+
+func TestPlanBackToBackReservations(t *testing.T) {
+	b := models.Block{StartDate: day(2), EndDate: day(6), RoomIDs: []int{1}}
+	booked := map[int][]models.RoomRestriction{
+		1: {
+			{StartDate: day(1), EndDate: day(3), RoomID: 1, ReservationID: 11},
+			{StartDate: day(3), EndDate: day(7), RoomID: 1, ReservationID: 12},
+		},
+	}
+
+	restrictions, conflicts := Plan(b, booked)
+	if len(restrictions) != 0 {
+		t.Errorf("expected nothing to block, got %v", restrictions)
+	}
+	if len(conflicts) != 2 || conflicts[0].ReservationID != 11 || conflicts[1].ReservationID != 12 {
+		t.Errorf("expected a conflict with each reservation, got %v", conflicts)
+	}
+}
+
+func TestWeekdays(t *testing.T) {
+	days := ParseWeekdays("5, 1,x,9,1")
+	if !reflect.DeepEqual(days, []time.Weekday{time.Monday, time.Friday}) {
+		t.Errorf("unexpected weekdays %v", days)
+	}
+	if s := FormatWeekdays(days); s != "1,5" {
+		t.Errorf("unexpected format %s", s)
+	}
+	if days := ParseWeekdays(""); len(days) != 0 {
+		t.Errorf("expected no weekdays, got %v", days)
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	tests := []struct {
+		days     []time.Weekday
+		expected string
+	}{
+		{nil, "every night"},
+		{[]time.Weekday{time.Monday}, "every Monday"},
+		{[]time.Weekday{time.Monday, time.Wednesday, time.Friday}, "every Monday, Wednesday and Friday"},
+	}
+	for _, tt := range tests {
+		if got := Describe(models.Block{Weekdays: tt.days}); got != tt.expected {
+			t.Errorf("got %q, expected %q", got, tt.expected)
+		}
+	}
+}
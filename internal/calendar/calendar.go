@@ -0,0 +1,171 @@
+package calendar
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"time"
+
+	"github.com/DmitryZzz/bookings/internal/models"
+)
+
+// DateLayout is the format of dates in a calendar
+const DateLayout = "2006-01-02"
+
+// MaxDays is the longest range a calendar can cover
+const MaxDays = 366
+
+// What a night in a room is taken by
+const (
+	KindFree        = "free"
+	KindReservation = "reservation"
+	KindExternal    = "external"
+	KindBlock       = "block"
+)
+
+// Calendar is the nights of every room over a range of dates
+type Calendar struct {
+	Start string `json:"start"`
+	// End is the day after the last night
+	End   string `json:"end"`
+	Types []Type `json:"restriction_types"`
+	Rooms []Row  `json:"rooms"`
+}
+
+// Type is a restriction type the nights can have
+type Type struct {
+	ID                 int    `json:"id"`
+	Name               string `json:"name"`
+	Code               string `json:"code,omitempty"`
+	Color              string `json:"color"`
+	BlocksAvailability bool   `json:"blocks_availability"`
+	CountsOccupied     bool   `json:"counts_occupied"`
+}
+
+// Row is a room's nights, one cell per night. Version changes whenever any of
+// the nights do, so a save based on an older copy of the row can be refused.
+type Row struct {
+	RoomID   int    `json:"room_id"`
+	RoomName string `json:"room_name"`
+	Version  string `json:"version"`
+	Days     []Cell `json:"days"`
+}
+
+// Cell is one night of a room. When a night has more than one restriction, a
+// reservation wins over an external booking, which wins over a block.
+type Cell struct {
+	Date string `json:"date"`
+	Day  int    `json:"day"`
+	Kind string `json:"kind"`
+	// RestrictionID is the room restriction taking the night, and Version the
+	// number of times it has been saved
+	RestrictionID int    `json:"restriction_id,omitempty"`
+	Version       int    `json:"version,omitempty"`
+	ReservationID int    `json:"reservation_id,omitempty"`
+	BlockID       int    `json:"block_id,omitempty"`
+	Reason        string `json:"reason,omitempty"`
+	TypeID        int    `json:"type_id,omitempty"`
+	// Tags are the reservation's, and Arrival is set on its first night
+	Tags    []string `json:"tags,omitempty"`
+	Arrival bool     `json:"arrival,omitempty"`
+	// Conflict is set when a reservation and an external booking share the night
+	Conflict bool `json:"conflict,omitempty"`
+}
+
+// rank orders the kinds of cell, so the one shown for a night can be chosen
+var rank = map[string]int{
+	KindFree:        0,
+	KindBlock:       1,
+	KindExternal:    2,
+	KindReservation: 3,
+}
+
+// Build lays out the restrictions of rooms over the nights from start up to end
+func Build(start, end time.Time, rooms []models.Room, types []models.Restriction, restrictions []models.RoomRestriction) Calendar {
+	c := Calendar{
+		Start: start.Format(DateLayout),
+		End:   end.Format(DateLayout),
+		Types: calendarTypes(types),
+		Rooms: []Row{},
+	}
+
+	index := make(map[int]int)
+	for _, room := range rooms {
+		row := Row{RoomID: room.ID, RoomName: room.RoomName}
+		for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+			row.Days = append(row.Days, Cell{Date: d.Format(DateLayout), Day: d.Day(), Kind: KindFree})
+		}
+		index[room.ID] = len(c.Rooms)
+		c.Rooms = append(c.Rooms, row)
+	}
+
+	for _, rr := range restrictions {
+		i, ok := index[rr.RoomID]
+		if !ok {
+			continue
+		}
+
+		cell := Cell{RestrictionID: rr.ID, Version: rr.Version, TypeID: rr.RestrictionID}
+		switch {
+		case rr.ReservationID > 0:
+			cell.Kind = KindReservation
+			cell.ReservationID = rr.ReservationID
+			cell.Tags = rr.Reservation.Tags
+		case rr.ICalFeedID > 0:
+			cell.Kind = KindExternal
+		default:
+			cell.Kind = KindBlock
+			cell.BlockID = rr.BlockID
+			cell.Reason = rr.Block.Reason
+		}
+
+		days := c.Rooms[i].Days
+		for d := rr.StartDate; d.Before(rr.EndDate); d = d.AddDate(0, 0, 1) {
+			n := nights(start, d)
+			if n < 0 || n >= len(days) {
+				continue
+			}
+
+			existing := days[n]
+			conflict := existing.Conflict ||
+				existing.Kind == KindReservation && cell.Kind == KindExternal ||
+				existing.Kind == KindExternal && cell.Kind == KindReservation
+			if rank[cell.Kind] > rank[existing.Kind] {
+				cell.Date, cell.Day = existing.Date, existing.Day
+				cell.Arrival = cell.Kind == KindReservation && d.Equal(rr.StartDate)
+				days[n] = cell
+			}
+			days[n].Conflict = conflict
+		}
+	}
+
+	for i := range c.Rooms {
+		c.Rooms[i].Version = version(c.Rooms[i].Days)
+	}
+
+	return c
+}
+
+// version sums up the nights of a row
+func version(days []Cell) string {
+	h := sha1.New()
+	for _, d := range days {
+		fmt.Fprintf(h, "%s %s %d %d %t\n", d.Date, d.Kind, d.RestrictionID, d.Version, d.Conflict)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))[:16]
+}
+
+// calendarTypes returns the restriction types as they are shown on a calendar
+func calendarTypes(types []models.Restriction) []Type {
+	out := []Type{}
+	for _, t := range types {
+		out = append(out, Type{
+			ID:                 t.ID,
+			Name:               t.RestrictionName,
+			Code:               t.Code,
+			Color:              t.Color,
+			BlocksAvailability: t.BlocksAvailability,
+			CountsOccupied:     t.CountsOccupied,
+		})
+	}
+	return out
+}
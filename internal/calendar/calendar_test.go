@@ -0,0 +1,110 @@
+package calendar
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DmitryZzz/bookings/internal/models"
+)
+
+func day(d int) time.Time {
+	return time.Date(2026, 11, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestBuild(t *testing.T) {
+	rooms := []models.Room{{ID: 1, RoomName: "General's Quarters"}, {ID: 2, RoomName: "Major's Suite"}}
+	types := []models.Restriction{
+		{ID: 1, RestrictionName: "Reservation", Code: models.ReservationRestriction, Color: "#dc3545", BlocksAvailability: true},
+		{ID: 2, RestrictionName: "Owner Block", Code: models.OwnerRestriction, Color: "#6c757d", BlocksAvailability: true},
+	}
+	restrictions := []models.RoomRestriction{
+		// a block over the nights of the 2nd to the 5th
+		{ID: 1, RoomID: 1, StartDate: day(2), EndDate: day(6), RestrictionID: 2, BlockID: 7, Block: models.Block{Reason: "Painting"}},
+		// a reservation on top of it for the nights of the 3rd and 4th
+		{ID: 2, RoomID: 1, StartDate: day(3), EndDate: day(5), RestrictionID: 1, ReservationID: 11,
+			Reservation: models.Reservation{Tags: []string{"VIP"}}},
+		// an external booking on the night of the 4th
+		{ID: 3, RoomID: 1, StartDate: day(4), EndDate: day(5), RestrictionID: 3, ICalFeedID: 1},
+		// a reservation starting before the calendar
+		{ID: 4, RoomID: 2, StartDate: day(1), EndDate: day(3), RestrictionID: 1, ReservationID: 12},
+		// a room that isn't shown
+		{ID: 5, RoomID: 9, StartDate: day(2), EndDate: day(3), RestrictionID: 1, ReservationID: 13},
+	}
+
+	c := Build(day(2), day(8), rooms, types, restrictions)
+
+	if c.Start != "2026-11-02" || c.End != "2026-11-08" || len(c.Types) != 2 || len(c.Rooms) != 2 {
+		t.Fatalf("unexpected calendar %+v", c)
+	}
+
+	tests := []struct {
+		room, night int
+		kind        string
+		restriction int
+		conflict    bool
+	}{
+		{1, 2, KindBlock, 1, false},
+		{1, 3, KindReservation, 2, false},
+		{1, 4, KindReservation, 2, true},
+		{1, 5, KindBlock, 1, false},
+		// the block ends on the morning of the 6th
+		{1, 6, KindFree, 0, false},
+		{2, 2, KindReservation, 4, false},
+		{2, 3, KindFree, 0, false},
+	}
+	for _, tt := range tests {
+		days := c.Rooms[tt.room-1].Days
+		if len(days) != 6 {
+			t.Fatalf("expected 6 nights, got %d", len(days))
+		}
+		cell := days[tt.night-2]
+		if cell.Day != tt.night || cell.Date != day(tt.night).Format(DateLayout) {
+			t.Errorf("room %d night %d: unexpected date %s", tt.room, tt.night, cell.Date)
+		}
+		if cell.Kind != tt.kind || cell.RestrictionID != tt.restriction || cell.Conflict != tt.conflict {
+			t.Errorf("room %d night %d: unexpected cell %+v", tt.room, tt.night, cell)
+		}
+	}
+
+	arrival, second := c.Rooms[0].Days[1], c.Rooms[0].Days[2]
+	if !arrival.Arrival || len(arrival.Tags) != 1 || arrival.Tags[0] != "VIP" {
+		t.Errorf("expected the tags on the arrival night, got %+v", arrival)
+	}
+	if second.Arrival || len(second.Tags) != 1 {
+		t.Errorf("expected the tags but no arrival on the second night, got %+v", second)
+	}
+	if c.Rooms[1].Days[0].Arrival {
+		t.Error("expected no arrival on a reservation starting before the calendar")
+	}
+
+	block := c.Rooms[0].Days[0]
+	if block.BlockID != 7 || block.Reason != "Painting" || block.TypeID != 2 {
+		t.Errorf("unexpected block cell %+v", block)
+	}
+}
+
+func TestBuildVersion(t *testing.T) {
+	rooms := []models.Room{{ID: 1}}
+	restrictions := []models.RoomRestriction{
+		{ID: 1, RoomID: 1, StartDate: day(2), EndDate: day(4), RestrictionID: 1, ReservationID: 11, Version: 1},
+	}
+
+	before := Build(day(1), day(8), rooms, nil, restrictions).Rooms[0].Version
+	if again := Build(day(1), day(8), rooms, nil, restrictions).Rooms[0].Version; again != before {
+		t.Errorf("expected the same nights to have the same version")
+	}
+
+	// the reservation is saved again
+	restrictions[0].Version = 2
+	if after := Build(day(1), day(8), rooms, nil, restrictions).Rooms[0].Version; after == before {
+		t.Errorf("expected the version to change with the restriction's")
+	}
+
+	// and then moved
+	restrictions[0].StartDate = day(3)
+	restrictions[0].Version = 3
+	moved := Build(day(1), day(8), rooms, nil, restrictions).Rooms[0]
+	if moved.Version == before || moved.Days[1].Kind != KindFree {
+		t.Errorf("unexpected row after the move %+v", moved)
+	}
+}
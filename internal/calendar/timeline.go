@@ -0,0 +1,146 @@
+package calendar
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"github.com/DmitryZzz/bookings/internal/models"
+)
+
+// The number of weeks a timeline can span
+const (
+	MinWeeks     = 2
+	MaxWeeks     = 12
+	DefaultWeeks = 4
+)
+
+// Timeline is the restrictions of every room as bars over a range of nights
+type Timeline struct {
+	Start string `json:"start"`
+	// End is the day after the last night
+	End   string `json:"end"`
+	Weeks int    `json:"weeks"`
+	Days  []Day  `json:"days"`
+	Types []Type `json:"restriction_types"`
+	Rooms []Lane `json:"rooms"`
+}
+
+// Day is a night along the top of a timeline
+type Day struct {
+	// Column is the night's place on the timeline, counting from 1
+	Column  int    `json:"column"`
+	Date    string `json:"date"`
+	Day     int    `json:"day"`
+	Weekday string `json:"weekday"`
+	// Month is set on the first night of the timeline and of every month
+	Month string `json:"month,omitempty"`
+}
+
+// Lane is a room's row of bars
+type Lane struct {
+	RoomID   int    `json:"room_id"`
+	RoomName string `json:"room_name"`
+	Bars     []Bar  `json:"bars"`
+}
+
+// Bar is a room restriction on a timeline
+type Bar struct {
+	Kind          string `json:"kind"`
+	RestrictionID int    `json:"restriction_id"`
+	ReservationID int    `json:"reservation_id,omitempty"`
+	BlockID       int    `json:"block_id,omitempty"`
+	TypeID        int    `json:"type_id"`
+	Label         string `json:"label"`
+	// Tags are the reservation's, and Version the reservation's version, which
+	// a move posts so it can't overwrite changes made since the timeline was shown
+	Tags    []string `json:"tags,omitempty"`
+	Version int      `json:"version,omitempty"`
+	// Start and End are the dates of the whole restriction, which may run past
+	// either end of the timeline
+	Start string `json:"start"`
+	End   string `json:"end"`
+	// Column is the bar's first night on the timeline, counting from 1, and
+	// Span its number of nights on the timeline
+	Column int `json:"column"`
+	Span   int `json:"span"`
+}
+
+// BuildTimeline lays out the restrictions of rooms over weeks from start
+func BuildTimeline(start time.Time, weeks int, rooms []models.Room, types []models.Restriction, restrictions []models.RoomRestriction) Timeline {
+	end := start.AddDate(0, 0, weeks*7)
+	t := Timeline{
+		Start: start.Format(DateLayout),
+		End:   end.Format(DateLayout),
+		Weeks: weeks,
+		Types: calendarTypes(types),
+		Rooms: []Lane{},
+	}
+
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		day := Day{Column: len(t.Days) + 1, Date: d.Format(DateLayout), Day: d.Day(), Weekday: d.Weekday().String()[:2]}
+		if d.Equal(start) || d.Day() == 1 {
+			day.Month = d.Format("January 2006")
+		}
+		t.Days = append(t.Days, day)
+	}
+
+	index := make(map[int]int)
+	for _, room := range rooms {
+		index[room.ID] = len(t.Rooms)
+		t.Rooms = append(t.Rooms, Lane{RoomID: room.ID, RoomName: room.RoomName, Bars: []Bar{}})
+	}
+
+	for _, rr := range restrictions {
+		i, ok := index[rr.RoomID]
+		if !ok || !rr.StartDate.Before(end) || !start.Before(rr.EndDate) {
+			continue
+		}
+
+		first, last := rr.StartDate, rr.EndDate
+		if first.Before(start) {
+			first = start
+		}
+		if last.After(end) {
+			last = end
+		}
+
+		bar := Bar{
+			RestrictionID: rr.ID,
+			TypeID:        rr.RestrictionID,
+			Label:         rr.Restriction.RestrictionName,
+			Start:         rr.StartDate.Format(DateLayout),
+			End:           rr.EndDate.Format(DateLayout),
+			Column:        nights(start, first) + 1,
+			Span:          nights(first, last),
+		}
+		switch {
+		case rr.ReservationID > 0:
+			bar.Kind = KindReservation
+			bar.ReservationID = rr.ReservationID
+			bar.Tags = rr.Reservation.Tags
+			bar.Version = rr.Reservation.Version
+			if name := strings.TrimSpace(rr.Reservation.FirstName + " " + rr.Reservation.LastName); name != "" {
+				bar.Label = name
+			}
+		case rr.ICalFeedID > 0:
+			bar.Kind = KindExternal
+		default:
+			bar.Kind = KindBlock
+			bar.BlockID = rr.BlockID
+			if rr.Block.Reason != "" {
+				bar.Label = rr.Block.Reason
+			}
+		}
+
+		t.Rooms[i].Bars = append(t.Rooms[i].Bars, bar)
+	}
+
+	return t
+}
+
+// nights returns the number of nights from start up to end, negative when end
+// is before start
+func nights(start, end time.Time) int {
+	return int(math.Round(end.Sub(start).Hours() / 24))
+}
@@ -0,0 +1,52 @@
+package calendar
+
+import (
+	"testing"
+
+	"github.com/DmitryZzz/bookings/internal/models"
+)
+
+func TestBuildTimeline(t *testing.T) {
+	rooms := []models.Room{{ID: 1, RoomName: "General's Quarters"}, {ID: 2, RoomName: "Major's Suite"}}
+	restrictions := []models.RoomRestriction{
+		// a reservation that arrived before the timeline
+		{ID: 1, RoomID: 1, StartDate: day(1), EndDate: day(4), RestrictionID: 1, ReservationID: 11,
+			Reservation: models.Reservation{FirstName: "Jane", LastName: "Doe", Version: 3}},
+		// a block running past its end
+		{ID: 2, RoomID: 2, StartDate: day(14), EndDate: day(20), RestrictionID: 2, BlockID: 7,
+			Restriction: models.Restriction{RestrictionName: "Owner Block"}},
+		// an external booking with nothing but its type to show
+		{ID: 3, RoomID: 2, StartDate: day(5), EndDate: day(6), RestrictionID: 3, ICalFeedID: 1,
+			Restriction: models.Restriction{RestrictionName: "External"}},
+		// one that checks out the day the timeline starts
+		{ID: 4, RoomID: 1, StartDate: day(1), EndDate: day(2), RestrictionID: 1, ReservationID: 12},
+	}
+
+	tl := BuildTimeline(day(2), 2, rooms, nil, restrictions)
+
+	if tl.Start != "2026-11-02" || tl.End != "2026-11-16" || len(tl.Days) != 14 {
+		t.Fatalf("unexpected timeline %+v", tl)
+	}
+	if tl.Days[0].Month != "November 2026" || tl.Days[1].Month != "" || tl.Days[0].Weekday != "Mo" || tl.Days[13].Column != 14 {
+		t.Errorf("unexpected days %+v", tl.Days)
+	}
+
+	lane := tl.Rooms[0].Bars
+	if len(lane) != 1 {
+		t.Fatalf("expected 1 bar for the first room, got %+v", lane)
+	}
+	if b := lane[0]; b.Kind != KindReservation || b.Label != "Jane Doe" || b.Column != 1 || b.Span != 2 || b.Start != "2026-11-01" || b.Version != 3 {
+		t.Errorf("unexpected reservation bar %+v", b)
+	}
+
+	lane = tl.Rooms[1].Bars
+	if len(lane) != 2 {
+		t.Fatalf("expected 2 bars for the second room, got %+v", lane)
+	}
+	if b := lane[0]; b.Kind != KindBlock || b.BlockID != 7 || b.Label != "Owner Block" || b.Column != 13 || b.Span != 2 {
+		t.Errorf("unexpected block bar %+v", b)
+	}
+	if b := lane[1]; b.Kind != KindExternal || b.Label != "External" || b.Column != 4 || b.Span != 1 {
+		t.Errorf("unexpected external bar %+v", b)
+	}
+}
@@ -4,17 +4,26 @@ import (
 	"html/template"
 	"log"
 
-	"github.com/DmitryZzz/bookings/internal/models"
 	"github.com/alexedwards/scs/v2"
 )
 
 // AppConfig holds the application config
 type AppConfig struct {
-	UseCache      bool
-	TemplateCache map[string]*template.Template
-	InfoLog       *log.Logger
-	ErrorLog      *log.Logger
-	InProduction  bool
-	Session       *scs.SessionManager
-	MailChan      chan models.MailData
+	UseCache           bool
+	TemplateCache      map[string]*template.Template
+	EmailTemplateCache map[string]*template.Template
+	InfoLog            *log.Logger
+	ErrorLog           *log.Logger
+	InProduction       bool
+	Session            *scs.SessionManager
+	Secret             string
+	// GuestSession keeps a guest's account login, in a cookie of its own so it
+	// never mixes with a staff login in the same browser
+	GuestSession *scs.SessionManager
+	// SiteURL is the public address of the site, for links in email
+	SiteURL string
+	// MailFrom is the address the site's mail is sent from
+	MailFrom string
+	// UploadDir is where uploaded room photos are stored
+	UploadDir string
 }
@@ -0,0 +1,240 @@
+package emails
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"path/filepath"
+	"strings"
+
+	"github.com/DmitryZzz/bookings/internal/config"
+	"github.com/DmitryZzz/bookings/internal/models"
+	"github.com/DmitryZzz/bookings/internal/render"
+	"github.com/DmitryZzz/bookings/internal/repository"
+)
+
+// TemplateDir holds the email layouts and message templates
+var TemplateDir = "./email-templates"
+
+var app *config.AppConfig
+var db repository.DatabaseRepo
+
+// NewRenderer sets the config for the emails package, and where the owner's
+// overrides of the default templates are kept
+func NewRenderer(a *config.AppConfig, d repository.DatabaseRepo) {
+	app = a
+	db = d
+}
+
+// Email is a rendered message
+type Email struct {
+	Subject string
+	HTML    string
+	Text    string
+}
+
+// Mail addresses a rendered message, ready to queue
+func (e Email) Mail(from, to string) models.MailData {
+	return models.MailData{
+		To:      to,
+		From:    from,
+		Subject: e.Subject,
+		Content: e.HTML,
+		Text:    e.Text,
+	}
+}
+
+// Render executes the message template name with data. Each template defines a
+// "subject" and a "body" and is wrapped in the "base" layout; the text part comes
+// from an optional "text" template, or is generated from the body. An override
+// saved by the owner is used in place of the default when there is one.
+func Render(name string, data interface{}) (Email, error) {
+	t, err := lookup(name)
+	if err != nil {
+		return Email{}, err
+	}
+	return execute(t, data)
+}
+
+// lookup returns the owner's override of a template, falling back to the
+// default when there is none or it can no longer be used
+func lookup(name string) (*template.Template, error) {
+	if db != nil {
+		o, err := db.GetEmailTemplate(name)
+		if err != nil {
+			logError("can't load email template", name, err)
+		} else if o.ID > 0 {
+			t, err := Parse(o)
+			if err == nil {
+				return t, nil
+			}
+			logError("bad email template override", name, err)
+		}
+	}
+
+	tc, err := templateCache()
+	if err != nil {
+		return nil, err
+	}
+
+	t, ok := tc[name]
+	if !ok {
+		return nil, fmt.Errorf("no email template %s", name)
+	}
+	return t, nil
+}
+
+func templateCache() (map[string]*template.Template, error) {
+	if app != nil && app.UseCache {
+		return app.EmailTemplateCache, nil
+	}
+	return CreateTemplateCache()
+}
+
+func logError(v ...interface{}) {
+	if app != nil && app.ErrorLog != nil {
+		app.ErrorLog.Println(v...)
+	}
+}
+
+func execute(t *template.Template, data interface{}) (Email, error) {
+	var e Email
+
+	subject, err := executeTemplate(t, "subject", data)
+	if err != nil {
+		return e, err
+	}
+	e.Subject = strings.Join(strings.Fields(html.UnescapeString(subject)), " ")
+
+	e.HTML, err = executeTemplate(t, "base", data)
+	if err != nil {
+		return e, err
+	}
+
+	if t.Lookup("text") != nil {
+		text, err := executeTemplate(t, "text", data)
+		if err != nil {
+			return e, err
+		}
+		e.Text = strings.TrimSpace(html.UnescapeString(text)) + "\n"
+	} else {
+		body, err := executeTemplate(t, "body", data)
+		if err != nil {
+			return e, err
+		}
+		e.Text = HTMLToText(body)
+	}
+
+	return e, nil
+}
+
+func executeTemplate(t *template.Template, name string, data interface{}) (string, error) {
+	var buf bytes.Buffer
+	err := t.ExecuteTemplate(&buf, name, data)
+	return buf.String(), err
+}
+
+// Parse builds a message template from an override's subject, body and
+// optional text, wrapped in the email layouts
+func Parse(o models.EmailTemplate) (*template.Template, error) {
+	blocks := []struct {
+		name, src string
+	}{
+		{"subject", o.Subject},
+		{"body", o.Body},
+		{"text", o.Text},
+	}
+
+	var src strings.Builder
+	src.WriteString(`{{template "base" .}}`)
+	for _, b := range blocks {
+		if b.name == "text" && strings.TrimSpace(b.src) == "" {
+			continue
+		}
+
+		// parse each part on its own first, so a stray {{end}} or {{define}}
+		// can't escape its block
+		t, err := template.New(b.name).Funcs(render.Functions).Parse(b.src)
+		if err != nil {
+			return nil, err
+		}
+		if len(t.Templates()) > 1 {
+			return nil, fmt.Errorf("%s: templates can't define other templates", b.name)
+		}
+
+		fmt.Fprintf(&src, `{{define %q}}%s{{end}}`, b.name, b.src)
+	}
+
+	t, err := template.New(o.Name).Funcs(render.Functions).Parse(src.String())
+	if err != nil {
+		return nil, err
+	}
+	return t.ParseGlob(filepath.Join(TemplateDir, "*.layout.tmpl"))
+}
+
+// Validate checks that an override parses and renders with sample data
+func Validate(o models.EmailTemplate, sample interface{}) (Email, error) {
+	if strings.TrimSpace(o.Subject) == "" || strings.TrimSpace(o.Body) == "" {
+		return Email{}, errors.New("subject and body can't be blank")
+	}
+
+	t, err := Parse(o)
+	if err != nil {
+		return Email{}, err
+	}
+	return execute(t, sample)
+}
+
+// Default returns the source of the default template for name, as an override
+// the owner can start editing from
+func Default(name string) (models.EmailTemplate, error) {
+	// parse afresh: executing an html template rewrites its tree with escapers
+	ts, err := template.New(name).Funcs(render.Functions).ParseFiles(filepath.Join(TemplateDir, name+".email.tmpl"))
+	if err != nil {
+		return models.EmailTemplate{}, err
+	}
+
+	o := models.EmailTemplate{Name: name}
+	for _, b := range []struct {
+		name string
+		dst  *string
+	}{
+		{"subject", &o.Subject},
+		{"body", &o.Body},
+		{"text", &o.Text},
+	} {
+		if t := ts.Lookup(b.name); t != nil && t.Tree != nil {
+			*b.dst = strings.TrimSpace(t.Tree.Root.String())
+		}
+	}
+
+	return o, nil
+}
+
+// CreateTemplateCache parses every message template with the email layouts
+func CreateTemplateCache() (map[string]*template.Template, error) {
+	myCache := map[string]*template.Template{}
+
+	pages, err := filepath.Glob(filepath.Join(TemplateDir, "*.email.tmpl"))
+	if err != nil {
+		return myCache, err
+	}
+
+	layouts := filepath.Join(TemplateDir, "*.layout.tmpl")
+	for _, page := range pages {
+		name := strings.TrimSuffix(filepath.Base(page), ".email.tmpl")
+		ts, err := template.New(name).Funcs(render.Functions).ParseFiles(page)
+		if err != nil {
+			return myCache, err
+		}
+		ts, err = ts.ParseGlob(layouts)
+		if err != nil {
+			return myCache, err
+		}
+		myCache[name] = ts
+	}
+
+	return myCache, nil
+}
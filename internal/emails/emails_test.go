@@ -0,0 +1,199 @@
+package emails
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DmitryZzz/bookings/internal/config"
+	"github.com/DmitryZzz/bookings/internal/models"
+	"github.com/DmitryZzz/bookings/internal/repository"
+)
+
+func init() {
+	TemplateDir = "./../../email-templates"
+}
+
+var testReservation = models.Reservation{
+	FirstName: `<script>alert("x")</script>`,
+	LastName:  "O'Brien",
+	Email:     "john@smith.com",
+	StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+	EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+	Room:      models.Room{RoomName: "General's Quarters"},
+}
+
+func TestReservationConfirmation(t *testing.T) {
+	e, err := ReservationConfirmation(testReservation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Subject != "Reservation Confirmation" {
+		t.Errorf("unexpected subject %q", e.Subject)
+	}
+
+	if strings.Contains(e.HTML, "<script>") || !strings.Contains(e.HTML, "&lt;script&gt;") {
+		t.Error("guest supplied names must be escaped in the html part")
+	}
+	if !strings.Contains(e.HTML, "<title>Reservation Confirmation</title>") {
+		t.Error("expected the layout to wrap the body")
+	}
+
+	for _, s := range []string{`Dear <script>alert("x")</script>:`, "from 2050-01-01 to 2050-01-03"} {
+		if !strings.Contains(e.Text, s) {
+			t.Errorf("expected text part to contain %q, got:\n%s", s, e.Text)
+		}
+	}
+	if strings.Contains(e.Text, "<p>") || strings.Contains(e.Text, "&lt;") {
+		t.Errorf("text part should be plain text, got:\n%s", e.Text)
+	}
+
+	mail := e.Mail("me@here.com", "john@smith.com")
+	if mail.Content != e.HTML || mail.Text != e.Text || mail.To != "john@smith.com" {
+		t.Errorf("unexpected mail %+v", mail)
+	}
+}
+
+func TestReservationNotification(t *testing.T) {
+	e, err := ReservationNotification(testReservation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Subject != "New reservation: General's Quarters, 2050-01-01" {
+		t.Errorf("subject should be plain text, got %q", e.Subject)
+	}
+	if !strings.Contains(e.Text, "Guest: <script>alert(\"x\")</script> O'Brien\nEmail: john@smith.com") {
+		t.Errorf("unexpected text part:\n%s", e.Text)
+	}
+}
+
+// fakeRepo holds overrides in memory; methods the package doesn't use are left
+// to the embedded nil interface
+type fakeRepo struct {
+	repository.DatabaseRepo
+	overrides map[string]models.EmailTemplate
+}
+
+func (f *fakeRepo) GetEmailTemplate(name string) (models.EmailTemplate, error) {
+	return f.overrides[name], nil
+}
+
+func TestRender_Override(t *testing.T) {
+	NewRenderer(&config.AppConfig{}, &fakeRepo{overrides: map[string]models.EmailTemplate{
+		"reservation-confirmation": {
+			ID:      1,
+			Name:    "reservation-confirmation",
+			Subject: "Thanks, {{.Reservation.LastName}}",
+			Body:    "<p>Custom body for {{.Reservation.FirstName}}</p>",
+			Text:    "Custom text",
+		},
+		"reservation-notification": {
+			ID:      2,
+			Name:    "reservation-notification",
+			Subject: "Broken {{",
+			Body:    "<p></p>",
+		},
+	}})
+	defer NewRenderer(nil, nil)
+
+	e, err := ReservationConfirmation(testReservation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Subject != "Thanks, O'Brien" || !strings.Contains(e.HTML, "Custom body for &lt;script&gt;") || e.Text != "Custom text\n" {
+		t.Errorf("expected the override to be used, got %+v", e)
+	}
+	if !strings.Contains(e.HTML, "<title>Thanks, O&#39;Brien</title>") {
+		t.Error("expected the override to be wrapped in the layout")
+	}
+
+	// an override that no longer parses falls back to the default
+	e, err = ReservationNotification(testReservation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(e.Subject, "New reservation") {
+		t.Errorf("expected the default template, got %q", e.Subject)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	sample := ReservationData{Reservation: testReservation}
+
+	var tests = []struct {
+		name  string
+		tmpl  models.EmailTemplate
+		valid bool
+	}{
+		{"valid", models.EmailTemplate{Subject: "Hi", Body: "<p>{{.Reservation.FirstName}}</p>"}, true},
+		{"blank body", models.EmailTemplate{Subject: "Hi"}, false},
+		{"syntax error", models.EmailTemplate{Subject: "Hi", Body: "{{if}}"}, false},
+		{"unknown field", models.EmailTemplate{Subject: "Hi", Body: "{{.Reservation.Nickname}}"}, false},
+		{"escapes its block", models.EmailTemplate{Subject: "Hi", Body: `x{{end}}{{define "base"}}y`}, false},
+		{"defines a template", models.EmailTemplate{Subject: "Hi", Body: `{{define "base"}}y{{end}}`}, false},
+	}
+
+	for _, e := range tests {
+		e.tmpl.Name = "reservation-confirmation"
+		_, err := Validate(e.tmpl, sample)
+		if e.valid && err != nil {
+			t.Errorf("%s: unexpected error %s", e.name, err)
+		}
+		if !e.valid && err == nil {
+			t.Errorf("%s: expected an error", e.name)
+		}
+	}
+}
+
+func TestDefault(t *testing.T) {
+	o, err := Default("reservation-confirmation")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if o.Subject != "Reservation Confirmation" || !strings.Contains(o.Body, "{{$res.FirstName}}") {
+		t.Errorf("unexpected default %+v", o)
+	}
+
+	// the default source must round trip through the editor
+	if _, err := Validate(o, ReservationData{Reservation: testReservation}); err != nil {
+		t.Errorf("default doesn't validate: %s", err)
+	}
+}
+
+func TestMessages(t *testing.T) {
+	for _, m := range Messages {
+		if _, err := Render(m.Name, m.Sample); err != nil {
+			t.Errorf("%s doesn't render with its sample: %s", m.Name, err)
+		}
+	}
+}
+
+func TestRender_UnknownTemplate(t *testing.T) {
+	if _, err := Render("no-such-email", nil); err == nil {
+		t.Error("expected error for unknown template")
+	}
+}
+
+func TestHTMLToText(t *testing.T) {
+	var tests = []struct {
+		name     string
+		html     string
+		expected string
+	}{
+		{"paragraphs", "<p>One\n   two</p><p>Three</p>", "One two\n\nThree\n"},
+		{"line breaks", "a<br>b<br/>c", "a\nb\nc\n"},
+		{"links", `<a href="https://example.com/r/1">your booking</a>`, "your booking (https://example.com/r/1)\n"},
+		{"lists", "<ul><li>one</li><li>two</li></ul>", "- one\n- two\n"},
+		{"entities", "Tom &amp; Jerry&#39;s", "Tom & Jerry's\n"},
+		{"styles", "<style>p { color: red; }</style><p>hi</p>", "hi\n"},
+	}
+
+	for _, e := range tests {
+		if got := HTMLToText(e.html); got != e.expected {
+			t.Errorf("%s: expected %q, got %q", e.name, e.expected, got)
+		}
+	}
+}
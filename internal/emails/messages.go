@@ -0,0 +1,225 @@
+package emails
+
+import (
+	"time"
+
+	"github.com/DmitryZzz/bookings/internal/models"
+)
+
+// Message describes an email the owner can reword
+type Message struct {
+	Name        string
+	Title       string
+	Description string
+	// Sample is data of the message's type, used for previews and test sends
+	Sample interface{}
+	// Scheduled messages take ReservationData and can be sent by a guest message schedule
+	Scheduled bool
+}
+
+// ReservationData is the data for reservation emails
+type ReservationData struct {
+	Reservation models.Reservation
+	// ReviewURL is the guest's link to review their stay, set for scheduled messages
+	ReviewURL string
+}
+
+// EnquiryData is the data for contact form emails
+type EnquiryData struct {
+	Enquiry models.Enquiry
+	// InboxURL links the owner's notification to the enquiry in the admin inbox
+	InboxURL string
+	// Reply is the owner's answer, for replies
+	Reply string
+}
+
+// AccountData is the data for guest account emails
+type AccountData struct {
+	Account models.GuestAccount
+	// URL is the link the guest follows from the email
+	URL string
+}
+
+// LoginLinkData is the data for login link emails
+type LoginLinkData struct {
+	// Name is the first name of whoever asked for the link
+	Name string
+	URL  string
+	// Minutes is how long the link works for
+	Minutes int
+}
+
+// Messages lists the emails the owner can reword
+var Messages = []Message{
+	{
+		Name:        "reservation-confirmation",
+		Title:       "Reservation confirmation",
+		Description: "Sent to the guest, with a calendar invite, when they book.",
+		Sample:      ReservationData{Reservation: sampleReservation()},
+	},
+	{
+		Name:        "reservation-notification",
+		Title:       "Owner notification",
+		Description: "Sent to you when a guest books.",
+		Sample:      ReservationData{Reservation: sampleReservation()},
+	},
+	{
+		Name:        "reservation-reminder",
+		Title:       "Reservation reminder",
+		Description: "Sent to the guest, with directions, a few days before they arrive.",
+		Sample:      ReservationData{Reservation: sampleReservation()},
+		Scheduled:   true,
+	},
+	{
+		Name:        "reservation-checkin",
+		Title:       "Check-in instructions",
+		Description: "Sent to the guest on the day they arrive.",
+		Sample:      ReservationData{Reservation: sampleReservation()},
+		Scheduled:   true,
+	},
+	{
+		Name:        "reservation-thank-you",
+		Title:       "Thank you",
+		Description: "Sent to the guest after they leave, with a link to review their stay.",
+		Sample:      ReservationData{Reservation: sampleReservation(), ReviewURL: "http://localhost:8080/reviews/1?token=sample"},
+		Scheduled:   true,
+	},
+	{
+		Name:        "reservation-changed",
+		Title:       "Reservation changed",
+		Description: "Sent to the guest, with an updated calendar invite, when their dates or room change.",
+		Sample:      ReservationData{Reservation: sampleReservation()},
+	},
+	{
+		Name:        "reservation-cancelled",
+		Title:       "Reservation cancelled",
+		Description: "Sent to the guest, with a calendar cancellation, when their booking is cancelled.",
+		Sample:      ReservationData{Reservation: sampleReservation()},
+	},
+	{
+		Name:        "enquiry-notification",
+		Title:       "New enquiry",
+		Description: "Sent to you when someone sends a message through the contact form.",
+		Sample:      EnquiryData{Enquiry: sampleEnquiry(), InboxURL: "http://localhost:8080/admin/enquiries/1"},
+	},
+	{
+		Name:        "enquiry-reply",
+		Title:       "Enquiry reply",
+		Description: "Sent when you reply to an enquiry from the inbox.",
+		Sample:      EnquiryData{Enquiry: sampleEnquiry(), Reply: "Yes, we do! Let us know when you would like to visit."},
+	},
+	{
+		Name:        "account-verification",
+		Title:       "Confirm email address",
+		Description: "Sent to a guest who registers an account, with a link that confirms their email address.",
+		Sample:      AccountData{Account: sampleAccount(), URL: "http://localhost:8080/account/verify/1?token=sample"},
+	},
+	{
+		Name:        "login-link",
+		Title:       "Login link",
+		Description: "Sent to staff or a guest who asks to log in by email, with a link that logs them in once.",
+		Sample:      LoginLinkData{Name: "John", URL: "http://localhost:8080/account/login/link/1?token=sample", Minutes: 15},
+	},
+}
+
+// FindMessage returns the message named name
+func FindMessage(name string) (Message, bool) {
+	for _, m := range Messages {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return Message{}, false
+}
+
+// ScheduledMessages returns the messages a guest message schedule can send
+func ScheduledMessages() []Message {
+	var out []Message
+	for _, m := range Messages {
+		if m.Scheduled {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+func sampleReservation() models.Reservation {
+	start := time.Now().AddDate(0, 0, 14).Truncate(24 * time.Hour)
+	return models.Reservation{
+		ID:        1,
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "john@smith.com",
+		Phone:     "555-555-5555",
+		StartDate: start,
+		EndDate:   start.AddDate(0, 0, 3),
+		RoomID:    1,
+		Room:      models.Room{ID: 1, RoomName: "General's Quarters"},
+		Price:     30000,
+	}
+}
+
+func sampleEnquiry() models.Enquiry {
+	return models.Enquiry{
+		ID:        1,
+		Name:      "John Smith",
+		Email:     "john@smith.com",
+		Message:   "Hello,\n\nDo you allow dogs in the rooms?",
+		CreatedAt: time.Now().Truncate(24 * time.Hour),
+	}
+}
+
+func sampleAccount() models.GuestAccount {
+	return models.GuestAccount{
+		ID:      1,
+		GuestID: 1,
+		Email:   "john@smith.com",
+		Guest:   models.Guest{ID: 1, FirstName: "John", LastName: "Smith", Email: "john@smith.com"},
+	}
+}
+
+// ReservationConfirmation renders the confirmation sent to a guest
+func ReservationConfirmation(res models.Reservation) (Email, error) {
+	return Render("reservation-confirmation", ReservationData{Reservation: res})
+}
+
+// ReservationNotification renders the notice of a new reservation sent to the owner
+func ReservationNotification(res models.Reservation) (Email, error) {
+	return Render("reservation-notification", ReservationData{Reservation: res})
+}
+
+// ReservationReminder renders the reminder sent to a guest before they arrive
+func ReservationReminder(res models.Reservation) (Email, error) {
+	return Render("reservation-reminder", ReservationData{Reservation: res})
+}
+
+// ReservationChanged renders the notice sent to a guest when their booking changes
+func ReservationChanged(res models.Reservation) (Email, error) {
+	return Render("reservation-changed", ReservationData{Reservation: res})
+}
+
+// ReservationCancelled renders the notice sent to a guest when their booking is cancelled
+func ReservationCancelled(res models.Reservation) (Email, error) {
+	return Render("reservation-cancelled", ReservationData{Reservation: res})
+}
+
+// EnquiryNotification renders the notice of a contact form enquiry sent to the owner
+func EnquiryNotification(e models.Enquiry, inboxURL string) (Email, error) {
+	return Render("enquiry-notification", EnquiryData{Enquiry: e, InboxURL: inboxURL})
+}
+
+// EnquiryReply renders the owner's reply to an enquiry
+func EnquiryReply(e models.Enquiry, reply string) (Email, error) {
+	return Render("enquiry-reply", EnquiryData{Enquiry: e, Reply: reply})
+}
+
+// AccountVerification renders the link a guest follows to confirm the email
+// address of their account
+func AccountVerification(a models.GuestAccount, url string) (Email, error) {
+	return Render("account-verification", AccountData{Account: a, URL: url})
+}
+
+// LoginLink renders the link that logs name in once, within minutes
+func LoginLink(name, url string, minutes int) (Email, error) {
+	return Render("login-link", LoginLinkData{Name: name, URL: url, Minutes: minutes})
+}
@@ -0,0 +1,96 @@
+package emails
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+var whitespace = regexp.MustCompile(`\s+`)
+
+// HTMLToText converts an html fragment to plain text for the text/plain part:
+// block elements become paragraphs, links keep their address and list items
+// get a dash
+func HTMLToText(s string) string {
+	var b strings.Builder
+	z := html.NewTokenizer(strings.NewReader(s))
+	var href string
+	skip := 0
+
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			return tidy(b.String())
+
+		case html.TextToken:
+			if skip > 0 {
+				continue
+			}
+			b.WriteString(whitespace.ReplaceAllString(string(z.Text()), " "))
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			tag := string(name)
+			switch tag {
+			case "style", "script", "head", "title":
+				if tt == html.StartTagToken {
+					skip++
+				}
+			case "br":
+				b.WriteString("\n")
+			case "p", "div", "table", "tr", "h1", "h2", "h3", "h4", "h5", "h6", "ul", "ol":
+				b.WriteString("\n\n")
+			case "li":
+				b.WriteString("\n- ")
+			case "hr":
+				b.WriteString("\n\n----\n\n")
+			case "a":
+				href = ""
+				for hasAttr {
+					var key, val []byte
+					key, val, hasAttr = z.TagAttr()
+					if string(key) == "href" {
+						href = string(val)
+					}
+				}
+			}
+
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "style", "script", "head", "title":
+				if skip > 0 {
+					skip--
+				}
+			case "p", "div", "table", "tr", "h1", "h2", "h3", "h4", "h5", "h6", "ul", "ol":
+				b.WriteString("\n\n")
+			case "a":
+				if href != "" && !strings.HasPrefix(href, "#") && !strings.HasPrefix(href, "mailto:") {
+					b.WriteString(" (" + href + ")")
+				}
+				href = ""
+			}
+		}
+	}
+}
+
+// tidy collapses spaces within lines and runs of blank lines
+func tidy(s string) string {
+	var lines []string
+	blank := false
+	for _, l := range strings.Split(s, "\n") {
+		l = strings.Join(strings.Fields(l), " ")
+		if l == "" {
+			blank = len(lines) > 0
+			continue
+		}
+		if blank {
+			lines = append(lines, "")
+			blank = false
+		}
+		lines = append(lines, l)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
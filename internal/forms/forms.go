@@ -62,3 +62,11 @@ func (f *Form) IsEmail(field string) {
 		f.Errors.Add(field, "Invalid email address")
 	}
 }
+
+// IsURL checks for a valid http or https url
+func (f *Form) IsURL(field string) {
+	value := f.Get(field)
+	if !govalidator.IsRequestURL(value) || !(strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://")) {
+		f.Errors.Add(field, "Invalid URL")
+	}
+}
@@ -118,4 +118,23 @@ func TestForm_IsEmail(t *testing.T) {
 	if !form.Valid() {
 		t.Error("got valid for invalid email address")
 	}
-}
\ No newline at end of file
+}
+
+func TestForm_IsURL(t *testing.T) {
+	postedValues := url.Values{}
+	postedValues.Add("good", "https://www.airbnb.com/calendar/ical/1.ics?s=abc")
+	postedValues.Add("bad", "ftp://example.com/cal.ics")
+	postedValues.Add("junk", "not a url")
+	form := New(postedValues)
+
+	form.IsURL("good")
+	if !form.Valid() {
+		t.Error("got invalid for valid url")
+	}
+
+	form.IsURL("bad")
+	form.IsURL("junk")
+	if form.Errors.Get("bad") == "" || form.Errors.Get("junk") == "" {
+		t.Error("got valid for invalid url")
+	}
+}
@@ -0,0 +1,69 @@
+package guests
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/DmitryZzz/bookings/internal/models"
+)
+
+// minPhoneDigits is the fewest digits a phone number needs to match guests by;
+// shorter ones are too likely to be shared or made up
+const minPhoneDigits = 7
+
+// EmailKey returns an email address the way guests are matched by it, trimmed
+// and in lower case
+func EmailKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// PhoneKey returns the digits of a phone number, which guests are matched by,
+// or "" when there are too few of them
+func PhoneKey(phone string) string {
+	var b strings.Builder
+	for _, r := range phone {
+		if unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	if b.Len() < minPhoneDigits {
+		return ""
+	}
+	return b.String()
+}
+
+// Match returns how two guests look like the same person, "email" or "phone",
+// or "" when they don't
+func Match(a, b models.Guest) string {
+	if key := EmailKey(a.Email); key != "" && key == EmailKey(b.Email) {
+		return "email"
+	}
+	if key := PhoneKey(a.Phone); key != "" && key == PhoneKey(b.Phone) {
+		return "phone"
+	}
+	return ""
+}
+
+// Merge returns the guest kept when other is merged into keep: keep's details
+// win, other's fill in the blanks and both sets of notes are kept
+func Merge(keep, other models.Guest) models.Guest {
+	if keep.FirstName == "" && keep.LastName == "" {
+		keep.FirstName, keep.LastName = other.FirstName, other.LastName
+	}
+	if strings.TrimSpace(keep.Email) == "" {
+		keep.Email = other.Email
+	}
+	if strings.TrimSpace(keep.Phone) == "" {
+		keep.Phone = other.Phone
+	}
+
+	notes := strings.TrimSpace(other.Notes)
+	switch {
+	case notes == "" || strings.Contains(keep.Notes, notes):
+	case strings.TrimSpace(keep.Notes) == "":
+		keep.Notes = notes
+	default:
+		keep.Notes = strings.TrimSpace(keep.Notes) + "\n\n" + notes
+	}
+	return keep
+}
@@ -0,0 +1,71 @@
+package guests
+
+import (
+	"testing"
+
+	"github.com/DmitryZzz/bookings/internal/models"
+)
+
+func TestEmailKey(t *testing.T) {
+	if key := EmailKey("  John.Smith@Example.COM "); key != "john.smith@example.com" {
+		t.Errorf("unexpected key %q", key)
+	}
+}
+
+func TestPhoneKey(t *testing.T) {
+	tests := map[string]string{
+		"+1 (555) 010-2030": "15550102030",
+		"555.010.2030":      "5550102030",
+		"12-34":             "",
+		"":                  "",
+	}
+	for phone, expected := range tests {
+		if key := PhoneKey(phone); key != expected {
+			t.Errorf("%q: expected %q, got %q", phone, expected, key)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	a := models.Guest{Email: "jane@example.com", Phone: "555 010 2030"}
+	tests := []struct {
+		b        models.Guest
+		expected string
+	}{
+		{models.Guest{Email: "JANE@example.com "}, "email"},
+		{models.Guest{Email: "other@example.com", Phone: "(555) 010-2030"}, "phone"},
+		{models.Guest{Email: "other@example.com", Phone: "555 010 2031"}, ""},
+		{models.Guest{}, ""},
+	}
+	for _, tt := range tests {
+		if by := Match(a, tt.b); by != tt.expected {
+			t.Errorf("%+v: expected %q, got %q", tt.b, tt.expected, by)
+		}
+	}
+	if by := Match(models.Guest{Phone: "12"}, models.Guest{Phone: "12"}); by != "" {
+		t.Errorf("expected short phone numbers not to match, got %q", by)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	keep := models.Guest{ID: 1, FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Notes: "Likes the quiet room"}
+	other := models.Guest{ID: 2, FirstName: "J", LastName: "Doe", Email: "jd@example.com", Phone: "555 010 2030", Notes: "Allergic to feathers"}
+
+	merged := Merge(keep, other)
+	if merged.ID != 1 || merged.FirstName != "Jane" || merged.Email != "jane@example.com" {
+		t.Errorf("expected the kept guest's details, got %+v", merged)
+	}
+	if merged.Phone != "555 010 2030" {
+		t.Errorf("expected the phone number to be filled in, got %q", merged.Phone)
+	}
+	if merged.Notes != "Likes the quiet room\n\nAllergic to feathers" {
+		t.Errorf("unexpected notes %q", merged.Notes)
+	}
+
+	if again := Merge(merged, other); again.Notes != merged.Notes {
+		t.Errorf("expected notes not to be repeated, got %q", again.Notes)
+	}
+	if empty := Merge(models.Guest{}, other); empty.FirstName != "J" || empty.Notes != "Allergic to feathers" {
+		t.Errorf("expected a blank guest to take the other's details, got %+v", empty)
+	}
+}
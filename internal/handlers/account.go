@@ -0,0 +1,538 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DmitryZzz/bookings/internal/forms"
+	"github.com/DmitryZzz/bookings/internal/guests"
+	"github.com/DmitryZzz/bookings/internal/helpers"
+	"github.com/DmitryZzz/bookings/internal/models"
+	"github.com/DmitryZzz/bookings/internal/render"
+	"github.com/DmitryZzz/bookings/internal/repository"
+	"github.com/DmitryZzz/bookings/internal/reviews"
+	"github.com/DmitryZzz/bookings/internal/webhooks"
+	"github.com/go-chi/chi/v5"
+)
+
+// minPasswordLength is the shortest password a guest account can have
+const minPasswordLength = 8
+
+// verifyScope is what the link confirming an account's email address is signed
+// for, so that it stops working if the address changes
+func verifyScope(a models.GuestAccount) string {
+	return fmt.Sprintf("verify-account-%d-%s", a.ID, guests.EmailKey(a.Email))
+}
+
+// verifyURL returns the link a guest follows to confirm their email address
+func (m *Repository) verifyURL(a models.GuestAccount) string {
+	return fmt.Sprintf("%s/account/verify/%d?token=%s", m.App.SiteURL, a.ID, helpers.SignedToken(verifyScope(a)))
+}
+
+// changeableOnline reports whether a guest can still change or cancel a booking
+// from their account, which they can until the day they arrive
+func changeableOnline(res models.Reservation, now time.Time) bool {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	return res.StartDate.After(today)
+}
+
+// accountBooking is a reservation as listed in a guest's account
+type accountBooking struct {
+	Reservation models.Reservation
+	Changeable  bool
+}
+
+// guestAccount returns the account of the guest logged in, with ID 0 when
+// there is none
+func (m *Repository) guestAccount(r *http.Request) (models.GuestAccount, error) {
+	id := helpers.GuestAccountID(r)
+	if id == 0 {
+		return models.GuestAccount{}, nil
+	}
+	return m.DB.GetGuestAccountByID(id)
+}
+
+// currentAccount returns the account of the guest logged in, writing a server
+// error or sending them to log in and returning ok false when there isn't one
+func (m *Repository) currentAccount(w http.ResponseWriter, r *http.Request) (a models.GuestAccount, ok bool) {
+	a, err := m.guestAccount(r)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return a, false
+	}
+	if a.ID == 0 {
+		m.App.GuestSession.Remove(r.Context(), "guest_account_id")
+		m.App.Session.Put(r.Context(), "error", "Log in to your account first")
+		http.Redirect(w, r, "/account/login", http.StatusSeeOther)
+		return a, false
+	}
+	return a, true
+}
+
+// AccountRegister shows the form to create a guest account
+func (m *Repository) AccountRegister(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "account-register.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostAccountRegister creates a guest account and emails the guest a link to
+// confirm their email address
+func (m *Repository) PostAccountRegister(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't parse form!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email", "password")
+	form.IsEmail("email")
+	form.MinLength("password", minPasswordLength)
+
+	a := models.GuestAccount{
+		Email: strings.TrimSpace(form.Get("email")),
+		Guest: models.Guest{
+			FirstName: strings.TrimSpace(form.Get("first_name")),
+			LastName:  strings.TrimSpace(form.Get("last_name")),
+			Phone:     strings.TrimSpace(form.Get("phone")),
+		},
+	}
+
+	if form.Valid() {
+		a.ID, err = m.DB.InsertGuestAccount(a, form.Get("password"))
+		if errors.Is(err, repository.ErrAccountExists) {
+			form.Errors.Add("email", "There is already an account for this email address, log in instead")
+		} else if err != nil {
+			log.Println(err)
+			m.App.Session.Put(r.Context(), "error", "can't create account")
+			http.Redirect(w, r, "/account/register", http.StatusSeeOther)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		render.Template(w, r, "account-register.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	m.audit(r, "register", models.AuditGuestAccount, a.ID, nil, a)
+	m.sendAccountVerification(a)
+
+	m.App.Session.Put(r.Context(), "flash", "Check your email for a link to confirm your address, then log in")
+	http.Redirect(w, r, "/account/login", http.StatusSeeOther)
+}
+
+// AccountVerify confirms the email address of an account from the link in the
+// verification email. It doesn't log the guest in: the link keeps working, so
+// whoever else gets hold of it must still know the password.
+func (m *Repository) AccountVerify(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	a, err := m.DB.GetGuestAccountByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if a.ID == 0 || !helpers.ValidToken(verifyScope(a), r.URL.Query().Get("token")) {
+		m.App.Session.Put(r.Context(), "error", "That link isn't valid, log in to get a new one")
+		http.Redirect(w, r, "/account/login", http.StatusSeeOther)
+		return
+	}
+
+	if !a.Verified() {
+		err = m.DB.VerifyGuestAccount(a.ID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		m.audit(r, "verify", models.AuditGuestAccount, a.ID, nil, nil)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Your email address is confirmed, log in to see your bookings")
+	http.Redirect(w, r, "/account/login", http.StatusSeeOther)
+}
+
+// AccountLogin shows the guest login form
+func (m *Repository) AccountLogin(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "account-login.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostAccountLogin logs a guest in to their account, once they have confirmed
+// their email address
+func (m *Repository) PostAccountLogin(w http.ResponseWriter, r *http.Request) {
+	_ = m.App.GuestSession.RenewToken(r.Context())
+
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't parse form!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email", "password")
+	form.IsEmail("email")
+	if !form.Valid() {
+		render.Template(w, r, "account-login.page.tmpl", &models.TemplateData{Form: form})
+		return
+	}
+
+	email := strings.TrimSpace(form.Get("email"))
+	id, err := m.DB.AuthenticateGuest(email, form.Get("password"))
+	if err != nil {
+		m.recordLogin(r, models.AuditGuestAccount, 0, loginAttempt{Email: email, Method: "password", Reason: "wrong email address or password"})
+		m.App.Session.Put(r.Context(), "error", "invalid login credentials")
+		http.Redirect(w, r, "/account/login", http.StatusSeeOther)
+		return
+	}
+
+	a, err := m.DB.GetGuestAccountByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if !a.Verified() {
+		m.recordLogin(r, models.AuditGuestAccount, a.ID, loginAttempt{Email: email, Method: "password", Reason: "email address not confirmed"})
+		m.sendAccountVerification(a)
+		m.App.Session.Put(r.Context(), "error", "Confirm your email address first, we've sent you a new link")
+		http.Redirect(w, r, "/account/login", http.StatusSeeOther)
+		return
+	}
+
+	m.App.GuestSession.Put(r.Context(), "guest_account_id", a.ID)
+	m.recordLogin(r, models.AuditGuestAccount, a.ID, loginAttempt{Email: email, Method: "password"})
+	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// AccountLogout logs a guest out of their account, leaving any staff login in
+// the same browser alone
+func (m *Repository) AccountLogout(w http.ResponseWriter, r *http.Request) {
+	_ = m.App.GuestSession.Destroy(r.Context())
+
+	m.App.Session.Put(r.Context(), "flash", "Logged out")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// Account shows a guest their upcoming and past bookings and their contact
+// details
+func (m *Repository) Account(w http.ResponseWriter, r *http.Request) {
+	a, ok := m.currentAccount(w, r)
+	if !ok {
+		return
+	}
+
+	form := forms.New(url.Values{})
+	form.Set("first_name", a.Guest.FirstName)
+	form.Set("last_name", a.Guest.LastName)
+	form.Set("phone", a.Guest.Phone)
+
+	m.renderAccount(w, r, a, form)
+}
+
+func (m *Repository) renderAccount(w http.ResponseWriter, r *http.Request, a models.GuestAccount, form *forms.Form) {
+	reservations, err := m.DB.GuestReservations(a.GuestID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// upcoming stays are listed soonest first, after the latest first order
+	// they come in
+	now := time.Now()
+	var upcoming, past []accountBooking
+	for _, res := range reservations {
+		if !ownBooking(a, res) {
+			continue
+		}
+		b := accountBooking{Reservation: res, Changeable: changeableOnline(res, now)}
+		if reviews.StayOver(res, now) {
+			past = append(past, b)
+		} else {
+			upcoming = append([]accountBooking{b}, upcoming...)
+		}
+	}
+
+	data := make(map[string]interface{})
+	data["account"] = a
+	data["upcoming"] = upcoming
+	data["past"] = past
+
+	render.Template(w, r, "account.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// PostAccountDetails saves the contact details of the guest logged in, which
+// are filled in for them when they book
+func (m *Repository) PostAccountDetails(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	a, ok := m.currentAccount(w, r)
+	if !ok {
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name")
+	if !form.Valid() {
+		m.renderAccount(w, r, a, form)
+		return
+	}
+
+	g, err := m.DB.GetGuestByID(a.GuestID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	before := g
+	g.FirstName = strings.TrimSpace(form.Get("first_name"))
+	g.LastName = strings.TrimSpace(form.Get("last_name"))
+	g.Phone = strings.TrimSpace(form.Get("phone"))
+
+	err = m.DB.UpdateGuest(g)
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "can't save your details")
+		http.Redirect(w, r, "/account", http.StatusSeeOther)
+		return
+	}
+
+	before.Stats, g.Stats = models.GuestStats{}, models.GuestStats{}
+	m.audit(r, "update", models.AuditGuest, g.ID, before, g)
+
+	m.App.Session.Put(r.Context(), "flash", "Your details are saved")
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// ownBooking reports whether a reservation was made by the guest with an
+// account. Reservations are linked to a guest by email or by phone, so the
+// reservation's email address must be the account's too: a stay linked by a
+// shared or mistyped phone number alone is someone else's.
+func ownBooking(a models.GuestAccount, res models.Reservation) bool {
+	return res.GuestID == a.GuestID && guests.EmailKey(res.Email) == guests.EmailKey(a.Email)
+}
+
+// accountBookingByURL returns the booking with the id in the url if it's the
+// logged in guest's own and they can still change it, otherwise writing an
+// error and returning ok false
+func (m *Repository) accountBookingByURL(w http.ResponseWriter, r *http.Request, a models.GuestAccount) (res models.Reservation, ok bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return res, false
+	}
+
+	res, err = m.DB.GetReservationByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		// cancelled, perhaps in another tab
+		helpers.ClientError(w, http.StatusNotFound)
+		return res, false
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return res, false
+	}
+	if res.ID == 0 || !ownBooking(a, res) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return res, false
+	}
+
+	if !changeableOnline(res, time.Now()) {
+		m.App.Session.Put(r.Context(), "error", "This booking can no longer be changed online, please contact us")
+		http.Redirect(w, r, "/account", http.StatusSeeOther)
+		return res, false
+	}
+	return res, true
+}
+
+// AccountBooking shows a guest the form to change the dates of one of their
+// upcoming bookings
+func (m *Repository) AccountBooking(w http.ResponseWriter, r *http.Request) {
+	a, ok := m.currentAccount(w, r)
+	if !ok {
+		return
+	}
+	res, ok := m.accountBookingByURL(w, r, a)
+	if !ok {
+		return
+	}
+
+	form := forms.New(url.Values{})
+	form.Set("start_date", res.StartDate.Format("2006-01-02"))
+	form.Set("end_date", res.EndDate.Format("2006-01-02"))
+	form.Set("version", strconv.Itoa(res.Version))
+
+	m.renderAccountBooking(w, r, res, form)
+}
+
+func (m *Repository) renderAccountBooking(w http.ResponseWriter, r *http.Request, res models.Reservation, form *forms.Form) {
+	room, err := m.DB.GetRoomByID(res.RoomID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	res.Room = room
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+
+	render.Template(w, r, "account-booking.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// PostAccountBooking moves one of the logged in guest's bookings to new dates
+// in the same room, if it's free, and sends them the updated booking
+func (m *Repository) PostAccountBooking(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	a, ok := m.currentAccount(w, r)
+	if !ok {
+		return
+	}
+	res, ok := m.accountBookingByURL(w, r, a)
+	if !ok {
+		return
+	}
+	original := res
+	back := fmt.Sprintf("/account/bookings/%d", res.ID)
+
+	// the version the booking was shown with must be posted, so that saving
+	// can't overwrite changes made since
+	res.Version, err = strconv.Atoi(r.Form.Get("version"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	layout := "2006-01-02"
+
+	res.StartDate, err = time.Parse(layout, form.Get("start_date"))
+	if err != nil {
+		form.Errors.Add("start_date", "Enter the arrival date")
+	}
+	res.EndDate, err = time.Parse(layout, form.Get("end_date"))
+	if err != nil {
+		form.Errors.Add("end_date", "Enter the departure date")
+	}
+	if form.Valid() {
+		switch {
+		case !changeableOnline(res, time.Now()):
+			form.Errors.Add("start_date", "The arrival must be after today")
+		case !res.EndDate.After(res.StartDate):
+			form.Errors.Add("end_date", "The departure must be after the arrival")
+		}
+	}
+
+	if form.Valid() {
+		room, err := m.DB.GetRoomByID(res.RoomID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		res.Room = room
+		res.Price = room.PriceFor(res.StartDate, res.EndDate)
+
+		conflicts, err := m.reservationConflicts(res)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		if len(conflicts) > 0 {
+			form.Errors.Add("start_date", "The room isn't available for these dates")
+		}
+	}
+
+	if !form.Valid() {
+		m.renderAccountBooking(w, r, original, form)
+		return
+	}
+
+	err = m.DB.UpdateReservation(res)
+	if errors.Is(err, repository.ErrUnavailable) {
+		form.Errors.Add("start_date", "The room isn't available for these dates")
+		m.renderAccountBooking(w, r, original, form)
+		return
+	}
+	if errors.Is(err, repository.ErrStale) {
+		m.App.Session.Put(r.Context(), "error", "Your booking was changed in the meantime, check its dates and try again")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "can't change booking")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	m.emitEvent(webhooks.ReservationUpdated, webhooks.NewReservation(res))
+	m.audit(r, "update", models.AuditReservation, res.ID, original, res)
+	if res.Email != "" {
+		m.sendReservationChanged(res)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Your booking has been changed")
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// PostAccountCancelBooking cancels one of the logged in guest's upcoming
+// bookings, which goes to the trash like those staff delete
+func (m *Repository) PostAccountCancelBooking(w http.ResponseWriter, r *http.Request) {
+	a, ok := m.currentAccount(w, r)
+	if !ok {
+		return
+	}
+	res, ok := m.accountBookingByURL(w, r, a)
+	if !ok {
+		return
+	}
+
+	err := m.DB.DeleteReservation(res.ID, 0)
+	if errors.Is(err, repository.ErrNotFound) {
+		m.App.Session.Put(r.Context(), "error", "This booking has been cancelled already")
+		http.Redirect(w, r, "/account", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "can't cancel booking")
+		http.Redirect(w, r, "/account", http.StatusSeeOther)
+		return
+	}
+
+	m.emitEvent(webhooks.ReservationCancelled, webhooks.NewReservation(res))
+	m.audit(r, "cancel", models.AuditReservation, res.ID, res, nil)
+	if res.Email != "" {
+		m.sendReservationCancelled(res)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Your booking has been cancelled")
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
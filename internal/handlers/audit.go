@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DmitryZzz/bookings/internal/audit"
+	"github.com/DmitryZzz/bookings/internal/helpers"
+	"github.com/DmitryZzz/bookings/internal/models"
+	"github.com/DmitryZzz/bookings/internal/render"
+)
+
+const (
+	// auditLogSize is how many entries the audit log page shows
+	auditLogSize = 200
+	// auditDateLayout is the format of dates in the audit log filters
+	auditDateLayout = "2006-01-02"
+)
+
+// auditEntities are the kinds of record the audit log can be filtered by
+var auditEntities = []string{
+	models.AuditReservation,
+	models.AuditRoom,
+	models.AuditRoomPhoto,
+	models.AuditRoomRestriction,
+	models.AuditBlock,
+	models.AuditRestriction,
+	models.AuditICalFeed,
+	models.AuditCalendarFeed,
+	models.AuditWebhook,
+	models.AuditWebhookDelivery,
+	models.AuditMail,
+	models.AuditEmailTemplate,
+	models.AuditMessageSchedule,
+	models.AuditReview,
+	models.AuditEnquiry,
+	models.AuditGuest,
+	models.AuditGuestAccount,
+	models.AuditUser,
+}
+
+// audit records a change in the audit log, with the record as json before and
+// after it. Either can be nil. Changes made under /admin and the staff login at
+// /user are put down to staff, the rest to guests, as are all those made with
+// the guest session, even in a browser where staff are logged in too. Failures
+// are logged and never fail the request.
+func (m *Repository) audit(r *http.Request, action, entity string, entityID int, before, after interface{}) {
+	e := models.AuditEntry{
+		UserID:   m.App.Session.GetInt(r.Context(), "user_id"),
+		Actor:    models.ActorGuest,
+		Action:   action,
+		Entity:   entity,
+		EntityID: entityID,
+		IP:       clientIP(r),
+	}
+	if helpers.IsGuestRequest(r) {
+		e.UserID = 0
+	} else if e.UserID > 0 || strings.HasPrefix(r.URL.Path, "/admin/") || strings.HasPrefix(r.URL.Path, "/user/") {
+		e.Actor = models.ActorStaff
+	}
+
+	err := audit.Record(m.DB, e, before, after)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+	}
+}
+
+// clientIP returns the address a request came from, without its port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// AdminAuditLog shows the newest changes, filtered by ?user=, ?entity=, ?id=
+// and the days ?from= and ?to=, like 2026-11-01
+func (m *Repository) AdminAuditLog(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	var f models.AuditFilter
+	f.UserID, _ = strconv.Atoi(q.Get("user"))
+	f.Entity = q.Get("entity")
+	f.EntityID, _ = strconv.Atoi(q.Get("id"))
+	if d, err := time.Parse(auditDateLayout, q.Get("from")); err == nil {
+		f.From = d
+	}
+	if d, err := time.Parse(auditDateLayout, q.Get("to")); err == nil {
+		f.To = d.AddDate(0, 0, 1)
+	}
+
+	entries, err := m.DB.AuditLog(f, auditLogSize)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	users, err := m.DB.AuditUsers()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["user"] = q.Get("user")
+	stringMap["entity"] = f.Entity
+	stringMap["id"] = q.Get("id")
+	stringMap["from"] = q.Get("from")
+	stringMap["to"] = q.Get("to")
+
+	data := make(map[string]interface{})
+	data["entries"] = entries
+	data["users"] = users
+	data["entities"] = auditEntities
+
+	render.Template(w, r, "admin-audit.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
@@ -0,0 +1,357 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DmitryZzz/bookings/internal/blocks"
+	"github.com/DmitryZzz/bookings/internal/forms"
+	"github.com/DmitryZzz/bookings/internal/helpers"
+	"github.com/DmitryZzz/bookings/internal/models"
+	"github.com/DmitryZzz/bookings/internal/render"
+	"github.com/DmitryZzz/bookings/internal/repository"
+	"github.com/DmitryZzz/bookings/internal/webhooks"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// blockDateLayout is the format of dates in the block form
+	blockDateLayout = "2006-01-02"
+	// maxBlockReasonLength fits the reason column
+	maxBlockReasonLength = 100
+)
+
+// weekdayOption is a day of the week in the block form
+type weekdayOption struct {
+	Value int
+	Name  string
+}
+
+// weekdayOptions lists the days of the week, starting on Monday
+func weekdayOptions() []weekdayOption {
+	var options []weekdayOption
+	for i := 1; i <= 7; i++ {
+		d := time.Weekday(i % 7)
+		options = append(options, weekdayOption{Value: int(d), Name: d.String()})
+	}
+	return options
+}
+
+// AdminBlocks lists the owner blocks
+func (m *Repository) AdminBlocks(w http.ResponseWriter, r *http.Request) {
+	all, err := m.DB.AllBlocks()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	descriptions := make(map[int]string)
+	for _, b := range all {
+		descriptions[b.ID] = blocks.Describe(b)
+	}
+
+	data := make(map[string]interface{})
+	data["blocks"] = all
+	data["descriptions"] = descriptions
+
+	render.Template(w, r, "admin-blocks.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminBlock shows the form to add an owner block, or edit the block with the
+// id in the url. A new block can start from ?room= and ?start= in the url.
+func (m *Repository) AdminBlock(w http.ResponseWriter, r *http.Request) {
+	var b models.Block
+	if idParam := chi.URLParam(r, "id"); idParam != "new" {
+		id, err := strconv.Atoi(idParam)
+		if err != nil {
+			helpers.ClientError(w, http.StatusNotFound)
+			return
+		}
+
+		b, err = m.DB.GetBlockByID(id)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		if b.ID == 0 {
+			helpers.ClientError(w, http.StatusNotFound)
+			return
+		}
+	}
+
+	form := forms.New(url.Values{})
+	if b.ID > 0 {
+		form.Set("reason", b.Reason)
+		form.Set("restriction_id", strconv.Itoa(b.RestrictionID))
+		form.Set("start_date", b.StartDate.Format(blockDateLayout))
+		form.Set("last_night", b.LastNight().Format(blockDateLayout))
+		for _, roomID := range b.RoomIDs {
+			form.Add("room_id", strconv.Itoa(roomID))
+		}
+		for _, d := range b.Weekdays {
+			form.Add("weekday", strconv.Itoa(int(d)))
+		}
+	} else {
+		ownerID, err := m.restrictionID(models.OwnerRestriction)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		form.Set("restriction_id", strconv.Itoa(ownerID))
+		form.Set("start_date", r.URL.Query().Get("start"))
+		form.Set("last_night", r.URL.Query().Get("start"))
+		if room := r.URL.Query().Get("room"); room != "" {
+			form.Set("room_id", room)
+		}
+	}
+
+	conflicts, _ := m.App.Session.Pop(r.Context(), "block_conflicts").([]string)
+
+	m.renderAdminBlock(w, r, b, form, conflicts)
+}
+
+func (m *Repository) renderAdminBlock(w http.ResponseWriter, r *http.Request, b models.Block, form *forms.Form, conflicts []string) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	selectedRooms := make(map[string]bool)
+	for _, v := range form.Values["room_id"] {
+		selectedRooms[v] = true
+	}
+	selectedDays := make(map[string]bool)
+	for _, v := range form.Values["weekday"] {
+		selectedDays[v] = true
+	}
+
+	restrictionTypes, err := m.DB.AllRestrictions()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	var blockTypes []models.Restriction
+	for _, t := range restrictionTypes {
+		if t.Code != models.ReservationRestriction {
+			blockTypes = append(blockTypes, t)
+		}
+	}
+
+	intMap := make(map[string]int)
+	intMap["id"] = b.ID
+
+	data := make(map[string]interface{})
+	data["block"] = b
+	data["rooms"] = rooms
+	data["block_types"] = blockTypes
+	data["weekdays"] = weekdayOptions()
+	data["selected_rooms"] = selectedRooms
+	data["selected_days"] = selectedDays
+	data["conflicts"] = conflicts
+
+	render.Template(w, r, "admin-block.page.tmpl", &models.TemplateData{
+		IntMap: intMap,
+		Data:   data,
+		Form:   form,
+	})
+}
+
+// AdminPostBlock saves an owner block across the chosen rooms. Nights that are
+// already booked are left out and reported.
+func (m *Repository) AdminPostBlock(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	var existing models.Block
+	if idParam := chi.URLParam(r, "id"); idParam != "new" {
+		id, err := strconv.Atoi(idParam)
+		if err != nil {
+			helpers.ClientError(w, http.StatusNotFound)
+			return
+		}
+
+		existing, err = m.DB.GetBlockByID(id)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		if existing.ID == 0 {
+			helpers.ClientError(w, http.StatusNotFound)
+			return
+		}
+	}
+
+	form := forms.New(r.PostForm)
+	b := models.Block{
+		ID:     existing.ID,
+		Reason: strings.TrimSpace(form.Get("reason")),
+	}
+
+	b.RestrictionID, err = m.blockRestrictionID(form.Get("restriction_id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	for _, v := range form.Values["room_id"] {
+		roomID, err := strconv.Atoi(v)
+		if err != nil {
+			continue
+		}
+		b.RoomIDs = append(b.RoomIDs, roomID)
+	}
+	if len(b.RoomIDs) == 0 {
+		form.Errors.Add("room_id", "Choose the rooms to block")
+	}
+
+	start, err := time.Parse(blockDateLayout, form.Get("start_date"))
+	if err != nil {
+		form.Errors.Add("start_date", "Enter the first night to block")
+	}
+	lastNight, err := time.Parse(blockDateLayout, form.Get("last_night"))
+	if err != nil {
+		form.Errors.Add("last_night", "Enter the last night to block")
+	} else if lastNight.Before(start) {
+		form.Errors.Add("last_night", "The last night can't be before the first")
+	} else if lastNight.Sub(start) >= blocks.MaxNights*24*time.Hour {
+		form.Errors.Add("last_night", fmt.Sprintf("A block can span at most %d nights", blocks.MaxNights))
+	}
+	b.StartDate = start
+	b.EndDate = lastNight.AddDate(0, 0, 1)
+
+	if len(b.Reason) > maxBlockReasonLength {
+		form.Errors.Add("reason", fmt.Sprintf("Keep the reason under %d characters", maxBlockReasonLength))
+	}
+
+	b.Weekdays = blocks.ParseWeekdays(strings.Join(form.Values["weekday"], ","))
+	if form.Valid() && b.Repeats() && len(blocks.Ranges(b)) == 0 {
+		form.Errors.Add("weekday", "None of these days fall between the first and last night")
+	}
+
+	if !form.Valid() {
+		m.renderAdminBlock(w, r, existing, form, nil)
+		return
+	}
+
+	booked := make(map[int][]models.RoomRestriction)
+	for _, roomID := range b.RoomIDs {
+		restrictions, err := m.DB.GetRestrictionsForRoomByDate(roomID, b.StartDate, b.EndDate)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		booked[roomID] = restrictions
+	}
+
+	restrictions, conflicts := blocks.Plan(b, booked)
+	if len(restrictions) == 0 {
+		form.Errors.Add("start_date", "Every one of these nights is already booked")
+		m.renderAdminBlock(w, r, existing, form, m.describeConflicts(conflicts))
+		return
+	}
+
+	id, restrictionIDs, err := m.DB.SaveBlock(b, restrictions)
+	if errors.Is(err, repository.ErrUnavailable) {
+		form.Errors.Add("start_date", "Some of these nights were booked while you were saving, save again to block the rest")
+		m.renderAdminBlock(w, r, existing, form, nil)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "can't save block")
+		http.Redirect(w, r, "/admin/blocks", http.StatusSeeOther)
+		return
+	}
+
+	b.ID = id
+	if existing.ID > 0 {
+		m.audit(r, "update", models.AuditBlock, id, existing, b)
+	} else {
+		m.audit(r, "create", models.AuditBlock, id, nil, b)
+	}
+
+	for _, old := range existing.Restrictions {
+		m.emitEvent(webhooks.BlockDeleted, webhooks.NewBlock(old.ID, old.RoomID, old.StartDate, old.EndDate))
+	}
+	for i, restriction := range restrictions {
+		m.emitEvent(webhooks.BlockCreated, webhooks.NewBlock(restrictionIDs[i], restriction.RoomID, restriction.StartDate, restriction.EndDate))
+	}
+
+	if len(conflicts) > 0 {
+		m.App.Session.Put(r.Context(), "block_conflicts", m.describeConflicts(conflicts))
+		m.App.Session.Put(r.Context(), "error", "Block saved, except for nights that are already booked")
+	} else {
+		m.App.Session.Put(r.Context(), "flash", "Block saved")
+	}
+	http.Redirect(w, r, fmt.Sprintf("/admin/blocks/%d", id), http.StatusSeeOther)
+}
+
+// describeConflicts says which booked nights a block had to leave out, e.g.
+// "General's Quarters: 2 nights from 2026-11-04, reservation 11"
+func (m *Repository) describeConflicts(conflicts []blocks.Conflict) []string {
+	roomNames := make(map[int]string)
+	if rooms, err := m.DB.AllRooms(); err == nil {
+		for _, room := range rooms {
+			roomNames[room.ID] = room.RoomName
+		}
+	}
+
+	var out []string
+	for _, c := range conflicts {
+		name, ok := roomNames[c.RoomID]
+		if !ok {
+			name = fmt.Sprintf("Room %d", c.RoomID)
+		}
+		nights := "1 night"
+		if n := c.Nights(); n != 1 {
+			nights = fmt.Sprintf("%d nights", n)
+		}
+		out = append(out, fmt.Sprintf("%s: %s from %s, reservation %d", name, nights, c.Start.Format(blockDateLayout), c.ReservationID))
+	}
+	return out
+}
+
+// AdminDeleteBlock deletes an owner block with all of its nights
+func (m *Repository) AdminDeleteBlock(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	b, err := m.DB.GetBlockByID(id)
+	if err != nil {
+		log.Println(err)
+	}
+
+	err = m.DB.DeleteBlock(id, m.App.Session.GetInt(r.Context(), "user_id"))
+	if errors.Is(err, repository.ErrNotFound) {
+		m.App.Session.Put(r.Context(), "error", "The block has been deleted already")
+		http.Redirect(w, r, "/admin/blocks", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "can't delete block")
+		http.Redirect(w, r, "/admin/blocks", http.StatusSeeOther)
+		return
+	}
+
+	m.audit(r, "delete", models.AuditBlock, id, b, nil)
+
+	for _, restriction := range b.Restrictions {
+		m.emitEvent(webhooks.BlockDeleted, webhooks.NewBlock(restriction.ID, restriction.RoomID, restriction.StartDate, restriction.EndDate))
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Block moved to the trash")
+	http.Redirect(w, r, "/admin/blocks", http.StatusSeeOther)
+}
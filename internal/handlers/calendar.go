@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/DmitryZzz/bookings/internal/calendar"
+	"github.com/DmitryZzz/bookings/internal/forms"
+	"github.com/DmitryZzz/bookings/internal/models"
+	"github.com/DmitryZzz/bookings/internal/render"
+)
+
+// buildCalendar lays out every room's nights from start up to end, loading all
+// of their restrictions at once
+func (m *Repository) buildCalendar(start, end time.Time) (calendar.Calendar, error) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		return calendar.Calendar{}, err
+	}
+
+	types, err := m.DB.AllRestrictions()
+	if err != nil {
+		return calendar.Calendar{}, err
+	}
+
+	restrictions, err := m.DB.GetRestrictionsByDate(start, end)
+	if err != nil {
+		return calendar.Calendar{}, err
+	}
+
+	return calendar.Build(start, end, rooms, types, restrictions), nil
+}
+
+// calendarError is the body of an error response from the calendar api
+type calendarError struct {
+	Error string `json:"error"`
+}
+
+// AdminCalendarJSON returns the nights of every room as json, from ?start= up to
+// ?end=, both like 2026-11-01. It defaults to the current month.
+func (m *Repository) AdminCalendarJSON(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+
+	var err error
+	if value := r.URL.Query().Get("start"); value != "" {
+		start, err = time.Parse(calendar.DateLayout, value)
+		if err != nil {
+			writeCalendarJSON(w, http.StatusBadRequest, calendarError{"start must be a date like 2026-11-01"})
+			return
+		}
+		end = start.AddDate(0, 1, 0)
+	}
+	if value := r.URL.Query().Get("end"); value != "" {
+		end, err = time.Parse(calendar.DateLayout, value)
+		if err != nil {
+			writeCalendarJSON(w, http.StatusBadRequest, calendarError{"end must be a date like 2026-12-01"})
+			return
+		}
+	}
+
+	if !end.After(start) {
+		writeCalendarJSON(w, http.StatusBadRequest, calendarError{"end must be after start"})
+		return
+	}
+	if end.Sub(start) > calendar.MaxDays*24*time.Hour {
+		writeCalendarJSON(w, http.StatusBadRequest, calendarError{fmt.Sprintf("a calendar can cover at most %d days", calendar.MaxDays)})
+		return
+	}
+
+	cal, err := m.buildCalendar(start, end)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		writeCalendarJSON(w, http.StatusInternalServerError, calendarError{"can't load the calendar"})
+		return
+	}
+
+	writeCalendarJSON(w, http.StatusOK, cal)
+}
+
+func writeCalendarJSON(w http.ResponseWriter, status int, v interface{}) {
+	out, _ := json.MarshalIndent(v, "", "     ")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
+}
+
+// calendarChange is a night the calendar form asks to block or open
+type calendarChange struct {
+	cell  calendar.Cell
+	block bool
+}
+
+// calendarChanges returns the nights of a room the posted calendar changes.
+// A night is blocked when its add_block box is ticked, and opened when it was
+// shown as a block, with a block field, and its remove_block box was cleared.
+func calendarChanges(form *forms.Form, row calendar.Row) []calendarChange {
+	var changes []calendarChange
+	for _, c := range row.Days {
+		d, _ := time.Parse(calendar.DateLayout, c.Date)
+		key := fmt.Sprintf("%d_%s", row.RoomID, d.Format("2006-01-2"))
+		switch {
+		case form.Has("add_block_" + key):
+			changes = append(changes, calendarChange{cell: c, block: true})
+		case form.Has("block_"+key) && !form.Has("remove_block_"+key):
+			changes = append(changes, calendarChange{cell: c, block: false})
+		}
+	}
+	return changes
+}
+
+// nightConflict is a night an admin changed that someone else changed first
+type nightConflict struct {
+	Date   string
+	Change string
+	Now    string
+}
+
+// roomConflict is the nights of a room that couldn't be saved
+type roomConflict struct {
+	RoomID   int
+	RoomName string
+	Nights   []nightConflict
+}
+
+// describeCalendarConflict sets the changes to a room next to what its nights
+// are now
+func (m *Repository) describeCalendarConflict(cal calendar.Calendar, row calendar.Row, changes []calendarChange) roomConflict {
+	types := make(map[int]calendar.Type)
+	for _, t := range cal.Types {
+		types[t.ID] = t
+	}
+
+	conflict := roomConflict{RoomID: row.RoomID, RoomName: row.RoomName}
+	for _, c := range changes {
+		change := "Open the night"
+		if c.block {
+			change = "Block the night"
+		}
+		conflict.Nights = append(conflict.Nights, nightConflict{
+			Date:   c.cell.Date,
+			Change: change,
+			Now:    describeCell(c.cell, types),
+		})
+	}
+	return conflict
+}
+
+// describeCell says what takes a night, e.g. "Reservation 7"
+func describeCell(c calendar.Cell, types map[int]calendar.Type) string {
+	switch c.Kind {
+	case calendar.KindReservation:
+		return fmt.Sprintf("Reservation %d", c.ReservationID)
+	case calendar.KindExternal:
+		return "Booked on an external channel"
+	case calendar.KindBlock:
+		name := types[c.TypeID].Name
+		if name == "" {
+			name = "Blocked"
+		}
+		if c.Reason != "" {
+			return fmt.Sprintf("%s: %s", name, c.Reason)
+		}
+		return name
+	}
+	return "Free"
+}
+
+// renderCalendarConflict shows the calendar changes that weren't saved, with a
+// form to save them over the calendar as it is now, and names the rooms whose
+// changes were saved and those that failed
+func (m *Repository) renderCalendarConflict(w http.ResponseWriter, r *http.Request, cal calendar.Calendar, form *forms.Form, conflicts []roomConflict, saved, failed []string) {
+	posted := url.Values{}
+	for name, values := range form.Values {
+		if name == "csrf_token" || strings.HasPrefix(name, "version_") {
+			continue
+		}
+		posted[name] = values
+	}
+
+	versions := make(map[int]string)
+	for _, row := range cal.Rooms {
+		versions[row.RoomID] = row.Version
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["y"] = form.Get("y")
+	stringMap["m"] = form.Get("m")
+	stringMap["saved"] = strings.Join(saved, ", ")
+	stringMap["failed"] = strings.Join(failed, ", ")
+
+	data := make(map[string]interface{})
+	data["conflicts"] = conflicts
+	data["posted"] = posted
+	data["versions"] = versions
+
+	w.WriteHeader(http.StatusConflict)
+	render.Template(w, r, "admin-calendar-conflict.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/DmitryZzz/bookings/internal/emails"
+	"github.com/DmitryZzz/bookings/internal/forms"
+	"github.com/DmitryZzz/bookings/internal/helpers"
+	"github.com/DmitryZzz/bookings/internal/models"
+	"github.com/DmitryZzz/bookings/internal/render"
+	"github.com/go-chi/chi/v5"
+)
+
+// AdminEmailTemplates lists the emails the owner can reword
+func (m *Repository) AdminEmailTemplates(w http.ResponseWriter, r *http.Request) {
+	overrides, err := m.DB.AllEmailTemplates()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	customized := make(map[string]bool)
+	for _, o := range overrides {
+		customized[o.Name] = true
+	}
+
+	data := make(map[string]interface{})
+	data["messages"] = emails.Messages
+	data["customized"] = customized
+
+	render.Template(w, r, "admin-email-templates.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminEmailTemplate shows the editor for one email, filled in with the saved
+// override or the default wording
+func (m *Repository) AdminEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	msg, ok := emails.FindMessage(chi.URLParam(r, "name"))
+	if !ok {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	o, err := m.DB.GetEmailTemplate(msg.Name)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if o.ID == 0 {
+		o, err = emails.Default(msg.Name)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	form := forms.New(url.Values{
+		"subject": {o.Subject},
+		"body":    {o.Body},
+		"text":    {o.Text},
+	})
+
+	m.renderEmailTemplate(w, r, msg, form)
+}
+
+// emailTemplateFromForm reads an override from the editor form
+func emailTemplateFromForm(name string, form *forms.Form) models.EmailTemplate {
+	return models.EmailTemplate{
+		Name:    name,
+		Subject: form.Get("subject"),
+		Body:    form.Get("body"),
+		Text:    form.Get("text"),
+	}
+}
+
+// renderEmailTemplate shows the editor with a preview of the form's contents,
+// or the reason they can't be rendered
+func (m *Repository) renderEmailTemplate(w http.ResponseWriter, r *http.Request, msg emails.Message, form *forms.Form) {
+	saved, err := m.DB.GetEmailTemplate(msg.Name)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	stringMap := make(map[string]string)
+
+	preview, err := emails.Validate(emailTemplateFromForm(msg.Name, form), msg.Sample)
+	if err != nil {
+		stringMap["preview_error"] = err.Error()
+	} else {
+		stringMap["preview_subject"] = preview.Subject
+		stringMap["preview_html"] = preview.HTML
+		stringMap["preview_text"] = preview.Text
+	}
+
+	data := make(map[string]interface{})
+	data["message"] = msg
+	data["customized"] = saved.ID > 0
+
+	render.Template(w, r, "admin-email-template.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		Form:      form,
+	})
+}
+
+// AdminPostEmailTemplate validates and saves an override
+func (m *Repository) AdminPostEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	msg, ok := emails.FindMessage(chi.URLParam(r, "name"))
+	if !ok {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("subject", "body")
+
+	o := emailTemplateFromForm(msg.Name, form)
+	if form.Valid() {
+		if _, err := emails.Validate(o, msg.Sample); err != nil {
+			form.Errors.Add("body", err.Error())
+		}
+	}
+
+	if !form.Valid() {
+		m.renderEmailTemplate(w, r, msg, form)
+		return
+	}
+
+	before, err := m.DB.GetEmailTemplate(msg.Name)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+	}
+
+	err = m.DB.SaveEmailTemplate(o)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't save email template")
+		http.Redirect(w, r, "/admin/email-templates/"+msg.Name, http.StatusSeeOther)
+		return
+	}
+
+	m.audit(r, "update", models.AuditEmailTemplate, 0, before, o)
+
+	m.App.Session.Put(r.Context(), "flash", "Email template saved")
+	http.Redirect(w, r, "/admin/email-templates/"+msg.Name, http.StatusSeeOther)
+}
+
+type emailPreviewJSON struct {
+	OK      bool   `json:"ok"`
+	Error   string `json:"error,omitempty"`
+	Subject string `json:"subject,omitempty"`
+	HTML    string `json:"html,omitempty"`
+	Text    string `json:"text,omitempty"`
+}
+
+// AdminPreviewEmailTemplate renders the editor's contents with sample data and returns JSON
+func (m *Repository) AdminPreviewEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	msg, ok := emails.FindMessage(chi.URLParam(r, "name"))
+	if !ok {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	var resp emailPreviewJSON
+
+	err := r.ParseForm()
+	if err != nil {
+		resp.Error = "Can't parse form"
+	} else {
+		preview, err := emails.Validate(emailTemplateFromForm(msg.Name, forms.New(r.PostForm)), msg.Sample)
+		if err != nil {
+			resp.Error = err.Error()
+		} else {
+			resp = emailPreviewJSON{
+				OK:      true,
+				Subject: preview.Subject,
+				HTML:    preview.HTML,
+				Text:    preview.Text,
+			}
+		}
+	}
+
+	out, _ := json.MarshalIndent(resp, "", "     ")
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+// AdminTestEmailTemplate sends the editor's contents, rendered with sample data,
+// to the logged in user through the mail outbox
+func (m *Repository) AdminTestEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	msg, ok := emails.FindMessage(chi.URLParam(r, "name"))
+	if !ok {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("subject", "body")
+
+	var email emails.Email
+	if form.Valid() {
+		email, err = emails.Validate(emailTemplateFromForm(msg.Name, form), msg.Sample)
+		if err != nil {
+			form.Errors.Add("body", err.Error())
+		}
+	}
+
+	if form.Valid() {
+		user, err := m.DB.GetUserByID(m.App.Session.GetInt(r.Context(), "user_id"))
+		if err != nil || user.Email == "" {
+			m.App.Session.Put(r.Context(), "error", "can't find your email address")
+		} else {
+			email.Subject = "[Test] " + email.Subject
+			m.queueMail(email.Mail(m.App.MailFrom, user.Email))
+			m.App.Session.Put(r.Context(), "flash", "Test email queued for "+user.Email)
+		}
+	}
+
+	// show the editor again, keeping any unsaved changes
+	m.renderEmailTemplate(w, r, msg, form)
+}
+
+// AdminResetEmailTemplate deletes an override, going back to the default wording
+func (m *Repository) AdminResetEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	msg, ok := emails.FindMessage(chi.URLParam(r, "name"))
+	if !ok {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	before, err := m.DB.GetEmailTemplate(msg.Name)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+	}
+
+	err = m.DB.DeleteEmailTemplate(msg.Name)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't reset email template")
+	} else {
+		m.audit(r, "reset", models.AuditEmailTemplate, 0, before, nil)
+		m.App.Session.Put(r.Context(), "flash", "Email template reset to the default")
+	}
+
+	http.Redirect(w, r, "/admin/email-templates/"+msg.Name, http.StatusSeeOther)
+}
@@ -0,0 +1,307 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/DmitryZzz/bookings/internal/emails"
+	"github.com/DmitryZzz/bookings/internal/forms"
+	"github.com/DmitryZzz/bookings/internal/helpers"
+	"github.com/DmitryZzz/bookings/internal/models"
+	"github.com/DmitryZzz/bookings/internal/render"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// maxEnquiryLength limits how much can be sent through the contact form
+	maxEnquiryLength = 5000
+	// honeypotField is hidden from people; bots that fill in every field give themselves away
+	honeypotField = "website"
+)
+
+// PostContact saves an enquiry from the contact form and lets the owner know
+func (m *Repository) PostContact(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if r.Form.Get(honeypotField) != "" {
+		// look like it worked, so the bot doesn't try again
+		m.App.InfoLog.Println("contact form honeypot filled in from", r.RemoteAddr)
+		m.App.Session.Put(r.Context(), "flash", "Thank you! We'll get back to you soon.")
+		http.Redirect(w, r, "/contact", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "email", "message")
+	if form.Has("email") {
+		form.IsEmail("email")
+	}
+	if len(form.Get("message")) > maxEnquiryLength {
+		form.Errors.Add("message", fmt.Sprintf("Please keep your message under %d characters", maxEnquiryLength))
+	}
+
+	if !form.Valid() {
+		render.Template(w, r, "contact.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	enquiry := models.Enquiry{
+		Name:    strings.TrimSpace(form.Get("name")),
+		Email:   strings.TrimSpace(form.Get("email")),
+		Phone:   strings.TrimSpace(form.Get("phone")),
+		Message: strings.TrimSpace(form.Get("message")),
+	}
+
+	enquiry.ID, err = m.DB.InsertEnquiry(enquiry)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't send your message, please try again")
+		http.Redirect(w, r, "/contact", http.StatusSeeOther)
+		return
+	}
+
+	m.audit(r, "create", models.AuditEnquiry, enquiry.ID, nil, enquiry)
+
+	email, err := emails.EnquiryNotification(enquiry, fmt.Sprintf("%s/admin/enquiries/%d", m.App.SiteURL, enquiry.ID))
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+	} else {
+		m.queueMail(email.Mail(m.App.MailFrom, ownerEmail))
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Thank you! We'll get back to you soon.")
+	http.Redirect(w, r, "/contact", http.StatusSeeOther)
+}
+
+// AdminEnquiries shows the enquiry inbox, or the archive when ?archived=1
+func (m *Repository) AdminEnquiries(w http.ResponseWriter, r *http.Request) {
+	archived := r.URL.Query().Get("archived") == "1"
+
+	enquiries, err := m.DB.AllEnquiries(archived)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	stringMap := make(map[string]string)
+	if archived {
+		stringMap["archived"] = "1"
+	}
+
+	data := make(map[string]interface{})
+	data["enquiries"] = enquiries
+
+	render.Template(w, r, "admin-enquiries.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+// adminEnquiry returns the enquiry with the id in the url, writing a not found
+// response and returning false when there isn't one
+func (m *Repository) adminEnquiry(w http.ResponseWriter, r *http.Request) (models.Enquiry, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return models.Enquiry{}, false
+	}
+
+	enquiry, err := m.DB.GetEnquiryByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return models.Enquiry{}, false
+	}
+	if enquiry.ID == 0 {
+		helpers.ClientError(w, http.StatusNotFound)
+		return models.Enquiry{}, false
+	}
+
+	return enquiry, true
+}
+
+// AdminEnquiry shows an enquiry with the replies sent to it, and marks it read
+func (m *Repository) AdminEnquiry(w http.ResponseWriter, r *http.Request) {
+	enquiry, ok := m.adminEnquiry(w, r)
+	if !ok {
+		return
+	}
+
+	if !enquiry.Read {
+		err := m.DB.MarkEnquiryRead(enquiry.ID)
+		if err != nil {
+			log.Println(err)
+		} else {
+			m.audit(r, "read", models.AuditEnquiry, enquiry.ID, nil, nil)
+		}
+	}
+
+	form := forms.New(url.Values{})
+	if enquiry.ReservationID > 0 {
+		form.Set("reservation_id", strconv.Itoa(enquiry.ReservationID))
+	}
+
+	m.renderAdminEnquiry(w, r, enquiry, form)
+}
+
+func (m *Repository) renderAdminEnquiry(w http.ResponseWriter, r *http.Request, enquiry models.Enquiry, form *forms.Form) {
+	replies, err := m.DB.RepliesForEnquiry(enquiry.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["enquiry"] = enquiry
+	data["replies"] = replies
+
+	if enquiry.ReservationID > 0 {
+		res, err := m.DB.GetReservationByID(enquiry.ReservationID)
+		if err != nil {
+			log.Println(err)
+		} else {
+			data["reservation"] = res
+		}
+	}
+
+	render.Template(w, r, "admin-enquiry.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// AdminReplyEnquiry emails a reply to the person who sent an enquiry
+func (m *Repository) AdminReplyEnquiry(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	enquiry, ok := m.adminEnquiry(w, r)
+	if !ok {
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("reply")
+	if !form.Valid() {
+		m.renderAdminEnquiry(w, r, enquiry, form)
+		return
+	}
+
+	reply := strings.TrimSpace(form.Get("reply"))
+	email, err := emails.EnquiryReply(enquiry, reply)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	back := fmt.Sprintf("/admin/enquiries/%d", enquiry.ID)
+
+	sent := models.EnquiryReply{
+		EnquiryID: enquiry.ID,
+		Body:      reply,
+	}
+	sent.ID, err = m.DB.InsertEnquiryReply(sent, email.Mail(m.App.MailFrom, enquiry.Email))
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "can't send reply")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	m.audit(r, "reply", models.AuditEnquiry, enquiry.ID, nil, sent)
+
+	m.App.Session.Put(r.Context(), "flash", "Reply sent")
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// AdminLinkEnquiry links an enquiry to the reservation it is about; a blank
+// reservation number removes the link
+func (m *Repository) AdminLinkEnquiry(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	enquiry, ok := m.adminEnquiry(w, r)
+	if !ok {
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	reservationID := 0
+	if value := strings.TrimSpace(form.Get("reservation_id")); value != "" {
+		reservationID, err = strconv.Atoi(value)
+		if err != nil || reservationID < 1 {
+			form.Errors.Add("reservation_id", "Enter a reservation number")
+		} else {
+			res, err := m.DB.GetReservationByID(reservationID)
+			if err != nil || res.ID == 0 {
+				form.Errors.Add("reservation_id", fmt.Sprintf("There is no reservation %d", reservationID))
+			}
+		}
+	}
+
+	if !form.Valid() {
+		m.renderAdminEnquiry(w, r, enquiry, form)
+		return
+	}
+
+	back := fmt.Sprintf("/admin/enquiries/%d", enquiry.ID)
+
+	err = m.DB.LinkEnquiryToReservation(enquiry.ID, reservationID)
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "can't link enquiry")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	m.audit(r, "link", models.AuditEnquiry, enquiry.ID,
+		map[string]int{"ReservationID": enquiry.ReservationID}, map[string]int{"ReservationID": reservationID})
+
+	if reservationID == 0 {
+		m.App.Session.Put(r.Context(), "flash", "Reservation unlinked")
+	} else {
+		m.App.Session.Put(r.Context(), "flash", "Reservation linked")
+	}
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// AdminArchiveEnquiry moves an enquiry out of the inbox
+func (m *Repository) AdminArchiveEnquiry(w http.ResponseWriter, r *http.Request) {
+	m.setEnquiryArchived(w, r, true, "Enquiry archived")
+}
+
+// AdminUnarchiveEnquiry moves an enquiry back into the inbox
+func (m *Repository) AdminUnarchiveEnquiry(w http.ResponseWriter, r *http.Request) {
+	m.setEnquiryArchived(w, r, false, "Enquiry moved to the inbox")
+}
+
+func (m *Repository) setEnquiryArchived(w http.ResponseWriter, r *http.Request, archived bool, flash string) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	err := m.DB.SetEnquiryArchived(id, archived)
+	if err != nil {
+		log.Println(err)
+	} else {
+		action := "unarchive"
+		if archived {
+			action = "archive"
+		}
+		m.audit(r, action, models.AuditEnquiry, id, nil, nil)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, "/admin/enquiries", http.StatusSeeOther)
+}
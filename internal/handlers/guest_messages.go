@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/DmitryZzz/bookings/internal/emails"
+	"github.com/DmitryZzz/bookings/internal/forms"
+	"github.com/DmitryZzz/bookings/internal/helpers"
+	"github.com/DmitryZzz/bookings/internal/models"
+	"github.com/DmitryZzz/bookings/internal/render"
+	"github.com/DmitryZzz/bookings/internal/scheduler"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// sentLogSize is how many scheduled messages the guest messages page shows
+	sentLogSize = 50
+	// maxScheduleDays is how far from arrival or departure a message can be scheduled
+	maxScheduleDays = 365
+)
+
+// AdminGuestMessages lists the guest message schedules and what they sent recently
+func (m *Repository) AdminGuestMessages(w http.ResponseWriter, r *http.Request) {
+	m.renderGuestMessages(w, r, forms.New(nil))
+}
+
+func (m *Repository) renderGuestMessages(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	schedules, err := m.DB.AllMessageSchedules()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	sent, err := m.DB.RecentScheduledSends(sentLogSize)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	titles := make(map[string]string)
+	for _, msg := range emails.Messages {
+		titles[msg.Name] = msg.Title
+	}
+
+	data := make(map[string]interface{})
+	data["schedules"] = schedules
+	data["sent"] = sent
+	data["messages"] = emails.ScheduledMessages()
+	data["titles"] = titles
+	data["anchors"] = scheduler.Anchors
+
+	render.Template(w, r, "admin-guest-messages.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// AdminPostGuestMessage adds a guest message schedule
+func (m *Repository) AdminPostGuestMessage(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	msg, ok := emails.FindMessage(form.Get("message"))
+	if !ok || !msg.Scheduled {
+		form.Errors.Add("message", "Choose a message")
+	}
+
+	days, err := strconv.Atoi(form.Get("days"))
+	if err != nil || days < 0 || days > maxScheduleDays {
+		form.Errors.Add("days", fmt.Sprintf("Enter a number of days from 0 to %d", maxScheduleDays))
+	}
+
+	when := form.Get("when")
+	if when != "before" && when != "after" {
+		form.Errors.Add("when", "Choose before or after")
+	} else if when == "before" {
+		days = -days
+	}
+
+	anchor := form.Get("anchor")
+	if anchor != scheduler.AnchorArrival && anchor != scheduler.AnchorDeparture {
+		form.Errors.Add("anchor", "Choose arrival or departure")
+	}
+
+	if !form.Valid() {
+		m.renderGuestMessages(w, r, form)
+		return
+	}
+
+	schedule := models.MessageSchedule{
+		Message: msg.Name,
+		Anchor:  anchor,
+		Days:    days,
+		Active:  true,
+	}
+	schedule.ID, err = m.DB.InsertMessageSchedule(schedule)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't save guest message")
+		http.Redirect(w, r, "/admin/guest-messages", http.StatusSeeOther)
+		return
+	}
+
+	m.audit(r, "create", models.AuditMessageSchedule, schedule.ID, nil, schedule)
+
+	m.App.Session.Put(r.Context(), "flash", "Guest message scheduled")
+	http.Redirect(w, r, "/admin/guest-messages", http.StatusSeeOther)
+}
+
+// AdminPauseGuestMessage stops a schedule sending until it is resumed
+func (m *Repository) AdminPauseGuestMessage(w http.ResponseWriter, r *http.Request) {
+	m.setGuestMessageActive(w, r, false, "Guest message paused")
+}
+
+// AdminResumeGuestMessage starts a paused schedule sending again
+func (m *Repository) AdminResumeGuestMessage(w http.ResponseWriter, r *http.Request) {
+	m.setGuestMessageActive(w, r, true, "Guest message resumed")
+}
+
+func (m *Repository) setGuestMessageActive(w http.ResponseWriter, r *http.Request, active bool, flash string) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	err := m.DB.SetMessageScheduleActive(id, active)
+	if err != nil {
+		log.Println(err)
+	} else {
+		action := "pause"
+		if active {
+			action = "resume"
+		}
+		m.audit(r, action, models.AuditMessageSchedule, id, map[string]bool{"Active": !active}, map[string]bool{"Active": active})
+	}
+
+	m.App.Session.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, "/admin/guest-messages", http.StatusSeeOther)
+}
+
+// AdminDeleteGuestMessage deletes a guest message schedule
+func (m *Repository) AdminDeleteGuestMessage(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	err := m.DB.DeleteMessageSchedule(id)
+	if err != nil {
+		log.Println(err)
+	} else {
+		m.audit(r, "delete", models.AuditMessageSchedule, id, nil, nil)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Guest message deleted")
+	http.Redirect(w, r, "/admin/guest-messages", http.StatusSeeOther)
+}
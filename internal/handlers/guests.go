@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/DmitryZzz/bookings/internal/forms"
+	"github.com/DmitryZzz/bookings/internal/guests"
+	"github.com/DmitryZzz/bookings/internal/helpers"
+	"github.com/DmitryZzz/bookings/internal/models"
+	"github.com/DmitryZzz/bookings/internal/render"
+	"github.com/go-chi/chi/v5"
+)
+
+// guestMatchesQuery reports whether a guest's name, email address or phone
+// number contains q, ignoring case
+func guestMatchesQuery(g models.Guest, q string) bool {
+	q = strings.ToLower(q)
+	for _, field := range []string{g.FirstName + " " + g.LastName, g.Email, g.Phone} {
+		if strings.Contains(strings.ToLower(field), q) {
+			return true
+		}
+	}
+	return false
+}
+
+// AdminGuests lists the guests with their stats, only those matching ?q= when
+// it's set, and the guests that look like duplicates of each other
+func (m *Repository) AdminGuests(w http.ResponseWriter, r *http.Request) {
+	all, err := m.DB.AllGuests()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	list := all
+	if q != "" {
+		list = nil
+		for _, g := range all {
+			if guestMatchesQuery(g, q) {
+				list = append(list, g)
+			}
+		}
+	}
+
+	matches, err := m.DB.GuestMatches()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["q"] = q
+
+	data := make(map[string]interface{})
+	data["guests"] = list
+	data["matches"] = matches
+
+	render.Template(w, r, "admin-guests.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+// guestByURL returns the guest with the id in the url, writing a not found or
+// server error and returning ok false when there isn't one
+func (m *Repository) guestByURL(w http.ResponseWriter, r *http.Request) (g models.Guest, ok bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return g, false
+	}
+
+	g, err = m.DB.GetGuestByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return g, false
+	}
+	if g.ID == 0 {
+		helpers.ClientError(w, http.StatusNotFound)
+		return g, false
+	}
+	return g, true
+}
+
+// AdminGuest shows a guest's details, notes, stats and stays
+func (m *Repository) AdminGuest(w http.ResponseWriter, r *http.Request) {
+	g, ok := m.guestByURL(w, r)
+	if !ok {
+		return
+	}
+
+	form := forms.New(url.Values{})
+	form.Set("first_name", g.FirstName)
+	form.Set("last_name", g.LastName)
+	form.Set("email", g.Email)
+	form.Set("phone", g.Phone)
+	form.Set("notes", g.Notes)
+
+	m.renderAdminGuest(w, r, g, form)
+}
+
+func (m *Repository) renderAdminGuest(w http.ResponseWriter, r *http.Request, g models.Guest, form *forms.Form) {
+	reservations, err := m.DB.GuestReservations(g.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// other guests that look like the same person, to merge into this one
+	var matches []models.Guest
+	all, err := m.DB.AllGuests()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	for _, other := range all {
+		if other.ID != g.ID && guests.Match(g, other) != "" {
+			matches = append(matches, other)
+		}
+	}
+
+	data := make(map[string]interface{})
+	data["guest"] = g
+	data["reservations"] = reservations
+	data["matches"] = matches
+
+	render.Template(w, r, "admin-guest.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// AdminPostGuest saves a guest's details and notes
+func (m *Repository) AdminPostGuest(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	g, ok := m.guestByURL(w, r)
+	if !ok {
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name")
+	if strings.TrimSpace(form.Get("email")) != "" {
+		form.IsEmail("email")
+	}
+
+	if !form.Valid() {
+		m.renderAdminGuest(w, r, g, form)
+		return
+	}
+
+	before := g
+	g.FirstName = strings.TrimSpace(form.Get("first_name"))
+	g.LastName = strings.TrimSpace(form.Get("last_name"))
+	g.Email = strings.TrimSpace(form.Get("email"))
+	g.Phone = strings.TrimSpace(form.Get("phone"))
+	g.Notes = strings.TrimSpace(form.Get("notes"))
+
+	err = m.DB.UpdateGuest(g)
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "can't save guest")
+		http.Redirect(w, r, fmt.Sprintf("/admin/guests/%d", g.ID), http.StatusSeeOther)
+		return
+	}
+
+	before.Stats, g.Stats = models.GuestStats{}, models.GuestStats{}
+	m.audit(r, "update", models.AuditGuest, g.ID, before, g)
+
+	m.App.Session.Put(r.Context(), "flash", "Guest saved")
+	http.Redirect(w, r, fmt.Sprintf("/admin/guests/%d", g.ID), http.StatusSeeOther)
+}
+
+// AdminMergeGuests merges the guest posted as merge_id into the one in the url,
+// which takes over their reservations and notes
+func (m *Repository) AdminMergeGuests(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	keep, ok := m.guestByURL(w, r)
+	if !ok {
+		return
+	}
+	back := fmt.Sprintf("/admin/guests/%d", keep.ID)
+
+	mergeID, _ := strconv.Atoi(r.Form.Get("merge_id"))
+	if mergeID == keep.ID {
+		m.App.Session.Put(r.Context(), "error", "A guest can't be merged into themselves")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	other, err := m.DB.GetGuestByID(mergeID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if other.ID == 0 {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("There is no guest %s", r.Form.Get("merge_id")))
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	merged := guests.Merge(keep, other)
+	merged.Stats = models.GuestStats{}
+	err = m.DB.MergeGuests(merged, other.ID)
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "can't merge guests")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	keep.Stats, other.Stats = models.GuestStats{}, models.GuestStats{}
+	m.audit(r, "merge", models.AuditGuest, keep.ID, []models.Guest{keep, other}, merged)
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%s %s merged into this guest", other.FirstName, other.LastName))
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
@@ -2,21 +2,27 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/DmitryZzz/bookings/internal/calendar"
 	"github.com/DmitryZzz/bookings/internal/config"
+	"github.com/DmitryZzz/bookings/internal/emails"
 	"github.com/DmitryZzz/bookings/internal/driver"
 	"github.com/DmitryZzz/bookings/internal/forms"
 	"github.com/DmitryZzz/bookings/internal/helpers"
 	"github.com/DmitryZzz/bookings/internal/models"
+	"github.com/DmitryZzz/bookings/internal/photos"
 	"github.com/DmitryZzz/bookings/internal/render"
 	"github.com/DmitryZzz/bookings/internal/repository"
 	"github.com/DmitryZzz/bookings/internal/repository/dbrepo"
+	"github.com/DmitryZzz/bookings/internal/webhooks"
 	"github.com/go-chi/chi/v5"
 )
 
@@ -25,23 +31,26 @@ var Repo *Repository
 
 // Repository is the repository type
 type Repository struct {
-	App *config.AppConfig
-	DB  repository.DatabaseRepo
+	App    *config.AppConfig
+	DB     repository.DatabaseRepo
+	Photos photos.Storage
 }
 
 // NewRepo creates a new repository
 func NewRepo(a *config.AppConfig, db *driver.DB) *Repository {
 	return &Repository{
-		App: a,
-		DB:  dbrepo.NewPostgresRepo(db.SQL, a),
+		App:    a,
+		DB:     dbrepo.NewPostgresRepo(db.SQL, a),
+		Photos: photos.NewDisk(a.UploadDir, photos.URLPrefix),
 	}
 }
 
 // NewTestRepo creates a new repository
 func NewTestRepo(a *config.AppConfig) *Repository {
 	return &Repository{
-		App: a,
-		DB:  dbrepo.NewTestingRepo(a),
+		App:    a,
+		DB:     dbrepo.NewTestingRepo(a),
+		Photos: photos.NewDisk(a.UploadDir, photos.URLPrefix),
 	}
 }
 
@@ -79,6 +88,20 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 
 	res.Room.RoomName = room.RoomName
 
+	// a guest logged in to their account books with their saved details
+	if res.FirstName == "" && res.Email == "" {
+		a, err := m.guestAccount(r)
+		if err != nil {
+			log.Println(err)
+		}
+		if a.ID > 0 {
+			res.FirstName = a.Guest.FirstName
+			res.LastName = a.Guest.LastName
+			res.Email = a.Email
+			res.Phone = a.Guest.Phone
+		}
+	}
+
 	m.App.Session.Put(r.Context(), "reservation", res)
 
 	sd := res.StartDate.Format("2006-01-02")
@@ -150,6 +173,7 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		EndDate:   endDate,
 		RoomID:    roomID,
 		Room:      room,
+		Price:     room.PriceFor(startDate, endDate),
 	}
 
 	form := forms.New(r.PostForm)
@@ -174,76 +198,59 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	newReservationID, err := m.DB.InsertReservation(reservation)
+	restrictionID, err := m.restrictionID(models.ReservationRestriction)
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't insert reservation into database!")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		helpers.ServerError(w, err)
 		return
 	}
 
-	restriction := models.RoomRestriction{
-		StartDate:     startDate,
-		EndDate:       endDate,
-		RoomID:        roomID,
-		ReservationID: newReservationID,
-		RestrictionID: 1,
+	// the booking goes on the account of a guest who is logged in
+	account, err := m.guestAccount(r)
+	if err != nil {
+		log.Println(err)
 	}
+	reservation.GuestID = account.GuestID
 
-	err = m.DB.InsertRoomRestriction(restriction)
+	newReservationID, err := m.DB.InsertReservation(reservation, restrictionID)
+	if errors.Is(err, repository.ErrUnavailable) {
+		m.App.Session.Put(r.Context(), "error", "Sorry, the room is no longer available for these dates, please search again")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't insert room restriction!")
+		m.App.Session.Put(r.Context(), "error", "can't insert reservation into database!")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
-	// send notification to guest
-	htmlMessage := fmt.Sprintf(`
-		<strong>Reservation Confirmation</strong><br>
-		Dear %s:<br>
-		This is confirm your reservation from %s to %s.
-	`, reservation.FirstName, reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"))
+	reservation.ID = newReservationID
+	m.emitEvent(webhooks.ReservationCreated, webhooks.NewReservation(reservation))
+	m.audit(r, "create", models.AuditReservation, reservation.ID, nil, reservation)
 
-	msg := models.MailData{
-		To:       reservation.Email,
-		From:     "me@here.com",
-		Subject:  "Reservation Confirmation",
-		Content:  htmlMessage,
-		Template: "basic.html",
+	// send notification to guest
+	email, err := emails.ReservationConfirmation(reservation)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+	} else {
+		msg := email.Mail(m.App.MailFrom, reservation.Email)
+		msg.Attachments = []models.Attachment{m.reservationInvite(reservation, inviteRequest)}
+		m.queueMail(msg)
 	}
 
-	m.App.MailChan <- msg
-
 	// send notifications to property owner
-	htmlMessage = fmt.Sprintf(`
-		<strong>Reservation Notification</strong><br>
-		A reservation has been made for %s from %s to %s.
-	`, reservation.Room.RoomName, reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"))
-
-	msg = models.MailData{
-		To:      "me@here.com",
-		From:    "me@here.com",
-		Subject: "Reservation Notification",
-		Content: htmlMessage,
+	email, err = emails.ReservationNotification(reservation)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+	} else {
+		m.queueMail(email.Mail(m.App.MailFrom, ownerEmail))
 	}
 
-	m.App.MailChan <- msg
-
 	m.App.Session.Put(r.Context(), "reservation", reservation)
 
 	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
 
 }
 
-// Generals renders the room page
-func (m *Repository) Generals(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "generals.page.tmpl", &models.TemplateData{})
-}
-
-// Majors renders the room page
-func (m *Repository) Majors(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "majors.page.tmpl", &models.TemplateData{})
-}
-
 // Availability renders the search availability page
 func (m *Repository) Availability(w http.ResponseWriter, r *http.Request) {
 	render.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{})
@@ -367,9 +374,11 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 
 }
 
-// Contact renders the search availability page
+// Contact renders the contact form
 func (m *Repository) Contact(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "contact.page.tmpl", &models.TemplateData{})
+	render.Template(w, r, "contact.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
 }
 
 // ReservationSummary displays the reservation summary page
@@ -495,12 +504,14 @@ func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
 
 	id, _, err := m.DB.Authenticate(email, password)
 	if err != nil {
+		m.recordLogin(r, models.AuditUser, 0, loginAttempt{Email: email, Method: "password", Reason: "wrong email address or password"})
 		m.App.Session.Put(r.Context(), "error", "invalid login credentials")
 		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 		return
 	}
 
 	m.App.Session.Put(r.Context(), "user_id", id)
+	m.recordLogin(r, models.AuditUser, id, loginAttempt{Email: email, Method: "password"})
 	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
@@ -513,42 +524,110 @@ func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 }
 
+// AdminDashboard shows the admin dashboard, with this month's occupancy
 func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "admin-dashboard.page.tmpl", &models.TemplateData{})
-}
+	now := time.Now()
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
 
-// AdminAllReservations shows all reservations in admin tool
-func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllReservations()
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// only restriction types that count as occupied are included
+	occupied, err := m.DB.OccupiedNights(start, end)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
+	nights := int(end.Sub(start).Hours() / 24)
+	var occupancy []roomOccupancy
+	total := 0
+	for _, room := range rooms {
+		occupancy = append(occupancy, roomOccupancy{
+			Room:    room,
+			Nights:  occupied[room.ID],
+			Percent: occupied[room.ID] * 100 / nights,
+		})
+		total += occupied[room.ID]
+	}
+
+	intMap := make(map[string]int)
+	intMap["nights"] = nights
+	if len(rooms) > 0 {
+		intMap["percent"] = total * 100 / (nights * len(rooms))
+	}
+
 	data := make(map[string]interface{})
-	data["reservations"] = reservations
+	data["month"] = start
+	data["occupancy"] = occupancy
 
-	render.Template(w, r, "admin-all-reservations.page.tmpl", &models.TemplateData{
-		Data: data,
+	render.Template(w, r, "admin-dashboard.page.tmpl", &models.TemplateData{
+		IntMap: intMap,
+		Data:   data,
 	})
 }
 
-// AdminNewReservations shows all new reservations in admin tool
+// roomOccupancy is how many nights of a month a room was occupied
+type roomOccupancy struct {
+	Room    models.Room
+	Nights  int
+	Percent int
+}
+
+// AdminAllReservations shows all reservations in admin tool, only those with
+// the tag ?tag= when it's set
+func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
+	tag := r.URL.Query().Get("tag")
+	reservations, err := m.DB.AllReservations(tag)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.renderReservationList(w, r, "admin-all-reservations.page.tmpl", tag, reservations)
+}
+
+// AdminNewReservations shows all new reservations in admin tool, only those
+// with the tag ?tag= when it's set
 func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllNewReservations()
+	tag := r.URL.Query().Get("tag")
+	reservations, err := m.DB.AllNewReservations(tag)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.renderReservationList(w, r, "admin-new-reservations.page.tmpl", tag, reservations)
+}
+
+// renderReservationList shows a list of reservations with the tags they can be
+// filtered by
+func (m *Repository) renderReservationList(w http.ResponseWriter, r *http.Request, tmpl, tag string, reservations []models.Reservation) {
+	tags, err := m.DB.ReservationTags()
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
+	stringMap := make(map[string]string)
+	stringMap["tag"] = tag
+
 	data := make(map[string]interface{})
 	data["reservations"] = reservations
-	render.Template(w, r, "admin-new-reservations.page.tmpl", &models.TemplateData{
-		Data: data,
+	data["tags"] = tags
+	render.Template(w, r, tmpl, &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
 	})
 }
 
-// AdminPostShowReservation shows the reservation in the admin tool
+// AdminPostShowReservation saves changes to a reservation from the admin tool.
+// When the dates or room change, the new nights are checked for conflicts, the
+// price is recomputed and, if asked, the guest is sent the new details.
 func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Request) {
 
 	err := r.ParseForm()
@@ -565,6 +644,14 @@ func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	// the version the form was shown with must be posted, so that saving
+	// can't overwrite changes made since
+	version, err := strconv.Atoi(r.Form.Get("version"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
 	src := exploded[3]
 	stringMap := make(map[string]string)
 	stringMap["src"] = src
@@ -575,27 +662,107 @@ func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Req
 		helpers.ServerError(w, err)
 		return
 	}
+	original := res
 
 	u := r.URL.Query().Get("updated_at")
 	layout := "2006-01-02"
 	updatedAt, _ := time.Parse(layout, u)
 
+	form := forms.New(r.PostForm)
+
 	res.FirstName = r.Form.Get("first_name")
 	res.LastName = r.Form.Get("last_name")
 	res.Email = r.Form.Get("email")
 	res.Phone = r.Form.Get("phone")
 	res.UpdatedAt = updatedAt
+	res.Version = version
+
+	// the dates and room are kept when they aren't posted
+	if form.Has("start_date") {
+		res.StartDate, err = time.Parse(layout, form.Get("start_date"))
+		if err != nil {
+			form.Errors.Add("start_date", "Enter the arrival date")
+		}
+	}
+	if form.Has("end_date") {
+		res.EndDate, err = time.Parse(layout, form.Get("end_date"))
+		if err != nil {
+			form.Errors.Add("end_date", "Enter the departure date")
+		}
+	}
+	datesPosted := form.Has("start_date") || form.Has("end_date")
+	if datesPosted && form.Valid() && !res.EndDate.After(res.StartDate) {
+		form.Errors.Add("end_date", "The departure must be after the arrival")
+	}
+	if form.Has("room_id") {
+		res.RoomID, _ = strconv.Atoi(form.Get("room_id"))
+	}
+
+	changed := res.RoomID != original.RoomID || !res.StartDate.Equal(original.StartDate) || !res.EndDate.Equal(original.EndDate)
+	if changed && form.Valid() {
+		room, err := m.DB.GetRoomByID(res.RoomID)
+		if err != nil || room.ID == 0 {
+			form.Errors.Add("room_id", "Choose a room")
+		} else {
+			res.Room = room
+			res.Price = room.PriceFor(res.StartDate, res.EndDate)
+
+			conflicts, err := m.reservationConflicts(res)
+			if err != nil {
+				helpers.ServerError(w, err)
+				return
+			}
+			if len(conflicts) > 0 {
+				form.Errors.Add("start_date", fmt.Sprintf("%s isn't available for these dates", room.RoomName))
+			}
+		}
+	}
+
+	if !form.Valid() {
+		stringMap["year"] = r.Form.Get("year")
+		stringMap["month"] = r.Form.Get("month")
+		m.renderAdminReservation(w, r, original, form, stringMap)
+		return
+	}
 
 	err = m.DB.UpdateReservation(res)
+	if err == repository.ErrUnavailable {
+		// taken since the nights were checked above
+		form.Errors.Add("start_date", fmt.Sprintf("%s isn't available for these dates", res.Room.RoomName))
+		stringMap["year"] = r.Form.Get("year")
+		stringMap["month"] = r.Form.Get("month")
+		m.renderAdminReservation(w, r, original, form, stringMap)
+		return
+	}
+	if err == repository.ErrStale {
+		saved, err := m.DB.GetReservationByID(id)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		stringMap["year"] = r.Form.Get("year")
+		stringMap["month"] = r.Form.Get("month")
+		m.renderReservationConflict(w, r, res, saved, form, stringMap)
+		return
+	}
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
+	m.emitEvent(webhooks.ReservationUpdated, webhooks.NewReservation(res))
+	m.audit(r, "update", models.AuditReservation, res.ID, original, res)
+
+	flash := "Changes saved"
+	if changed && form.Has("notify_guest") {
+		m.sendReservationChanged(res)
+		flash = "Changes saved and the guest notified"
+	}
+
 	month := r.Form.Get("month")
 	year := r.Form.Get("year")
 
-	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	m.App.Session.Put(r.Context(), "flash", flash)
 
 	if year == "" {
 		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
@@ -631,13 +798,93 @@ func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	form := forms.New(url.Values{})
+	form.Set("start_date", res.StartDate.Format("2006-01-02"))
+	form.Set("end_date", res.EndDate.Format("2006-01-02"))
+	form.Set("room_id", strconv.Itoa(res.RoomID))
+	form.Set("version", strconv.Itoa(res.Version))
+
+	m.renderAdminReservation(w, r, res, form, stringMap)
+}
+
+func (m *Repository) renderAdminReservation(w http.ResponseWriter, r *http.Request, res models.Reservation, form *forms.Form, stringMap map[string]string) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	history, err := m.DB.AuditLog(models.AuditFilter{Entity: models.AuditReservation, EntityID: res.ID}, auditLogSize)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	notes, err := m.DB.ReservationNotes(res.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	stringMap["feed_url"] = reservationFeedURL(r, res)
+	stringMap["tags"] = strings.Join(res.Tags, ", ")
+
 	data := make(map[string]interface{})
 	data["reservation"] = res
+	data["rooms"] = rooms
+	data["history"] = history
+	data["notes"] = notes
 
 	render.Template(w, r, "admin-reservations-show.page.tmpl", &models.TemplateData{
 		StringMap: stringMap,
 		Data:      data,
-		Form:      forms.New(nil),
+		Form:      form,
+	})
+}
+
+// fieldDiff is a field of a record as an admin saved it and as it was saved
+// before them
+type fieldDiff struct {
+	Field string
+	Mine  string
+	Saved string
+}
+
+// reservationDiff lists the fields of two copies of a reservation
+func reservationDiff(mine, saved models.Reservation) []fieldDiff {
+	layout := "2006-01-02"
+	return []fieldDiff{
+		{"First name", mine.FirstName, saved.FirstName},
+		{"Last name", mine.LastName, saved.LastName},
+		{"Email", mine.Email, saved.Email},
+		{"Phone", mine.Phone, saved.Phone},
+		{"Arrival", mine.StartDate.Format(layout), saved.StartDate.Format(layout)},
+		{"Departure", mine.EndDate.Format(layout), saved.EndDate.Format(layout)},
+		{"Room", mine.Room.RoomName, saved.Room.RoomName},
+		{"Price", render.Money(mine.Price), render.Money(saved.Price)},
+	}
+}
+
+// renderReservationConflict shows an admin the changes to a reservation that
+// someone else saved first, with a form to save theirs over them
+func (m *Repository) renderReservationConflict(w http.ResponseWriter, r *http.Request, mine, saved models.Reservation, form *forms.Form, stringMap map[string]string) {
+	retry := forms.New(url.Values{})
+	for _, name := range []string{"first_name", "last_name", "email", "phone", "start_date", "end_date", "room_id", "notify_guest"} {
+		if form.Has(name) {
+			retry.Set(name, form.Get(name))
+		}
+	}
+	retry.Set("version", strconv.Itoa(saved.Version))
+
+	data := make(map[string]interface{})
+	data["reservation"] = saved
+	data["diff"] = reservationDiff(mine, saved)
+
+	w.WriteHeader(http.StatusConflict)
+	render.Template(w, r, "admin-reservation-conflict.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		Form:      retry,
 	})
 }
 
@@ -682,47 +929,38 @@ func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Re
 	intMap := make(map[string]int)
 	intMap["days_in_month"] = lastOfMonth.Day()
 
-	rooms, err := m.DB.AllRooms()
+	cal, err := m.buildCalendar(firstOfMonth, firstOfMonth.AddDate(0, 1, 0))
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
+	data["calendar"] = cal
 
-	data["rooms"] = rooms
-
-	for _, x := range rooms {
-		// create maps
-		reservationMap := make(map[string]int)
-		blockMap := make(map[string]int)
-
-		for d := firstOfMonth; d.After(lastOfMonth) == false; d = d.AddDate(0, 0, 1) {
-			reservationMap[d.Format("2006-01-2")] = 0
-			blockMap[d.Format("2006-01-2")] = 0
-		}
+	feeds, err := m.DB.AllICalFeeds()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 
-		// get all the restrictions for the current room
-		restrictions, err := m.DB.GetRestrictionsForRoomByDate(x.ID, firstOfMonth, lastOfMonth)
-		if err != nil {
-			helpers.ServerError(w, err)
-			return
+	roomFeeds := make(map[int][]models.ICalFeed)
+	for _, f := range feeds {
+		roomFeeds[f.RoomID] = append(roomFeeds[f.RoomID], f)
+	}
+	data["ical_feeds"] = roomFeeds
+
+	typesByID := make(map[int]calendar.Type)
+	var blockTypes []calendar.Type
+	for _, t := range cal.Types {
+		typesByID[t.ID] = t
+		if t.Code != models.ReservationRestriction {
+			blockTypes = append(blockTypes, t)
 		}
-
-		for _, y := range restrictions {
-			if y.ReservationID > 0 {
-				// it's a reservation
-				for d := y.StartDate; d.After(y.EndDate) == false; d = d.AddDate(0, 0, 1) {
-					reservationMap[d.Format("2006-01-2")] = y.ReservationID
-				}
-			} else {
-				// it's a block
-				blockMap[y.StartDate.Format("2006-01-2")] = y.ID
-			}
+		if t.Code == models.OwnerRestriction {
+			intMap["owner_restriction_id"] = t.ID
 		}
-		data[fmt.Sprintf("reservation_map_%d", x.ID)] = reservationMap
-		data[fmt.Sprintf("block_map_%d", x.ID)] = blockMap
-
-		m.App.Session.Put(r.Context(), fmt.Sprintf("block_map_%d", x.ID), blockMap)
 	}
+	data["restriction_types_by_id"] = typesByID
+	data["block_types"] = blockTypes
 
 	render.Template(w, r, "admin-reservations-calendar.page.tmpl", &models.TemplateData{
 		StringMap: stringMap,
@@ -735,9 +973,15 @@ func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Re
 func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
 	src := chi.URLParam(r, "src")
+	res, getErr := m.DB.GetReservationByID(id)
 	err := m.DB.UpdateProcessedForReservation(id, 1)
 	if err != nil {
 		log.Println(err)
+	} else if getErr == nil {
+		processed := res
+		processed.Processed = 1
+		m.emitEvent(webhooks.ReservationProcessed, webhooks.NewReservation(processed))
+		m.audit(r, "process", models.AuditReservation, id, res, processed)
 	}
 
 	year := r.URL.Query().Get("y")
@@ -756,15 +1000,30 @@ func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Requ
 func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
 	src := chi.URLParam(r, "src")
-	err := m.DB.DeleteReservation(id)
-	if err != nil {
+
+	// keep a copy, so the cancellation can say what was cancelled
+	res, getErr := m.DB.GetReservationByID(id)
+	res.ID = id
+
+	err := m.DB.DeleteReservation(id, m.App.Session.GetInt(r.Context(), "user_id"))
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		m.App.Session.Put(r.Context(), "error", "The reservation has been deleted already")
+	case err != nil:
 		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "can't delete reservation")
+	default:
+		m.emitEvent(webhooks.ReservationCancelled, webhooks.NewReservation(res))
+		m.audit(r, "delete", models.AuditReservation, id, res, nil)
+		if getErr == nil && res.Email != "" {
+			m.sendReservationCancelled(res)
+		}
+		m.App.Session.Put(r.Context(), "flash", "Reservation moved to the trash")
 	}
 
 	year := r.URL.Query().Get("y")
 	month := r.URL.Query().Get("m")
 	log.Println("year:", year, "month", month)
-	m.App.Session.Put(r.Context(), "flash", "Reservation deleted")
 
 	if year == "" {
 		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
@@ -783,9 +1042,10 @@ func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *htt
 
 	year, _ := strconv.Atoi(r.Form.Get("y"))
 	month, _ := strconv.Atoi(r.Form.Get("m"))
+	firstOfMonth := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
 
-	//process blocks
-	rooms, err := m.DB.AllRooms()
+	// the calendar as it is now, rather than as it was shown
+	cal, err := m.buildCalendar(firstOfMonth, firstOfMonth.AddDate(0, 1, 0))
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
@@ -793,47 +1053,99 @@ func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *htt
 
 	form := forms.New(r.PostForm)
 
-	for _, x := range rooms {
-		// Get the block map from the session. Loop through entire map, if we have an entry in the map
-		// that does not exist in our posted data, and if the restriction id > 0, then it is a block we need to
-		// remove
-		curMap := m.App.Session.Get(r.Context(), fmt.Sprintf("block_map_%d", x.ID)).(map[string]int)
-		for name, value := range curMap {
-			// ok will be false	if the value is not in the map
-			if val, ok := curMap[name]; ok {
-				// only pay attention to values > 0, and that are not in the form post
-				// the rest are just placeholders for days without blocks
-				if val > 0 {
-					if !form.Has(fmt.Sprintf("remove_block_%d_%s", x.ID, name)) {
-						// delete the restriction by id
-						err := m.DB.DeleteBlockById(value)
-						if err != nil {
-							log.Println(err)
-						}
-					}
+	restrictionID, err := m.blockRestrictionID(form.Get("restriction_id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 
-				}
+	// each room is saved on its own, so say which ones were if any weren't
+	var conflicts []roomConflict
+	var saved, failed []string
+	for _, row := range cal.Rooms {
+		changes := calendarChanges(form, row)
+		if len(changes) == 0 {
+			continue
+		}
+
+		// someone else has changed the room since the calendar was shown, so
+		// leave it to the admin to look at the changes again
+		if form.Get(fmt.Sprintf("version_%d", row.RoomID)) != row.Version {
+			conflicts = append(conflicts, m.describeCalendarConflict(cal, row, changes))
+			continue
+		}
 
+		ok := true
+		for _, c := range changes {
+			if c.block {
+				if c.cell.Kind != calendar.KindFree {
+					continue
+				}
+				t, _ := time.Parse(calendar.DateLayout, c.cell.Date)
+				blockID, err := m.DB.InsertBlockForRoom(row.RoomID, restrictionID, t)
+				if err != nil {
+					log.Println(err)
+					ok = false
+				} else {
+					m.emitEvent(webhooks.BlockCreated, webhooks.NewBlock(blockID, row.RoomID, t, t.AddDate(0, 0, 1)))
+					m.audit(r, "create", models.AuditRoomRestriction, blockID, nil, models.RoomRestriction{
+						ID:            blockID,
+						RoomID:        row.RoomID,
+						RestrictionID: restrictionID,
+						StartDate:     t,
+						EndDate:       t.AddDate(0, 0, 1),
+					})
+				}
+			} else {
+				if c.cell.Kind != calendar.KindBlock || c.cell.BlockID > 0 {
+					continue
+				}
+				// loaded first, so the event can say which nights were freed
+				block, err := m.DB.GetRoomRestrictionByID(c.cell.RestrictionID)
+				if err != nil {
+					log.Println(err)
+					ok = false
+					continue
+				}
+				if block.ID == 0 {
+					// it's already gone
+					continue
+				}
+				err = m.DB.DeleteBlockById(c.cell.RestrictionID, m.App.Session.GetInt(r.Context(), "user_id"))
+				if errors.Is(err, repository.ErrNotFound) {
+					// deleted by someone else since it was loaded
+					continue
+				}
+				if err != nil {
+					log.Println(err)
+					ok = false
+				} else {
+					m.emitEvent(webhooks.BlockDeleted, webhooks.NewBlock(block.ID, block.RoomID, block.StartDate, block.EndDate))
+					m.audit(r, "delete", models.AuditRoomRestriction, block.ID, block, nil)
+				}
 			}
 		}
 
+		if ok {
+			saved = append(saved, row.RoomName)
+		} else {
+			failed = append(failed, row.RoomName)
+		}
 	}
 
-	// now handle new blocks
-
-	for name, _ := range r.PostForm {
-		if strings.HasPrefix(name, "add_block") {
-			exploded := strings.Split(name, "_")
-			roomID, _ := strconv.Atoi(exploded[2])
-
-			t, _ := time.Parse("2006-01-2", exploded[3])
-			// insert a new block
-			err := m.DB.InsertBlockForRoom(roomID, t)
-			if err != nil {
-				log.Println(err)
-			}
+	if len(conflicts) > 0 {
+		m.renderCalendarConflict(w, r, cal, form, conflicts, saved, failed)
+		return
+	}
 
+	if len(failed) > 0 {
+		msg := fmt.Sprintf("can't save all the changes to %s", strings.Join(failed, ", "))
+		if len(saved) > 0 {
+			msg = fmt.Sprintf("Changes to %s saved, but %s", strings.Join(saved, ", "), msg)
 		}
+		m.App.Session.Put(r.Context(), "error", msg)
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", year, month), http.StatusSeeOther)
+		return
 	}
 
 	m.App.Session.Put(r.Context(), "flash", "Changes saved")
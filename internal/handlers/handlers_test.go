@@ -1,16 +1,27 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/DmitryZzz/bookings/internal/calendar"
 	"github.com/DmitryZzz/bookings/internal/driver"
+	"github.com/DmitryZzz/bookings/internal/helpers"
+	"github.com/DmitryZzz/bookings/internal/loginlinks"
 	"github.com/DmitryZzz/bookings/internal/models"
+	"github.com/DmitryZzz/bookings/internal/reviews"
+	"github.com/go-chi/chi/v5"
+	"image"
+	"image/png"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"path/filepath"
 	"reflect"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -29,8 +40,7 @@ var theTests = []struct {
 }{
 	{"home", "/", "GET", http.StatusOK},
 	{"about", "/about", "GET", http.StatusOK},
-	{"gq", "/generals-quarters", "GET", http.StatusOK},
-	{"ms", "/majors-suite", "GET", http.StatusOK},
+	{"rooms", "/rooms", "GET", http.StatusOK},
 	{"sa", "/search-availability", "GET", http.StatusOK},
 	{"contact", "/contact", "GET", http.StatusOK},
 	{"non-existent", "/green/eggs/and/ham", "GET", http.StatusNotFound},
@@ -42,6 +52,26 @@ var theTests = []struct {
 	{"show res", "/admin/reservations/new/1/show", "GET", http.StatusOK},
 	{"show res cal", "/admin/reservations-calendar", "GET", http.StatusOK},
 	{"show res cal with params", "/admin/reservations-calendar?y=2020&m=1", "GET", http.StatusOK},
+	{"timeline", "/admin/timeline", "GET", http.StatusOK},
+	{"timeline with params", "/admin/timeline?start=2050-01-03&weeks=20", "GET", http.StatusOK},
+	{"calendar feeds", "/admin/calendar-feeds", "GET", http.StatusOK},
+	{"imported calendars", "/admin/ical-feeds", "GET", http.StatusOK},
+	{"webhooks", "/admin/webhooks", "GET", http.StatusOK},
+	{"webhook deliveries", "/admin/webhooks/deliveries", "GET", http.StatusOK},
+	{"mail outbox", "/admin/mail", "GET", http.StatusOK},
+	{"email templates", "/admin/email-templates", "GET", http.StatusOK},
+	{"guest messages", "/admin/guest-messages", "GET", http.StatusOK},
+	{"reviews", "/admin/reviews", "GET", http.StatusOK},
+	{"admin rooms", "/admin/rooms", "GET", http.StatusOK},
+	{"enquiries", "/admin/enquiries", "GET", http.StatusOK},
+	{"archived enquiries", "/admin/enquiries?archived=1", "GET", http.StatusOK},
+	{"blocks", "/admin/blocks", "GET", http.StatusOK},
+	{"restriction types", "/admin/restrictions", "GET", http.StatusOK},
+	{"audit log", "/admin/audit", "GET", http.StatusOK},
+	{"trash", "/admin/trash", "GET", http.StatusOK},
+	{"guests", "/admin/guests", "GET", http.StatusOK},
+	{"account-register", "/account/register", "GET", http.StatusOK},
+	{"account-login", "/account/login", "GET", http.StatusOK},
 }
 
 // TestHandlers tests all routes that don't require extra tests (gets)
@@ -109,7 +139,7 @@ var reservationTests = []struct {
 func TestReservation(t *testing.T) {
 	for _, e := range reservationTests {
 		req, _ := http.NewRequest("GET", "/make-reservation", nil)
-		ctx := getCtx(req)
+		ctx := getGuestCtx(req)
 		req = req.WithContext(ctx)
 
 		rr := httptest.NewRecorder()
@@ -248,19 +278,19 @@ var postReservationTests = []struct {
 		expectedLocation:     "/",
 	},
 	{
-		name: "database-insert-fails-restriction",
+		name: "taken-while-booking",
 		postedData: url.Values{
-			"start_date": {"2050-01-01"},
-			"end_date":   {"2050-01-02"},
+			"start_date": {"2051-01-01"},
+			"end_date":   {"2051-01-02"},
 			"first_name": {"John"},
 			"last_name":  {"Smith"},
 			"email":      {"john@smith.com"},
 			"phone":      {"555-555-5555"},
-			"room_id":    {"1000"},
+			"room_id":    {"1"},
 		},
 		expectedResponseCode: http.StatusSeeOther,
 		expectedHTML:         "",
-		expectedLocation:     "/",
+		expectedLocation:     "/search-availability",
 	},
 }
 
@@ -274,7 +304,7 @@ func TestPostReservation(t *testing.T) {
 			req, _ = http.NewRequest("POST", "/make-reservation", nil)
 
 		}
-		ctx := getCtx(req)
+		ctx := getGuestCtx(req)
 		req = req.WithContext(ctx)
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
@@ -726,6 +756,7 @@ var adminPostShowReservationTests = []struct {
 		name: "valid-data-from-new",
 		url:  "/admin/reservations/new/1/show",
 		postedData: url.Values{
+			"version":    {"0"},
 			"first_name": {"John"},
 			"last_name":  {"Smith"},
 			"email":      {"john@smith.com"},
@@ -739,6 +770,7 @@ var adminPostShowReservationTests = []struct {
 		name: "valid-data-from-all",
 		url:  "/admin/reservations/all/1/show",
 		postedData: url.Values{
+			"version":    {"0"},
 			"first_name": {"John"},
 			"last_name":  {"Smith"},
 			"email":      {"john@smith.com"},
@@ -752,6 +784,7 @@ var adminPostShowReservationTests = []struct {
 		name: "valid-data-from-cal",
 		url:  "/admin/reservations/cal/1/show",
 		postedData: url.Values{
+			"version":    {"0"},
 			"first_name": {"John"},
 			"last_name":  {"Smith"},
 			"email":      {"john@smith.com"},
@@ -763,6 +796,24 @@ var adminPostShowReservationTests = []struct {
 		expectedLocation:     "/admin/reservations-calendar?y=2022&m=01",
 		expectedHTML:         "",
 	},
+	{
+		name: "no-version",
+		url:  "/admin/reservations/all/1/show",
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+		},
+		expectedResponseCode: http.StatusBadRequest,
+	},
+	{
+		name: "bad-version",
+		url:  "/admin/reservations/all/1/show",
+		postedData: url.Values{
+			"first_name": {"John"},
+			"version":    {"latest"},
+		},
+		expectedResponseCode: http.StatusBadRequest,
+	},
 }
 
 // TestAdminPostShowReservation tests the AdminPostReservation handler
@@ -809,74 +860,249 @@ func TestAdminPostShowReservation(t *testing.T) {
 	}
 }
 
-var adminPostReservationCalendarTests = []struct {
+var adminPostShowReservationChangeTests = []struct {
 	name                 string
 	postedData           url.Values
 	expectedResponseCode int
-	expectedLocation     string
+	expectedFlash        string
 	expectedHTML         string
-	blocks               int
-	reservations         int
 }{
 	{
-		name: "cal",
+		name: "move-room-and-notify",
 		postedData: url.Values{
-			"year":  {time.Now().Format("2006")},
-			"month": {time.Now().Format("01")},
-			fmt.Sprintf("add_block_1_%s", time.Now().AddDate(0, 0, 2).Format("2006-01-2")): {"1"},
+			"start_date":   {"2050-01-01"},
+			"end_date":     {"2050-01-03"},
+			"room_id":      {"2"},
+			"notify_guest": {"1"},
 		},
 		expectedResponseCode: http.StatusSeeOther,
+		expectedFlash:        "Changes saved and the guest notified",
 	},
 	{
-		name:                 "cal-blocks",
-		postedData:           url.Values{},
+		name: "move-dates-quietly",
+		postedData: url.Values{
+			"start_date": {"2050-02-01"},
+			"end_date":   {"2050-02-05"},
+			"room_id":    {"2"},
+		},
 		expectedResponseCode: http.StatusSeeOther,
-		blocks:               1,
+		expectedFlash:        "Changes saved",
 	},
 	{
-		name:                 "cal-res",
-		postedData:           url.Values{},
+		name: "unchanged-doesnt-notify",
+		postedData: url.Values{
+			"start_date":   {"2050-01-01"},
+			"end_date":     {"2050-01-03"},
+			"room_id":      {"1"},
+			"notify_guest": {"1"},
+		},
 		expectedResponseCode: http.StatusSeeOther,
-		reservations:         1,
+		expectedFlash:        "Changes saved",
+	},
+	{
+		name: "booked",
+		postedData: url.Values{
+			"start_date": {"2050-02-01"},
+			"end_date":   {"2050-02-05"},
+			"room_id":    {"1"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "isn&#39;t available for these dates",
+	},
+	{
+		name: "booked-while-saving",
+		postedData: url.Values{
+			"start_date": {"2051-02-01"},
+			"end_date":   {"2051-02-05"},
+			"room_id":    {"2"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "isn&#39;t available for these dates",
+	},
+	{
+		name: "departure-before-arrival",
+		postedData: url.Values{
+			"start_date": {"2050-02-05"},
+			"end_date":   {"2050-02-01"},
+			"room_id":    {"2"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "The departure must be after the arrival",
+	},
+	{
+		name: "bad-date",
+		postedData: url.Values{
+			"start_date": {"1/2/2050"},
+			"end_date":   {"2050-02-01"},
+			"room_id":    {"2"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "Enter the arrival date",
+	},
+	{
+		name: "missing-room",
+		postedData: url.Values{
+			"start_date": {"2050-01-01"},
+			"end_date":   {"2050-01-03"},
+			"room_id":    {"7"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "Choose a room",
 	},
 }
 
-func TestPostReservationCalendar(t *testing.T) {
-	for _, e := range adminPostReservationCalendarTests {
-		var req *http.Request
-		if e.postedData != nil {
-			req, _ = http.NewRequest("POST", "/admin/reservations-calendar", strings.NewReader(e.postedData.Encode()))
-		} else {
-			req, _ = http.NewRequest("POST", "/admin/reservations-calendar", nil)
-		}
+// TestAdminPostShowReservationChanges tests changing the dates and room of a reservation
+func TestAdminPostShowReservationChanges(t *testing.T) {
+	for _, e := range adminPostShowReservationChangeTests {
+		e.postedData.Set("first_name", "John")
+		e.postedData.Set("version", "2")
+		req, _ := http.NewRequest("POST", "/admin/reservations/all/3", strings.NewReader(e.postedData.Encode()))
 		ctx := getCtx(req)
 		req = req.WithContext(ctx)
+		req.RequestURI = "/admin/reservations/all/3"
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-		now := time.Now()
-		bm := make(map[string]int)
-		rm := make(map[string]int)
+		rr := httptest.NewRecorder()
+		Repo.AdminPostShowReservation(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+		if flash := session.PopString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("failed %s: expected flash %q, but got %q", e.name, e.expectedFlash, flash)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
 
-		currentYear, currentMonth, _ := now.Date()
-		currentLocation := now.Location()
+// TestAdminPostShowReservationStale tests that a save based on an old copy of
+// a reservation shows what changed instead
+func TestAdminPostShowReservationStale(t *testing.T) {
+	for version, expectedCode := range map[string]int{"1": http.StatusConflict, "2": http.StatusSeeOther} {
+		postedData := url.Values{
+			"first_name": {"Johnny"},
+			"version":    {version},
+		}
+		req, _ := http.NewRequest("POST", "/admin/reservations/all/3", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = "/admin/reservations/all/3"
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-		firstOfMonth := time.Date(currentYear, currentMonth, 1, 0, 0, 0, 0, currentLocation)
-		lastOfMonth := firstOfMonth.AddDate(0, 1, -1)
+		rr := httptest.NewRecorder()
+		Repo.AdminPostShowReservation(rr, req)
 
-		for d := firstOfMonth; d.After(lastOfMonth) == false; d = d.AddDate(0, 0, 1) {
-			rm[d.Format("2006-01-2")] = 0
-			bm[d.Format("2006-01-2")] = 0
+		if rr.Code != expectedCode {
+			t.Errorf("version %s: expected code %d, but got %d", version, expectedCode, rr.Code)
+		}
+		if expectedCode != http.StatusConflict {
+			continue
 		}
 
-		if e.blocks > 0 {
-			bm[firstOfMonth.Format("2006-01-2")] = e.blocks
+		body := rr.Body.String()
+		expected := []string{
+			`<tr class="table-warning">`,
+			"<td>Johnny</td>",
+			// saving over it posts the version it was shown
+			`name="version" value="2"`,
+			`name="first_name" value="Johnny"`,
+		}
+		for _, x := range expected {
+			if !strings.Contains(body, x) {
+				t.Errorf("expected to find %s but did not", x)
+			}
 		}
+	}
+}
+
+var adminPostReservationCalendarTests = []struct {
+	name                 string
+	postedData           url.Values
+	staleVersion         bool
+	expectedResponseCode int
+	expectedHTML         string
+	expectedError        string
+}{
+	{
+		name: "cal",
+		postedData: url.Values{
+			"add_block_1_2050-01-20": {"1"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+	},
+	{
+		name: "cal-maintenance",
+		postedData: url.Values{
+			"restriction_id":         {"4"},
+			"add_block_1_2050-01-20": {"1"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+	},
+	{
+		name: "cal-remove-block",
+		postedData: url.Values{
+			"block_1_2050-01-4": {"2"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+	},
+	{
+		name: "cal-keep-block",
+		postedData: url.Values{
+			"block_1_2050-01-4":        {"2"},
+			"remove_block_1_2050-01-4": {"2"},
+		},
+		staleVersion:         true,
+		expectedResponseCode: http.StatusSeeOther,
+	},
+	{
+		name: "cal-stale-add",
+		postedData: url.Values{
+			"add_block_1_2050-01-20": {"1"},
+		},
+		staleVersion:         true,
+		expectedResponseCode: http.StatusConflict,
+		expectedHTML:         "<td>2050-01-20</td>",
+	},
+	{
+		name: "cal-stale-remove",
+		postedData: url.Values{
+			"block_1_2050-01-4": {"2"},
+		},
+		staleVersion:         true,
+		expectedResponseCode: http.StatusConflict,
+		expectedHTML:         "<td>Owner Block</td>",
+	},
+	{
+		name: "cal-save-fails",
+		postedData: url.Values{
+			"add_block_1_2050-01-31": {"1"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedError:        "can't save all the changes to General`s Quarters",
+	},
+}
+
+// TestPostReservationCalendar tests saving the blocks ticked on the calendar
+func TestPostReservationCalendar(t *testing.T) {
+	first := time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)
+	cal, err := Repo.buildCalendar(first, first.AddDate(0, 1, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
 
-		if e.reservations > 0 {
-			rm[lastOfMonth.Format("2006-01-2")] = e.reservations
+	for _, e := range adminPostReservationCalendarTests {
+		e.postedData.Set("y", "2050")
+		e.postedData.Set("m", "1")
+		e.postedData.Set("version_1", cal.Rooms[0].Version)
+		if e.staleVersion {
+			e.postedData.Set("version_1", "stale")
 		}
 
-		session.Put(ctx, "block_map_1", bm)
-		session.Put(ctx, "reservation_map_1", rm)
+		req, _ := http.NewRequest("POST", "/admin/reservations-calendar", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
 
 		// set the header
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
@@ -889,7 +1115,16 @@ func TestPostReservationCalendar(t *testing.T) {
 		if rr.Code != e.expectedResponseCode {
 			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
 		}
-
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+		if msg := session.PopString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("failed %s: expected error %q, but got %q", e.name, e.expectedError, msg)
+		}
+		if e.expectedResponseCode == http.StatusConflict && !strings.Contains(rr.Body.String(),
+			fmt.Sprintf(`name="version_1" value="%s"`, cal.Rooms[0].Version)) {
+			t.Errorf("failed %s: expected the current version in the form to save anyway", e.name)
+		}
 	}
 }
 
@@ -932,46 +1167,3326 @@ func TestAdminProcessReservation(t *testing.T) {
 
 var adminDeleteReservationTests = []struct {
 	name                 string
+	id                   string
 	queryParams          string
 	expectedResponseCode int
 	expectedLocation     string
+	expectedFlash        string
+	expectedError        string
 }{
 	{
 		name:                 "delete-reservation",
+		id:                   "1",
 		queryParams:          "",
 		expectedResponseCode: http.StatusSeeOther,
-		expectedLocation:     "",
+		expectedLocation:     "/admin/reservations-cal",
+		expectedFlash:        "Reservation moved to the trash",
 	},
 	{
 		name:                 "delete-reservation-back-to-cal",
+		id:                   "1",
 		queryParams:          "?y=2021&m=12",
 		expectedResponseCode: http.StatusSeeOther,
-		expectedLocation:     "",
+		expectedLocation:     "/admin/reservations-calendar?y=2021&m=12",
+		expectedFlash:        "Reservation moved to the trash",
+	},
+	{
+		name:                 "deleted-already",
+		id:                   "9",
+		queryParams:          "",
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/reservations-cal",
+		expectedError:        "The reservation has been deleted already",
 	},
 }
 
 func TestAdminDeleteReservation(t *testing.T) {
 	for _, e := range adminDeleteReservationTests {
-		req, _ := http.NewRequest("GET", fmt.Sprintf("/admin/process-reservation/cal/1/do%s", e.queryParams), nil)
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/admin/delete-reservation/cal/%s/do%s", e.id, e.queryParams), nil)
 		ctx := getCtx(req)
 		req = req.WithContext(ctx)
+		req = withURLParams(req, map[string]string{"src": "cal", "id": e.id})
 
 		rr := httptest.NewRecorder()
 
 		handler := http.HandlerFunc(Repo.AdminDeleteReservation)
 		handler.ServeHTTP(rr, req)
 
-		if rr.Code != http.StatusSeeOther {
+		if rr.Code != e.expectedResponseCode {
 			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
 		}
+		if loc, _ := rr.Result().Location(); loc.String() != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, loc.String())
+		}
+		if flash := session.PopString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("failed %s: expected flash %q, but got %q", e.name, e.expectedFlash, flash)
+		}
+		if msg := session.PopString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("failed %s: expected error %q, but got %q", e.name, e.expectedError, msg)
+		}
 	}
 }
 
-// gets the context
-func getCtx(req *http.Request) context.Context {
-	ctx, err := session.Load(req.Context(), req.Header.Get("X-Session"))
-	if err != nil {
-		log.Println(err)
+var icalFeedTests = []struct {
+	name               string
+	url                string
+	scope              string
+	expires            time.Time
+	id                 string
+	handler            func(*Repository, http.ResponseWriter, *http.Request)
+	expectedStatusCode int
+	expectedICS        []string
+	unexpectedICS      []string
+}{
+	{
+		name:               "room-feed",
+		url:                "/ical/rooms/1.ics",
+		scope:              "ical:room:1",
+		id:                 "1",
+		handler:            (*Repository).ICalRoomFeed,
+		expectedStatusCode: http.StatusOK,
+		expectedICS:        []string{"UID:reservation-1@", "UID:restriction-2@", "SUMMARY:Blocked"},
+		unexpectedICS:      []string{"UID:restriction-4@"},
+	},
+	{
+		name:               "room-feed-wrong-token",
+		url:                "/ical/rooms/1.ics",
+		scope:              "ical:room:2",
+		id:                 "1",
+		handler:            (*Repository).ICalRoomFeed,
+		expectedStatusCode: http.StatusForbidden,
+	},
+	{
+		name:               "room-feed-no-token",
+		url:                "/ical/rooms/1.ics",
+		id:                 "1",
+		handler:            (*Repository).ICalRoomFeed,
+		expectedStatusCode: http.StatusForbidden,
+	},
+	{
+		name:               "room-feed-unknown-room",
+		url:                "/ical/rooms/3.ics",
+		scope:              "ical:room:3",
+		id:                 "3",
+		handler:            (*Repository).ICalRoomFeed,
+		expectedStatusCode: http.StatusNotFound,
+	},
+	{
+		name:               "room-feed-reset",
+		url:                "/ical/rooms/2.ics",
+		scope:              "ical:room:2:reset",
+		id:                 "2",
+		handler:            (*Repository).ICalRoomFeed,
+		expectedStatusCode: http.StatusOK,
+	},
+	{
+		name:               "room-feed-link-before-reset",
+		url:                "/ical/rooms/2.ics",
+		scope:              "ical:room:2",
+		id:                 "2",
+		handler:            (*Repository).ICalRoomFeed,
+		expectedStatusCode: http.StatusForbidden,
+	},
+	{
+		name:               "property-feed",
+		url:                "/ical/property.ics",
+		scope:              "ical:property",
+		handler:            (*Repository).ICalPropertyFeed,
+		expectedStatusCode: http.StatusOK,
+		expectedICS:        []string{"BEGIN:VCALENDAR"},
+	},
+	{
+		name:               "reservation-feed",
+		url:                "/ical/reservations/1.ics",
+		scope:              "ical:reservation:1",
+		expires:            time.Now().Add(time.Hour),
+		id:                 "1",
+		handler:            (*Repository).ICalReservationFeed,
+		expectedStatusCode: http.StatusOK,
+		expectedICS:        []string{"UID:reservation-1@", "Reservation reference: 1"},
+	},
+	{
+		name:               "reservation-feed-other-reservation",
+		url:                "/ical/reservations/2.ics",
+		scope:              "ical:reservation:1",
+		expires:            time.Now().Add(time.Hour),
+		id:                 "2",
+		handler:            (*Repository).ICalReservationFeed,
+		expectedStatusCode: http.StatusForbidden,
+	},
+	{
+		name:               "reservation-feed-expired",
+		url:                "/ical/reservations/1.ics",
+		scope:              "ical:reservation:1",
+		expires:            time.Now().Add(-time.Hour),
+		id:                 "1",
+		handler:            (*Repository).ICalReservationFeed,
+		expectedStatusCode: http.StatusForbidden,
+	},
+	{
+		name:               "reservation-feed-without-expiry",
+		url:                "/ical/reservations/1.ics",
+		scope:              "ical:reservation:1",
+		id:                 "1",
+		handler:            (*Repository).ICalReservationFeed,
+		expectedStatusCode: http.StatusForbidden,
+	},
+}
+
+// TestICalFeeds tests the room, property and reservation calendar feeds
+func TestICalFeeds(t *testing.T) {
+	for _, e := range icalFeedTests {
+		url := e.url
+		if !e.expires.IsZero() {
+			url += "?token=" + helpers.ExpiringToken(e.scope, e.expires)
+		} else if e.scope != "" {
+			url += "?token=" + helpers.SignedToken(e.scope)
+		}
+
+		req, _ := http.NewRequest("GET", url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		if e.id != "" {
+			req = withURLParams(req, map[string]string{"id": e.id})
+		}
+
+		rr := httptest.NewRecorder()
+		e.handler(Repo, rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		for _, x := range e.expectedICS {
+			if !strings.Contains(rr.Body.String(), x) {
+				t.Errorf("failed %s: expected to find %s in feed but did not", e.name, x)
+			}
+		}
+		for _, x := range e.unexpectedICS {
+			if strings.Contains(rr.Body.String(), x) {
+				t.Errorf("failed %s: expected not to find %s in feed but did", e.name, x)
+			}
+		}
+	}
+}
+
+// TestAdminPostResetCalendarFeed tests giving a calendar feed a new link
+func TestAdminPostResetCalendarFeed(t *testing.T) {
+	tests := []struct {
+		name          string
+		roomID        string
+		expectedFlash string
+		expectedError string
+	}{
+		{"property", "0", "Feed link reset, the old one no longer works", ""},
+		{"room", "1", "Feed link reset, the old one no longer works", ""},
+		{"database-error", "1001", "", "can't reset feed link"},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{"room_id": {e.roomID}}
+		req, _ := http.NewRequest("POST", "/admin/calendar-feeds/reset", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		Repo.AdminPostResetCalendarFeed(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+		if flash := session.PopString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("failed %s: expected flash %q, but got %q", e.name, e.expectedFlash, flash)
+		}
+		if msg := session.PopString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("failed %s: expected error %q, but got %q", e.name, e.expectedError, msg)
+		}
+	}
+}
+
+var adminPostICalFeedTests = []struct {
+	name               string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedLocation   string
+	expectedHTML       string
+}{
+	{
+		name: "valid-feed",
+		postedData: url.Values{
+			"room_id": {"1"},
+			"url":     {"https://www.airbnb.com/calendar/ical/1.ics"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/ical-feeds",
+	},
+	{
+		name: "invalid-url",
+		postedData: url.Values{
+			"room_id": {"1"},
+			"url":     {"not a url"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "Invalid URL",
+	},
+	{
+		name: "missing-room",
+		postedData: url.Values{
+			"url": {"https://www.airbnb.com/calendar/ical/1.ics"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       `action="/admin/ical-feeds"`,
+	},
+	{
+		name: "database-insert-fails",
+		postedData: url.Values{
+			"room_id": {"2"},
+			"url":     {"https://www.airbnb.com/calendar/ical/2.ics"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/ical-feeds",
+	},
+}
+
+// TestAdminPostICalFeed tests adding an imported calendar
+func TestAdminPostICalFeed(t *testing.T) {
+	for _, e := range adminPostICalFeedTests {
+		req, _ := http.NewRequest("POST", "/admin/ical-feeds", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostICalFeed)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+// TestAdminDeleteICalFeed tests removing an imported calendar
+func TestAdminDeleteICalFeed(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/ical-feeds/1/delete", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req = withURLParams(req, map[string]string{"id": "1"})
+
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminDeleteICalFeed)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("expected code %d, but got %d", http.StatusSeeOther, rr.Code)
+	}
+}
+
+var adminPostWebhookTests = []struct {
+	name               string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedLocation   string
+	expectedHTML       string
+}{
+	{
+		name: "valid-endpoint",
+		postedData: url.Values{
+			"url":                       {"https://example.com/hooks"},
+			"event_reservation.created": {"1"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/webhooks",
+	},
+	{
+		name: "invalid-url",
+		postedData: url.Values{
+			"url":                       {"not a url"},
+			"event_reservation.created": {"1"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "Invalid URL",
+	},
+	{
+		name: "no-events",
+		postedData: url.Values{
+			"url": {"https://example.com/hooks"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "Choose at least one event",
+	},
+	{
+		name: "database-insert-fails",
+		postedData: url.Values{
+			"url":                 {"https://example.com/fail"},
+			"event_block.created": {"1"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/webhooks",
+	},
+}
+
+// TestAdminPostWebhook tests adding a webhook endpoint
+func TestAdminPostWebhook(t *testing.T) {
+	for _, e := range adminPostWebhookTests {
+		req, _ := http.NewRequest("POST", "/admin/webhooks", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostWebhook)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+var adminActionTests = []struct {
+	name             string
+	url              string
+	handler          func(*Repository, http.ResponseWriter, *http.Request)
+	expectedLocation string
+}{
+	{"delete-endpoint", "/admin/webhooks/1/delete", (*Repository).AdminDeleteWebhook, "/admin/webhooks"},
+	{"retry-delivery", "/admin/webhooks/deliveries/1/retry", (*Repository).AdminRetryWebhookDelivery, "/admin/webhooks/deliveries"},
+	{"resend-mail", "/admin/mail/1/resend", (*Repository).AdminResendMail, "/admin/mail"},
+	{"pause-guest-message", "/admin/guest-messages/1/pause", (*Repository).AdminPauseGuestMessage, "/admin/guest-messages"},
+	{"resume-guest-message", "/admin/guest-messages/1/resume", (*Repository).AdminResumeGuestMessage, "/admin/guest-messages"},
+	{"delete-guest-message", "/admin/guest-messages/1/delete", (*Repository).AdminDeleteGuestMessage, "/admin/guest-messages"},
+	{"approve-review", "/admin/reviews/1/approve", (*Repository).AdminApproveReview, "/admin/reviews"},
+	{"hide-review", "/admin/reviews/1/hide", (*Repository).AdminHideReview, "/admin/reviews"},
+	{"delete-room", "/admin/rooms/1/delete", (*Repository).AdminDeleteRoom, "/admin/rooms"},
+	{"archive-enquiry", "/admin/enquiries/1/archive", (*Repository).AdminArchiveEnquiry, "/admin/enquiries"},
+	{"unarchive-enquiry", "/admin/enquiries/3/unarchive", (*Repository).AdminUnarchiveEnquiry, "/admin/enquiries"},
+	{"delete-block", "/admin/blocks/1/delete", (*Repository).AdminDeleteBlock, "/admin/blocks"},
+}
+
+// TestAdminDeleteBlockDeletedAlready tests that deleting a block twice doesn't
+// report it deleted again
+func TestAdminDeleteBlockDeletedAlready(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/blocks/9/delete", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req = withURLParams(req, map[string]string{"id": "9"})
+
+	rr := httptest.NewRecorder()
+	Repo.AdminDeleteBlock(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("expected code %d, but got %d", http.StatusSeeOther, rr.Code)
+	}
+	if flash := session.PopString(ctx, "flash"); flash != "" {
+		t.Errorf("expected no flash, but got %q", flash)
+	}
+	if msg := session.PopString(ctx, "error"); msg != "The block has been deleted already" {
+		t.Errorf("unexpected error %q", msg)
+	}
+}
+
+// TestAdminActions tests admin links that change something and redirect back
+func TestAdminActions(t *testing.T) {
+	for _, e := range adminActionTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req = withURLParams(req, map[string]string{"id": "1"})
+
+		rr := httptest.NewRecorder()
+		e.handler(Repo, rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+
+		actualLoc, _ := rr.Result().Location()
+		if actualLoc.String() != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+		}
+	}
+}
+
+var adminEmailTemplateTests = []struct {
+	name               string
+	method             string
+	template           string
+	handler            func(*Repository, http.ResponseWriter, *http.Request)
+	postedData         url.Values
+	expectedStatusCode int
+	expectedLocation   string
+	expectedHTML       string
+}{
+	{
+		name:               "show-default",
+		method:             "GET",
+		template:           "reservation-confirmation",
+		handler:            (*Repository).AdminEmailTemplate,
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "Reservation Confirmation",
+	},
+	{
+		name:               "show-override",
+		method:             "GET",
+		template:           "reservation-notification",
+		handler:            (*Repository).AdminEmailTemplate,
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "Booked: General&#39;s Quarters",
+	},
+	{
+		name:               "show-unknown",
+		method:             "GET",
+		template:           "no-such-email",
+		handler:            (*Repository).AdminEmailTemplate,
+		expectedStatusCode: http.StatusNotFound,
+	},
+	{
+		name:     "save-valid",
+		method:   "POST",
+		template: "reservation-confirmation",
+		handler:  (*Repository).AdminPostEmailTemplate,
+		postedData: url.Values{
+			"subject": {"Thanks {{.Reservation.FirstName}}"},
+			"body":    {"<p>See you on {{humanDate .Reservation.StartDate}}</p>"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/email-templates/reservation-confirmation",
+	},
+	{
+		name:     "save-blank-subject",
+		method:   "POST",
+		template: "reservation-confirmation",
+		handler:  (*Repository).AdminPostEmailTemplate,
+		postedData: url.Values{
+			"body": {"<p>Hello</p>"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "This field cannot be blank",
+	},
+	{
+		name:     "save-bad-syntax",
+		method:   "POST",
+		template: "reservation-confirmation",
+		handler:  (*Repository).AdminPostEmailTemplate,
+		postedData: url.Values{
+			"subject": {"Hello"},
+			"body":    {"<p>{{.Reservation.FirstName</p>"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "is-invalid",
+	},
+	{
+		name:     "save-unknown-field",
+		method:   "POST",
+		template: "reservation-confirmation",
+		handler:  (*Repository).AdminPostEmailTemplate,
+		postedData: url.Values{
+			"subject": {"Hello"},
+			"body":    {"<p>{{.Reservation.Nickname}}</p>"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "Nickname",
+	},
+	{
+		name:     "save-database-fails",
+		method:   "POST",
+		template: "reservation-confirmation",
+		handler:  (*Repository).AdminPostEmailTemplate,
+		postedData: url.Values{
+			"subject": {"fail"},
+			"body":    {"<p>Hello</p>"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/email-templates/reservation-confirmation",
+	},
+	{
+		name:     "preview-valid",
+		method:   "POST",
+		template: "reservation-confirmation",
+		handler:  (*Repository).AdminPreviewEmailTemplate,
+		postedData: url.Values{
+			"subject": {"Hi {{.Reservation.FirstName}}"},
+			"body":    {"<p>Hello</p>"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       `"subject": "Hi John"`,
+	},
+	{
+		name:     "preview-invalid",
+		method:   "POST",
+		template: "reservation-confirmation",
+		handler:  (*Repository).AdminPreviewEmailTemplate,
+		postedData: url.Values{
+			"subject": {"Hi"},
+			"body":    {"<p>{{end}}</p>"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       `"ok": false`,
+	},
+	{
+		name:     "send-test",
+		method:   "POST",
+		template: "reservation-reminder",
+		handler:  (*Repository).AdminTestEmailTemplate,
+		postedData: url.Values{
+			"subject": {"See you soon"},
+			"body":    {"<p>Hello {{.Reservation.FirstName}}</p>"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "Hello {{.Reservation.FirstName}}",
+	},
+	{
+		name:               "reset",
+		method:             "GET",
+		template:           "reservation-notification",
+		handler:            (*Repository).AdminResetEmailTemplate,
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/email-templates/reservation-notification",
+	},
+}
+
+// TestAdminEmailTemplates tests the email template editor
+func TestAdminEmailTemplates(t *testing.T) {
+	for _, e := range adminEmailTemplateTests {
+		var req *http.Request
+		if e.method == "POST" {
+			req, _ = http.NewRequest("POST", "/admin/email-templates/"+e.template, strings.NewReader(e.postedData.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req, _ = http.NewRequest("GET", "/admin/email-templates/"+e.template, nil)
+		}
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req = withURLParams(req, map[string]string{"name": e.template})
+
+		rr := httptest.NewRecorder()
+		e.handler(Repo, rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+// TestReservationInvite tests the calendar invites attached to reservation emails
+func TestReservationInvite(t *testing.T) {
+	res := models.Reservation{
+		ID:        7,
+		Email:     "john@smith.com",
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		Room:      models.Room{RoomName: "General's Quarters"},
+	}
+
+	a := Repo.reservationInvite(res, inviteRequest)
+	if a.Name != "invite.ics" || !strings.Contains(a.ContentType, "method=REQUEST") {
+		t.Errorf("unexpected attachment %s %s", a.Name, a.ContentType)
+	}
+
+	invite := strings.ReplaceAll(string(a.Data), "\r\n ", "")
+	checkIn := time.Date(2050, 1, 1, checkInHour, 0, 0, 0, time.Local).UTC().Format("20060102T150405Z")
+	checkOut := time.Date(2050, 1, 3, checkOutHour, 0, 0, 0, time.Local).UTC().Format("20060102T150405Z")
+	for _, e := range []string{
+		"METHOD:REQUEST",
+		"UID:reservation-7@" + uidDomain,
+		"DTSTART:" + checkIn,
+		"DTEND:" + checkOut,
+		"Reservation #7",
+		"LOCATION:100 Rocky Road\\, Northbrook",
+		"STATUS:CONFIRMED",
+		"mailto:john@smith.com",
+	} {
+		if !strings.Contains(invite, e) {
+			t.Errorf("expected invite to contain %q, got:\n%s", e, invite)
+		}
+	}
+
+	a = Repo.reservationInvite(res, inviteCancel)
+	if !strings.Contains(string(a.Data), "METHOD:CANCEL") || !strings.Contains(string(a.Data), "STATUS:CANCELLED") {
+		t.Errorf("expected a cancellation, got:\n%s", a.Data)
+	}
+}
+
+// withURLParams adds chi url parameters to a request, for handlers tested without the router
+func withURLParams(req *http.Request, params map[string]string) *http.Request {
+	rctx := chi.NewRouteContext()
+	for k, v := range params {
+		rctx.URLParams.Add(k, v)
+	}
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+}
+
+// gets the context
+func getCtx(req *http.Request) context.Context {
+	ctx, err := session.Load(req.Context(), req.Header.Get("X-Session"))
+	if err != nil {
+		log.Println(err)
+	}
+	return ctx
+}
+
+// getGuestCtx returns the context of a request made with the guest session as
+// well, as on the account pages and the booking form
+func getGuestCtx(req *http.Request) context.Context {
+	ctx, err := guestSession.Load(getCtx(req), req.Header.Get("X-Guest-Session"))
+	if err != nil {
+		log.Println(err)
+	}
+	return helpers.WithGuestSession(ctx)
+}
+
+// TestAdminGuestMessages tests the guest message schedules page
+func TestAdminGuestMessages(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/guest-messages", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.AdminGuestMessages)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected code %d, but got %d", http.StatusOK, rr.Code)
+	}
+
+	for _, s := range []string{"3 days before arrival", "1 day after departure", "Check-in instructions", "John Smith"} {
+		if !strings.Contains(rr.Body.String(), s) {
+			t.Errorf("expected to find %q but did not", s)
+		}
+	}
+}
+
+var adminPostGuestMessageTests = []struct {
+	name               string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedLocation   string
+	expectedHTML       string
+}{
+	{
+		name: "valid-schedule",
+		postedData: url.Values{
+			"message": {"reservation-checkin"},
+			"days":    {"0"},
+			"when":    {"after"},
+			"anchor":  {"arrival"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/guest-messages",
+	},
+	{
+		name: "unschedulable-message",
+		postedData: url.Values{
+			"message": {"reservation-notification"},
+			"days":    {"1"},
+			"when":    {"before"},
+			"anchor":  {"arrival"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "Choose a message",
+	},
+	{
+		name: "bad-days",
+		postedData: url.Values{
+			"message": {"reservation-reminder"},
+			"days":    {"-2"},
+			"when":    {"before"},
+			"anchor":  {"arrival"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "Enter a number of days from 0 to 365",
+	},
+	{
+		name: "bad-anchor",
+		postedData: url.Values{
+			"message": {"reservation-reminder"},
+			"days":    {"2"},
+			"when":    {"before"},
+			"anchor":  {"booking"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "Choose arrival or departure",
+	},
+	{
+		name: "database-insert-fails",
+		postedData: url.Values{
+			"message": {"reservation-thank-you"},
+			"days":    {"99"},
+			"when":    {"after"},
+			"anchor":  {"departure"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/guest-messages",
+	},
+}
+
+// TestAdminPostGuestMessage tests scheduling a guest message
+func TestAdminPostGuestMessage(t *testing.T) {
+	for _, e := range adminPostGuestMessageTests {
+		req, _ := http.NewRequest("POST", "/admin/guest-messages", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostGuestMessage)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+var reviewTests = []struct {
+	name               string
+	id                 string
+	token              string
+	expired            bool
+	method             string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedLocation   string
+	expectedHTML       string
+}{
+	{name: "form", id: "2", method: "GET", expectedStatusCode: http.StatusOK, expectedHTML: "Send Review"},
+	{name: "before-stay", id: "3", method: "GET", expectedStatusCode: http.StatusOK, expectedHTML: "once it is over"},
+	{name: "already-reviewed", id: "4", method: "GET", expectedStatusCode: http.StatusOK, expectedHTML: "already reviewed"},
+	{name: "bad-token", id: "2", token: "nope", method: "GET", expectedStatusCode: http.StatusForbidden},
+	{name: "expired-token", id: "2", expired: true, method: "GET", expectedStatusCode: http.StatusForbidden},
+	{name: "no-reservation", id: "1", method: "GET", expectedStatusCode: http.StatusNotFound},
+	{name: "reservation-error", id: "1001", method: "GET", expectedStatusCode: http.StatusNotFound},
+	{name: "bad-id", id: "x", method: "GET", expectedStatusCode: http.StatusNotFound},
+	{
+		name:               "post-valid",
+		id:                 "2",
+		method:             "POST",
+		postedData:         url.Values{"rating": {"5"}, "body": {"Wonderful stay"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/",
+	},
+	{
+		name:               "post-no-rating",
+		id:                 "2",
+		method:             "POST",
+		postedData:         url.Values{"body": {"Wonderful stay"}},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "Choose a rating",
+	},
+	{
+		name:               "post-rating-out-of-range",
+		id:                 "2",
+		method:             "POST",
+		postedData:         url.Values{"rating": {"6"}, "body": {"Wonderful stay"}},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "Choose a rating",
+	},
+	{
+		name:               "post-no-body",
+		id:                 "2",
+		method:             "POST",
+		postedData:         url.Values{"rating": {"4"}},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "This field cannot be blank",
+	},
+	{
+		name:               "post-before-stay",
+		id:                 "3",
+		method:             "POST",
+		postedData:         url.Values{"rating": {"4"}, "body": {"Early"}},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "once it is over",
+	},
+	{
+		name:               "post-bad-token",
+		id:                 "2",
+		token:              "nope",
+		method:             "POST",
+		postedData:         url.Values{"rating": {"4"}, "body": {"Wonderful stay"}},
+		expectedStatusCode: http.StatusForbidden,
+	},
+	{
+		name:               "post-database-insert-fails",
+		id:                 "2",
+		method:             "POST",
+		postedData:         url.Values{"rating": {"4"}, "body": {"fail"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/",
+	},
+}
+
+// TestReview tests the guest review form
+func TestReview(t *testing.T) {
+	for _, e := range reviewTests {
+		id, _ := strconv.Atoi(e.id)
+		token := e.token
+		if token == "" {
+			expires := time.Now().Add(time.Hour)
+			if e.expired {
+				expires = time.Now().Add(-time.Hour)
+			}
+			token = helpers.ExpiringToken(reviews.Scope(id), expires)
+		}
+
+		var req *http.Request
+		if e.method == "POST" {
+			data := e.postedData
+			data.Set("token", token)
+			req, _ = http.NewRequest("POST", "/reviews/"+e.id, strings.NewReader(data.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req, _ = http.NewRequest("GET", "/reviews/"+e.id+"?token="+token, nil)
+		}
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req = withURLParams(req, map[string]string{"id": e.id})
+
+		rr := httptest.NewRecorder()
+		if e.method == "POST" {
+			Repo.PostReview(rr, req)
+		} else {
+			Repo.Review(rr, req)
+		}
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+// TestRoomReviews tests approved reviews on the room pages and in the JSON feed
+func TestRoomReviews(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/rooms/generals-quarters", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req = withURLParams(req, map[string]string{"slug": "generals-quarters"})
+
+	rr := httptest.NewRecorder()
+	Repo.Room(rr, req)
+
+	for _, s := range []string{"4.5 out of 5 from 2 reviews", "Quiet and comfortable", "Thank you!"} {
+		if !strings.Contains(rr.Body.String(), s) {
+			t.Errorf("expected room page to contain %q", s)
+		}
+	}
+
+	req, _ = http.NewRequest("GET", "/rooms/generals-quarters/reviews.json", nil)
+	req = withURLParams(req, map[string]string{"slug": "generals-quarters"})
+
+	rr = httptest.NewRecorder()
+	Repo.RoomReviewsJSON(rr, req)
+
+	var resp roomReviewsResponse
+	err := json.Unmarshal(rr.Body.Bytes(), &resp)
+	if err != nil {
+		t.Fatal("failed to parse json", err)
+	}
+	if resp.RoomID != 1 || resp.Room != "generals-quarters" || resp.Count != 2 || resp.Average != 4.5 || len(resp.Reviews) != 2 {
+		t.Errorf("unexpected response %+v", resp)
+	}
+	if resp.Reviews[0].GuestName != "Jane D." || resp.Reviews[0].Reply != "Thank you!" {
+		t.Errorf("unexpected review %+v", resp.Reviews[0])
+	}
+
+	req, _ = http.NewRequest("GET", "/rooms/attic/reviews.json", nil)
+	req = withURLParams(req, map[string]string{"slug": "attic"})
+
+	rr = httptest.NewRecorder()
+	Repo.RoomReviewsJSON(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected code %d for a missing room, but got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+// TestAdminReplyReview tests replying to a review
+func TestAdminReplyReview(t *testing.T) {
+	for _, reply := range []string{"Thanks for staying", "fail"} {
+		data := url.Values{"reply": {reply}}
+		req, _ := http.NewRequest("POST", "/admin/reviews/1/reply", strings.NewReader(data.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = withURLParams(req, map[string]string{"id": "1"})
+
+		rr := httptest.NewRecorder()
+		Repo.AdminReplyReview(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("reply %q: expected code %d, but got %d", reply, http.StatusSeeOther, rr.Code)
+		}
+		actualLoc, _ := rr.Result().Location()
+		if actualLoc.String() != "/admin/reviews" {
+			t.Errorf("reply %q: expected location /admin/reviews, but got %s", reply, actualLoc.String())
+		}
+	}
+}
+
+// TestAdminSetReviewStatus tests that approving or hiding a review says when it
+// couldn't be saved
+func TestAdminSetReviewStatus(t *testing.T) {
+	tests := []struct {
+		name          string
+		handler       func(*Repository, http.ResponseWriter, *http.Request)
+		id            string
+		expectedFlash string
+		expectedError string
+	}{
+		{"approve", (*Repository).AdminApproveReview, "1", "Review approved", ""},
+		{"hide", (*Repository).AdminHideReview, "1", "Review hidden", ""},
+		{"approve-fails", (*Repository).AdminApproveReview, "1001", "", "can't change review"},
+		{"hide-fails", (*Repository).AdminHideReview, "1001", "", "can't change review"},
+	}
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/reviews/"+e.id+"/approve", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req = withURLParams(req, map[string]string{"id": e.id})
+
+		rr := httptest.NewRecorder()
+		e.handler(Repo, rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+		if flash := session.PopString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("%s: expected flash %q, but got %q", e.name, e.expectedFlash, flash)
+		}
+		if msg := session.PopString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("%s: expected error %q, but got %q", e.name, e.expectedError, msg)
+		}
+	}
+}
+
+var roomTests = []struct {
+	name               string
+	slug               string
+	expectedStatusCode int
+	expectedHTML       string
+}{
+	{"generals", "generals-quarters", http.StatusOK, "CheckAvailability ( 1 )"},
+	{"majors", "majors-suite", http.StatusOK, "Sleeps 4"},
+	{"amenities", "generals-quarters", http.StatusOK, "<li>Ocean view</li>"},
+	{"gallery", "generals-quarters", http.StatusOK, "/uploads/rooms/1/aaaaaaaaaaaaaaaa-medium.jpg"},
+	{"missing", "attic", http.StatusNotFound, ""},
+	{"database-error", "fail", http.StatusInternalServerError, ""},
+}
+
+// TestRoom tests the room pages
+func TestRoom(t *testing.T) {
+	for _, e := range roomTests {
+		req, _ := http.NewRequest("GET", "/rooms/"+e.slug, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req = withURLParams(req, map[string]string{"slug": e.slug})
+
+		rr := httptest.NewRecorder()
+		Repo.Room(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+var adminPostRoomTests = []struct {
+	name               string
+	id                 string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedLocation   string
+	expectedHTML       string
+}{
+	{
+		name: "new-room",
+		id:   "new",
+		postedData: url.Values{
+			"room_name":     {"Colonel's Loft"},
+			"capacity":      {"3"},
+			"display_order": {"3"},
+			"nightly_rate":  {"120.50"},
+			"amenities":     {"Double bed\r\n\r\nSkylight"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/rooms",
+	},
+	{
+		name: "edit-room-keeps-its-slug",
+		id:   "1",
+		postedData: url.Values{
+			"room_name":     {"General`s Quarters"},
+			"slug":          {"generals-quarters"},
+			"capacity":      {"2"},
+			"display_order": {"1"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/rooms",
+	},
+	{
+		name: "slug-taken",
+		id:   "new",
+		postedData: url.Values{
+			"room_name":     {"Major's Suite"},
+			"capacity":      {"2"},
+			"display_order": {"0"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "already uses this address",
+	},
+	{
+		name: "bad-slug",
+		id:   "new",
+		postedData: url.Values{
+			"room_name":     {"Loft"},
+			"slug":          {"The Loft"},
+			"capacity":      {"2"},
+			"display_order": {"0"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "Use lowercase letters, numbers and dashes",
+	},
+	{
+		name: "no-capacity",
+		id:   "new",
+		postedData: url.Values{
+			"room_name":     {"Loft"},
+			"capacity":      {"0"},
+			"display_order": {"0"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "Enter how many guests the room sleeps",
+	},
+	{
+		name: "bad-rate",
+		id:   "new",
+		postedData: url.Values{
+			"room_name":     {"Loft"},
+			"capacity":      {"2"},
+			"display_order": {"0"},
+			"nightly_rate":  {"-5"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "Enter the price of a night",
+	},
+	{
+		name: "no-name",
+		id:   "new",
+		postedData: url.Values{
+			"slug":          {"loft"},
+			"capacity":      {"2"},
+			"display_order": {"0"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "This field cannot be blank",
+	},
+	{
+		name: "database-insert-fails",
+		id:   "new",
+		postedData: url.Values{
+			"room_name":     {"fail"},
+			"slug":          {"broken"},
+			"capacity":      {"2"},
+			"display_order": {"0"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/rooms",
+	},
+}
+
+// TestAdminPostRoom tests adding and editing rooms
+func TestAdminPostRoom(t *testing.T) {
+	for _, e := range adminPostRoomTests {
+		req, _ := http.NewRequest("POST", "/admin/rooms/"+e.id, strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = withURLParams(req, map[string]string{"id": e.id})
+
+		rr := httptest.NewRecorder()
+		Repo.AdminPostRoom(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+// TestAdminRoom tests the room form
+func TestAdminRoom(t *testing.T) {
+	for id, expected := range map[string]string{"new": "Add Room", "1": "generals-quarters"} {
+		req, _ := http.NewRequest("GET", "/admin/rooms/"+id, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req = withURLParams(req, map[string]string{"id": id})
+
+		rr := httptest.NewRecorder()
+		Repo.AdminRoom(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("room %s: expected code %d, but got %d", id, http.StatusOK, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("room %s: expected to find %s but did not", id, expected)
+		}
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	tests := map[string]string{
+		"Major's Suite":        "majors-suite",
+		"General`s Quarters":   "generals-quarters",
+		"  The Loft -- No. 3 ": "the-loft-no-3",
+	}
+	for name, expected := range tests {
+		if got := slugify(name); got != expected {
+			t.Errorf("slugify(%q) = %q, expected %q", name, got, expected)
+		}
+	}
+}
+
+// testPNG returns a small PNG image
+func testPNG(t *testing.T) []byte {
+	var buf bytes.Buffer
+	err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 40, 30)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+var adminPostRoomPhotosTests = []struct {
+	name             string
+	id               string
+	files            map[string][]byte
+	expectedStatus   int
+	expectedFlash    string
+	expectedError    string
+	expectedLocation string
+}{
+	{"upload", "1", map[string][]byte{"room.png": nil}, http.StatusSeeOther, "1 photos uploaded", "", "/admin/rooms/1"},
+	{"not-an-image", "1", map[string][]byte{"notes.txt": []byte("just some text")}, http.StatusSeeOther, "", "notes.txt: photos must be JPEG, PNG or GIF images", "/admin/rooms/1"},
+	{"no-files", "1", map[string][]byte{}, http.StatusSeeOther, "", "Choose the photos to upload", "/admin/rooms/1"},
+	{"database-error", "2", map[string][]byte{"room.png": nil}, http.StatusSeeOther, "", "room.png couldn't be saved", "/admin/rooms/2"},
+	{"bad-id", "x", map[string][]byte{"room.png": nil}, http.StatusNotFound, "", "", ""},
+}
+
+// TestAdminPostRoomPhotos tests uploading room photos
+func TestAdminPostRoomPhotos(t *testing.T) {
+	for _, e := range adminPostRoomPhotosTests {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		for name, data := range e.files {
+			if data == nil {
+				data = testPNG(t)
+			}
+			fw, err := mw.CreateFormFile("photos", name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fw.Write(data)
+		}
+		mw.Close()
+
+		req, _ := http.NewRequest("POST", "/admin/rooms/"+e.id+"/photos", &body)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		req = withURLParams(req, map[string]string{"id": e.id})
+
+		rr := httptest.NewRecorder()
+		Repo.AdminPostRoomPhotos(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if flash := session.PopString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("failed %s: expected flash %q, but got %q", e.name, e.expectedFlash, flash)
+		}
+		if msg := session.PopString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("failed %s: expected error %q, but got %q", e.name, e.expectedError, msg)
+		}
+	}
+
+	// the failed upload's files were removed again
+	matches, _ := filepath.Glob(filepath.Join(app.UploadDir, "rooms", "2", "*"))
+	if len(matches) > 0 {
+		t.Errorf("expected no files for room 2, found %v", matches)
+	}
+}
+
+// TestAdminOrderRoomPhotos tests reordering room photos
+func TestAdminOrderRoomPhotos(t *testing.T) {
+	tests := []struct {
+		name          string
+		id            string
+		postedData    url.Values
+		expectedError string
+	}{
+		{"reorder", "1", url.Values{"position_1": {"2"}, "position_2": {"1"}}, ""},
+		{"not-a-number", "1", url.Values{"position_1": {"first"}, "position_2": {"1"}}, "Enter a number for each photo"},
+		{"database-error", "2", url.Values{"position_3": {"1"}}, "can't save photo order"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/rooms/"+e.id+"/photos/order", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = withURLParams(req, map[string]string{"id": e.id})
+
+		rr := httptest.NewRecorder()
+		Repo.AdminOrderRoomPhotos(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+		if msg := session.PopString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("failed %s: expected error %q, but got %q", e.name, e.expectedError, msg)
+		}
+	}
+}
+
+// TestAdminDeleteRoomPhoto tests deleting a room photo
+func TestAdminDeleteRoomPhoto(t *testing.T) {
+	tests := []struct {
+		name          string
+		id            string
+		photoID       string
+		expectedError string
+	}{
+		{"delete", "1", "1", ""},
+		{"other-room", "1", "3", "Photo not found"},
+		{"missing", "1", "9", "Photo not found"},
+		{"database-error", "1", "1001", "Photo not found"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/rooms/"+e.id+"/photos/"+e.photoID+"/delete", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req = withURLParams(req, map[string]string{"id": e.id, "photoID": e.photoID})
+
+		rr := httptest.NewRecorder()
+		Repo.AdminDeleteRoomPhoto(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+		actualLoc, _ := rr.Result().Location()
+		if actualLoc.String() != "/admin/rooms/"+e.id {
+			t.Errorf("failed %s: expected location /admin/rooms/%s, but got %s", e.name, e.id, actualLoc.String())
+		}
+		if msg := session.PopString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("failed %s: expected error %q, but got %q", e.name, e.expectedError, msg)
+		}
+	}
+}
+
+var postContactTests = []struct {
+	name               string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedHTML       string
+	expectedFlash      string
+	expectedError      string
+}{
+	{
+		name: "valid",
+		postedData: url.Values{
+			"name":    {"John Smith"},
+			"email":   {"john@smith.com"},
+			"message": {"Do you allow dogs?"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedFlash:      "Thank you! We'll get back to you soon.",
+	},
+	{
+		name:               "missing-fields",
+		postedData:         url.Values{"email": {"john@smith.com"}},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "This field cannot be blank",
+	},
+	{
+		name: "invalid-email",
+		postedData: url.Values{
+			"name":    {"John Smith"},
+			"email":   {"john"},
+			"message": {"Do you allow dogs?"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "Invalid email address",
+	},
+	{
+		name: "too-long",
+		postedData: url.Values{
+			"name":    {"John Smith"},
+			"email":   {"john@smith.com"},
+			"message": {strings.Repeat("a", maxEnquiryLength+1)},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "Please keep your message under",
+	},
+	{
+		// a filled honeypot is thanked but not saved, so the failing name never reaches the database
+		name: "honeypot",
+		postedData: url.Values{
+			"name":    {"fail"},
+			"email":   {"bot@spam.com"},
+			"message": {"Cheap pills"},
+			"website": {"http://spam.com"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedFlash:      "Thank you! We'll get back to you soon.",
+	},
+	{
+		name: "database-error",
+		postedData: url.Values{
+			"name":    {"fail"},
+			"email":   {"john@smith.com"},
+			"message": {"Do you allow dogs?"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedError:      "can't send your message, please try again",
+	},
+}
+
+// TestPostContact tests sending the contact form
+func TestPostContact(t *testing.T) {
+	for _, e := range postContactTests {
+		req, _ := http.NewRequest("POST", "/contact", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		Repo.PostContact(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+		if flash := session.PopString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("failed %s: expected flash %q, but got %q", e.name, e.expectedFlash, flash)
+		}
+		if msg := session.PopString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("failed %s: expected error %q, but got %q", e.name, e.expectedError, msg)
+		}
+	}
+}
+
+var adminEnquiryTests = []struct {
+	name               string
+	id                 string
+	expectedStatusCode int
+	expectedHTML       string
+}{
+	{"unread", "1", http.StatusOK, "Do you allow dogs?"},
+	{"linked", "2", http.StatusOK, "/admin/reservations/all/2/show"},
+	{"with-reply", "2", http.StatusOK, "the door code is in your confirmation"},
+	{"missing", "9", http.StatusNotFound, ""},
+	{"bad-id", "x", http.StatusNotFound, ""},
+	{"database-error", "1001", http.StatusInternalServerError, ""},
+}
+
+// TestAdminEnquiry tests showing an enquiry
+func TestAdminEnquiry(t *testing.T) {
+	for _, e := range adminEnquiryTests {
+		req, _ := http.NewRequest("GET", "/admin/enquiries/"+e.id, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req = withURLParams(req, map[string]string{"id": e.id})
+
+		rr := httptest.NewRecorder()
+		Repo.AdminEnquiry(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+var adminEnquiryPostTests = []struct {
+	name               string
+	handler            func(*Repository, http.ResponseWriter, *http.Request)
+	postedData         url.Values
+	expectedStatusCode int
+	expectedHTML       string
+	expectedFlash      string
+	expectedError      string
+}{
+	{"reply", (*Repository).AdminReplyEnquiry, url.Values{"reply": {"Yes, dogs are welcome."}}, http.StatusSeeOther, "", "Reply sent", ""},
+	{"blank-reply", (*Repository).AdminReplyEnquiry, url.Values{"reply": {" "}}, http.StatusOK, "This field cannot be blank", "", ""},
+	{"reply-error", (*Repository).AdminReplyEnquiry, url.Values{"reply": {"fail"}}, http.StatusSeeOther, "", "", "can't send reply"},
+	{"link", (*Repository).AdminLinkEnquiry, url.Values{"reservation_id": {"2"}}, http.StatusSeeOther, "", "Reservation linked", ""},
+	{"unlink", (*Repository).AdminLinkEnquiry, url.Values{"reservation_id": {""}}, http.StatusSeeOther, "", "Reservation unlinked", ""},
+	{"link-not-a-number", (*Repository).AdminLinkEnquiry, url.Values{"reservation_id": {"abc"}}, http.StatusOK, "Enter a reservation number", "", ""},
+	{"link-missing", (*Repository).AdminLinkEnquiry, url.Values{"reservation_id": {"7"}}, http.StatusOK, "There is no reservation 7", "", ""},
+}
+
+// TestAdminEnquiryPosts tests replying to enquiries and linking them to reservations
+func TestAdminEnquiryPosts(t *testing.T) {
+	for _, e := range adminEnquiryPostTests {
+		req, _ := http.NewRequest("POST", "/admin/enquiries/1", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = withURLParams(req, map[string]string{"id": "1"})
+
+		rr := httptest.NewRecorder()
+		e.handler(Repo, rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedStatusCode == http.StatusSeeOther {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != "/admin/enquiries/1" {
+				t.Errorf("failed %s: expected location /admin/enquiries/1, but got %s", e.name, actualLoc.String())
+			}
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+		if flash := session.PopString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("failed %s: expected flash %q, but got %q", e.name, e.expectedFlash, flash)
+		}
+		if msg := session.PopString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("failed %s: expected error %q, but got %q", e.name, e.expectedError, msg)
+		}
+	}
+}
+
+var adminBlockTests = []struct {
+	name               string
+	url                string
+	id                 string
+	conflicts          []string
+	expectedStatusCode int
+	expectedHTML       string
+}{
+	{"new", "/admin/blocks/new", "new", nil, http.StatusOK, `action="/admin/blocks/new"`},
+	{"new-from-calendar", "/admin/blocks/new?room=1&start=2050-02-01", "new", nil, http.StatusOK, `value="2050-02-01"`},
+	{"range", "/admin/blocks/1", "1", nil, http.StatusOK, `value="Painting"`},
+	{"new-is-owner-block", "/admin/blocks/new", "new", nil, http.StatusOK, `<option value="2" selected>Owner Block</option>`},
+	{"type", "/admin/blocks/2", "2", nil, http.StatusOK, `<option value="4" selected>Maintenance</option>`},
+	{"last-night", "/admin/blocks/1", "1", nil, http.StatusOK, `value="2050-01-08"`},
+	{"repeating", "/admin/blocks/2", "2", nil, http.StatusOK, `id="weekday_1" checked`},
+	{"conflicts", "/admin/blocks/1", "1", []string{"General's Quarters: 2 nights from 2050-02-01, reservation 1"}, http.StatusOK, "2 nights from 2050-02-01"},
+	{"missing", "/admin/blocks/9", "9", nil, http.StatusNotFound, ""},
+	{"bad-id", "/admin/blocks/x", "x", nil, http.StatusNotFound, ""},
+	{"database-error", "/admin/blocks/1001", "1001", nil, http.StatusInternalServerError, ""},
+}
+
+// TestAdminBlock tests showing the block form
+func TestAdminBlock(t *testing.T) {
+	for _, e := range adminBlockTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req = withURLParams(req, map[string]string{"id": e.id})
+		if e.conflicts != nil {
+			session.Put(ctx, "block_conflicts", e.conflicts)
+		}
+
+		rr := httptest.NewRecorder()
+		Repo.AdminBlock(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+var adminPostBlockTests = []struct {
+	name               string
+	id                 string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedLocation   string
+	expectedHTML       string
+	expectedFlash      string
+	expectedError      string
+	expectedConflicts  int
+}{
+	{
+		name: "free-nights",
+		id:   "new",
+		postedData: url.Values{
+			"room_id":    {"2"},
+			"start_date": {"2050-02-01"},
+			"last_night": {"2050-02-04"},
+			"reason":     {"Maintenance"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/blocks/3",
+		expectedFlash:      "Block saved",
+	},
+	{
+		name: "edit",
+		id:   "1",
+		postedData: url.Values{
+			"room_id":    {"2"},
+			"start_date": {"2050-02-01"},
+			"last_night": {"2050-02-01"},
+			"reason":     {"Painting"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/blocks/1",
+		expectedFlash:      "Block saved",
+	},
+	{
+		name: "partly-booked",
+		id:   "new",
+		postedData: url.Values{
+			"room_id":    {"1", "2"},
+			"start_date": {"2050-02-01"},
+			"last_night": {"2050-02-04"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/blocks/3",
+		expectedError:      "Block saved, except for nights that are already booked",
+		expectedConflicts:  1,
+	},
+	{
+		name: "all-booked",
+		id:   "new",
+		postedData: url.Values{
+			"room_id":    {"1"},
+			"start_date": {"2050-02-01"},
+			"last_night": {"2050-02-02"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "Every one of these nights is already booked",
+	},
+	{
+		name: "no-rooms",
+		id:   "new",
+		postedData: url.Values{
+			"start_date": {"2050-02-01"},
+			"last_night": {"2050-02-02"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "Choose the rooms to block",
+	},
+	{
+		name: "backwards",
+		id:   "new",
+		postedData: url.Values{
+			"room_id":    {"2"},
+			"start_date": {"2050-02-05"},
+			"last_night": {"2050-02-01"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "The last night can&#39;t be before the first",
+	},
+	{
+		name: "too-long",
+		id:   "new",
+		postedData: url.Values{
+			"room_id":    {"2"},
+			"start_date": {"2050-02-01"},
+			"last_night": {"2055-02-01"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "A block can span at most",
+	},
+	{
+		name: "no-matching-days",
+		id:   "new",
+		postedData: url.Values{
+			"room_id":    {"2"},
+			"start_date": {"2050-02-01"},
+			"last_night": {"2050-02-01"},
+			"weekday":    {"0"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "None of these days fall between the first and last night",
+	},
+	{
+		name: "database-error",
+		id:   "new",
+		postedData: url.Values{
+			"room_id":    {"2"},
+			"start_date": {"2050-02-01"},
+			"last_night": {"2050-02-01"},
+			"reason":     {"fail"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/blocks",
+		expectedError:      "can't save block",
+	},
+	{
+		name: "booked-while-saving",
+		id:   "new",
+		postedData: url.Values{
+			"room_id":    {"2"},
+			"start_date": {"2050-02-01"},
+			"last_night": {"2050-02-01"},
+			"reason":     {"taken"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "Some of these nights were booked while you were saving",
+	},
+	{
+		name:               "missing",
+		id:                 "9",
+		postedData:         url.Values{},
+		expectedStatusCode: http.StatusNotFound,
+	},
+}
+
+// TestAdminPostBlock tests saving blocks
+func TestAdminPostBlock(t *testing.T) {
+	for _, e := range adminPostBlockTests {
+		req, _ := http.NewRequest("POST", "/admin/blocks/"+e.id, strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = withURLParams(req, map[string]string{"id": e.id})
+
+		rr := httptest.NewRecorder()
+		Repo.AdminPostBlock(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+		if flash := session.PopString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("failed %s: expected flash %q, but got %q", e.name, e.expectedFlash, flash)
+		}
+		if msg := session.PopString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("failed %s: expected error %q, but got %q", e.name, e.expectedError, msg)
+		}
+		conflicts, _ := session.Pop(ctx, "block_conflicts").([]string)
+		if len(conflicts) != e.expectedConflicts {
+			t.Errorf("failed %s: expected %d conflicts, but got %v", e.name, e.expectedConflicts, conflicts)
+		}
+	}
+}
+
+// TestAdminReservationsCalendarBlocks tests that block ranges link to their block
+func TestAdminReservationsCalendarBlocks(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/reservations-calendar?y=2050&m=1", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	Repo.AdminReservationsCalendar(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected code %d, but got %d", http.StatusOK, rr.Code)
+	}
+	body := rr.Body.String()
+	if n := strings.Count(body, `href="/admin/blocks/1"`); n != 3 {
+		t.Errorf("expected 3 nights linked to the block, got %d", n)
+	}
+	if !strings.Contains(body, `title="Painting"`) {
+		t.Error("expected the block reason on the calendar")
+	}
+
+	// nights of a block range are edited on the blocks page, not with the checkboxes
+	if !strings.Contains(body, `name="block_1_2050-01-4" value="2"`) || strings.Contains(body, `name="block_1_2050-01-6"`) {
+		t.Error("expected only the single night block to be sent back with the form")
+	}
+	if !strings.Contains(body, `name="version_1" value="`) {
+		t.Error("expected the version of the room's nights in the form")
+	}
+}
+
+// TestAdminReservationsCalendarTypes tests that nights show the color of their restriction type
+func TestAdminReservationsCalendarTypes(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/reservations-calendar?y=2050&m=1", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	Repo.AdminReservationsCalendar(rr, req)
+
+	body := rr.Body.String()
+	expected := []string{
+		// the single night of staff use
+		`style="background-color: #28a745" title="Staff use"`,
+		// the nights of the owner block range
+		`style="background-color: #6c757d" title="Owner Block"`,
+		// the choice of type for new blocks, which leaves out reservations
+		`<option value="2" selected>Owner Block</option>`,
+	}
+	for _, x := range expected {
+		if !strings.Contains(body, x) {
+			t.Errorf("expected to find %s but did not", x)
+		}
+	}
+	if strings.Contains(body, `<option value="1"`) {
+		t.Error("expected reservations not to be offered as a block type")
+	}
+}
+
+var adminRestrictionTests = []struct {
+	name               string
+	id                 string
+	expectedStatusCode int
+	expectedHTML       string
+}{
+	{"new", "new", http.StatusOK, `value="#6c757d"`},
+	{"reservation", "1", http.StatusOK, "disabled"},
+	{"staff-use", "5", http.StatusOK, `value="Staff use"`},
+	{"missing", "9", http.StatusNotFound, ""},
+	{"bad-id", "x", http.StatusNotFound, ""},
+	{"database-error", "1001", http.StatusInternalServerError, ""},
+}
+
+// TestAdminRestriction tests showing the restriction type form
+func TestAdminRestriction(t *testing.T) {
+	for _, e := range adminRestrictionTests {
+		req, _ := http.NewRequest("GET", "/admin/restrictions/"+e.id, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req = withURLParams(req, map[string]string{"id": e.id})
+
+		rr := httptest.NewRecorder()
+		Repo.AdminRestriction(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+var adminPostRestrictionTests = []struct {
+	name               string
+	id                 string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedHTML       string
+	expectedFlash      string
+	expectedError      string
+}{
+	{"new", "new", url.Values{"restriction_name": {"Out of order"}, "color": {"#343A40"}, "blocks_availability": {"1"}}, http.StatusSeeOther, "", "Restriction type saved", ""},
+	{"edit", "5", url.Values{"restriction_name": {"Staff"}, "color": {"#28a745"}, "counts_occupied": {"1"}}, http.StatusSeeOther, "", "Restriction type saved", ""},
+	{"blank-name", "new", url.Values{"restriction_name": {""}, "color": {"#28a745"}}, http.StatusOK, "This field cannot be blank", "", ""},
+	{"bad-color", "new", url.Values{"restriction_name": {"Out of order"}, "color": {"red; x: y"}}, http.StatusOK, "Choose a color like #ffc107", "", ""},
+	{"database-error", "new", url.Values{"restriction_name": {"fail"}, "color": {"#28a745"}}, http.StatusSeeOther, "", "", "can't save restriction type"},
+	{"missing", "9", url.Values{}, http.StatusNotFound, "", "", ""},
+}
+
+// TestAdminPostRestriction tests saving restriction types
+func TestAdminPostRestriction(t *testing.T) {
+	for _, e := range adminPostRestrictionTests {
+		req, _ := http.NewRequest("POST", "/admin/restrictions/"+e.id, strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = withURLParams(req, map[string]string{"id": e.id})
+
+		rr := httptest.NewRecorder()
+		Repo.AdminPostRestriction(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+		if flash := session.PopString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("failed %s: expected flash %q, but got %q", e.name, e.expectedFlash, flash)
+		}
+		if msg := session.PopString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("failed %s: expected error %q, but got %q", e.name, e.expectedError, msg)
+		}
+	}
+}
+
+var adminDeleteRestrictionTests = []struct {
+	name          string
+	id            string
+	expectedFlash string
+	expectedError string
+}{
+	{"unused", "4", "Restriction type deleted", ""},
+	{"built-in", "2", "", "Owner Block is used by the application and can't be deleted"},
+	{"in-use", "5", "", "Staff use is used by 2 restrictions and can't be deleted"},
+	{"used-by-blocks", "6", "", "Deep clean is used by 2 blocks and can't be deleted"},
+	{"used-while-deleting", "7", "", "Painting has just been used and can't be deleted"},
+}
+
+// TestAdminDeleteRestriction tests deleting restriction types
+func TestAdminDeleteRestriction(t *testing.T) {
+	for _, e := range adminDeleteRestrictionTests {
+		req, _ := http.NewRequest("GET", "/admin/restrictions/"+e.id+"/delete", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req = withURLParams(req, map[string]string{"id": e.id})
+
+		rr := httptest.NewRecorder()
+		Repo.AdminDeleteRestriction(rr, req)
+
+		actualLoc, _ := rr.Result().Location()
+		if rr.Code != http.StatusSeeOther || actualLoc.String() != "/admin/restrictions" {
+			t.Errorf("failed %s: expected a redirect to /admin/restrictions, but got %d %s", e.name, rr.Code, actualLoc)
+		}
+		if flash := session.PopString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("failed %s: expected flash %q, but got %q", e.name, e.expectedFlash, flash)
+		}
+		if msg := session.PopString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("failed %s: expected error %q, but got %q", e.name, e.expectedError, msg)
+		}
+	}
+}
+
+// TestAdminDashboard tests the occupancy report on the dashboard
+func TestAdminDashboard(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/dashboard", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	Repo.AdminDashboard(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected code %d, but got %d", http.StatusOK, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "<td>3 of ") {
+		t.Error("expected the occupied nights of the room")
+	}
+}
+
+var adminCalendarJSONTests = []struct {
+	name               string
+	query              string
+	expectedStatusCode int
+	expectedDays       int
+}{
+	{"default", "", http.StatusOK, 0},
+	{"month", "?start=2050-01-01", http.StatusOK, 31},
+	{"range", "?start=2050-01-01&end=2050-01-15", http.StatusOK, 14},
+	{"bad-start", "?start=1/1/2050", http.StatusBadRequest, 0},
+	{"bad-end", "?start=2050-01-01&end=x", http.StatusBadRequest, 0},
+	{"end-before-start", "?start=2050-01-15&end=2050-01-01", http.StatusBadRequest, 0},
+	{"too-long", "?start=2050-01-01&end=2052-01-01", http.StatusBadRequest, 0},
+	{"database-error", "?start=1999-01-01", http.StatusInternalServerError, 0},
+}
+
+// TestAdminCalendarJSON tests the calendar api
+func TestAdminCalendarJSON(t *testing.T) {
+	for _, e := range adminCalendarJSONTests {
+		req, _ := http.NewRequest("GET", "/admin/api/calendar"+e.query, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		Repo.AdminCalendarJSON(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+			continue
+		}
+		if rr.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("failed %s: expected a json response", e.name)
+		}
+		if e.expectedDays == 0 {
+			continue
+		}
+
+		var cal calendar.Calendar
+		err := json.Unmarshal(rr.Body.Bytes(), &cal)
+		if err != nil {
+			t.Fatalf("failed %s: failed to parse json: %s", e.name, err)
+		}
+		if len(cal.Rooms) == 0 || len(cal.Rooms[0].Days) != e.expectedDays {
+			t.Errorf("failed %s: expected %d days in each room", e.name, e.expectedDays)
+			continue
+		}
+
+		days := cal.Rooms[0].Days
+		if days[0].Kind != calendar.KindReservation || days[0].ReservationID != 1 {
+			t.Errorf("failed %s: expected the reservation on the first night, got %+v", e.name, days[0])
+		}
+		if days[5].Kind != calendar.KindBlock || days[5].BlockID != 1 || days[5].TypeID != 2 {
+			t.Errorf("failed %s: expected the block range on the sixth night, got %+v", e.name, days[5])
+		}
+		if days[2].Kind != calendar.KindFree {
+			t.Errorf("failed %s: expected the third night to be free, got %+v", e.name, days[2])
+		}
+	}
+}
+
+// TestAdminTimeline tests that restrictions show as bars on the timeline
+func TestAdminTimeline(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/timeline?start=2050-01-03&weeks=2", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	Repo.AdminTimeline(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected code %d, but got %d", http.StatusOK, rr.Code)
+	}
+	body := rr.Body.String()
+	expected := []string{
+		// the reservation opens the reservation and can be dragged
+		`href="/admin/reservations/cal/1/show?y=2050&m=01"`,
+		`data-reservation-id="1" data-version="4" data-start="2050-01-03"`,
+		`style="grid-column: 1 / span 2; background-color: #dc3545"`,
+		`>Jane Doe <span class="badge badge-light">VIP</span></a>`,
+		// the block range opens the block
+		`href="/admin/blocks/1"`,
+		`style="grid-column: 6 / span 3; background-color: #6c757d"`,
+		`<option value="2" selected>2 weeks</option>`,
+		`href="/admin/timeline?start=2050-01-17&weeks=2"`,
+	}
+	for _, x := range expected {
+		if !strings.Contains(body, x) {
+			t.Errorf("expected to find %s but did not", x)
+		}
+	}
+	if n := strings.Count(body, `class="timeline-day `); n != 14 {
+		t.Errorf("expected 14 nights in the room, got %d", n)
+	}
+}
+
+var adminPostTimelineMoveTests = []struct {
+	name               string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedOK         bool
+	expectedFlash      string
+}{
+	{
+		name: "another-room",
+		postedData: url.Values{
+			"reservation_id": {"3"},
+			"version":        {"2"},
+			"room_id":        {"2"},
+			"start_date":     {"2050-02-01"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedOK:         true,
+		expectedFlash:      "Reservation moved",
+	},
+	{
+		name: "another-room-notified",
+		postedData: url.Values{
+			"reservation_id": {"3"},
+			"version":        {"2"},
+			"room_id":        {"2"},
+			"start_date":     {"2050-02-01"},
+			"notify_guest":   {"1"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedOK:         true,
+		expectedFlash:      "Reservation moved and the guest notified",
+	},
+	{
+		name: "unchanged",
+		postedData: url.Values{
+			"reservation_id": {"3"},
+			"version":        {"2"},
+			"room_id":        {"1"},
+			"start_date":     {"2050-01-01"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedOK:         true,
+	},
+	{
+		name: "booked",
+		postedData: url.Values{
+			"reservation_id": {"3"},
+			"version":        {"2"},
+			"room_id":        {"1"},
+			"start_date":     {"2050-02-01"},
+		},
+		expectedStatusCode: http.StatusConflict,
+	},
+	{
+		name: "booked-while-moving",
+		postedData: url.Values{
+			"reservation_id": {"3"},
+			"version":        {"2"},
+			"room_id":        {"2"},
+			"start_date":     {"2051-02-01"},
+		},
+		expectedStatusCode: http.StatusConflict,
+	},
+	{
+		name: "changed-since-shown",
+		postedData: url.Values{
+			"reservation_id": {"3"},
+			"version":        {"1"},
+			"room_id":        {"2"},
+			"start_date":     {"2050-02-01"},
+		},
+		expectedStatusCode: http.StatusConflict,
+	},
+	{
+		name: "no-version",
+		postedData: url.Values{
+			"reservation_id": {"3"},
+			"room_id":        {"2"},
+			"start_date":     {"2050-02-01"},
+		},
+		expectedStatusCode: http.StatusBadRequest,
+	},
+	{
+		name: "missing-reservation",
+		postedData: url.Values{
+			"reservation_id": {"9"},
+			"room_id":        {"2"},
+			"start_date":     {"2050-02-01"},
+		},
+		expectedStatusCode: http.StatusNotFound,
+	},
+	{
+		name: "missing-room",
+		postedData: url.Values{
+			"reservation_id": {"3"},
+			"version":        {"2"},
+			"room_id":        {"7"},
+			"start_date":     {"2050-02-01"},
+		},
+		expectedStatusCode: http.StatusBadRequest,
+	},
+	{
+		name: "bad-date",
+		postedData: url.Values{
+			"reservation_id": {"3"},
+			"version":        {"2"},
+			"room_id":        {"2"},
+			"start_date":     {"1/2/2050"},
+		},
+		expectedStatusCode: http.StatusBadRequest,
+	},
+}
+
+// TestAdminPostTimelineMove tests moving reservations dragged on the timeline
+func TestAdminPostTimelineMove(t *testing.T) {
+	for _, e := range adminPostTimelineMoveTests {
+		req, _ := http.NewRequest("POST", "/admin/timeline/move", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		Repo.AdminPostTimelineMove(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		var j jsonResponse
+		err := json.Unmarshal(rr.Body.Bytes(), &j)
+		if err != nil {
+			t.Fatalf("failed %s: failed to parse json: %s", e.name, err)
+		}
+		if j.OK != e.expectedOK {
+			t.Errorf("failed %s: expected ok to be %t: %s", e.name, e.expectedOK, j.Message)
+		}
+		if flash := session.PopString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("failed %s: expected flash %q, but got %q", e.name, e.expectedFlash, flash)
+		}
+	}
+}
+
+var adminAuditLogTests = []struct {
+	name               string
+	query              string
+	expectedStatusCode int
+	expected           []string
+	unexpected         []string
+}{
+	{
+		name:               "everything",
+		expectedStatusCode: http.StatusOK,
+		expected: []string{
+			`<a href="/admin/reservations/all/3/show">reservation 3</a>`,
+			"room 9",
+			"<code>192.0.2.7</code>",
+			`<option value="1" >Admin User</option>`,
+		},
+	},
+	{
+		name:               "rooms",
+		query:              "?entity=room&user=1&from=2026-10-01&to=2026-10-31",
+		expectedStatusCode: http.StatusOK,
+		expected: []string{
+			"room 9",
+			`<option value="room" selected>room</option>`,
+			`<option value="1" selected>Admin User</option>`,
+			`value="2026-10-31"`,
+		},
+		unexpected: []string{"reservation 3"},
+	},
+	{
+		name:               "database error",
+		query:              "?entity=fail",
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+}
+
+func TestAdminAuditLog(t *testing.T) {
+	for _, e := range adminAuditLogTests {
+		req, _ := http.NewRequest("GET", "/admin/audit"+e.query, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		Repo.AdminAuditLog(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		body := rr.Body.String()
+		for _, x := range e.expected {
+			if !strings.Contains(body, x) {
+				t.Errorf("%s: expected to find %s but did not", e.name, x)
+			}
+		}
+		for _, x := range e.unexpected {
+			if strings.Contains(body, x) {
+				t.Errorf("%s: did not expect to find %s", e.name, x)
+			}
+		}
+	}
+}
+
+func TestAdminShowReservationHistory(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/reservations/all/3/show", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.RequestURI = "/admin/reservations/all/3/show"
+
+	rr := httptest.NewRecorder()
+	Repo.AdminShowReservation(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected code %d, but got %d", http.StatusOK, rr.Code)
+	}
+
+	body := rr.Body.String()
+	history := body[strings.Index(body, `id="history"`):]
+	expected := []string{
+		"Admin User",
+		"<td>update</td>",
+		"<td>create</td>",
+		"guest",
+	}
+	for _, x := range expected {
+		if !strings.Contains(history, x) {
+			t.Errorf("expected to find %s in the history but did not", x)
+		}
+	}
+	if strings.Contains(history, "room 9") {
+		t.Error("expected only the reservation's own history")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	for remote, expected := range map[string]string{
+		"192.0.2.7:51234":   "192.0.2.7",
+		"[2001:db8::1]:443": "2001:db8::1",
+		"192.0.2.7":         "192.0.2.7",
+	} {
+		r := &http.Request{RemoteAddr: remote}
+		if ip := clientIP(r); ip != expected {
+			t.Errorf("%s: expected %s, got %s", remote, expected, ip)
+		}
+	}
+}
+
+func TestAdminTrash(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/trash", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	Repo.AdminTrash(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected code %d, but got %d", http.StatusOK, rr.Code)
+	}
+
+	body := rr.Body.String()
+	expected := []string{
+		"Sam Trash",
+		"Roof repair",
+		"by Admin User",
+		"/admin/trash/reservations/7/restore",
+		"/admin/trash/blocks/7/restore",
+		"/admin/trash/nights/7/restore",
+	}
+	for _, x := range expected {
+		if !strings.Contains(body, x) {
+			t.Errorf("expected to find %s but did not", x)
+		}
+	}
+}
+
+var adminRestoreTests = []struct {
+	name          string
+	id            string
+	handler       func(*Repository, http.ResponseWriter, *http.Request)
+	expectedFlash string
+	expectedError string
+}{
+	{"reservation", "7", (*Repository).AdminRestoreReservation, "Reservation restored", ""},
+	{"reservation-taken", "2", (*Repository).AdminRestoreReservation, "", "Can't restore the reservation, some of its dates have been taken since it was deleted"},
+	{"reservation-not-in-trash", "5", (*Repository).AdminRestoreReservation, "", "The reservation is no longer in the trash"},
+	{"reservation-db-error", "1001", (*Repository).AdminRestoreReservation, "", "can't restore reservation"},
+	{"block", "7", (*Repository).AdminRestoreBlock, "Block restored", ""},
+	{"block-taken", "2", (*Repository).AdminRestoreBlock, "", "Can't restore the block, some of its dates have been taken since it was deleted"},
+	{"night", "7", (*Repository).AdminRestoreNight, "Blocked night restored", ""},
+	{"night-not-in-trash", "5", (*Repository).AdminRestoreNight, "", "The blocked night is no longer in the trash"},
+}
+
+func TestAdminRestore(t *testing.T) {
+	for _, e := range adminRestoreTests {
+		req, _ := http.NewRequest("GET", "/admin/trash", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req = withURLParams(req, map[string]string{"id": e.id})
+
+		rr := httptest.NewRecorder()
+		e.handler(Repo, rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+		if loc, _ := rr.Result().Location(); loc.String() != "/admin/trash" {
+			t.Errorf("%s: expected redirect to /admin/trash, but got %s", e.name, loc.String())
+		}
+		if flash := session.PopString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("%s: expected flash %q, but got %q", e.name, e.expectedFlash, flash)
+		}
+		if msg := session.PopString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("%s: expected error %q, but got %q", e.name, e.expectedError, msg)
+		}
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected []string
+	}{
+		{"", nil},
+		{"VIP", []string{"VIP"}},
+		{" late   arrival , VIP,, vip ", []string{"late arrival", "VIP"}},
+		{strings.Repeat("x", 40), []string{strings.Repeat("x", maxTagLength)}},
+		{"a,b,c,d,e,f,g,h,i,j,k,l", []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"}},
+	}
+	for _, tt := range tests {
+		got := parseTags(tt.in)
+		if strings.Join(got, "|") != strings.Join(tt.expected, "|") {
+			t.Errorf("%q: expected %q, got %q", tt.in, tt.expected, got)
+		}
+	}
+}
+
+func TestAdminReservationsByTag(t *testing.T) {
+	tests := []struct {
+		name       string
+		url        string
+		handler    func(*Repository, http.ResponseWriter, *http.Request)
+		expected   []string
+		unexpected []string
+	}{
+		{"all", "/admin/reservations-all", (*Repository).AdminAllReservations, []string{"Future", "Untagged", `<option value="VIP" >`}, nil},
+		{"all-vip", "/admin/reservations-all?tag=vip", (*Repository).AdminAllReservations, []string{"Future", "/admin/reservations-all?tag=VIP"}, []string{"Untagged"}},
+		{"new-late", "/admin/reservations-new?tag=late%20arrival", (*Repository).AdminNewReservations, []string{"Future", `<option value="late arrival" selected>`}, []string{"Untagged"}},
+	}
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		e.handler(Repo, rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: expected code %d, but got %d", e.name, http.StatusOK, rr.Code)
+		}
+		body := rr.Body.String()
+		for _, x := range e.expected {
+			if !strings.Contains(body, x) {
+				t.Errorf("%s: expected to find %s but did not", e.name, x)
+			}
+		}
+		for _, x := range e.unexpected {
+			if strings.Contains(body, x) {
+				t.Errorf("%s: did not expect to find %s", e.name, x)
+			}
+		}
+	}
+}
+
+func TestAdminShowReservationNotes(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/reservations/all/3/show", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.RequestURI = "/admin/reservations/all/3/show"
+
+	rr := httptest.NewRecorder()
+	Repo.AdminShowReservation(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected code %d, but got %d", http.StatusOK, rr.Code)
+	}
+
+	body := rr.Body.String()
+	expected := []string{
+		"Notes (1)",
+		"Arriving after midnight, leave the key in the box",
+		"Admin User",
+		`value="late arrival, VIP"`,
+		`<span class="badge badge-info">VIP</span>`,
+		"/admin/reservations/all/3/notes/1/delete",
+	}
+	for _, x := range expected {
+		if !strings.Contains(body, x) {
+			t.Errorf("expected to find %s but did not", x)
+		}
+	}
+}
+
+var adminReservationNoteTests = []struct {
+	name          string
+	method        string
+	params        map[string]string
+	handler       func(*Repository, http.ResponseWriter, *http.Request)
+	postedData    url.Values
+	expectedFlash string
+	expectedError string
+}{
+	{"tags", "POST", map[string]string{"id": "3"}, (*Repository).AdminPostReservationTags, url.Values{"tags": {"VIP, pets"}}, "Tags saved", ""},
+	{"note", "POST", map[string]string{"id": "3"}, (*Repository).AdminPostReservationNote, url.Values{"body": {"Bringing a dog"}}, "Note added", ""},
+	{"empty-note", "POST", map[string]string{"id": "3"}, (*Repository).AdminPostReservationNote, url.Values{"body": {"  "}}, "", "Write something in the note"},
+	{"note-db-error", "POST", map[string]string{"id": "3"}, (*Repository).AdminPostReservationNote, url.Values{"body": {"fail"}}, "", "can't add note"},
+	{"delete-note", "GET", map[string]string{"id": "3", "noteID": "1"}, (*Repository).AdminDeleteReservationNote, nil, "Note deleted", ""},
+	{"delete-note-db-error", "GET", map[string]string{"id": "3", "noteID": "1001"}, (*Repository).AdminDeleteReservationNote, nil, "", "can't delete note"},
+}
+
+func TestAdminReservationNotesAndTags(t *testing.T) {
+	for _, e := range adminReservationNoteTests {
+		var req *http.Request
+		if e.method == "POST" {
+			req, _ = http.NewRequest("POST", "/admin/reservations/all/3/x", strings.NewReader(e.postedData.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req, _ = http.NewRequest("GET", "/admin/reservations/all/3/x", nil)
+		}
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		e.params["src"] = "all"
+		req = withURLParams(req, e.params)
+
+		rr := httptest.NewRecorder()
+		e.handler(Repo, rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+		if loc, _ := rr.Result().Location(); loc.String() != "/admin/reservations/all/3/show" {
+			t.Errorf("%s: expected redirect to the reservation, but got %s", e.name, loc.String())
+		}
+		if flash := session.PopString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("%s: expected flash %q, but got %q", e.name, e.expectedFlash, flash)
+		}
+		if msg := session.PopString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("%s: expected error %q, but got %q", e.name, e.expectedError, msg)
+		}
+	}
+}
+
+func TestAdminGuests(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		expected   []string
+		unexpected []string
+	}{
+		{"all", "", []string{"Jane Doe", "John Smith", "Possible duplicates", "same email", "500.00"}, nil},
+		{"search", "?q=SMITH", []string{"John Smith"}, []string{"jane@example.com"}},
+	}
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/guests"+e.query, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		Repo.AdminGuests(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: expected code %d, but got %d", e.name, http.StatusOK, rr.Code)
+		}
+		body := rr.Body.String()
+		for _, x := range e.expected {
+			if !strings.Contains(body, x) {
+				t.Errorf("%s: expected to find %s but did not", e.name, x)
+			}
+		}
+		for _, x := range e.unexpected {
+			if strings.Contains(body, x) {
+				t.Errorf("%s: did not expect to find %s", e.name, x)
+			}
+		}
+	}
+}
+
+func TestGuestMatchesQuery(t *testing.T) {
+	g := models.Guest{FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Phone: "555 010 2030"}
+	for q, expected := range map[string]bool{"jane doe": true, "EXAMPLE": true, "010": true, "smith": false} {
+		if got := guestMatchesQuery(g, q); got != expected {
+			t.Errorf("%q: expected %t, got %t", q, expected, got)
+		}
+	}
+}
+
+func TestAdminGuest(t *testing.T) {
+	tests := []struct {
+		name               string
+		id                 string
+		expectedStatusCode int
+		expected           []string
+	}{
+		{"guest", "1", http.StatusOK, []string{
+			"<strong>Stays:</strong> 2",
+			"<strong>Nights:</strong> 5",
+			"500.00",
+			"Prefers a ground floor room",
+			"/admin/reservations/all/3/show",
+			`<a href="/admin/guests/2">J Doe</a> #2`,
+			`value="2"`,
+		}},
+		{"missing", "9", http.StatusNotFound, nil},
+		{"not-a-number", "x", http.StatusNotFound, nil},
+		{"db-error", "1001", http.StatusInternalServerError, nil},
+	}
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/guests/"+e.id, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req = withURLParams(req, map[string]string{"id": e.id})
+
+		rr := httptest.NewRecorder()
+		Repo.AdminGuest(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		body := rr.Body.String()
+		for _, x := range e.expected {
+			if !strings.Contains(body, x) {
+				t.Errorf("%s: expected to find %s but did not", e.name, x)
+			}
+		}
+	}
+}
+
+var adminPostGuestTests = []struct {
+	name               string
+	handler            func(*Repository, http.ResponseWriter, *http.Request)
+	postedData         url.Values
+	expectedStatusCode int
+	expectedFlash      string
+	expectedError      string
+	expectedHTML       string
+}{
+	{
+		name:               "save",
+		handler:            (*Repository).AdminPostGuest,
+		postedData:         url.Values{"first_name": {"Jane"}, "last_name": {"Doe"}, "email": {"jane@example.com"}, "notes": {"VIP"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedFlash:      "Guest saved",
+	},
+	{
+		name:               "invalid",
+		handler:            (*Repository).AdminPostGuest,
+		postedData:         url.Values{"first_name": {"Jane"}, "last_name": {""}, "email": {"not-an-email"}},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "Invalid email address",
+	},
+	{
+		name:               "save-db-error",
+		handler:            (*Repository).AdminPostGuest,
+		postedData:         url.Values{"first_name": {"Jane"}, "last_name": {"fail"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedError:      "can't save guest",
+	},
+	{
+		name:               "merge",
+		handler:            (*Repository).AdminMergeGuests,
+		postedData:         url.Values{"merge_id": {"2"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedFlash:      "J Doe merged into this guest",
+	},
+	{
+		name:               "merge-self",
+		handler:            (*Repository).AdminMergeGuests,
+		postedData:         url.Values{"merge_id": {"1"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedError:      "A guest can't be merged into themselves",
+	},
+	{
+		name:               "merge-missing",
+		handler:            (*Repository).AdminMergeGuests,
+		postedData:         url.Values{"merge_id": {"9"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedError:      "There is no guest 9",
+	},
+	{
+		name:               "merge-db-error",
+		handler:            (*Repository).AdminMergeGuests,
+		postedData:         url.Values{"merge_id": {"3"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedError:      "can't merge guests",
+	},
+}
+
+func TestAdminPostGuest(t *testing.T) {
+	for _, e := range adminPostGuestTests {
+		req, _ := http.NewRequest("POST", "/admin/guests/1", strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req = withURLParams(req, map[string]string{"id": "1"})
+
+		rr := httptest.NewRecorder()
+		e.handler(Repo, rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedStatusCode == http.StatusSeeOther {
+			if loc, _ := rr.Result().Location(); loc.String() != "/admin/guests/1" {
+				t.Errorf("%s: expected redirect to /admin/guests/1, but got %s", e.name, loc.String())
+			}
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+		if flash := session.PopString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("%s: expected flash %q, but got %q", e.name, e.expectedFlash, flash)
+		}
+		if msg := session.PopString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("%s: expected error %q, but got %q", e.name, e.expectedError, msg)
+		}
+	}
+}
+
+func TestChangeableOnline(t *testing.T) {
+	now := time.Date(2050, 3, 1, 15, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		start    time.Time
+		expected bool
+	}{
+		{"tomorrow", time.Date(2050, 3, 2, 0, 0, 0, 0, time.UTC), true},
+		{"today", time.Date(2050, 3, 1, 0, 0, 0, 0, time.UTC), false},
+		{"past", time.Date(2050, 2, 1, 0, 0, 0, 0, time.UTC), false},
+	}
+	for _, e := range tests {
+		if got := changeableOnline(models.Reservation{StartDate: e.start}, now); got != e.expected {
+			t.Errorf("%s: expected %v, but got %v", e.name, e.expected, got)
+		}
+	}
+}
+
+var postAccountRegisterTests = []struct {
+	name               string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedLocation   string
+	expectedHTML       string
+	expectedError      string
+}{
+	{
+		name:               "registered",
+		postedData:         url.Values{"first_name": {"Ann"}, "last_name": {"New"}, "email": {"ann@new.com"}, "password": {"secret123"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/account/login",
+	},
+	{
+		name:               "invalid",
+		postedData:         url.Values{"first_name": {"Ann"}, "last_name": {"New"}, "email": {"not-an-email"}, "password": {"short"}},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "This field must be at least 8 characters long",
+	},
+	{
+		name:               "exists",
+		postedData:         url.Values{"first_name": {"Jane"}, "last_name": {"Doe"}, "email": {"jane@example.com"}, "password": {"secret123"}},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "There is already an account for this email address",
+	},
+	{
+		name:               "db-error",
+		postedData:         url.Values{"first_name": {"Ann"}, "last_name": {"New"}, "email": {"fail@here.ca"}, "password": {"secret123"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/account/register",
+		expectedError:      "can't create account",
+	},
+}
+
+func TestPostAccountRegister(t *testing.T) {
+	for _, e := range postAccountRegisterTests {
+		req, _ := http.NewRequest("POST", "/account/register", strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getGuestCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		Repo.PostAccountRegister(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedLocation != "" {
+			if loc, _ := rr.Result().Location(); loc.String() != e.expectedLocation {
+				t.Errorf("%s: expected redirect to %s, but got %s", e.name, e.expectedLocation, loc.String())
+			}
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+		if msg := session.PopString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("%s: expected error %q, but got %q", e.name, e.expectedError, msg)
+		}
+	}
+}
+
+func TestAccountVerify(t *testing.T) {
+	unverified := models.GuestAccount{ID: 2, Email: "john@smith.com"}
+	tests := []struct {
+		name               string
+		id                 string
+		token              string
+		expectedStatusCode int
+		expectedLocation   string
+		expectedAccount    int
+	}{
+		{"verified", "2", helpers.SignedToken(verifyScope(unverified)), http.StatusSeeOther, "/account/login", 0},
+		{"bad-token", "2", "nope", http.StatusSeeOther, "/account/login", 0},
+		{"other-address", "2", helpers.SignedToken(verifyScope(models.GuestAccount{ID: 2, Email: "old@smith.com"})), http.StatusSeeOther, "/account/login", 0},
+		{"missing", "9", helpers.SignedToken("verify-account-9-"), http.StatusSeeOther, "/account/login", 0},
+		{"db-error", "1001", "", http.StatusInternalServerError, "", 0},
+	}
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/account/verify/"+e.id+"?token="+e.token, nil)
+		ctx := getGuestCtx(req)
+		req = req.WithContext(ctx)
+		req = withURLParams(req, map[string]string{"id": e.id})
+
+		rr := httptest.NewRecorder()
+		Repo.AccountVerify(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedLocation != "" {
+			if loc, _ := rr.Result().Location(); loc.String() != e.expectedLocation {
+				t.Errorf("%s: expected redirect to %s, but got %s", e.name, e.expectedLocation, loc.String())
+			}
+		}
+		if id := guestSession.GetInt(req.Context(), "guest_account_id"); id != e.expectedAccount {
+			t.Errorf("%s: expected to be logged in to account %d, but got %d", e.name, e.expectedAccount, id)
+		}
+	}
+}
+
+func TestPostAccountLogin(t *testing.T) {
+	tests := []struct {
+		name               string
+		email              string
+		password           string
+		expectedStatusCode int
+		expectedLocation   string
+		expectedError      string
+		expectedAccount    int
+	}{
+		{"logged-in", "jane@example.com", "secret123", http.StatusSeeOther, "/account", "", 1},
+		{"wrong-password", "jane@example.com", "guess", http.StatusSeeOther, "/account/login", "invalid login credentials", 0},
+		{"unverified", "john@smith.com", "secret123", http.StatusSeeOther, "/account/login", "Confirm your email address first, we've sent you a new link", 0},
+		{"invalid", "jane", "", http.StatusOK, "", "", 0},
+	}
+	for _, e := range tests {
+		postedData := url.Values{"email": {e.email}, "password": {e.password}}
+		req, _ := http.NewRequest("POST", "/account/login", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getGuestCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		Repo.PostAccountLogin(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedLocation != "" {
+			if loc, _ := rr.Result().Location(); loc.String() != e.expectedLocation {
+				t.Errorf("%s: expected redirect to %s, but got %s", e.name, e.expectedLocation, loc.String())
+			}
+		}
+		if msg := session.PopString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("%s: expected error %q, but got %q", e.name, e.expectedError, msg)
+		}
+		if id := guestSession.GetInt(ctx, "guest_account_id"); id != e.expectedAccount {
+			t.Errorf("%s: expected to be logged in to account %d, but got %d", e.name, e.expectedAccount, id)
+		}
+	}
+}
+
+func TestAccountLogout(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/account/logout", nil)
+	ctx := getGuestCtx(req)
+	req = req.WithContext(ctx)
+	guestSession.Put(ctx, "guest_account_id", 1)
+	session.Put(ctx, "user_id", 1)
+
+	rr := httptest.NewRecorder()
+	Repo.AccountLogout(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("expected code %d, but got %d", http.StatusSeeOther, rr.Code)
+	}
+	if guestSession.Exists(ctx, "guest_account_id") {
+		t.Error("expected the guest to be logged out")
+	}
+	if !session.Exists(ctx, "user_id") {
+		t.Error("expected the staff login to be kept")
+	}
+}
+
+// TestLogoutKeepsGuestLogin tests that staff logging out leaves a guest logged
+// in to their account in the same browser
+func TestLogoutKeepsGuestLogin(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/user/logout", nil)
+	ctx := getGuestCtx(req)
+	req = req.WithContext(ctx)
+	guestSession.Put(ctx, "guest_account_id", 1)
+	session.Put(ctx, "user_id", 1)
+
+	rr := httptest.NewRecorder()
+	Repo.Logout(rr, req)
+
+	if session.Exists(ctx, "user_id") {
+		t.Error("expected the staff user to be logged out")
+	}
+	if guestSession.GetInt(ctx, "guest_account_id") != 1 {
+		t.Error("expected the guest login to be kept")
+	}
+}
+
+func TestAccount(t *testing.T) {
+	tests := []struct {
+		name               string
+		account            int
+		expectedStatusCode int
+		expected           []string
+	}{
+		{"bookings", 1, http.StatusOK, []string{
+			"Logged in as jane@example.com",
+			`href="/account/bookings/3"`,
+			`action="/account/bookings/6/cancel"`,
+			"2020-06-01",
+			`name="first_name" value="Jane"`,
+		}},
+		{"logged-out", 0, http.StatusSeeOther, nil},
+		{"account-gone", 9, http.StatusSeeOther, nil},
+		{"db-error", 1001, http.StatusInternalServerError, nil},
+	}
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/account", nil)
+		ctx := getGuestCtx(req)
+		req = req.WithContext(ctx)
+		if e.account > 0 {
+			guestSession.Put(ctx, "guest_account_id", e.account)
+		}
+
+		rr := httptest.NewRecorder()
+		Repo.Account(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		body := rr.Body.String()
+		for _, x := range e.expected {
+			if !strings.Contains(body, x) {
+				t.Errorf("%s: expected to find %s but did not", e.name, x)
+			}
+		}
+		if e.name == "bookings" {
+			// upcoming stays are listed soonest first, and past ones can't be changed
+			if strings.Index(body, "/account/bookings/3") > strings.Index(body, "/account/bookings/6") {
+				t.Errorf("%s: expected booking 3 before booking 6", e.name)
+			}
+			if strings.Contains(body, "/account/bookings/8") {
+				t.Errorf("%s: expected the past stay not to be changeable", e.name)
+			}
+			if strings.Contains(body, "/account/bookings/11") {
+				t.Errorf("%s: expected the stay linked by phone alone not to be listed", e.name)
+			}
+		}
+	}
+}
+
+var postAccountBookingTests = []struct {
+	name               string
+	handler            func(*Repository, http.ResponseWriter, *http.Request)
+	id                 string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedFlash      string
+	expectedError      string
+	expectedHTML       string
+}{
+	{
+		name:               "change-dates",
+		handler:            (*Repository).PostAccountBooking,
+		id:                 "6",
+		postedData:         url.Values{"start_date": {"2050-03-05"}, "end_date": {"2050-03-08"}, "version": {"1"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedFlash:      "Your booking has been changed",
+	},
+	{
+		name:               "taken",
+		handler:            (*Repository).PostAccountBooking,
+		id:                 "3",
+		postedData:         url.Values{"start_date": {"2050-02-01"}, "end_date": {"2050-02-03"}, "version": {"2"}},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "The room isn&#39;t available for these dates",
+	},
+	{
+		name:               "taken-while-saving",
+		handler:            (*Repository).PostAccountBooking,
+		id:                 "6",
+		postedData:         url.Values{"start_date": {"2051-03-05"}, "end_date": {"2051-03-08"}, "version": {"1"}},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "The room isn&#39;t available for these dates",
+	},
+	{
+		name:               "backwards",
+		handler:            (*Repository).PostAccountBooking,
+		id:                 "6",
+		postedData:         url.Values{"start_date": {"2050-03-05"}, "end_date": {"2050-03-05"}, "version": {"1"}},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "The departure must be after the arrival",
+	},
+	{
+		name:               "into-the-past",
+		handler:            (*Repository).PostAccountBooking,
+		id:                 "6",
+		postedData:         url.Values{"start_date": {"2020-03-05"}, "end_date": {"2020-03-08"}, "version": {"1"}},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "The arrival must be after today",
+	},
+	{
+		name:               "stale",
+		handler:            (*Repository).PostAccountBooking,
+		id:                 "6",
+		postedData:         url.Values{"start_date": {"2050-03-05"}, "end_date": {"2050-03-08"}, "version": {"0"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedError:      "Your booking was changed in the meantime, check its dates and try again",
+	},
+	{
+		name:               "past-stay",
+		handler:            (*Repository).PostAccountBooking,
+		id:                 "8",
+		postedData:         url.Values{"start_date": {"2050-03-05"}, "end_date": {"2050-03-08"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedError:      "This booking can no longer be changed online, please contact us",
+	},
+	{
+		name:               "someone-elses",
+		handler:            (*Repository).PostAccountBooking,
+		id:                 "4",
+		postedData:         url.Values{"start_date": {"2050-03-05"}, "end_date": {"2050-03-08"}},
+		expectedStatusCode: http.StatusNotFound,
+	},
+	{
+		name:               "no-version",
+		handler:            (*Repository).PostAccountBooking,
+		id:                 "6",
+		postedData:         url.Values{"start_date": {"2050-03-05"}, "end_date": {"2050-03-08"}},
+		expectedStatusCode: http.StatusBadRequest,
+	},
+	{
+		name:               "linked-by-phone",
+		handler:            (*Repository).PostAccountBooking,
+		id:                 "11",
+		postedData:         url.Values{"start_date": {"2050-04-05"}, "end_date": {"2050-04-08"}, "version": {"1"}},
+		expectedStatusCode: http.StatusNotFound,
+	},
+	{
+		name:               "cancel",
+		handler:            (*Repository).PostAccountCancelBooking,
+		id:                 "6",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedFlash:      "Your booking has been cancelled",
+	},
+	{
+		name:               "cancel-past-stay",
+		handler:            (*Repository).PostAccountCancelBooking,
+		id:                 "8",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedError:      "This booking can no longer be changed online, please contact us",
+	},
+	{
+		name:               "cancel-someone-elses",
+		handler:            (*Repository).PostAccountCancelBooking,
+		id:                 "4",
+		expectedStatusCode: http.StatusNotFound,
+	},
+	{
+		name:               "cancelled-already",
+		handler:            (*Repository).PostAccountCancelBooking,
+		id:                 "9",
+		expectedStatusCode: http.StatusNotFound,
+	},
+	{
+		name:               "cancel-linked-by-phone",
+		handler:            (*Repository).PostAccountCancelBooking,
+		id:                 "11",
+		expectedStatusCode: http.StatusNotFound,
+	},
+	{
+		name:               "details",
+		handler:            (*Repository).PostAccountDetails,
+		postedData:         url.Values{"first_name": {"Jane"}, "last_name": {"Doe"}, "phone": {"555 010 9999"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedFlash:      "Your details are saved",
+	},
+	{
+		name:               "details-invalid",
+		handler:            (*Repository).PostAccountDetails,
+		postedData:         url.Values{"first_name": {"Jane"}, "last_name": {""}},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "This field cannot be blank",
+	},
+}
+
+func TestPostAccountBooking(t *testing.T) {
+	for _, e := range postAccountBookingTests {
+		req, _ := http.NewRequest("POST", "/account/bookings/"+e.id, strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getGuestCtx(req)
+		req = req.WithContext(ctx)
+		req = withURLParams(req, map[string]string{"id": e.id})
+		guestSession.Put(ctx, "guest_account_id", 1)
+
+		rr := httptest.NewRecorder()
+		e.handler(Repo, rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+		if flash := session.PopString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("%s: expected flash %q, but got %q", e.name, e.expectedFlash, flash)
+		}
+		if msg := session.PopString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("%s: expected error %q, but got %q", e.name, e.expectedError, msg)
+		}
+	}
+}
+
+// TestReservationFromAccount tests that a guest's saved details are filled in
+// when they book while logged in to their account
+func TestReservationFromAccount(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/make-reservation", nil)
+	ctx := getGuestCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "reservation", models.Reservation{RoomID: 1})
+	guestSession.Put(ctx, "guest_account_id", 1)
+
+	rr := httptest.NewRecorder()
+	Repo.Reservation(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected code %d, but got %d", http.StatusOK, rr.Code)
+	}
+	for _, x := range []string{`name="first_name" value="Jane"`, `name="email" value="jane@example.com"`, `name="phone" value="555 010 2030"`} {
+		if !strings.Contains(rr.Body.String(), x) {
+			t.Errorf("expected to find %s but did not", x)
+		}
+	}
+}
+
+var postLoginLinkTests = []struct {
+	name               string
+	handler            func(*Repository, http.ResponseWriter, *http.Request)
+	postedData         url.Values
+	expectedStatusCode int
+	expectedLocation   string
+	expectedFlash      string
+	expectedError      string
+	expectedBrowserKey bool
+}{
+	{
+		name:               "staff",
+		handler:            (*Repository).PostLoginLink,
+		postedData:         url.Values{"link_email": {"me@here.ca"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/user/login",
+		expectedFlash:      "If there's an account for that address, we've emailed it a login link",
+	},
+	{
+		name:               "staff-same-browser",
+		handler:            (*Repository).PostLoginLink,
+		postedData:         url.Values{"link_email": {"me@here.ca"}, "same_browser": {"1"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/user/login",
+		expectedFlash:      "If there's an account for that address, we've emailed it a login link",
+		expectedBrowserKey: true,
+	},
+	{
+		name:               "staff-unknown",
+		handler:            (*Repository).PostLoginLink,
+		postedData:         url.Values{"link_email": {"nobody@here.ca"}, "same_browser": {"1"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/user/login",
+		expectedFlash:      "If there's an account for that address, we've emailed it a login link",
+	},
+	{
+		name:               "staff-invalid",
+		handler:            (*Repository).PostLoginLink,
+		postedData:         url.Values{"link_email": {"me"}},
+		expectedStatusCode: http.StatusOK,
+	},
+	{
+		name:               "staff-db-error",
+		handler:            (*Repository).PostLoginLink,
+		postedData:         url.Values{"link_email": {"fail@here.ca"}},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+	{
+		name:               "guest",
+		handler:            (*Repository).PostAccountLoginLink,
+		postedData:         url.Values{"link_email": {"JANE@example.com"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/account/login",
+		expectedFlash:      "If there's an account for that address, we've emailed it a login link",
+	},
+	{
+		name:               "guest-unknown",
+		handler:            (*Repository).PostAccountLoginLink,
+		postedData:         url.Values{"link_email": {"nobody@here.ca"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/account/login",
+		expectedFlash:      "If there's an account for that address, we've emailed it a login link",
+	},
+}
+
+func TestPostLoginLink(t *testing.T) {
+	for _, e := range postLoginLinkTests {
+		req, _ := http.NewRequest("POST", "/user/login/link", strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getGuestCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		e.handler(Repo, rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedLocation != "" {
+			if loc, _ := rr.Result().Location(); loc.String() != e.expectedLocation {
+				t.Errorf("%s: expected redirect to %s, but got %s", e.name, e.expectedLocation, loc.String())
+			}
+		}
+		if flash := session.PopString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("%s: expected flash %q, but got %q", e.name, e.expectedFlash, flash)
+		}
+		if msg := session.PopString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("%s: expected error %q, but got %q", e.name, e.expectedError, msg)
+		}
+		if key := session.Exists(ctx, "login_link_browser"); key != e.expectedBrowserKey {
+			t.Errorf("%s: expected a browser key %v, but got %v", e.name, e.expectedBrowserKey, key)
+		}
+	}
+}
+
+var loginWithLinkTests = []struct {
+	name               string
+	handler            func(*Repository, http.ResponseWriter, *http.Request)
+	id                 int
+	badToken           bool
+	browserKey         string
+	expectedStatusCode int
+	expectedLocation   string
+	expectedError      string
+	expectedUser       int
+	expectedAccount    int
+}{
+	{name: "staff", handler: (*Repository).LoginWithLink, id: 1,
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/", expectedUser: 1},
+	{name: "staff-bad-token", handler: (*Repository).LoginWithLink, id: 1, badToken: true,
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/user/login", expectedError: "That login link isn't valid, ask for a new one"},
+	{name: "staff-missing", handler: (*Repository).LoginWithLink, id: 9,
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/user/login", expectedError: "That login link isn't valid, ask for a new one"},
+	{name: "staff-expired", handler: (*Repository).LoginWithLink, id: 3,
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/user/login", expectedError: "That login link has expired, ask for a new one"},
+	{name: "staff-used", handler: (*Repository).LoginWithLink, id: 4,
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/user/login", expectedError: "That login link has already been used, ask for a new one"},
+	{name: "staff-used-meanwhile", handler: (*Repository).LoginWithLink, id: 7,
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/user/login", expectedError: "That login link has already been used, ask for a new one"},
+	{name: "staff-same-browser", handler: (*Repository).LoginWithLink, id: 5, browserKey: "test-browser",
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/", expectedUser: 1},
+	{name: "staff-other-browser", handler: (*Repository).LoginWithLink, id: 5, browserKey: "other-browser",
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/user/login", expectedError: "Open the login link in the browser you asked for it from"},
+	{name: "staff-guest-link", handler: (*Repository).LoginWithLink, id: 2,
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/user/login", expectedError: "That login link isn't valid, ask for a new one"},
+	{name: "staff-db-error", handler: (*Repository).LoginWithLink, id: 1001,
+		expectedStatusCode: http.StatusInternalServerError},
+	{name: "guest", handler: (*Repository).AccountLoginWithLink, id: 2,
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/account", expectedAccount: 1},
+	{name: "guest-unverified", handler: (*Repository).AccountLoginWithLink, id: 6,
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/account", expectedAccount: 2},
+	{name: "guest-staff-link", handler: (*Repository).AccountLoginWithLink, id: 1,
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/account/login", expectedError: "That login link isn't valid, ask for a new one"},
+}
+
+func TestLoginWithLink(t *testing.T) {
+	for _, e := range loginWithLinkTests {
+		l, _ := Repo.DB.GetLoginLink(e.id)
+		token := helpers.SignedToken(loginlinks.Scope(l))
+		if e.badToken {
+			token = "nope"
+		}
+		id := strconv.Itoa(e.id)
+
+		req, _ := http.NewRequest("GET", loginlinks.Path(models.LoginLink{ID: e.id, Kind: l.Kind})+"?token="+token, nil)
+		ctx := getGuestCtx(req)
+		req = req.WithContext(ctx)
+		req = withURLParams(req, map[string]string{"id": id})
+		if e.browserKey != "" {
+			session.Put(ctx, "login_link_browser", e.browserKey)
+		}
+
+		rr := httptest.NewRecorder()
+		e.handler(Repo, rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedLocation != "" {
+			if loc, _ := rr.Result().Location(); loc.String() != e.expectedLocation {
+				t.Errorf("%s: expected redirect to %s, but got %s", e.name, e.expectedLocation, loc.String())
+			}
+		}
+		if msg := session.PopString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("%s: expected error %q, but got %q", e.name, e.expectedError, msg)
+		}
+		if user := session.GetInt(ctx, "user_id"); user != e.expectedUser {
+			t.Errorf("%s: expected staff user %d to be logged in, but got %d", e.name, e.expectedUser, user)
+		}
+		if account := guestSession.GetInt(ctx, "guest_account_id"); account != e.expectedAccount {
+			t.Errorf("%s: expected guest account %d to be logged in, but got %d", e.name, e.expectedAccount, account)
+		}
 	}
-	return ctx
 }
@@ -0,0 +1,442 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/DmitryZzz/bookings/internal/forms"
+	"github.com/DmitryZzz/bookings/internal/helpers"
+	"github.com/DmitryZzz/bookings/internal/ical"
+	"github.com/DmitryZzz/bookings/internal/models"
+	"github.com/DmitryZzz/bookings/internal/render"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	propertyName    = "Fort Smythe Bed and Breakfast"
+	propertyAddress = "100 Rocky Road, Northbrook, Ontario, Canada"
+
+	// uidDomain keeps event UIDs stable no matter which host serves the feed
+	uidDomain = "bookings.fort-smythe"
+
+	// feeds cover a short window in the past and the bookable future
+	feedDaysBack   = 90
+	feedYearsAhead = 2
+
+	// a reservation's feed link stops working this long after the guest leaves
+	reservationFeedDays = 30
+
+	// guests arrive and leave at these hours, in the server's time zone
+	checkInHour  = 15
+	checkOutHour = 11
+
+	// methods of invites sent by mail
+	inviteRequest = "REQUEST"
+	inviteCancel  = "CANCEL"
+)
+
+// roomFeedScope, propertyFeedScope and reservationFeedScope name what a feed token grants access to
+func roomFeedScope(roomID int) string {
+	return fmt.Sprintf("ical:room:%d", roomID)
+}
+
+const propertyFeedScope = "ical:property"
+
+func reservationFeedScope(reservationID int) string {
+	return fmt.Sprintf("ical:reservation:%d", reservationID)
+}
+
+// feedKeyScope is what the token of a room or property feed is signed for with
+// the feed's key. Staff reset the key to stop a leaked link working; a feed that
+// was never reset has no key and keeps its first link.
+func feedKeyScope(scope, key string) string {
+	if key == "" {
+		return scope
+	}
+	return scope + ":" + key
+}
+
+// newFeedKey returns a random key for a feed being reset
+func newFeedKey() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// validFeedToken reports whether token opens the room or property feed of scope
+// with the feed's current key
+func (m *Repository) validFeedToken(scope, token string) (bool, error) {
+	key, err := m.DB.GetCalendarFeedKey(scope)
+	if err != nil {
+		return false, err
+	}
+	return helpers.ValidToken(feedKeyScope(scope, key), token), nil
+}
+
+// feedRange returns the date range exported in room and property feeds
+func feedRange() (time.Time, time.Time) {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	return today.AddDate(0, 0, -feedDaysBack), today.AddDate(feedYearsAhead, 0, 0)
+}
+
+// restrictionEvent converts a room restriction into a calendar event. Only the
+// kind of restriction is exported, never guest details.
+func restrictionEvent(room models.Room, rr models.RoomRestriction, prefixRoom bool) ical.Event {
+	e := ical.Event{
+		Start:  rr.StartDate,
+		End:    rr.EndDate,
+		AllDay: true,
+		Stamp:  rr.UpdatedAt,
+		Status: "CONFIRMED",
+	}
+
+	if rr.ReservationID > 0 {
+		e.UID = fmt.Sprintf("reservation-%d@%s", rr.ReservationID, uidDomain)
+		e.Summary = "Reserved"
+		e.Description = fmt.Sprintf("Reservation #%d", rr.ReservationID)
+	} else {
+		e.UID = fmt.Sprintf("restriction-%d@%s", rr.ID, uidDomain)
+		e.Summary = "Blocked"
+	}
+
+	if prefixRoom {
+		e.Summary = fmt.Sprintf("%s: %s", room.RoomName, e.Summary)
+	}
+
+	return e
+}
+
+// reservationInvite builds the calendar invite attached to reservation emails. It
+// keeps the UID of the reservation's feed event and a rising sequence, so calendar
+// apps update or remove the stay they already have.
+func (m *Repository) reservationInvite(res models.Reservation, method string) models.Attachment {
+	e := ical.Event{
+		UID:     fmt.Sprintf("reservation-%d@%s", res.ID, uidDomain),
+		Summary: fmt.Sprintf("%s: %s", propertyName, res.Room.RoomName),
+		Description: fmt.Sprintf("Reservation #%d\nCheck-in from %02d:00, check-out by %02d:00.",
+			res.ID, checkInHour, checkOutHour),
+		Location:      propertyAddress,
+		Start:         atHour(res.StartDate, checkInHour),
+		End:           atHour(res.EndDate, checkOutHour),
+		Sequence:      int(time.Now().Unix()),
+		Status:        "CONFIRMED",
+		Organizer:     m.App.MailFrom,
+		OrganizerName: propertyName,
+		Attendees:     []string{res.Email},
+	}
+	if method == inviteCancel {
+		e.Status = "CANCELLED"
+	}
+
+	cal := ical.New("")
+	cal.Method = method
+	cal.Add(e)
+
+	return models.Attachment{
+		Name:        "invite.ics",
+		ContentType: "text/calendar; charset=utf-8; method=" + method,
+		Data:        cal.Bytes(),
+	}
+}
+
+// atHour returns hour o'clock on the day of d
+func atHour(d time.Time, hour int) time.Time {
+	return time.Date(d.Year(), d.Month(), d.Day(), hour, 0, 0, 0, time.Local)
+}
+
+// writeCalendar sends a calendar to the browser
+func writeCalendar(w http.ResponseWriter, filename string, cal *ical.Calendar) {
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s"`, filename))
+	w.Write(cal.Bytes())
+}
+
+// ICalRoomFeed serves the iCalendar feed for one room
+func (m *Repository) ICalRoomFeed(w http.ResponseWriter, r *http.Request) {
+	roomID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	ok, err := m.validFeedToken(roomFeedScope(roomID), r.URL.Query().Get("token"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if !ok {
+		helpers.ClientError(w, http.StatusForbidden)
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(roomID)
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	start, end := feedRange()
+	restrictions, err := m.DB.GetRestrictionsForRoomByDate(room.ID, start, end)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	cal := ical.New(fmt.Sprintf("%s - %s", propertyName, room.RoomName))
+	for _, rr := range restrictions {
+		if !rr.Restriction.BlocksAvailability {
+			// the room can still be booked, so other channels shouldn't see it as busy
+			continue
+		}
+		cal.Add(restrictionEvent(room, rr, false))
+	}
+
+	writeCalendar(w, fmt.Sprintf("room-%d.ics", room.ID), cal)
+}
+
+// ICalPropertyFeed serves the iCalendar feed for all rooms
+func (m *Repository) ICalPropertyFeed(w http.ResponseWriter, r *http.Request) {
+	ok, err := m.validFeedToken(propertyFeedScope, r.URL.Query().Get("token"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if !ok {
+		helpers.ClientError(w, http.StatusForbidden)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	start, end := feedRange()
+	cal := ical.New(propertyName)
+	for _, room := range rooms {
+		restrictions, err := m.DB.GetRestrictionsForRoomByDate(room.ID, start, end)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		for _, rr := range restrictions {
+			if !rr.Restriction.BlocksAvailability {
+				continue
+			}
+			cal.Add(restrictionEvent(room, rr, true))
+		}
+	}
+
+	writeCalendar(w, "property.ics", cal)
+}
+
+// ICalReservationFeed serves the iCalendar feed for a single reservation
+func (m *Repository) ICalReservationFeed(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	if !helpers.ValidExpiringToken(reservationFeedScope(id), r.URL.Query().Get("token"), time.Now()) {
+		helpers.ClientError(w, http.StatusForbidden)
+		return
+	}
+
+	res, err := m.DB.GetReservationByID(id)
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	cal := ical.New(propertyName)
+	cal.Add(ical.Event{
+		UID:         fmt.Sprintf("reservation-%d@%s", id, uidDomain),
+		Summary:     fmt.Sprintf("Stay at %s", propertyName),
+		Description: fmt.Sprintf("Room: %s\nReservation reference: %d", res.Room.RoomName, id),
+		Location:    propertyAddress,
+		Start:       res.StartDate,
+		End:         res.EndDate,
+		AllDay:      true,
+		Stamp:       res.UpdatedAt,
+		Status:      "CONFIRMED",
+	})
+
+	writeCalendar(w, fmt.Sprintf("reservation-%d.ics", id), cal)
+}
+
+// baseURL returns the scheme and host the request was made to
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s", scheme, r.Host)
+}
+
+// reservationFeedURL returns the tokenized feed url for a reservation, which
+// works until a while after the guest leaves
+func reservationFeedURL(r *http.Request, res models.Reservation) string {
+	expires := res.EndDate.AddDate(0, 0, reservationFeedDays)
+	return fmt.Sprintf("%s/ical/reservations/%d.ics?token=%s", baseURL(r), res.ID, helpers.ExpiringToken(reservationFeedScope(res.ID), expires))
+}
+
+// AdminCalendarFeeds lists the calendar feed urls for the property and each room
+func (m *Repository) AdminCalendarFeeds(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	keys, err := m.DB.CalendarFeedKeys()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["property_feed"] = fmt.Sprintf("%s/ical/property.ics?token=%s", baseURL(r),
+		helpers.SignedToken(feedKeyScope(propertyFeedScope, keys[propertyFeedScope])))
+
+	roomFeeds := make(map[int]string)
+	for _, room := range rooms {
+		scope := roomFeedScope(room.ID)
+		roomFeeds[room.ID] = fmt.Sprintf("%s/ical/rooms/%d.ics?token=%s", baseURL(r), room.ID,
+			helpers.SignedToken(feedKeyScope(scope, keys[scope])))
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+	data["room_feeds"] = roomFeeds
+
+	render.Template(w, r, "admin-calendar-feeds.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+// AdminPostResetCalendarFeed gives the feed of the posted room_id, or of the
+// whole property when it is 0, a new link. The old link stops working, so
+// whoever subscribed with it needs the new one.
+func (m *Repository) AdminPostResetCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	roomID, _ := strconv.Atoi(r.Form.Get("room_id"))
+	scope := propertyFeedScope
+	if roomID > 0 {
+		scope = roomFeedScope(roomID)
+	}
+
+	key, err := newFeedKey()
+	if err == nil {
+		err = m.DB.SetCalendarFeedKey(scope, key)
+	}
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "can't reset feed link")
+		http.Redirect(w, r, "/admin/calendar-feeds", http.StatusSeeOther)
+		return
+	}
+
+	m.audit(r, "reset link", models.AuditCalendarFeed, roomID, nil, nil)
+
+	m.App.Session.Put(r.Context(), "flash", "Feed link reset, the old one no longer works")
+	http.Redirect(w, r, "/admin/calendar-feeds", http.StatusSeeOther)
+}
+
+// AdminICalFeeds lists the external calendars imported for each room
+func (m *Repository) AdminICalFeeds(w http.ResponseWriter, r *http.Request) {
+	m.renderICalFeeds(w, r, forms.New(nil))
+}
+
+func (m *Repository) renderICalFeeds(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	feeds, err := m.DB.AllICalFeeds()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["feeds"] = feeds
+	data["rooms"] = rooms
+
+	render.Template(w, r, "admin-ical-feeds.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// AdminPostICalFeed adds an external calendar to import for a room
+func (m *Repository) AdminPostICalFeed(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("room_id", "url")
+	form.IsURL("url")
+
+	roomID, err := strconv.Atoi(r.Form.Get("room_id"))
+	if err != nil {
+		form.Errors.Add("room_id", "Choose a room")
+	}
+
+	if !form.Valid() {
+		m.renderICalFeeds(w, r, form)
+		return
+	}
+
+	feed := models.ICalFeed{
+		RoomID: roomID,
+		URL:    r.Form.Get("url"),
+	}
+	feed.ID, err = m.DB.InsertICalFeed(feed)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't save calendar feed")
+		http.Redirect(w, r, "/admin/ical-feeds", http.StatusSeeOther)
+		return
+	}
+
+	m.audit(r, "create", models.AuditICalFeed, feed.ID, nil, feed)
+
+	m.App.Session.Put(r.Context(), "flash", "Calendar feed added, it will be imported shortly")
+	http.Redirect(w, r, "/admin/ical-feeds", http.StatusSeeOther)
+}
+
+// AdminDeleteICalFeed removes an external calendar and the restrictions imported from it
+func (m *Repository) AdminDeleteICalFeed(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	err := m.DB.DeleteICalFeed(id)
+	if err != nil {
+		log.Println(err)
+	} else {
+		m.audit(r, "delete", models.AuditICalFeed, id, nil, nil)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Calendar feed deleted")
+	http.Redirect(w, r, "/admin/ical-feeds", http.StatusSeeOther)
+}
@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DmitryZzz/bookings/internal/emails"
+	"github.com/DmitryZzz/bookings/internal/forms"
+	"github.com/DmitryZzz/bookings/internal/helpers"
+	"github.com/DmitryZzz/bookings/internal/loginlinks"
+	"github.com/DmitryZzz/bookings/internal/models"
+	"github.com/DmitryZzz/bookings/internal/render"
+	"github.com/DmitryZzz/bookings/internal/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+// loginAttempt is what the audit log records about a login, by "password" or
+// "link" Method. Reason is why it failed.
+type loginAttempt struct {
+	Email  string `json:",omitempty"`
+	Method string
+	Reason string `json:",omitempty"`
+}
+
+// recordLogin records a login to the staff user or guest account id of entity
+// in the audit log, as failed when it has a reason
+func (m *Repository) recordLogin(r *http.Request, entity string, id int, a loginAttempt) {
+	action := "login"
+	if a.Reason != "" {
+		action = "login failed"
+	}
+	m.audit(r, action, entity, id, nil, a)
+}
+
+// loginPage is the login form for a kind of login link
+func loginPage(kind string) string {
+	if kind == loginlinks.KindGuest {
+		return "/account/login"
+	}
+	return "/user/login"
+}
+
+// loginEntity is what logins with a kind of login link are recorded against
+func loginEntity(kind string) string {
+	if kind == loginlinks.KindGuest {
+		return models.AuditGuestAccount
+	}
+	return models.AuditUser
+}
+
+// PostLoginLink emails a member of staff a link to log in without their password
+func (m *Repository) PostLoginLink(w http.ResponseWriter, r *http.Request) {
+	m.postLoginLink(w, r, loginlinks.KindStaff)
+}
+
+// PostAccountLoginLink emails a guest a link to log in to their account without
+// their password
+func (m *Repository) PostAccountLoginLink(w http.ResponseWriter, r *http.Request) {
+	m.postLoginLink(w, r, loginlinks.KindGuest)
+}
+
+// postLoginLink emails a link of kind to the account with the address posted as
+// link_email. Whether there is one isn't given away, so the same message is
+// shown either way.
+func (m *Repository) postLoginLink(w http.ResponseWriter, r *http.Request, kind string) {
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't parse form!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("link_email")
+	form.IsEmail("link_email")
+	if !form.Valid() {
+		tmpl := "login.page.tmpl"
+		if kind == loginlinks.KindGuest {
+			tmpl = "account-login.page.tmpl"
+		}
+		render.Template(w, r, tmpl, &models.TemplateData{Form: form})
+		return
+	}
+
+	email := strings.TrimSpace(form.Get("link_email"))
+
+	var id int
+	var name, to string
+	if kind == loginlinks.KindGuest {
+		a, err := m.DB.GetGuestAccountByEmail(email)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		id, name, to = a.ID, a.Guest.FirstName, a.Email
+	} else {
+		u, err := m.DB.GetUserByEmail(email)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		id, name, to = u.ID, u.FirstName, u.Email
+	}
+
+	if id == 0 {
+		m.recordLogin(r, loginEntity(kind), 0, loginAttempt{Email: email, Method: "link", Reason: "there is no account for this email address"})
+	} else {
+		err = m.sendLoginLink(r, kind, id, name, to, form.Has("same_browser"))
+		if err != nil {
+			log.Println(err)
+			m.App.Session.Put(r.Context(), "error", "can't send login link")
+			http.Redirect(w, r, loginPage(kind), http.StatusSeeOther)
+			return
+		}
+		m.audit(r, "send login link", loginEntity(kind), id, nil, loginAttempt{Email: to, Method: "link"})
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "If there's an account for that address, we've emailed it a login link")
+	http.Redirect(w, r, loginPage(kind), http.StatusSeeOther)
+}
+
+// sendLoginLink adds a login link of kind to an account and emails it to them.
+// A link for the same browser only works in a browser with the key kept in the
+// session of the one asking for it.
+func (m *Repository) sendLoginLink(r *http.Request, kind string, id int, name, to string, sameBrowser bool) error {
+	l := models.LoginLink{
+		Kind:      kind,
+		AccountID: id,
+		ExpiresAt: time.Now().Add(loginlinks.Lifetime),
+	}
+
+	if sameBrowser {
+		key := m.App.Session.GetString(r.Context(), "login_link_browser")
+		if key == "" {
+			var err error
+			key, err = loginlinks.NewBrowserKey()
+			if err != nil {
+				return err
+			}
+			m.App.Session.Put(r.Context(), "login_link_browser", key)
+		}
+		l.Browser = loginlinks.BrowserHash(key)
+	}
+
+	var err error
+	l.ID, err = m.DB.InsertLoginLink(l)
+	if err != nil {
+		return err
+	}
+
+	email, err := emails.LoginLink(name, loginlinks.Link(m.App.SiteURL, l), int(loginlinks.Lifetime.Minutes()))
+	if err != nil {
+		return err
+	}
+
+	m.queueMail(email.Mail(m.App.MailFrom, to))
+	return nil
+}
+
+// loginLinkMessage tells whoever followed a login link why it didn't work
+func loginLinkMessage(err error) string {
+	switch {
+	case errors.Is(err, loginlinks.ErrExpired):
+		return "That login link has expired, ask for a new one"
+	case errors.Is(err, loginlinks.ErrUsed):
+		return "That login link has already been used, ask for a new one"
+	case errors.Is(err, loginlinks.ErrOtherBrowser):
+		return "Open the login link in the browser you asked for it from"
+	default:
+		return "That login link isn't valid, ask for a new one"
+	}
+}
+
+// useLoginLink checks the login link of kind in the url and marks it used,
+// recording why and sending the user back to log in when it can't be used
+func (m *Repository) useLoginLink(w http.ResponseWriter, r *http.Request, kind string) (l models.LoginLink, ok bool) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	l, err := m.DB.GetLoginLink(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return l, false
+	}
+
+	browserKey := m.App.Session.GetString(r.Context(), "login_link_browser")
+	err = loginlinks.Check(l, kind, r.URL.Query().Get("token"), browserKey, time.Now())
+	if err == nil {
+		// only one request can mark the link used, however many follow it
+		err = m.DB.UseLoginLink(l.ID)
+		if errors.Is(err, repository.ErrLinkUsed) {
+			err = loginlinks.ErrUsed
+		} else if err != nil {
+			helpers.ServerError(w, err)
+			return l, false
+		}
+	}
+
+	if err != nil {
+		// an invalid link can't be trusted to say whose it is
+		accountID := l.AccountID
+		if errors.Is(err, loginlinks.ErrInvalid) {
+			accountID = 0
+		}
+		m.recordLogin(r, loginEntity(kind), accountID, loginAttempt{Method: "link", Reason: err.Error()})
+
+		m.App.Session.Put(r.Context(), "error", loginLinkMessage(err))
+		http.Redirect(w, r, loginPage(kind), http.StatusSeeOther)
+		return l, false
+	}
+	return l, true
+}
+
+// LoginWithLink logs a member of staff in with the link emailed to them
+func (m *Repository) LoginWithLink(w http.ResponseWriter, r *http.Request) {
+	l, ok := m.useLoginLink(w, r, loginlinks.KindStaff)
+	if !ok {
+		return
+	}
+
+	_ = m.App.Session.RenewToken(r.Context())
+	m.App.Session.Put(r.Context(), "user_id", l.AccountID)
+	m.recordLogin(r, models.AuditUser, l.AccountID, loginAttempt{Method: "link"})
+
+	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// AccountLoginWithLink logs a guest in to their account with the link emailed
+// to them, which also confirms their email address
+func (m *Repository) AccountLoginWithLink(w http.ResponseWriter, r *http.Request) {
+	l, ok := m.useLoginLink(w, r, loginlinks.KindGuest)
+	if !ok {
+		return
+	}
+
+	a, err := m.DB.GetGuestAccountByID(l.AccountID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if a.ID == 0 {
+		m.App.Session.Put(r.Context(), "error", loginLinkMessage(loginlinks.ErrInvalid))
+		http.Redirect(w, r, "/account/login", http.StatusSeeOther)
+		return
+	}
+
+	if !a.Verified() {
+		err = m.DB.VerifyGuestAccount(a.ID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		m.audit(r, "verify", models.AuditGuestAccount, a.ID, nil, nil)
+	}
+
+	_ = m.App.GuestSession.RenewToken(r.Context())
+	m.App.GuestSession.Put(r.Context(), "guest_account_id", a.ID)
+	m.recordLogin(r, models.AuditGuestAccount, a.ID, loginAttempt{Email: a.Email, Method: "link"})
+
+	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
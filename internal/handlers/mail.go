@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/DmitryZzz/bookings/internal/emails"
+	"github.com/DmitryZzz/bookings/internal/helpers"
+	"github.com/DmitryZzz/bookings/internal/mailer"
+	"github.com/DmitryZzz/bookings/internal/models"
+	"github.com/DmitryZzz/bookings/internal/render"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// ownerEmail receives notices meant for the property owner
+	ownerEmail = "me@here.com"
+	// mailLogSize is how many messages the outbox page shows
+	mailLogSize = 100
+)
+
+// queueMail adds a message to the outbox; failures are logged and never fail the request
+func (m *Repository) queueMail(msg models.MailData) {
+	err := mailer.Queue(m.DB, msg)
+	if err != nil {
+		m.App.ErrorLog.Println("can't queue mail to", msg.To, err)
+	}
+}
+
+// sendReservationChanged emails the guest an updated invite after their dates or room change
+func (m *Repository) sendReservationChanged(res models.Reservation) {
+	email, err := emails.ReservationChanged(res)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		return
+	}
+
+	msg := email.Mail(m.App.MailFrom, res.Email)
+	msg.Attachments = []models.Attachment{m.reservationInvite(res, inviteRequest)}
+	m.queueMail(msg)
+}
+
+// sendReservationCancelled emails the guest a cancellation that removes the stay from their calendar
+func (m *Repository) sendReservationCancelled(res models.Reservation) {
+	email, err := emails.ReservationCancelled(res)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		return
+	}
+
+	msg := email.Mail(m.App.MailFrom, res.Email)
+	msg.Attachments = []models.Attachment{m.reservationInvite(res, inviteCancel)}
+	m.queueMail(msg)
+}
+
+// sendAccountVerification emails a guest the link that confirms the email
+// address of their account
+func (m *Repository) sendAccountVerification(a models.GuestAccount) {
+	email, err := emails.AccountVerification(a, m.verifyURL(a))
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		return
+	}
+
+	m.queueMail(email.Mail(m.App.MailFrom, a.Email))
+}
+
+// AdminMail shows the mail outbox
+func (m *Repository) AdminMail(w http.ResponseWriter, r *http.Request) {
+	mail, err := m.DB.RecentMail(mailLogSize)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["mail"] = mail
+
+	render.Template(w, r, "admin-mail.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminResendMail queues a message to be sent again
+func (m *Repository) AdminResendMail(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	err := m.DB.ResendMail(id)
+	if err != nil {
+		log.Println(err)
+	} else {
+		m.audit(r, "resend", models.AuditMail, id, nil, nil)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Message queued")
+	http.Redirect(w, r, "/admin/mail", http.StatusSeeOther)
+}
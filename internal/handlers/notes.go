@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/DmitryZzz/bookings/internal/helpers"
+	"github.com/DmitryZzz/bookings/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// maxTags is how many tags a reservation can have
+	maxTags = 10
+	// maxTagLength is the longest a tag can be, in characters
+	maxTagLength = 30
+)
+
+// parseTags splits a comma separated list of tags, tidying up the spaces in
+// each and dropping empty ones and repeats, which are found ignoring case
+func parseTags(s string) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, tag := range strings.Split(s, ",") {
+		tag = strings.Join(strings.Fields(tag), " ")
+		if tag == "" || seen[strings.ToLower(tag)] {
+			continue
+		}
+		if r := []rune(tag); len(r) > maxTagLength {
+			tag = string(r[:maxTagLength])
+		}
+		seen[strings.ToLower(tag)] = true
+		tags = append(tags, tag)
+		if len(tags) == maxTags {
+			break
+		}
+	}
+	return tags
+}
+
+// reservationURL returns the admin page of a reservation opened from src
+func reservationURL(src string, id int) string {
+	return fmt.Sprintf("/admin/reservations/%s/%d/show", src, id)
+}
+
+// AdminPostReservationTags replaces the tags of a reservation with the comma
+// separated list posted as tags
+func (m *Repository) AdminPostReservationTags(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	src := chi.URLParam(r, "src")
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	res, err := m.DB.GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	tags := parseTags(r.Form.Get("tags"))
+	err = m.DB.SetReservationTags(id, tags)
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "can't save tags")
+		http.Redirect(w, r, reservationURL(src, id), http.StatusSeeOther)
+		return
+	}
+
+	m.audit(r, "tag", models.AuditReservation, id, res.Tags, tags)
+
+	m.App.Session.Put(r.Context(), "flash", "Tags saved")
+	http.Redirect(w, r, reservationURL(src, id), http.StatusSeeOther)
+}
+
+// AdminPostReservationNote adds a note by the logged in user to a reservation
+func (m *Repository) AdminPostReservationNote(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	src := chi.URLParam(r, "src")
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	res, err := m.DB.GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	note := models.ReservationNote{
+		ReservationID: res.ID,
+		UserID:        m.App.Session.GetInt(r.Context(), "user_id"),
+		Body:          strings.TrimSpace(r.Form.Get("body")),
+	}
+	if note.Body == "" {
+		m.App.Session.Put(r.Context(), "error", "Write something in the note")
+		http.Redirect(w, r, reservationURL(src, id), http.StatusSeeOther)
+		return
+	}
+
+	note.ID, err = m.DB.InsertReservationNote(note)
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "can't add note")
+		http.Redirect(w, r, reservationURL(src, id), http.StatusSeeOther)
+		return
+	}
+
+	m.audit(r, "add note", models.AuditReservation, id, nil, note)
+
+	m.App.Session.Put(r.Context(), "flash", "Note added")
+	http.Redirect(w, r, reservationURL(src, id), http.StatusSeeOther)
+}
+
+// AdminDeleteReservationNote deletes a note from a reservation
+func (m *Repository) AdminDeleteReservationNote(w http.ResponseWriter, r *http.Request) {
+	src := chi.URLParam(r, "src")
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	noteID, _ := strconv.Atoi(chi.URLParam(r, "noteID"))
+
+	var note interface{}
+	notes, err := m.DB.ReservationNotes(id)
+	if err != nil {
+		log.Println(err)
+	}
+	for _, n := range notes {
+		if n.ID == noteID {
+			note = n
+		}
+	}
+
+	err = m.DB.DeleteReservationNote(noteID, id)
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "can't delete note")
+		http.Redirect(w, r, reservationURL(src, id), http.StatusSeeOther)
+		return
+	}
+
+	m.audit(r, "delete note", models.AuditReservation, id, note, nil)
+
+	m.App.Session.Put(r.Context(), "flash", "Note deleted")
+	http.Redirect(w, r, reservationURL(src, id), http.StatusSeeOther)
+}
@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/DmitryZzz/bookings/internal/helpers"
+	"github.com/DmitryZzz/bookings/internal/models"
+	"github.com/DmitryZzz/bookings/internal/photos"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxPhotosPerUpload is how many photos can be uploaded at once
+const maxPhotosPerUpload = 10
+
+// MaxPhotoUploadSize is the largest body a photo upload can have: a full set
+// of the largest photos, with room for the rest of the form
+const MaxPhotoUploadSize = maxPhotosPerUpload*photos.MaxUploadSize + 1<<20
+
+// PhotoUploadMemory is how much of a photo upload is kept in memory while it
+// is parsed, the rest goes to temporary files
+const PhotoUploadMemory = 8 << 20
+
+// photoView is a room photo with the addresses of its sizes
+type photoView struct {
+	models.RoomPhoto
+	Thumb  string
+	Medium string
+	Large  string
+}
+
+func (m *Repository) photoView(p models.RoomPhoto) photoView {
+	return photoView{
+		RoomPhoto: p,
+		Thumb:     m.Photos.URL(photos.FileName(p.Key, "thumb")),
+		Medium:    m.Photos.URL(photos.FileName(p.Key, "medium")),
+		Large:     m.Photos.URL(photos.FileName(p.Key, "large")),
+	}
+}
+
+// roomPhotos returns the photos of a room, in the order they are shown
+func (m *Repository) roomPhotos(roomID int) ([]photoView, error) {
+	roomPhotos, err := m.DB.PhotosForRoom(roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	var views []photoView
+	for _, p := range roomPhotos {
+		views = append(views, m.photoView(p))
+	}
+	return views, nil
+}
+
+// coverPhotos returns the address of each room's first photo, by room id
+func (m *Repository) coverPhotos() (map[int]string, error) {
+	covers, err := m.DB.CoverPhotos()
+	if err != nil {
+		return nil, err
+	}
+
+	urls := make(map[int]string)
+	for roomID, p := range covers {
+		urls[roomID] = m.photoView(p).Medium
+	}
+	return urls, nil
+}
+
+// AdminPostRoomPhotos uploads photos of the room with the id in the url
+func (m *Repository) AdminPostRoomPhotos(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	_, err = m.DB.GetRoomByID(id)
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	back := fmt.Sprintf("/admin/rooms/%d", id)
+
+	// the body is limited and usually already parsed by LimitUploads in cmd/web,
+	// which has to run before the CSRF check reads the form
+	err = r.ParseMultipartForm(PhotoUploadMemory)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Upload at most %d photos at once, each under %d MB", maxPhotosPerUpload, photos.MaxUploadSize>>20))
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	files := r.MultipartForm.File["photos"]
+	if len(files) == 0 {
+		m.App.Session.Put(r.Context(), "error", "Choose the photos to upload")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	if len(files) > maxPhotosPerUpload {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Upload at most %d photos at once", maxPhotosPerUpload))
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	saved := 0
+	var problems []string
+	for _, fh := range files {
+		photo, err := m.saveRoomPhoto(id, fh)
+		switch {
+		case err == nil:
+			saved++
+			m.audit(r, "create", models.AuditRoomPhoto, photo.ID, nil, photo)
+		case errors.Is(err, photos.ErrUnsupportedType), errors.Is(err, photos.ErrTooLarge):
+			problems = append(problems, fmt.Sprintf("%s: %s", fh.Filename, err))
+		default:
+			log.Println(err)
+			problems = append(problems, fmt.Sprintf("%s couldn't be saved", fh.Filename))
+		}
+	}
+
+	if len(problems) > 0 {
+		m.App.Session.Put(r.Context(), "error", strings.Join(problems, "; "))
+	}
+	if saved > 0 {
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%d photos uploaded", saved))
+	}
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// saveRoomPhoto resizes an uploaded photo, stores its files and records it
+func (m *Repository) saveRoomPhoto(roomID int, fh *multipart.FileHeader) (models.RoomPhoto, error) {
+	var photo models.RoomPhoto
+	if fh.Size > photos.MaxUploadSize {
+		return photo, photos.ErrTooLarge
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		return photo, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, photos.MaxUploadSize+1))
+	if err != nil {
+		return photo, err
+	}
+	if len(data) > photos.MaxUploadSize {
+		return photo, photos.ErrTooLarge
+	}
+
+	p, err := photos.Process(data)
+	if err != nil {
+		return photo, err
+	}
+
+	key, err := photos.NewKey(roomID)
+	if err != nil {
+		return photo, err
+	}
+
+	err = photos.Save(m.Photos, key, p)
+	if err != nil {
+		return photo, err
+	}
+
+	photo = models.RoomPhoto{
+		RoomID: roomID,
+		Key:    key,
+		Width:  p.Width,
+		Height: p.Height,
+	}
+	photo.ID, err = m.DB.InsertRoomPhoto(photo)
+	if err != nil {
+		_ = photos.Remove(m.Photos, key)
+		return photo, err
+	}
+
+	return photo, nil
+}
+
+// AdminOrderRoomPhotos saves the order of a room's photos from the position
+// entered for each one
+func (m *Repository) AdminOrderRoomPhotos(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	back := fmt.Sprintf("/admin/rooms/%d", id)
+
+	roomPhotos, err := m.DB.PhotosForRoom(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	positions := make(map[int]int)
+	for _, p := range roomPhotos {
+		pos, err := strconv.Atoi(r.Form.Get(fmt.Sprintf("position_%d", p.ID)))
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "Enter a number for each photo")
+			http.Redirect(w, r, back, http.StatusSeeOther)
+			return
+		}
+		positions[p.ID] = pos
+	}
+
+	var before []int
+	for _, p := range roomPhotos {
+		before = append(before, p.ID)
+	}
+
+	// photos given the same number keep their current order
+	sort.SliceStable(roomPhotos, func(i, j int) bool {
+		return positions[roomPhotos[i].ID] < positions[roomPhotos[j].ID]
+	})
+
+	var ids []int
+	for _, p := range roomPhotos {
+		ids = append(ids, p.ID)
+	}
+
+	err = m.DB.SetRoomPhotoOrder(id, ids)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't save photo order")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	m.audit(r, "reorder photos", models.AuditRoom, id, before, ids)
+
+	m.App.Session.Put(r.Context(), "flash", "Photo order saved")
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// AdminDeleteRoomPhoto deletes a room photo and its files
+func (m *Repository) AdminDeleteRoomPhoto(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	photoID, _ := strconv.Atoi(chi.URLParam(r, "photoID"))
+
+	back := fmt.Sprintf("/admin/rooms/%d", id)
+
+	p, err := m.DB.GetRoomPhoto(photoID)
+	if err != nil {
+		log.Println(err)
+	}
+	if p.ID == 0 || p.RoomID != id {
+		m.App.Session.Put(r.Context(), "error", "Photo not found")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.DeleteRoomPhoto(p.ID)
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "can't delete photo")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	m.audit(r, "delete", models.AuditRoomPhoto, p.ID, p, nil)
+
+	err = photos.Remove(m.Photos, p.Key)
+	if err != nil {
+		log.Println(err)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Photo deleted")
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/DmitryZzz/bookings/internal/forms"
+	"github.com/DmitryZzz/bookings/internal/helpers"
+	"github.com/DmitryZzz/bookings/internal/models"
+	"github.com/DmitryZzz/bookings/internal/render"
+	"github.com/DmitryZzz/bookings/internal/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+// validColor matches the colors a restriction type can be shown in, e.g. #ffc107
+var validColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// restrictionID returns the id of the restriction type with a code
+func (m *Repository) restrictionID(code string) (int, error) {
+	t, err := m.DB.GetRestrictionByCode(code)
+	if err != nil {
+		return 0, err
+	}
+	if t.ID == 0 {
+		return 0, fmt.Errorf("there is no %q restriction type", code)
+	}
+	return t.ID, nil
+}
+
+// blockRestrictionID returns the restriction type id posted for a block, or the
+// owner block type when none, or one that isn't for blocks, was chosen
+func (m *Repository) blockRestrictionID(value string) (int, error) {
+	if id, err := strconv.Atoi(value); err == nil {
+		t, err := m.DB.GetRestrictionByID(id)
+		if err != nil {
+			return 0, err
+		}
+		if t.ID > 0 && t.Code != models.ReservationRestriction {
+			return t.ID, nil
+		}
+	}
+	return m.restrictionID(models.OwnerRestriction)
+}
+
+// AdminRestrictions lists the restriction types
+func (m *Repository) AdminRestrictions(w http.ResponseWriter, r *http.Request) {
+	restrictions, err := m.DB.AllRestrictions()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["restrictions"] = restrictions
+
+	render.Template(w, r, "admin-restrictions.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminRestriction shows the form to add a restriction type, or edit the one
+// with the id in the url
+func (m *Repository) AdminRestriction(w http.ResponseWriter, r *http.Request) {
+	var t models.Restriction
+	if idParam := chi.URLParam(r, "id"); idParam != "new" {
+		id, err := strconv.Atoi(idParam)
+		if err != nil {
+			helpers.ClientError(w, http.StatusNotFound)
+			return
+		}
+
+		t, err = m.DB.GetRestrictionByID(id)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		if t.ID == 0 {
+			helpers.ClientError(w, http.StatusNotFound)
+			return
+		}
+	} else {
+		t.Color = "#6c757d"
+		t.BlocksAvailability = true
+	}
+
+	form := forms.New(url.Values{})
+	form.Set("restriction_name", t.RestrictionName)
+	form.Set("color", t.Color)
+	if t.BlocksAvailability {
+		form.Set("blocks_availability", "1")
+	}
+	if t.CountsOccupied {
+		form.Set("counts_occupied", "1")
+	}
+
+	m.renderAdminRestriction(w, r, t, form)
+}
+
+func (m *Repository) renderAdminRestriction(w http.ResponseWriter, r *http.Request, t models.Restriction, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["restriction"] = t
+
+	render.Template(w, r, "admin-restriction.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// AdminPostRestriction saves a new or edited restriction type
+func (m *Repository) AdminPostRestriction(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	var t models.Restriction
+	if idParam := chi.URLParam(r, "id"); idParam != "new" {
+		id, err := strconv.Atoi(idParam)
+		if err != nil {
+			helpers.ClientError(w, http.StatusNotFound)
+			return
+		}
+
+		t, err = m.DB.GetRestrictionByID(id)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		if t.ID == 0 {
+			helpers.ClientError(w, http.StatusNotFound)
+			return
+		}
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("restriction_name")
+	if !validColor.MatchString(form.Get("color")) {
+		form.Errors.Add("color", "Choose a color like #ffc107")
+	}
+
+	if !form.Valid() {
+		m.renderAdminRestriction(w, r, t, form)
+		return
+	}
+
+	before := t
+	t.RestrictionName = strings.TrimSpace(form.Get("restriction_name"))
+	t.Color = strings.ToLower(form.Get("color"))
+	t.BlocksAvailability = form.Has("blocks_availability")
+	t.CountsOccupied = form.Has("counts_occupied")
+	if t.Code == models.ReservationRestriction {
+		// a room can't be booked twice for the same night
+		t.BlocksAvailability = true
+	}
+
+	if t.ID == 0 {
+		t.ID, err = m.DB.InsertRestriction(t)
+	} else {
+		err = m.DB.UpdateRestriction(t)
+	}
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "can't save restriction type")
+		http.Redirect(w, r, "/admin/restrictions", http.StatusSeeOther)
+		return
+	}
+
+	if before.ID == 0 {
+		m.audit(r, "create", models.AuditRestriction, t.ID, nil, t)
+	} else {
+		m.audit(r, "update", models.AuditRestriction, t.ID, before, t)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Restriction type saved")
+	http.Redirect(w, r, "/admin/restrictions", http.StatusSeeOther)
+}
+
+// AdminDeleteRestriction deletes a restriction type that nothing uses
+func (m *Repository) AdminDeleteRestriction(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	t, err := m.DB.GetRestrictionByID(id)
+	if err != nil {
+		log.Println(err)
+	}
+	if t.System() {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("%s is used by the application and can't be deleted", t.RestrictionName))
+		http.Redirect(w, r, "/admin/restrictions", http.StatusSeeOther)
+		return
+	}
+
+	count, err := m.DB.CountRoomRestrictionsForRestriction(id)
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "can't delete restriction type")
+		http.Redirect(w, r, "/admin/restrictions", http.StatusSeeOther)
+		return
+	}
+	if count > 0 {
+		// the database refuses too, counting first says why
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("%s is used by %d restrictions and can't be deleted", t.RestrictionName, count))
+		http.Redirect(w, r, "/admin/restrictions", http.StatusSeeOther)
+		return
+	}
+
+	// blocks in the trash count too, they still refer to the type
+	count, err = m.DB.CountBlocksForRestriction(id)
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "can't delete restriction type")
+		http.Redirect(w, r, "/admin/restrictions", http.StatusSeeOther)
+		return
+	}
+	if count > 0 {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("%s is used by %d blocks and can't be deleted", t.RestrictionName, count))
+		http.Redirect(w, r, "/admin/restrictions", http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.DeleteRestriction(id)
+	if errors.Is(err, repository.ErrInUse) {
+		// nights or blocks of the type were saved since they were counted
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("%s has just been used and can't be deleted", t.RestrictionName))
+		http.Redirect(w, r, "/admin/restrictions", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "can't delete restriction type")
+		http.Redirect(w, r, "/admin/restrictions", http.StatusSeeOther)
+		return
+	}
+
+	m.audit(r, "delete", models.AuditRestriction, id, t, nil)
+
+	m.App.Session.Put(r.Context(), "flash", "Restriction type deleted")
+	http.Redirect(w, r, "/admin/restrictions", http.StatusSeeOther)
+}
@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DmitryZzz/bookings/internal/forms"
+	"github.com/DmitryZzz/bookings/internal/helpers"
+	"github.com/DmitryZzz/bookings/internal/models"
+	"github.com/DmitryZzz/bookings/internal/render"
+	"github.com/DmitryZzz/bookings/internal/reviews"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxReviewLength limits how much a guest can write in a review
+const maxReviewLength = 2000
+
+// reviewReservation returns the reservation a review link is for, writing an
+// error response and returning false when the link isn't valid
+func (m *Repository) reviewReservation(w http.ResponseWriter, r *http.Request, token string) (models.Reservation, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return models.Reservation{}, false
+	}
+
+	if !helpers.ValidExpiringToken(reviews.Scope(id), token, time.Now()) {
+		helpers.ClientError(w, http.StatusForbidden)
+		return models.Reservation{}, false
+	}
+
+	res, err := m.DB.GetReservationByID(id)
+	if err != nil || res.ID == 0 {
+		helpers.ClientError(w, http.StatusNotFound)
+		return models.Reservation{}, false
+	}
+
+	return res, true
+}
+
+// Review shows the form a guest uses to review their stay. The link is sent
+// after they leave and can be used once.
+func (m *Repository) Review(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	res, ok := m.reviewReservation(w, r, token)
+	if !ok {
+		return
+	}
+
+	m.renderReview(w, r, res, token, forms.New(nil))
+}
+
+// renderReview shows the review form, or why the stay can't be reviewed
+func (m *Repository) renderReview(w http.ResponseWriter, r *http.Request, res models.Reservation, token string, form *forms.Form) {
+	existing, err := m.DB.GetReviewByReservationID(res.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["token"] = token
+	switch {
+	case existing.ID > 0:
+		stringMap["state"] = "reviewed"
+	case !reviews.StayOver(res, time.Now()):
+		stringMap["state"] = "early"
+	default:
+		stringMap["state"] = "open"
+	}
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["ratings"] = render.Iterate(reviews.MaxRating)
+
+	render.Template(w, r, "review.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		Form:      form,
+	})
+}
+
+// PostReview saves a guest's review, to be shown once the owner approves it
+func (m *Repository) PostReview(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	token := r.Form.Get("token")
+	res, ok := m.reviewReservation(w, r, token)
+	if !ok {
+		return
+	}
+
+	if !reviews.StayOver(res, time.Now()) {
+		m.renderReview(w, r, res, token, forms.New(nil))
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("body")
+
+	rating, err := strconv.Atoi(form.Get("rating"))
+	if err != nil || rating < 1 || rating > reviews.MaxRating {
+		form.Errors.Add("rating", "Choose a rating")
+	}
+	if len(form.Get("body")) > maxReviewLength {
+		form.Errors.Add("body", fmt.Sprintf("Please keep your review under %d characters", maxReviewLength))
+	}
+
+	if !form.Valid() {
+		m.renderReview(w, r, res, token, form)
+		return
+	}
+
+	review := models.Review{
+		ReservationID: res.ID,
+		RoomID:        res.RoomID,
+		GuestName:     reviews.GuestName(res),
+		Rating:        rating,
+		Body:          strings.TrimSpace(form.Get("body")),
+		Status:        reviews.StatusPending,
+	}
+	newID, err := m.DB.InsertReview(review)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't save your review")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	if newID == 0 {
+		// submitted twice, e.g. from two tabs
+		m.renderReview(w, r, res, token, forms.New(nil))
+		return
+	}
+
+	review.ID = newID
+	m.audit(r, "create", models.AuditReview, newID, nil, review)
+
+	m.App.Session.Put(r.Context(), "flash", "Thank you for your review!")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// roomReviewData adds a room's approved reviews and their summary to data.
+// Reviews are a nice-to-have on a room page, so a failure is only logged.
+func (m *Repository) roomReviewData(roomID int, data map[string]interface{}) {
+	approved, err := m.DB.ApprovedReviewsForRoom(roomID)
+	if err != nil {
+		m.App.ErrorLog.Println("can't load reviews for room", roomID, err)
+		return
+	}
+
+	data["reviews"] = approved
+	data["rating"] = reviews.Summarize(approved)
+}
+
+// reviewJSON is a public review in the room reviews feed
+type reviewJSON struct {
+	GuestName string    `json:"guest_name"`
+	Rating    int       `json:"rating"`
+	Body      string    `json:"body"`
+	Reply     string    `json:"reply,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// roomReviewsResponse is the room reviews feed
+type roomReviewsResponse struct {
+	RoomID int    `json:"room_id"`
+	Room   string `json:"room"`
+	reviews.Summary
+	Reviews []reviewJSON `json:"reviews"`
+}
+
+// RoomReviewsJSON serves a room's approved reviews and average rating as JSON
+func (m *Repository) RoomReviewsJSON(w http.ResponseWriter, r *http.Request) {
+	room, err := m.DB.GetRoomBySlug(chi.URLParam(r, "slug"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if room.ID == 0 {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	approved, err := m.DB.ApprovedReviewsForRoom(room.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	resp := roomReviewsResponse{
+		RoomID:  room.ID,
+		Room:    room.Slug,
+		Summary: reviews.Summarize(approved),
+		Reviews: []reviewJSON{},
+	}
+	for _, v := range approved {
+		resp.Reviews = append(resp.Reviews, reviewJSON{
+			GuestName: v.GuestName,
+			Rating:    v.Rating,
+			Body:      v.Body,
+			Reply:     v.Reply,
+			CreatedAt: v.CreatedAt,
+		})
+	}
+
+	out, _ := json.MarshalIndent(resp, "", "     ")
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+// AdminReviews lists guest reviews for moderation
+func (m *Repository) AdminReviews(w http.ResponseWriter, r *http.Request) {
+	all, err := m.DB.AllReviews()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reviews"] = all
+
+	render.Template(w, r, "admin-reviews.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminApproveReview shows a review on its room's page
+func (m *Repository) AdminApproveReview(w http.ResponseWriter, r *http.Request) {
+	m.setReviewStatus(w, r, reviews.StatusApproved, "Review approved")
+}
+
+// AdminHideReview takes a review off its room's page
+func (m *Repository) AdminHideReview(w http.ResponseWriter, r *http.Request) {
+	m.setReviewStatus(w, r, reviews.StatusHidden, "Review hidden")
+}
+
+func (m *Repository) setReviewStatus(w http.ResponseWriter, r *http.Request, status, flash string) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	err := m.DB.UpdateReviewStatus(id, status)
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "can't change review")
+		http.Redirect(w, r, "/admin/reviews", http.StatusSeeOther)
+		return
+	}
+
+	m.audit(r, "set status", models.AuditReview, id, nil, map[string]string{"Status": status})
+
+	m.App.Session.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, "/admin/reviews", http.StatusSeeOther)
+}
+
+// AdminReplyReview saves the owner's public reply to a review
+func (m *Repository) AdminReplyReview(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	reply := strings.TrimSpace(r.Form.Get("reply"))
+	err = m.DB.ReplyToReview(id, reply)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't save reply")
+		http.Redirect(w, r, "/admin/reviews", http.StatusSeeOther)
+		return
+	}
+
+	m.audit(r, "reply", models.AuditReview, id, nil, map[string]string{"Reply": reply})
+
+	m.App.Session.Put(r.Context(), "flash", "Reply saved")
+	http.Redirect(w, r, "/admin/reviews", http.StatusSeeOther)
+}
@@ -0,0 +1,340 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/DmitryZzz/bookings/internal/forms"
+	"github.com/DmitryZzz/bookings/internal/helpers"
+	"github.com/DmitryZzz/bookings/internal/models"
+	"github.com/DmitryZzz/bookings/internal/photos"
+	"github.com/DmitryZzz/bookings/internal/render"
+	"github.com/go-chi/chi/v5"
+)
+
+// validSlug matches the part of a room's address after /rooms/
+var validSlug = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// slugify turns a room name into a slug, e.g. "Major's Suite" into "majors-suite"
+func slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, c := range strings.ToLower(name) {
+		switch {
+		case c >= 'a' && c <= 'z' || c >= '0' && c <= '9':
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(c)
+			dash = false
+		case c == '\'' || c == '`' || c == '’':
+			// "major's" reads better as "majors" than "major-s"
+		default:
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+// Rooms lists the rooms
+func (m *Repository) Rooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	covers, err := m.coverPhotos()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+	data["covers"] = covers
+
+	render.Template(w, r, "rooms.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// Room renders the page of the room with the slug in the url
+func (m *Repository) Room(w http.ResponseWriter, r *http.Request) {
+	room, err := m.DB.GetRoomBySlug(chi.URLParam(r, "slug"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if room.ID == 0 {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	roomPhotos, err := m.roomPhotos(room.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+	data["photos"] = roomPhotos
+	m.roomReviewData(room.ID, data)
+
+	render.Template(w, r, "room.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminRooms lists the rooms for editing
+func (m *Repository) AdminRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	render.Template(w, r, "admin-rooms.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminRoom shows the form to add a room, or edit the room with the id in the url
+func (m *Repository) AdminRoom(w http.ResponseWriter, r *http.Request) {
+	var room models.Room
+	if idParam := chi.URLParam(r, "id"); idParam != "new" {
+		id, err := strconv.Atoi(idParam)
+		if err != nil {
+			helpers.ClientError(w, http.StatusNotFound)
+			return
+		}
+
+		room, err = m.DB.GetRoomByID(id)
+		if err != nil {
+			helpers.ClientError(w, http.StatusNotFound)
+			return
+		}
+	}
+
+	form := forms.New(url.Values{})
+	if room.ID > 0 {
+		form.Set("room_name", room.RoomName)
+		form.Set("slug", room.Slug)
+		form.Set("description", room.Description)
+		form.Set("capacity", strconv.Itoa(room.Capacity))
+		form.Set("amenities", strings.Join(room.Amenities, "\n"))
+		form.Set("display_order", strconv.Itoa(room.DisplayOrder))
+		form.Set("image", room.Image)
+		form.Set("nightly_rate", render.Money(room.NightlyRate))
+	} else {
+		form.Set("capacity", "2")
+		form.Set("display_order", "0")
+		form.Set("nightly_rate", "0.00")
+	}
+
+	m.renderAdminRoom(w, r, room.ID, form)
+}
+
+func (m *Repository) renderAdminRoom(w http.ResponseWriter, r *http.Request, id int, form *forms.Form) {
+	intMap := make(map[string]int)
+	intMap["id"] = id
+	intMap["max_photos"] = maxPhotosPerUpload
+
+	data := make(map[string]interface{})
+	if id > 0 {
+		roomPhotos, err := m.roomPhotos(id)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		data["photos"] = roomPhotos
+	}
+
+	render.Template(w, r, "admin-room.page.tmpl", &models.TemplateData{
+		IntMap: intMap,
+		Data:   data,
+		Form:   form,
+	})
+}
+
+// AdminPostRoom saves a new or edited room
+func (m *Repository) AdminPostRoom(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	var id int
+	if idParam := chi.URLParam(r, "id"); idParam != "new" {
+		id, err = strconv.Atoi(idParam)
+		if err != nil {
+			helpers.ClientError(w, http.StatusNotFound)
+			return
+		}
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("room_name")
+
+	if strings.TrimSpace(form.Get("slug")) == "" {
+		form.Set("slug", slugify(form.Get("room_name")))
+	}
+	slug := strings.TrimSpace(form.Get("slug"))
+	if !validSlug.MatchString(slug) {
+		form.Errors.Add("slug", "Use lowercase letters, numbers and dashes")
+	} else {
+		existing, err := m.DB.GetRoomBySlug(slug)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		if existing.ID > 0 && existing.ID != id {
+			form.Errors.Add("slug", fmt.Sprintf("%s already uses this address", existing.RoomName))
+		}
+	}
+
+	capacity, err := strconv.Atoi(form.Get("capacity"))
+	if err != nil || capacity < 1 {
+		form.Errors.Add("capacity", "Enter how many guests the room sleeps")
+	}
+
+	order, err := strconv.Atoi(form.Get("display_order"))
+	if err != nil {
+		form.Errors.Add("display_order", "Enter a whole number")
+	}
+
+	rate, err := parseMoney(form.Get("nightly_rate"))
+	if err != nil {
+		form.Errors.Add("nightly_rate", "Enter the price of a night, like 120.00")
+	}
+
+	if !form.Valid() {
+		m.renderAdminRoom(w, r, id, form)
+		return
+	}
+
+	room := models.Room{
+		ID:           id,
+		RoomName:     strings.TrimSpace(form.Get("room_name")),
+		Slug:         slug,
+		Description:  strings.TrimSpace(form.Get("description")),
+		Capacity:     capacity,
+		Amenities:    splitLines(form.Get("amenities")),
+		DisplayOrder: order,
+		Image:        strings.TrimSpace(form.Get("image")),
+		NightlyRate:  rate,
+	}
+
+	var before interface{}
+	if id == 0 {
+		room.ID, err = m.DB.InsertRoom(room)
+	} else {
+		if existing, err := m.DB.GetRoomByID(id); err == nil {
+			before = existing
+		}
+		err = m.DB.UpdateRoom(room)
+	}
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't save room")
+		http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+
+	if before == nil {
+		m.audit(r, "create", models.AuditRoom, room.ID, nil, room)
+	} else {
+		m.audit(r, "update", models.AuditRoom, room.ID, before, room)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Room saved")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// parseMoney reads an amount like 120 or 120.50 as cents; a blank amount is 0
+func parseMoney(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if f < 0 {
+		return 0, errors.New("amount can't be negative")
+	}
+	return int(math.Round(f * 100)), nil
+}
+
+// splitLines returns the non-blank lines of s, trimmed
+func splitLines(s string) []string {
+	var lines []string
+	for _, l := range strings.Split(s, "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
+
+// AdminDeleteRoom deletes a room that has never been booked
+func (m *Repository) AdminDeleteRoom(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	count, err := m.DB.CountReservationsForRoom(id)
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "can't delete room")
+		http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+	if count > 0 {
+		// deleting the room would delete its reservations with it
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("This room has %d reservations and can't be deleted", count))
+		http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+
+	roomPhotos, err := m.DB.PhotosForRoom(id)
+	if err != nil {
+		log.Println(err)
+	}
+
+	room, err := m.DB.GetRoomByID(id)
+	if err != nil {
+		log.Println(err)
+	}
+
+	err = m.DB.DeleteRoom(id)
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "can't delete room")
+		http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+
+	m.audit(r, "delete", models.AuditRoom, id, room, nil)
+
+	// the photo rows go with the room, but their files have to be removed
+	for _, p := range roomPhotos {
+		err = photos.Remove(m.Photos, p.Key)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Room deleted")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
@@ -8,6 +8,8 @@ import (
 	"github.com/go-chi/chi/middleware"
 	"github.com/justinas/nosurf"
 	"github.com/DmitryZzz/bookings/internal/config"
+	"github.com/DmitryZzz/bookings/internal/emails"
+	"github.com/DmitryZzz/bookings/internal/helpers"
 	"github.com/DmitryZzz/bookings/internal/models"
 	"github.com/DmitryZzz/bookings/internal/render"
 	"html/template"
@@ -21,12 +23,15 @@ import (
 
 var app config.AppConfig
 var session *scs.SessionManager
+var guestSession *scs.SessionManager
 var pathToTemplates = "./../../templates"
 
 var functions = template.FuncMap{
 	"humanDate":  render.HumanDate,
 	"formatDate": render.FormatDate,
 	"iterate":    render.Iterate,
+	"stars":      render.Stars,
+	"money":      render.Money,
 }
 
 func TestMain(m *testing.M) {
@@ -53,11 +58,22 @@ func TestMain(m *testing.M) {
 
 	app.Session = session
 
-	mailChan := make(chan models.MailData)
-	app.MailChan = mailChan
-	defer close(mailChan)
+	guestSession = scs.New()
+	guestSession.Lifetime = 24 * time.Hour
+	guestSession.Cookie.Name = "guest_session"
+	guestSession.Cookie.Persist = true
+	guestSession.Cookie.SameSite = http.SameSiteLaxMode
+	guestSession.Cookie.Secure = app.InProduction
 
-	listenForMail()
+	app.GuestSession = guestSession
+	app.Secret = "test-secret"
+	app.MailFrom = "me@here.com"
+
+	uploadDir, err := os.MkdirTemp("", "bookings-uploads")
+	if err != nil {
+		log.Fatal("cannot create upload directory")
+	}
+	app.UploadDir = uploadDir
 
 	tc, err := CreateTestTemplateCache()
 	if err != nil {
@@ -65,21 +81,24 @@ func TestMain(m *testing.M) {
 	}
 
 	app.TemplateCache = tc
+
+	emails.TemplateDir = "./../../email-templates"
+	etc, err := emails.CreateTemplateCache()
+	if err != nil {
+		log.Fatal("cannot create email template cache")
+	}
+	app.EmailTemplateCache = etc
 	app.UseCache = true
 
 	repo := NewTestRepo(&app)
 	NewHandlers(repo)
 	render.NewRenderer(&app)
+	emails.NewRenderer(&app, repo.DB)
+	helpers.NewHelpers(&app)
 
-	os.Exit(m.Run())
-}
-
-func listenForMail() {
-	go func() {
-		for {
-			_ = <-app.MailChan
-		}
-	}()
+	code := m.Run()
+	os.RemoveAll(uploadDir)
+	os.Exit(code)
 }
 
 func getRoutes() http.Handler {
@@ -91,8 +110,7 @@ func getRoutes() http.Handler {
 
 	mux.Get("/", Repo.Home)
 	mux.Get("/about", Repo.About)
-	mux.Get("/generals-quarters", Repo.Generals)
-	mux.Get("/majors-suite", Repo.Majors)
+	mux.Get("/rooms", Repo.Rooms)
 
 	mux.Get("/search-availability", Repo.Availability)
 	mux.Post("/search-availability", Repo.PostAvailability)
@@ -100,26 +118,51 @@ func getRoutes() http.Handler {
 
 	mux.Get("/contact", Repo.Contact)
 
-	mux.Get("/make-reservation", Repo.Reservation)
-	mux.Post("/make-reservation", Repo.PostReservation)
 	mux.Get("/reservation-summary", Repo.ReservationSummary)
 
 	mux.Get("/user/login", Repo.ShowLogin)
 	mux.Post("/user/login", Repo.PostShowLogin)
 	mux.Get("/user/logout", Repo.Logout)
 
+	mux.Group(func(mux chi.Router) {
+		mux.Use(GuestSessionLoad)
+		mux.Get("/make-reservation", Repo.Reservation)
+		mux.Post("/make-reservation", Repo.PostReservation)
+		mux.Get("/account/register", Repo.AccountRegister)
+		mux.Get("/account/login", Repo.AccountLogin)
+	})
+
 	mux.Get("/admin/dashboard", Repo.AdminDashboard)
 
 	mux.Get("/admin/reservations-new", Repo.AdminNewReservations)
 	mux.Get("/admin/reservations-all", Repo.AdminAllReservations)
 	mux.Get("/admin/reservations-calendar", Repo.AdminReservationsCalendar)
 	mux.Post("/admin/reservations-calendar", Repo.AdminPostReservationsCalendar)
+	mux.Get("/admin/api/calendar", Repo.AdminCalendarJSON)
+	mux.Get("/admin/timeline", Repo.AdminTimeline)
+	mux.Post("/admin/timeline/move", Repo.AdminPostTimelineMove)
 	mux.Get("/admin/process-reservation/{src}/{id}/do", Repo.AdminProcessReservation)
 	mux.Get("/admin/delete-reservation/{src}/{id}/do", Repo.AdminDeleteReservation)
 
 	mux.Get("/admin/reservations/{src}/{id}/show", Repo.AdminShowReservation)
 	mux.Post("/admin/reservations/{src}/{id}", Repo.AdminPostShowReservation)
 
+	mux.Get("/admin/calendar-feeds", Repo.AdminCalendarFeeds)
+	mux.Get("/admin/ical-feeds", Repo.AdminICalFeeds)
+	mux.Get("/admin/webhooks", Repo.AdminWebhooks)
+	mux.Get("/admin/webhooks/deliveries", Repo.AdminWebhookDeliveries)
+	mux.Get("/admin/mail", Repo.AdminMail)
+	mux.Get("/admin/email-templates", Repo.AdminEmailTemplates)
+	mux.Get("/admin/guest-messages", Repo.AdminGuestMessages)
+	mux.Get("/admin/reviews", Repo.AdminReviews)
+	mux.Get("/admin/rooms", Repo.AdminRooms)
+	mux.Get("/admin/enquiries", Repo.AdminEnquiries)
+	mux.Get("/admin/guests", Repo.AdminGuests)
+	mux.Get("/admin/blocks", Repo.AdminBlocks)
+	mux.Get("/admin/restrictions", Repo.AdminRestrictions)
+	mux.Get("/admin/audit", Repo.AdminAuditLog)
+	mux.Get("/admin/trash", Repo.AdminTrash)
+
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
 
@@ -144,6 +187,13 @@ func SessionLoad(next http.Handler) http.Handler {
 	return session.LoadAndSave(next)
 }
 
+// GuestSessionLoad loads and saves the guest session
+func GuestSessionLoad(next http.Handler) http.Handler {
+	return guestSession.LoadAndSave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(helpers.WithGuestSession(r.Context())))
+	}))
+}
+
 // CreateTestTemplateCache creates a template cache as a map
 func CreateTestTemplateCache() (map[string]*template.Template, error) {
 
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/DmitryZzz/bookings/internal/calendar"
+	"github.com/DmitryZzz/bookings/internal/helpers"
+	"github.com/DmitryZzz/bookings/internal/models"
+	"github.com/DmitryZzz/bookings/internal/render"
+	"github.com/DmitryZzz/bookings/internal/repository"
+	"github.com/DmitryZzz/bookings/internal/webhooks"
+)
+
+// AdminTimeline shows the rooms as rows of bars over ?weeks= weeks from
+// ?start=, starting on this week's Monday by default
+func (m *Repository) AdminTimeline(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	start = start.AddDate(0, 0, -(int(start.Weekday())+6)%7)
+	if d, err := time.Parse(calendar.DateLayout, r.URL.Query().Get("start")); err == nil {
+		start = d
+	}
+
+	weeks, err := strconv.Atoi(r.URL.Query().Get("weeks"))
+	if err != nil {
+		weeks = calendar.DefaultWeeks
+	}
+	if weeks < calendar.MinWeeks {
+		weeks = calendar.MinWeeks
+	}
+	if weeks > calendar.MaxWeeks {
+		weeks = calendar.MaxWeeks
+	}
+	end := start.AddDate(0, 0, weeks*7)
+
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	types, err := m.DB.AllRestrictions()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	restrictions, err := m.DB.GetRestrictionsByDate(start, end)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	timeline := calendar.BuildTimeline(start, weeks, rooms, types, restrictions)
+
+	typesByID := make(map[int]calendar.Type)
+	for _, t := range timeline.Types {
+		typesByID[t.ID] = t
+	}
+
+	var weekOptions []int
+	for i := calendar.MinWeeks; i <= calendar.MaxWeeks; i++ {
+		weekOptions = append(weekOptions, i)
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["previous"] = start.AddDate(0, 0, -weeks*7).Format(calendar.DateLayout)
+	stringMap["next"] = end.Format(calendar.DateLayout)
+
+	intMap := make(map[string]int)
+	intMap["weeks"] = weeks
+
+	data := make(map[string]interface{})
+	data["timeline"] = timeline
+	data["restriction_types_by_id"] = typesByID
+	data["week_options"] = weekOptions
+
+	render.Template(w, r, "admin-timeline.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		IntMap:    intMap,
+		Data:      data,
+	})
+}
+
+// AdminPostTimelineMove moves a reservation dragged on the timeline to the
+// posted room and first night, keeping its number of nights, if the room is
+// free for them and the reservation is still at the posted version. The guest
+// is emailed the new details only when notify_guest is posted
+func (m *Repository) AdminPostTimelineMove(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		writeMoveJSON(w, http.StatusBadRequest, false, "Can't parse form")
+		return
+	}
+
+	id, _ := strconv.Atoi(r.Form.Get("reservation_id"))
+	res, err := m.DB.GetReservationByID(id)
+	if err != nil {
+		log.Println(err)
+	}
+	if res.ID == 0 {
+		writeMoveJSON(w, http.StatusNotFound, false, "Reservation not found")
+		return
+	}
+
+	version, err := strconv.Atoi(r.Form.Get("version"))
+	if err != nil {
+		writeMoveJSON(w, http.StatusBadRequest, false, "Reload the timeline and try again")
+		return
+	}
+
+	roomID, _ := strconv.Atoi(r.Form.Get("room_id"))
+	room, err := m.DB.GetRoomByID(roomID)
+	if err != nil {
+		log.Println(err)
+	}
+	if room.ID == 0 {
+		writeMoveJSON(w, http.StatusBadRequest, false, "Room not found")
+		return
+	}
+
+	start, err := time.Parse(calendar.DateLayout, r.Form.Get("start_date"))
+	if err != nil {
+		writeMoveJSON(w, http.StatusBadRequest, false, "Invalid start date")
+		return
+	}
+
+	moved := res
+	moved.Version = version
+	moved.RoomID = roomID
+	moved.Room = room
+	moved.StartDate = start
+	moved.EndDate = start.Add(res.EndDate.Sub(res.StartDate))
+	moved.Price = room.PriceFor(moved.StartDate, moved.EndDate)
+
+	if moved.RoomID == res.RoomID && moved.StartDate.Equal(res.StartDate) {
+		writeMoveJSON(w, http.StatusOK, true, "Nothing to change")
+		return
+	}
+
+	conflicts, err := m.reservationConflicts(moved)
+	if err != nil {
+		log.Println(err)
+		writeMoveJSON(w, http.StatusInternalServerError, false, "Error querying database")
+		return
+	}
+	unavailable := fmt.Sprintf("%s isn't available from %s to %s",
+		room.RoomName, moved.StartDate.Format(calendar.DateLayout), moved.EndDate.Format(calendar.DateLayout))
+	if len(conflicts) > 0 {
+		writeMoveJSON(w, http.StatusConflict, false, unavailable)
+		return
+	}
+
+	// the nights are checked again while saving, in case they were taken since
+	err = m.DB.UpdateReservation(moved)
+	if err == repository.ErrUnavailable {
+		writeMoveJSON(w, http.StatusConflict, false, unavailable)
+		return
+	}
+	if err == repository.ErrStale {
+		writeMoveJSON(w, http.StatusConflict, false, "Someone else has changed this reservation, reload the timeline and try again")
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		writeMoveJSON(w, http.StatusInternalServerError, false, "Can't move reservation")
+		return
+	}
+
+	m.emitEvent(webhooks.ReservationUpdated, webhooks.NewReservation(moved))
+	m.audit(r, "move", models.AuditReservation, moved.ID, res, moved)
+
+	message := "Reservation moved"
+	if r.Form.Get("notify_guest") != "" && moved.Email != "" {
+		m.sendReservationChanged(moved)
+		message = "Reservation moved and the guest notified"
+	}
+
+	m.App.Session.Put(r.Context(), "flash", message)
+	writeMoveJSON(w, http.StatusOK, true, message)
+}
+
+// reservationConflicts returns the restrictions, other than its own, that take
+// any of a reservation's nights in its room
+func (m *Repository) reservationConflicts(res models.Reservation) ([]models.RoomRestriction, error) {
+	restrictions, err := m.DB.GetRestrictionsForRoomByDate(res.RoomID, res.StartDate, res.EndDate)
+	if err != nil {
+		return nil, err
+	}
+
+	var conflicts []models.RoomRestriction
+	for _, rr := range restrictions {
+		if rr.ReservationID == res.ID || !rr.Restriction.BlocksAvailability {
+			continue
+		}
+		if rr.StartDate.Before(res.EndDate) && res.StartDate.Before(rr.EndDate) {
+			conflicts = append(conflicts, rr)
+		}
+	}
+	return conflicts, nil
+}
+
+func writeMoveJSON(w http.ResponseWriter, status int, ok bool, message string) {
+	resp := jsonResponse{
+		OK:      ok,
+		Message: message,
+	}
+
+	out, _ := json.MarshalIndent(resp, "", "     ")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
+}
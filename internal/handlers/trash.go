@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/DmitryZzz/bookings/internal/helpers"
+	"github.com/DmitryZzz/bookings/internal/models"
+	"github.com/DmitryZzz/bookings/internal/render"
+	"github.com/DmitryZzz/bookings/internal/repository"
+	"github.com/DmitryZzz/bookings/internal/webhooks"
+	"github.com/go-chi/chi/v5"
+)
+
+// trashSince returns the oldest deletion that can still be restored
+func trashSince() time.Time {
+	return time.Now().Add(-repository.TrashRetention)
+}
+
+// AdminTrash shows the reservations, blocks and blocked nights deleted within
+// the retention window
+func (m *Repository) AdminTrash(w http.ResponseWriter, r *http.Request) {
+	since := trashSince()
+
+	reservations, err := m.DB.DeletedReservations(since)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	blocks, err := m.DB.DeletedBlocks(since)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	nights, err := m.DB.DeletedRoomRestrictions(since)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	intMap := make(map[string]int)
+	intMap["retention_days"] = int(repository.TrashRetention.Hours() / 24)
+
+	data := make(map[string]interface{})
+	data["reservations"] = reservations
+	data["blocks"] = blocks
+	data["nights"] = nights
+
+	render.Template(w, r, "admin-trash.page.tmpl", &models.TemplateData{
+		IntMap: intMap,
+		Data:   data,
+	})
+}
+
+// restoreFailed puts the reason a restore didn't happen in the session and
+// sends the user back to the trash
+func (m *Repository) restoreFailed(w http.ResponseWriter, r *http.Request, what string, err error) {
+	switch {
+	case errors.Is(err, repository.ErrUnavailable):
+		m.App.Session.Put(r.Context(), "error", "Can't restore the "+what+", some of its dates have been taken since it was deleted")
+	case errors.Is(err, repository.ErrNotInTrash):
+		m.App.Session.Put(r.Context(), "error", "The "+what+" is no longer in the trash")
+	default:
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "can't restore "+what)
+	}
+	http.Redirect(w, r, "/admin/trash", http.StatusSeeOther)
+}
+
+// AdminRestoreReservation takes a reservation out of the trash, if its room is
+// still free on its dates
+func (m *Repository) AdminRestoreReservation(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	err := m.DB.RestoreReservation(id, trashSince())
+	if err != nil {
+		m.restoreFailed(w, r, "reservation", err)
+		return
+	}
+
+	res, err := m.DB.GetReservationByID(id)
+	if err != nil {
+		log.Println(err)
+	} else {
+		m.emitEvent(webhooks.ReservationRestored, webhooks.NewReservation(res))
+	}
+	m.audit(r, "restore", models.AuditReservation, id, nil, res)
+
+	m.App.Session.Put(r.Context(), "flash", "Reservation restored")
+	http.Redirect(w, r, "/admin/trash", http.StatusSeeOther)
+}
+
+// AdminRestoreBlock takes an owner block out of the trash, if all of its rooms
+// are still free on its nights
+func (m *Repository) AdminRestoreBlock(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	err := m.DB.RestoreBlock(id, trashSince())
+	if err != nil {
+		m.restoreFailed(w, r, "block", err)
+		return
+	}
+
+	b, err := m.DB.GetBlockByID(id)
+	if err != nil {
+		log.Println(err)
+	}
+	for _, restriction := range b.Restrictions {
+		m.emitEvent(webhooks.BlockRestored, webhooks.NewBlock(restriction.ID, restriction.RoomID, restriction.StartDate, restriction.EndDate))
+	}
+	m.audit(r, "restore", models.AuditBlock, id, nil, b)
+
+	m.App.Session.Put(r.Context(), "flash", "Block restored")
+	http.Redirect(w, r, "/admin/trash", http.StatusSeeOther)
+}
+
+// AdminRestoreNight takes a night blocked from the calendar out of the trash,
+// if the room is still free that night
+func (m *Repository) AdminRestoreNight(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	since := trashSince()
+
+	// the night can only be looked up while it's in the trash
+	var night models.RoomRestriction
+	nights, err := m.DB.DeletedRoomRestrictions(since)
+	if err != nil {
+		log.Println(err)
+	}
+	for _, n := range nights {
+		if n.ID == id {
+			night = n
+		}
+	}
+
+	err = m.DB.RestoreRoomRestriction(id, since)
+	if err != nil {
+		m.restoreFailed(w, r, "blocked night", err)
+		return
+	}
+
+	night.Deleted = models.Deletion{}
+	m.emitEvent(webhooks.BlockRestored, webhooks.NewBlock(id, night.RoomID, night.StartDate, night.EndDate))
+	m.audit(r, "restore", models.AuditRoomRestriction, id, nil, night)
+
+	m.App.Session.Put(r.Context(), "flash", "Blocked night restored")
+	http.Redirect(w, r, "/admin/trash", http.StatusSeeOther)
+}
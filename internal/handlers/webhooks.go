@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/DmitryZzz/bookings/internal/forms"
+	"github.com/DmitryZzz/bookings/internal/helpers"
+	"github.com/DmitryZzz/bookings/internal/models"
+	"github.com/DmitryZzz/bookings/internal/render"
+	"github.com/DmitryZzz/bookings/internal/webhooks"
+	"github.com/go-chi/chi/v5"
+)
+
+// deliveryLogSize is how many deliveries the delivery log shows
+const deliveryLogSize = 100
+
+// emitEvent queues a webhook event; failures are logged and never fail the request
+func (m *Repository) emitEvent(event string, data interface{}) {
+	err := webhooks.Enqueue(m.DB, event, data)
+	if err != nil {
+		m.App.ErrorLog.Println("can't queue webhook", event, err)
+	}
+}
+
+// AdminWebhooks lists webhook endpoints
+func (m *Repository) AdminWebhooks(w http.ResponseWriter, r *http.Request) {
+	m.renderWebhooks(w, r, forms.New(nil))
+}
+
+func (m *Repository) renderWebhooks(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	endpoints, err := m.DB.AllWebhookEndpoints()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["endpoints"] = endpoints
+	data["events"] = webhooks.Events
+
+	render.Template(w, r, "admin-webhooks.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// AdminPostWebhook registers a webhook endpoint
+func (m *Repository) AdminPostWebhook(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("url")
+	form.IsURL("url")
+
+	var events []string
+	for _, e := range webhooks.Events {
+		if form.Has("event_" + e) {
+			events = append(events, e)
+		}
+	}
+	if len(events) == 0 {
+		form.Errors.Add("events", "Choose at least one event")
+	}
+
+	if !form.Valid() {
+		m.renderWebhooks(w, r, form)
+		return
+	}
+
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	endpoint := models.WebhookEndpoint{
+		URL:    r.Form.Get("url"),
+		Secret: secret,
+		Events: events,
+		Active: true,
+	}
+	endpoint.ID, err = m.DB.InsertWebhookEndpoint(endpoint)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't save webhook endpoint")
+		http.Redirect(w, r, "/admin/webhooks", http.StatusSeeOther)
+		return
+	}
+
+	// the secret signs deliveries, so it stays out of the audit log
+	endpoint.Secret = ""
+	m.audit(r, "create", models.AuditWebhook, endpoint.ID, nil, endpoint)
+
+	m.App.Session.Put(r.Context(), "flash", "Webhook endpoint added")
+	http.Redirect(w, r, "/admin/webhooks", http.StatusSeeOther)
+}
+
+// AdminDeleteWebhook deletes a webhook endpoint
+func (m *Repository) AdminDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	err := m.DB.DeleteWebhookEndpoint(id)
+	if err != nil {
+		log.Println(err)
+	} else {
+		m.audit(r, "delete", models.AuditWebhook, id, nil, nil)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Webhook endpoint deleted")
+	http.Redirect(w, r, "/admin/webhooks", http.StatusSeeOther)
+}
+
+// AdminWebhookDeliveries shows the webhook delivery log
+func (m *Repository) AdminWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	deliveries, err := m.DB.RecentWebhookDeliveries(deliveryLogSize)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["deliveries"] = deliveries
+
+	render.Template(w, r, "admin-webhook-deliveries.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminRetryWebhookDelivery queues a delivery to be sent again
+func (m *Repository) AdminRetryWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	err := m.DB.RetryWebhookDelivery(id)
+	if err != nil {
+		log.Println(err)
+	} else {
+		m.audit(r, "retry", models.AuditWebhookDelivery, id, nil, nil)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Delivery queued")
+	http.Redirect(w, r, "/admin/webhooks/deliveries", http.StatusSeeOther)
+}
@@ -1,9 +1,16 @@
 package helpers
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"net/http"
 	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/DmitryZzz/bookings/internal/config"
 )
@@ -28,5 +35,71 @@ func ServerError(w http.ResponseWriter, err error) {
 
 func IsAuthenticated(r *http.Request) bool {
 	exists := app.Session.Exists(r.Context(), "user_id")
-	return exists	
-}
\ No newline at end of file
+	return exists
+}
+
+// guestSessionKey marks the requests the guest session is loaded for
+type guestSessionKey struct{}
+
+// WithGuestSession returns ctx marked as having the guest session loaded
+func WithGuestSession(ctx context.Context) context.Context {
+	return context.WithValue(ctx, guestSessionKey{}, true)
+}
+
+// IsGuestRequest reports whether the guest session is loaded for r, which it is
+// on the account pages and the booking form
+func IsGuestRequest(r *http.Request) bool {
+	loaded, _ := r.Context().Value(guestSessionKey{}).(bool)
+	return loaded
+}
+
+// GuestAccountID returns the id of the guest account logged in, which is kept
+// in the guest session apart from any staff login, or 0 when there is none
+func GuestAccountID(r *http.Request) int {
+	if !IsGuestRequest(r) {
+		return 0
+	}
+	return app.GuestSession.GetInt(r.Context(), "guest_account_id")
+}
+
+// IsGuestAuthenticated reports whether a guest is logged in to their account,
+// which is separate from a staff login
+func IsGuestAuthenticated(r *http.Request) bool {
+	return GuestAccountID(r) > 0
+}
+
+// SignedToken returns a token for scope, signed with the application secret
+func SignedToken(scope string) string {
+	mac := hmac.New(sha256.New, []byte(app.Secret))
+	mac.Write([]byte(scope))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ValidToken reports whether token was issued for scope by SignedToken
+func ValidToken(scope, token string) bool {
+	if app.Secret == "" || token == "" {
+		return false
+	}
+	return hmac.Equal([]byte(SignedToken(scope)), []byte(token))
+}
+
+// ExpiringToken returns a token for scope that ValidExpiringToken accepts until
+// expires. The expiry is part of the token and covered by its signature.
+func ExpiringToken(scope string, expires time.Time) string {
+	unix := strconv.FormatInt(expires.Unix(), 10)
+	return unix + "." + SignedToken(scope+"@"+unix)
+}
+
+// ValidExpiringToken reports whether token was issued for scope by
+// ExpiringToken and is still good at now
+func ValidExpiringToken(scope, token string, now time.Time) bool {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	unix, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || !now.Before(time.Unix(unix, 0)) {
+		return false
+	}
+	return ValidToken(scope+"@"+parts[0], parts[1])
+}
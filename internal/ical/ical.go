@@ -0,0 +1,174 @@
+package ical
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	dateLayout     = "20060102"
+	dateTimeLayout = "20060102T150405Z"
+	lineLimit      = 75
+)
+
+// Event is a single VEVENT in a calendar
+type Event struct {
+	UID         string
+	Summary     string
+	Description string
+	Location    string
+	Start       time.Time
+	End         time.Time
+	AllDay      bool
+	Stamp       time.Time
+	Sequence    int
+	Status      string
+	// Organizer and Attendees are email addresses, set on invites sent by mail
+	Organizer     string
+	OrganizerName string
+	Attendees     []string
+}
+
+// Calendar is a VCALENDAR holding a list of events
+type Calendar struct {
+	ProdID string
+	Name   string
+	Method string
+	Events []Event
+}
+
+// New returns an empty calendar with the given display name
+func New(name string) *Calendar {
+	return &Calendar{
+		ProdID: "-//Fort Smythe Bed and Breakfast//Bookings//EN",
+		Name:   name,
+	}
+}
+
+// Add appends an event to the calendar
+func (c *Calendar) Add(e Event) {
+	c.Events = append(c.Events, e)
+}
+
+// Bytes returns the calendar encoded as RFC 5545 text
+func (c *Calendar) Bytes() []byte {
+	var buf bytes.Buffer
+	_ = c.Encode(&buf)
+	return buf.Bytes()
+}
+
+// Encode writes the calendar to w as RFC 5545 text
+func (c *Calendar) Encode(w io.Writer) error {
+	lw := &lineWriter{w: w}
+
+	lw.line("BEGIN:VCALENDAR")
+	lw.line("VERSION:2.0")
+	lw.line("PRODID:" + c.ProdID)
+	lw.line("CALSCALE:GREGORIAN")
+	if c.Method != "" {
+		lw.line("METHOD:" + c.Method)
+	}
+	if c.Name != "" {
+		lw.line("X-WR-CALNAME:" + escapeText(c.Name))
+	}
+
+	for _, e := range c.Events {
+		stamp := e.Stamp
+		if stamp.IsZero() {
+			stamp = time.Now()
+		}
+
+		lw.line("BEGIN:VEVENT")
+		lw.line("UID:" + e.UID)
+		lw.line("DTSTAMP:" + stamp.UTC().Format(dateTimeLayout))
+		if e.AllDay {
+			lw.line("DTSTART;VALUE=DATE:" + e.Start.Format(dateLayout))
+			lw.line("DTEND;VALUE=DATE:" + e.End.Format(dateLayout))
+		} else {
+			lw.line("DTSTART:" + e.Start.UTC().Format(dateTimeLayout))
+			lw.line("DTEND:" + e.End.UTC().Format(dateTimeLayout))
+		}
+		lw.line(fmt.Sprintf("SEQUENCE:%d", e.Sequence))
+		if e.Status != "" {
+			lw.line("STATUS:" + e.Status)
+		}
+		lw.line("SUMMARY:" + escapeText(e.Summary))
+		if e.Description != "" {
+			lw.line("DESCRIPTION:" + escapeText(e.Description))
+		}
+		if e.Location != "" {
+			lw.line("LOCATION:" + escapeText(e.Location))
+		}
+		if e.Organizer != "" {
+			if e.OrganizerName != "" {
+				lw.line(fmt.Sprintf("ORGANIZER;CN=%s:mailto:%s", paramValue(e.OrganizerName), e.Organizer))
+			} else {
+				lw.line("ORGANIZER:mailto:" + e.Organizer)
+			}
+		}
+		for _, a := range e.Attendees {
+			lw.line("ATTENDEE;ROLE=REQ-PARTICIPANT;PARTSTAT=ACCEPTED;RSVP=FALSE:mailto:" + a)
+		}
+		lw.line("TRANSP:OPAQUE")
+		lw.line("END:VEVENT")
+	}
+
+	lw.line("END:VCALENDAR")
+
+	return lw.err
+}
+
+// lineWriter writes content lines terminated by CRLF, folding them at 75 octets
+type lineWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (lw *lineWriter) line(s string) {
+	if lw.err != nil {
+		return
+	}
+
+	var b strings.Builder
+	limit := lineLimit
+	for len(s) > limit {
+		// never split a multi-byte character
+		cut := limit
+		for cut > 0 && !isRuneStart(s[cut]) {
+			cut--
+		}
+		b.WriteString(s[:cut])
+		b.WriteString("\r\n ")
+		s = s[cut:]
+		// continuation lines start with a space, which counts towards the limit
+		limit = lineLimit - 1
+	}
+	b.WriteString(s)
+	b.WriteString("\r\n")
+
+	_, lw.err = io.WriteString(lw.w, b.String())
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+// paramValue quotes a parameter value; quotes can't be escaped, so they are dropped
+func paramValue(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, "") + `"`
+}
+
+// escapeText escapes a TEXT property value
+func escapeText(s string) string {
+	r := strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	)
+	return r.Replace(s)
+}
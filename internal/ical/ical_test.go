@@ -0,0 +1,163 @@
+package ical
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCalendar_Bytes(t *testing.T) {
+	cal := New("Test, Calendar")
+	cal.Add(Event{
+		UID:     "reservation-1@test",
+		Summary: "Reserved; room 1",
+		Start:   time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		End:     time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		AllDay:  true,
+		Stamp:   time.Date(2049, 12, 1, 10, 30, 0, 0, time.UTC),
+	})
+
+	out := string(cal.Bytes())
+
+	expected := []string{
+		"BEGIN:VCALENDAR\r\n",
+		"X-WR-CALNAME:Test\\, Calendar\r\n",
+		"UID:reservation-1@test\r\n",
+		"DTSTAMP:20491201T103000Z\r\n",
+		"DTSTART;VALUE=DATE:20500101\r\n",
+		"DTEND;VALUE=DATE:20500103\r\n",
+		"SUMMARY:Reserved\\; room 1\r\n",
+		"END:VCALENDAR\r\n",
+	}
+	for _, e := range expected {
+		if !strings.Contains(out, e) {
+			t.Errorf("expected calendar to contain %q, got:\n%s", e, out)
+		}
+	}
+}
+
+func TestCalendar_LineFolding(t *testing.T) {
+	cal := New("")
+	cal.Add(Event{
+		UID:         "long@test",
+		Summary:     "x",
+		Description: strings.Repeat("é", 100),
+		Start:       time.Date(2050, 1, 1, 15, 0, 0, 0, time.UTC),
+		End:         time.Date(2050, 1, 2, 11, 0, 0, 0, time.UTC),
+	})
+
+	out := string(cal.Bytes())
+	for _, line := range strings.Split(out, "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line longer than 75 octets: %q", line)
+		}
+	}
+
+	if !strings.Contains(out, "DTSTART:20500101T150000Z") {
+		t.Error("expected timed DTSTART in UTC")
+	}
+}
+
+func TestCalendar_Invite(t *testing.T) {
+	cal := New("")
+	cal.Method = "REQUEST"
+	cal.Add(Event{
+		UID:           "reservation-1@test",
+		Summary:       "Stay",
+		Start:         time.Date(2050, 1, 1, 15, 0, 0, 0, time.UTC),
+		End:           time.Date(2050, 1, 3, 11, 0, 0, 0, time.UTC),
+		Sequence:      2,
+		Organizer:     "me@here.com",
+		OrganizerName: `Fort "Smythe"`,
+		Attendees:     []string{"john@smith.com"},
+	})
+
+	// unfold long lines before looking for properties
+	out := strings.ReplaceAll(string(cal.Bytes()), "\r\n ", "")
+	for _, e := range []string{
+		"METHOD:REQUEST\r\n",
+		"SEQUENCE:2\r\n",
+		"ORGANIZER;CN=\"Fort Smythe\":mailto:me@here.com\r\n",
+		"ATTENDEE;ROLE=REQ-PARTICIPANT;PARTSTAT=ACCEPTED;RSVP=FALSE:mailto:john@smith.com\r\n",
+	} {
+		if !strings.Contains(out, e) {
+			t.Errorf("expected invite to contain %q, got:\n%s", e, out)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	in := "BEGIN:VCALENDAR\r\n" +
+		"VERSION:2.0\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"UID:abc@example.com\r\n" +
+		"DTSTART;VALUE=DATE:20500101\r\n" +
+		"DTEND;VALUE=DATE:20500104\r\n" +
+		"SUMMARY:Reserved\\, thanks\r\n" +
+		"DESCRIPTION:a long description that has been \r\n" +
+		" folded\r\n" +
+		"BEGIN:VALARM\r\n" +
+		"DESCRIPTION:alarm\r\n" +
+		"END:VALARM\r\n" +
+		"END:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"UID:def@example.com\r\n" +
+		"DTSTART;TZID=America/Toronto:20500201T150000\r\n" +
+		"DTEND:20500203T160000Z\r\n" +
+		"STATUS:cancelled\r\n" +
+		"END:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+
+	events, err := Parse(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+
+	e := events[0]
+	if e.UID != "abc@example.com" || !e.AllDay || e.Summary != "Reserved, thanks" {
+		t.Errorf("unexpected first event: %+v", e)
+	}
+	if e.Description != "a long description that has been folded" {
+		t.Errorf("unexpected description, alarm or folding not handled: %q", e.Description)
+	}
+	if !e.End.Equal(time.Date(2050, 1, 4, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected end date %s", e.End)
+	}
+
+	e = events[1]
+	if e.AllDay || e.Status != "CANCELLED" {
+		t.Errorf("unexpected second event: %+v", e)
+	}
+	if e.Start.Location().String() != "America/Toronto" && e.Start.Location() != time.UTC {
+		t.Errorf("unexpected location %s", e.Start.Location())
+	}
+
+	_, err = Parse(strings.NewReader("not a calendar"))
+	if err != ErrNoCalendar {
+		t.Errorf("expected ErrNoCalendar, got %v", err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	cal := New("Round trip")
+	cal.Add(Event{
+		UID:     "rt@test",
+		Summary: "Line one\nline; two",
+		Start:   time.Date(2050, 3, 1, 0, 0, 0, 0, time.UTC),
+		End:     time.Date(2050, 3, 2, 0, 0, 0, 0, time.UTC),
+		AllDay:  true,
+	})
+
+	events, err := Parse(strings.NewReader(string(cal.Bytes())))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 1 || events[0].Summary != "Line one\nline; two" || events[0].UID != "rt@test" {
+		t.Errorf("round trip failed: %+v", events)
+	}
+}
@@ -0,0 +1,204 @@
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNoCalendar is returned when the input has no VCALENDAR component
+var ErrNoCalendar = errors.New("ical: no VCALENDAR found")
+
+// property is one unfolded content line
+type property struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// Parse reads a calendar and returns its events. Components other than VEVENT
+// (time zones, alarms, to-dos) are skipped.
+func Parse(r io.Reader) ([]Event, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	var current *Event
+	var nested int
+	var seenCalendar bool
+
+	for _, line := range lines {
+		p, ok := parseLine(line)
+		if !ok {
+			continue
+		}
+
+		switch p.name {
+		case "BEGIN":
+			switch {
+			case current != nil:
+				nested++
+			case strings.EqualFold(p.value, "VEVENT"):
+				current = &Event{}
+			case strings.EqualFold(p.value, "VCALENDAR"):
+				seenCalendar = true
+			}
+			continue
+		case "END":
+			if current == nil {
+				continue
+			}
+			if nested > 0 {
+				nested--
+				continue
+			}
+			if current.End.IsZero() {
+				current.End = current.Start
+				if current.AllDay {
+					current.End = current.Start.AddDate(0, 0, 1)
+				}
+			}
+			events = append(events, *current)
+			current = nil
+			continue
+		}
+
+		// ignore calendar properties and those of nested components such as VALARM
+		if current == nil || nested > 0 {
+			continue
+		}
+
+		switch p.name {
+		case "UID":
+			current.UID = p.value
+		case "SUMMARY":
+			current.Summary = unescapeText(p.value)
+		case "DESCRIPTION":
+			current.Description = unescapeText(p.value)
+		case "LOCATION":
+			current.Location = unescapeText(p.value)
+		case "STATUS":
+			current.Status = strings.ToUpper(p.value)
+		case "SEQUENCE":
+			current.Sequence, _ = strconv.Atoi(p.value)
+		case "DTSTAMP":
+			current.Stamp, _, _ = parseTime(p)
+		case "DTSTART":
+			t, allDay, err := parseTime(p)
+			if err != nil {
+				return nil, err
+			}
+			current.Start = t
+			current.AllDay = allDay
+		case "DTEND":
+			t, _, err := parseTime(p)
+			if err != nil {
+				return nil, err
+			}
+			current.End = t
+		}
+	}
+
+	if !seenCalendar {
+		return nil, ErrNoCalendar
+	}
+
+	return events, nil
+}
+
+// unfold joins continuation lines onto the line they belong to
+func unfold(r io.Reader) ([]string, error) {
+	var lines []string
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// parseLine splits a content line into name, parameters and value
+func parseLine(line string) (property, bool) {
+	var p property
+
+	// the value starts at the first colon outside a quoted parameter value
+	quoted := false
+	sep := -1
+	for i, c := range line {
+		if c == '"' {
+			quoted = !quoted
+		}
+		if c == ':' && !quoted {
+			sep = i
+			break
+		}
+	}
+	if sep < 0 {
+		return p, false
+	}
+
+	p.value = line[sep+1:]
+	parts := strings.Split(line[:sep], ";")
+	p.name = strings.ToUpper(parts[0])
+	p.params = make(map[string]string)
+	for _, param := range parts[1:] {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) == 2 {
+			p.params[strings.ToUpper(kv[0])] = strings.Trim(kv[1], `"`)
+		}
+	}
+
+	return p, true
+}
+
+// parseTime parses a DATE or DATE-TIME value, reporting whether it was a date
+func parseTime(p property) (time.Time, bool, error) {
+	if p.params["VALUE"] == "DATE" || len(p.value) == len(dateLayout) {
+		t, err := time.Parse(dateLayout, p.value)
+		return t, true, err
+	}
+
+	if strings.HasSuffix(p.value, "Z") {
+		t, err := time.Parse(dateTimeLayout, p.value)
+		return t, false, err
+	}
+
+	loc := time.UTC
+	if tzid := p.params["TZID"]; tzid != "" {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+	t, err := time.ParseInLocation("20060102T150405", p.value, loc)
+	return t, false, err
+}
+
+// unescapeText reverses escapeText
+func unescapeText(s string) string {
+	r := strings.NewReplacer(
+		`\\`, `\`,
+		`\;`, ";",
+		`\,`, ",",
+		`\n`, "\n",
+		`\N`, "\n",
+	)
+	return r.Replace(s)
+}
@@ -0,0 +1,208 @@
+package icalsync
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/DmitryZzz/bookings/internal/audit"
+	"github.com/DmitryZzz/bookings/internal/config"
+	"github.com/DmitryZzz/bookings/internal/ical"
+	"github.com/DmitryZzz/bookings/internal/models"
+	"github.com/DmitryZzz/bookings/internal/repository"
+)
+
+// Status values recorded on a feed after each sync
+const (
+	StatusOK    = "ok"
+	StatusError = "error"
+)
+
+// maxFeedSize limits how much of a remote calendar is read
+const maxFeedSize = 5 << 20
+
+// Syncer imports external calendar feeds into room restrictions
+type Syncer struct {
+	DB       repository.DatabaseRepo
+	Client   *http.Client
+	ErrorLog *log.Logger
+}
+
+// New creates a syncer for the application
+func New(a *config.AppConfig, db repository.DatabaseRepo) *Syncer {
+	s := &Syncer{
+		DB:       db,
+		Client:   &http.Client{Timeout: 30 * time.Second},
+		ErrorLog: a.ErrorLog,
+	}
+	if s.ErrorLog == nil {
+		s.ErrorLog = log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+	}
+	return s
+}
+
+// Start polls all feeds every interval in the background
+func (s *Syncer) Start(interval time.Duration) {
+	go func() {
+		for {
+			s.SyncAll()
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// SyncAll syncs every feed, recording the result of each one
+func (s *Syncer) SyncAll() {
+	feeds, err := s.DB.AllICalFeeds()
+	if err != nil {
+		s.ErrorLog.Println("can't load calendar feeds:", err)
+		return
+	}
+
+	for _, f := range feeds {
+		err := s.SyncFeed(f)
+		if err != nil {
+			s.ErrorLog.Printf("calendar feed %d for room %d: %s", f.ID, f.RoomID, err)
+		}
+	}
+}
+
+// SyncFeed fetches one feed, upserts its events as external restrictions, removes
+// restrictions for events that are no longer in the feed and records the result
+func (s *Syncer) SyncFeed(f models.ICalFeed) error {
+	f.LastSyncedAt = time.Now()
+
+	err := s.importFeed(f)
+	if err != nil {
+		f.LastStatus = StatusError
+		f.LastError = err.Error()
+	} else {
+		f.LastStatus = StatusOK
+		f.LastError = ""
+	}
+
+	conflicts, cerr := s.DB.CountConflictsForFeed(f.ID)
+	if cerr != nil {
+		s.ErrorLog.Println(cerr)
+	} else {
+		f.Conflicts = conflicts
+	}
+
+	if uerr := s.DB.UpdateICalFeedStatus(f); uerr != nil {
+		s.ErrorLog.Println(uerr)
+	}
+
+	return err
+}
+
+func (s *Syncer) importFeed(f models.ICalFeed) error {
+	events, err := s.fetch(f.URL)
+	if err != nil {
+		return err
+	}
+
+	external, err := s.DB.GetRestrictionByCode(models.ExternalRestriction)
+	if err != nil {
+		return err
+	}
+	if external.ID == 0 {
+		return fmt.Errorf("there is no %q restriction type", models.ExternalRestriction)
+	}
+
+	existing, err := s.DB.GetExternalRestrictionsForFeed(f.ID)
+	if err != nil {
+		return err
+	}
+	byUID := make(map[string]models.RoomRestriction)
+	for _, rr := range existing {
+		byUID[rr.ExternalUID] = rr
+	}
+
+	seen := make(map[string]bool)
+	for _, e := range events {
+		if e.UID == "" || e.Status == "CANCELLED" || e.Start.IsZero() {
+			continue
+		}
+
+		start, end := stayDates(e)
+		rr := models.RoomRestriction{
+			StartDate:     start,
+			EndDate:       end,
+			RoomID:        f.RoomID,
+			RestrictionID: external.ID,
+			ICalFeedID:    f.ID,
+			ExternalUID:   e.UID,
+		}
+		rr.ID, err = s.DB.UpsertExternalRestriction(rr)
+		if err != nil {
+			return err
+		}
+		seen[e.UID] = true
+
+		if rr.ID > 0 {
+			if before, ok := byUID[e.UID]; ok {
+				s.audit("update", rr.ID, before, rr)
+			} else {
+				s.audit("import", rr.ID, nil, rr)
+			}
+		}
+	}
+
+	// events that disappeared from the feed have been cancelled upstream
+	for _, rr := range existing {
+		if !seen[rr.ExternalUID] {
+			err := s.DB.DeleteBlockById(rr.ID, 0)
+			if errors.Is(err, repository.ErrNotFound) {
+				// deleted by staff since it was loaded
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			s.audit("delete", rr.ID, rr, nil)
+		}
+	}
+
+	return nil
+}
+
+// audit records a change to an imported restriction; failures are logged
+func (s *Syncer) audit(action string, id int, before, after interface{}) {
+	err := audit.System(s.DB, action, models.AuditRoomRestriction, id, before, after)
+	if err != nil {
+		s.ErrorLog.Println(err)
+	}
+}
+
+func (s *Syncer) fetch(url string) ([]ical.Event, error) {
+	resp, err := s.Client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response %s", resp.Status)
+	}
+
+	return ical.Parse(io.LimitReader(resp.Body, maxFeedSize))
+}
+
+// stayDates converts an event to the nights it occupies, with the end date
+// exclusive like room_restrictions. Timed events end on their checkout day.
+func stayDates(e ical.Event) (time.Time, time.Time) {
+	start := truncateDay(e.Start)
+	end := truncateDay(e.End)
+	if !end.After(start) {
+		end = start.AddDate(0, 0, 1)
+	}
+	return start, end
+}
+
+func truncateDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,213 @@
+package icalsync
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DmitryZzz/bookings/internal/config"
+	"github.com/DmitryZzz/bookings/internal/ical"
+	"github.com/DmitryZzz/bookings/internal/models"
+	"github.com/DmitryZzz/bookings/internal/repository"
+)
+
+// fakeRepo keeps imported restrictions in memory; methods the syncer doesn't use
+// are left to the embedded nil interface
+type fakeRepo struct {
+	repository.DatabaseRepo
+	nextID       int
+	restrictions map[string]models.RoomRestriction
+	status       models.ICalFeed
+	audited      []models.AuditEntry
+}
+
+func newFakeRepo() *fakeRepo {
+	return &fakeRepo{restrictions: make(map[string]models.RoomRestriction)}
+}
+
+func (f *fakeRepo) UpsertExternalRestriction(r models.RoomRestriction) (int, error) {
+	if existing, ok := f.restrictions[r.ExternalUID]; ok {
+		if existing.StartDate.Equal(r.StartDate) && existing.EndDate.Equal(r.EndDate) && existing.RoomID == r.RoomID {
+			return 0, nil
+		}
+		r.ID = existing.ID
+	} else {
+		f.nextID++
+		r.ID = f.nextID
+	}
+	f.restrictions[r.ExternalUID] = r
+	return r.ID, nil
+}
+
+func (f *fakeRepo) GetExternalRestrictionsForFeed(feedID int) ([]models.RoomRestriction, error) {
+	var out []models.RoomRestriction
+	for _, r := range f.restrictions {
+		if r.ICalFeedID == feedID {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeRepo) DeleteBlockById(id, deletedBy int) error {
+	for uid, r := range f.restrictions {
+		if r.ID == id {
+			delete(f.restrictions, uid)
+		}
+	}
+	return nil
+}
+
+func (f *fakeRepo) CountConflictsForFeed(feedID int) (int, error) {
+	return 1, nil
+}
+
+func (f *fakeRepo) GetRestrictionByCode(code string) (models.Restriction, error) {
+	if code == models.ExternalRestriction {
+		return models.Restriction{ID: 3, RestrictionName: "External", Code: code}, nil
+	}
+	return models.Restriction{}, nil
+}
+
+func (f *fakeRepo) InsertAuditEntry(e models.AuditEntry) (int, error) {
+	f.audited = append(f.audited, e)
+	return len(f.audited), nil
+}
+
+func (f *fakeRepo) UpdateICalFeedStatus(feed models.ICalFeed) error {
+	f.status = feed
+	return nil
+}
+
+func calendar(events ...string) string {
+	body := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n"
+	for _, e := range events {
+		body += e
+	}
+	return body + "END:VCALENDAR\r\n"
+}
+
+func event(uid, start, end string) string {
+	return fmt.Sprintf("BEGIN:VEVENT\r\nUID:%s\r\nDTSTART;VALUE=DATE:%s\r\nDTEND;VALUE=DATE:%s\r\nSUMMARY:Reserved\r\nEND:VEVENT\r\n", uid, start, end)
+}
+
+func TestSyncer_SyncFeed(t *testing.T) {
+	body := calendar(
+		event("a@airbnb", "20500101", "20500104"),
+		event("b@airbnb", "20500110", "20500111"),
+	)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/calendar")
+		fmt.Fprint(w, body)
+	}))
+	defer ts.Close()
+
+	repo := newFakeRepo()
+	s := New(&config.AppConfig{}, repo)
+	feed := models.ICalFeed{ID: 7, RoomID: 1, URL: ts.URL}
+
+	if err := s.SyncFeed(feed); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(repo.restrictions) != 2 {
+		t.Fatalf("expected 2 imported restrictions, got %d", len(repo.restrictions))
+	}
+
+	a := repo.restrictions["a@airbnb"]
+	if !a.StartDate.Equal(time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)) || !a.EndDate.Equal(time.Date(2050, 1, 4, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("wrong dates for imported event: %s - %s", a.StartDate, a.EndDate)
+	}
+	if a.RestrictionID != 3 || a.RoomID != 1 || a.ICalFeedID != 7 {
+		t.Errorf("imported restriction has wrong room, feed or type: %+v", a)
+	}
+
+	if repo.status.LastStatus != StatusOK || repo.status.Conflicts != 1 {
+		t.Errorf("expected ok status with 1 conflict, got %+v", repo.status)
+	}
+
+	// syncing again with nothing changed records nothing
+	if err := s.SyncFeed(feed); err != nil {
+		t.Fatal(err)
+	}
+	if len(repo.audited) != 2 || repo.audited[0].Action != "import" || repo.audited[0].Actor != models.ActorSystem {
+		t.Errorf("expected the 2 imports to be audited once, got %+v", repo.audited)
+	}
+
+	// moving one event and dropping the other should update and remove restrictions
+	firstID := a.ID
+	body = calendar(event("a@airbnb", "20500102", "20500105"))
+
+	if err := s.SyncFeed(feed); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(repo.restrictions) != 1 {
+		t.Fatalf("expected removed event to be deleted, got %d restrictions", len(repo.restrictions))
+	}
+
+	a = repo.restrictions["a@airbnb"]
+	if a.ID != firstID {
+		t.Error("expected event to be updated in place, keyed by uid")
+	}
+	if !a.StartDate.Equal(time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected updated start date, got %s", a.StartDate)
+	}
+
+	var actions []string
+	for _, e := range repo.audited[2:] {
+		actions = append(actions, e.Action)
+	}
+	if strings.Join(actions, ",") != "update,delete" {
+		t.Errorf("expected the update and delete to be audited, got %v", actions)
+	}
+}
+
+func TestSyncer_SyncFeedError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "gone", http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	repo := newFakeRepo()
+	repo.restrictions["a@airbnb"] = models.RoomRestriction{ID: 1, ICalFeedID: 7, ExternalUID: "a@airbnb"}
+
+	s := New(&config.AppConfig{}, repo)
+	err := s.SyncFeed(models.ICalFeed{ID: 7, RoomID: 1, URL: ts.URL})
+	if err == nil {
+		t.Fatal("expected error for failing feed")
+	}
+
+	if repo.status.LastStatus != StatusError || repo.status.LastError == "" {
+		t.Errorf("expected error status to be recorded, got %+v", repo.status)
+	}
+
+	if len(repo.restrictions) != 1 {
+		t.Error("restrictions must be kept when the feed can't be fetched")
+	}
+}
+
+func TestStayDates(t *testing.T) {
+	start := time.Date(2050, 1, 1, 15, 0, 0, 0, time.UTC)
+	end := time.Date(2050, 1, 3, 11, 0, 0, 0, time.UTC)
+
+	var tests = []struct {
+		name        string
+		start, end  time.Time
+		expectedEnd time.Time
+	}{
+		{"timed stay", start, end, time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{"same day", start, start, time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, e := range tests {
+		s, en := stayDates(ical.Event{Start: e.start, End: e.end})
+		if !s.Equal(time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)) || !en.Equal(e.expectedEnd) {
+			t.Errorf("%s: got %s - %s", e.name, s, en)
+		}
+	}
+}
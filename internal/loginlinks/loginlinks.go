@@ -0,0 +1,88 @@
+package loginlinks
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/DmitryZzz/bookings/internal/helpers"
+	"github.com/DmitryZzz/bookings/internal/models"
+)
+
+// The kinds of account a link logs in to
+const (
+	KindStaff = "staff"
+	KindGuest = "guest"
+)
+
+// Lifetime is how long a link works for after it's sent
+const Lifetime = 15 * time.Minute
+
+// The reasons Check refuses a link
+var (
+	ErrInvalid      = errors.New("the login link isn't valid")
+	ErrExpired      = errors.New("the login link has expired")
+	ErrUsed         = errors.New("the login link has already been used")
+	ErrOtherBrowser = errors.New("the login link was requested from another browser")
+)
+
+// Scope is what a link's token is signed for, so that it can't be changed to
+// log in to another account or to last longer
+func Scope(l models.LoginLink) string {
+	return fmt.Sprintf("login-link-%d-%s-%d-%d", l.ID, l.Kind, l.AccountID, l.ExpiresAt.Unix())
+}
+
+// Path returns the address of a link on the site, which is under the staff
+// login or the guest account depending on its kind
+func Path(l models.LoginLink) string {
+	if l.Kind == KindGuest {
+		return fmt.Sprintf("/account/login/link/%d", l.ID)
+	}
+	return fmt.Sprintf("/user/login/link/%d", l.ID)
+}
+
+// Link returns the link emailed to log in
+func Link(siteURL string, l models.LoginLink) string {
+	return fmt.Sprintf("%s%s?token=%s", siteURL, Path(l), helpers.SignedToken(Scope(l)))
+}
+
+// NewBrowserKey returns a random key to keep in a browser's session, so that
+// links requested from it can be bound to it
+func NewBrowserKey() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// BrowserHash returns what is stored on a link for a browser key, or "" for no
+// key
+func BrowserHash(key string) string {
+	if key == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Check returns why a link of kind can't log in with token, from a browser
+// holding browserKey at now, or nil when it can
+func Check(l models.LoginLink, kind, token, browserKey string, now time.Time) error {
+	switch {
+	case l.ID == 0 || l.Kind != kind || !helpers.ValidToken(Scope(l), token):
+		return ErrInvalid
+	case !l.UsedAt.IsZero():
+		return ErrUsed
+	case !now.Before(l.ExpiresAt):
+		return ErrExpired
+	case l.Browser != "" && subtle.ConstantTimeCompare([]byte(BrowserHash(browserKey)), []byte(l.Browser)) != 1:
+		return ErrOtherBrowser
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package loginlinks
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DmitryZzz/bookings/internal/config"
+	"github.com/DmitryZzz/bookings/internal/helpers"
+	"github.com/DmitryZzz/bookings/internal/models"
+)
+
+func TestLink(t *testing.T) {
+	helpers.NewHelpers(&config.AppConfig{Secret: "test-secret"})
+
+	expires := time.Date(2050, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := models.LoginLink{ID: 3, Kind: KindGuest, AccountID: 7, ExpiresAt: expires}
+
+	link := Link("https://example.com", l)
+	prefix := "https://example.com/account/login/link/3?token="
+	if !strings.HasPrefix(link, prefix) {
+		t.Fatalf("expected %s to start with %s", link, prefix)
+	}
+	token := link[len(prefix):]
+	if !helpers.ValidToken(Scope(l), token) {
+		t.Errorf("expected %s to carry a valid token", link)
+	}
+
+	// the token must not work once any part of the link is changed
+	for _, other := range []models.LoginLink{
+		{ID: 4, Kind: KindGuest, AccountID: 7, ExpiresAt: expires},
+		{ID: 3, Kind: KindStaff, AccountID: 7, ExpiresAt: expires},
+		{ID: 3, Kind: KindGuest, AccountID: 8, ExpiresAt: expires},
+		{ID: 3, Kind: KindGuest, AccountID: 7, ExpiresAt: expires.Add(time.Hour)},
+	} {
+		if helpers.ValidToken(Scope(other), token) {
+			t.Errorf("the token must not work for %+v", other)
+		}
+	}
+
+	if staff := Link("https://example.com", models.LoginLink{ID: 3, Kind: KindStaff}); !strings.HasPrefix(staff, "https://example.com/user/login/link/3?token=") {
+		t.Errorf("expected a staff link to go to the staff login, but got %s", staff)
+	}
+}
+
+func TestBrowserKey(t *testing.T) {
+	a, err := NewBrowserKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := NewBrowserKey()
+	if len(a) != 32 || a == b {
+		t.Errorf("expected two different 32 character keys, but got %q and %q", a, b)
+	}
+	if BrowserHash(a) == a || BrowserHash(a) != BrowserHash(a) {
+		t.Error("expected the hash of a key to be stable and not the key itself")
+	}
+	if BrowserHash("") != "" {
+		t.Error("expected no hash for no key")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	helpers.NewHelpers(&config.AppConfig{Secret: "test-secret"})
+
+	now := time.Date(2050, 1, 1, 12, 0, 0, 0, time.UTC)
+	valid := models.LoginLink{ID: 1, Kind: KindStaff, AccountID: 1, ExpiresAt: now.Add(Lifetime)}
+
+	used := valid
+	used.UsedAt = now.Add(-time.Minute)
+
+	expired := valid
+	expired.ExpiresAt = now
+
+	bound := valid
+	bound.Browser = BrowserHash("my-browser")
+
+	tests := []struct {
+		name       string
+		link       models.LoginLink
+		kind       string
+		token      string
+		browserKey string
+		expected   error
+	}{
+		{"valid", valid, KindStaff, helpers.SignedToken(Scope(valid)), "", nil},
+		{"missing", models.LoginLink{}, KindStaff, helpers.SignedToken(Scope(models.LoginLink{})), "", ErrInvalid},
+		{"bad-token", valid, KindStaff, "nope", "", ErrInvalid},
+		{"other-kind", valid, KindGuest, helpers.SignedToken(Scope(valid)), "", ErrInvalid},
+		{"used", used, KindStaff, helpers.SignedToken(Scope(used)), "", ErrUsed},
+		{"expired", expired, KindStaff, helpers.SignedToken(Scope(expired)), "", ErrExpired},
+		{"same-browser", bound, KindStaff, helpers.SignedToken(Scope(bound)), "my-browser", nil},
+		{"other-browser", bound, KindStaff, helpers.SignedToken(Scope(bound)), "their-browser", ErrOtherBrowser},
+		{"no-browser-key", bound, KindStaff, helpers.SignedToken(Scope(bound)), "", ErrOtherBrowser},
+	}
+	for _, e := range tests {
+		if err := Check(e.link, e.kind, e.token, e.browserKey, now); err != e.expected {
+			t.Errorf("%s: expected %v, but got %v", e.name, e.expected, err)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package mailer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/DmitryZzz/bookings/internal/models"
+)
+
+var maildirSeq int64
+
+// Maildir writes messages to a maildir, for development. Any mail client that
+// reads maildirs can open them.
+type Maildir struct {
+	Path string
+}
+
+// Send writes a message to tmp and moves it into new, as the maildir format asks
+func (d *Maildir) Send(m models.MailData) error {
+	msg, err := buildMessage(m)
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(d.Path, sub), 0700); err != nil {
+			return err
+		}
+	}
+
+	host, _ := os.Hostname()
+	name := fmt.Sprintf("%d.P%dQ%d.%s", time.Now().UnixNano(), os.Getpid(), atomic.AddInt64(&maildirSeq, 1), host)
+
+	tmp := filepath.Join(d.Path, "tmp", name)
+	if err := ioutil.WriteFile(tmp, msg, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filepath.Join(d.Path, "new", name))
+}
@@ -0,0 +1,95 @@
+package mailer
+
+import (
+	"fmt"
+	"net/mail"
+	"time"
+
+	"github.com/DmitryZzz/bookings/internal/models"
+	simplemail "github.com/xhit/go-simple-mail/v2"
+)
+
+// Mailer delivers a message. Implementations must be safe for concurrent use.
+type Mailer interface {
+	Send(m models.MailData) error
+}
+
+// Backends
+const (
+	BackendSMTP     = "smtp"
+	BackendSendmail = "sendmail"
+	BackendMaildir  = "maildir"
+	BackendMemory   = "memory"
+)
+
+// Config selects and configures a mail backend
+type Config struct {
+	Backend      string
+	Host         string
+	Port         int
+	Username     string
+	Password     string
+	Encryption   string
+	SendmailPath string
+	MaildirPath  string
+}
+
+// NewMailer creates the mailer described by c
+func NewMailer(c Config) (Mailer, error) {
+	switch c.Backend {
+	case BackendSMTP, "":
+		switch c.Encryption {
+		case EncryptionNone, EncryptionSTARTTLS, EncryptionTLS:
+		default:
+			return nil, fmt.Errorf("unknown smtp encryption %q", c.Encryption)
+		}
+		return &SMTP{
+			Host:       c.Host,
+			Port:       c.Port,
+			Username:   c.Username,
+			Password:   c.Password,
+			Encryption: c.Encryption,
+			Timeout:    10 * time.Second,
+		}, nil
+	case BackendSendmail:
+		return &Sendmail{Path: c.SendmailPath}, nil
+	case BackendMaildir:
+		return &Maildir{Path: c.MaildirPath}, nil
+	case BackendMemory:
+		return &Memory{}, nil
+	}
+	return nil, fmt.Errorf("unknown mail backend %q", c.Backend)
+}
+
+// buildMessage formats a message as RFC 5322 text, ready to hand to a transport
+func buildMessage(m models.MailData) ([]byte, error) {
+	email := simplemail.NewMSG()
+	email.SetFrom(m.From).AddTo(m.To).SetSubject(m.Subject)
+	if m.Text == "" {
+		email.SetBody(simplemail.TextHTML, m.Content)
+	} else {
+		email.SetBody(simplemail.TextPlain, m.Text)
+		email.AddAlternative(simplemail.TextHTML, m.Content)
+	}
+	for _, a := range m.Attachments {
+		email.Attach(&simplemail.File{Name: a.Name, MimeType: a.ContentType, Data: a.Data})
+	}
+	if email.Error != nil {
+		return nil, email.Error
+	}
+
+	return []byte(email.GetMessage()), nil
+}
+
+// envelope returns the bare sender and recipient addresses of a message
+func envelope(m models.MailData) (string, string, error) {
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return "", "", fmt.Errorf("bad from address %q: %w", m.From, err)
+	}
+	to, err := mail.ParseAddress(m.To)
+	if err != nil {
+		return "", "", fmt.Errorf("bad to address %q: %w", m.To, err)
+	}
+	return from.Address, to.Address, nil
+}
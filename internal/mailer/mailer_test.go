@@ -0,0 +1,230 @@
+package mailer
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DmitryZzz/bookings/internal/models"
+)
+
+var testMessage = models.MailData{
+	To:      "john@smith.com",
+	From:    "me@here.com",
+	Subject: "Reservation Confirmation",
+	Content: "<strong>Reservation Confirmation</strong>",
+}
+
+// fakeSMTP accepts one session on a local port, answering just enough of the
+// protocol for a plain send, and returns the DATA it received
+func fakeSMTP(t *testing.T, extensions ...string) (int, <-chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := make(chan string, 1)
+	go func() {
+		defer ln.Close()
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+		reply("220 localhost ESMTP")
+		var data strings.Builder
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				out <- data.String()
+				return
+			}
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"):
+				for _, e := range extensions {
+					reply("250-" + e)
+				}
+				reply("250 localhost")
+			case cmd == "DATA":
+				reply("354 go ahead")
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil || l == ".\r\n" {
+						break
+					}
+					data.WriteString(l)
+				}
+				reply("250 queued")
+			case cmd == "QUIT":
+				reply("221 bye")
+				out <- data.String()
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+
+	return ln.Addr().(*net.TCPAddr).Port, out
+}
+
+func TestSMTP_Send(t *testing.T) {
+	port, received := fakeSMTP(t)
+
+	s := &SMTP{Host: "127.0.0.1", Port: port, Encryption: EncryptionNone, Timeout: 5 * time.Second}
+	if err := s.Send(testMessage); err != nil {
+		t.Fatal(err)
+	}
+
+	data := <-received
+	if !strings.Contains(data, "Subject: Reservation Confirmation") || !strings.Contains(data, "Reservation Confirmation</strong>") {
+		t.Errorf("unexpected message:\n%s", data)
+	}
+}
+
+func TestSMTP_RequiresSTARTTLS(t *testing.T) {
+	port, _ := fakeSMTP(t)
+
+	s := &SMTP{Host: "127.0.0.1", Port: port, Encryption: EncryptionSTARTTLS, Timeout: 5 * time.Second}
+	if err := s.Send(testMessage); err != ErrNoSTARTTLS {
+		t.Errorf("expected ErrNoSTARTTLS, got %v", err)
+	}
+}
+
+func TestMaildir_Send(t *testing.T) {
+	dir := t.TempDir()
+
+	d := &Maildir{Path: dir}
+	if err := d.Send(testMessage); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "new", "*"))
+	if len(files) != 1 {
+		t.Fatalf("expected one message in new, got %d", len(files))
+	}
+
+	data, _ := ioutil.ReadFile(files[0])
+	if !strings.Contains(string(data), "john@smith.com") {
+		t.Errorf("unexpected message:\n%s", data)
+	}
+
+	if strings.Contains(string(data), "multipart/alternative") {
+		t.Error("expected a single html part for a message without text")
+	}
+
+	tmp, _ := filepath.Glob(filepath.Join(dir, "tmp", "*"))
+	if len(tmp) != 0 {
+		t.Error("expected tmp to be empty once delivered")
+	}
+}
+
+func TestSendmail_Send(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell")
+	}
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	script := filepath.Join(dir, "sendmail")
+	err := ioutil.WriteFile(script, []byte("#!/bin/sh\necho \"$@\" > "+out+".args\ncat > "+out+"\n"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Sendmail{Path: script}
+	if err := s.Send(testMessage); err != nil {
+		t.Fatal(err)
+	}
+
+	args, _ := ioutil.ReadFile(out + ".args")
+	if strings.TrimSpace(string(args)) != "-i -f me@here.com -- john@smith.com" {
+		t.Errorf("unexpected arguments %q", args)
+	}
+
+	data, _ := ioutil.ReadFile(out)
+	if !strings.Contains(string(data), "Subject: Reservation Confirmation") {
+		t.Errorf("unexpected message:\n%s", data)
+	}
+
+	s = &Sendmail{Path: filepath.Join(dir, "missing")}
+	if err := s.Send(testMessage); err == nil {
+		t.Error("expected error for missing binary")
+	}
+}
+
+func TestBuildMessage_TextAlternative(t *testing.T) {
+	m := testMessage
+	m.Text = "Reservation Confirmation"
+
+	msg, err := buildMessage(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range []string{"multipart/alternative", "text/plain", "text/html"} {
+		if !strings.Contains(string(msg), e) {
+			t.Errorf("expected message to contain %s:\n%s", e, msg)
+		}
+	}
+}
+
+func TestBuildMessage_Attachments(t *testing.T) {
+	m := testMessage
+	m.Attachments = []models.Attachment{
+		{Name: "invite.ics", ContentType: "text/calendar; charset=utf-8; method=REQUEST", Data: []byte("BEGIN:VCALENDAR")},
+	}
+
+	msg, err := buildMessage(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range []string{"multipart/mixed", "text/calendar; charset=utf-8; method=REQUEST", `filename="invite.ics"`} {
+		if !strings.Contains(string(msg), e) {
+			t.Errorf("expected message to contain %s:\n%s", e, msg)
+		}
+	}
+}
+
+func TestNewMailer(t *testing.T) {
+	var tests = []struct {
+		config   Config
+		expected string
+	}{
+		{Config{Backend: BackendSMTP, Encryption: EncryptionSTARTTLS}, "*mailer.SMTP"},
+		{Config{Backend: BackendSendmail}, "*mailer.Sendmail"},
+		{Config{Backend: BackendMaildir}, "*mailer.Maildir"},
+		{Config{Backend: BackendMemory}, "*mailer.Memory"},
+		{Config{Backend: "pigeon"}, ""},
+		{Config{Backend: BackendSMTP, Encryption: "ssl"}, ""},
+	}
+
+	for i, e := range tests {
+		m, err := NewMailer(e.config)
+		if e.expected == "" {
+			if err == nil {
+				t.Errorf("%d: expected error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: unexpected error %s", i, err)
+			continue
+		}
+		if got := fmt.Sprintf("%T", m); got != e.expected {
+			t.Errorf("%d: expected %s, got %s", i, e.expected, got)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package mailer
+
+import (
+	"sync"
+
+	"github.com/DmitryZzz/bookings/internal/models"
+)
+
+// Memory records messages instead of sending them, for tests
+type Memory struct {
+	mu   sync.Mutex
+	sent []models.MailData
+}
+
+// Send records a message
+func (r *Memory) Send(m models.MailData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sent = append(r.sent, m)
+	return nil
+}
+
+// Sent returns the messages recorded so far
+func (r *Memory) Sent() []models.MailData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	out := make([]models.MailData, len(r.sent))
+	copy(out, r.sent)
+	return out
+}
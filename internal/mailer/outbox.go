@@ -0,0 +1,153 @@
+package mailer
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/DmitryZzz/bookings/internal/audit"
+	"github.com/DmitryZzz/bookings/internal/config"
+	"github.com/DmitryZzz/bookings/internal/models"
+	"github.com/DmitryZzz/bookings/internal/repository"
+	"github.com/DmitryZzz/bookings/internal/retry"
+)
+
+// Outbox statuses
+const (
+	StatusPending = "pending"
+	StatusSending = "sending"
+	StatusSent    = "sent"
+	StatusFailed  = "failed"
+)
+
+// Retry is how mail that couldn't be sent is tried again. Guest mail is about
+// stays that are close, so it is given up on within a few hours and shown as
+// failed for staff to follow up, rather than arriving days late.
+var Retry = retry.Policy{MaxAttempts: 6, Base: time.Minute, Max: 2 * time.Hour}
+
+// batchSize is how many due messages are claimed at a time
+const batchSize = 20
+
+// Queue adds a message to the outbox; the pool sends it in the background
+func Queue(db repository.DatabaseRepo, msg models.MailData) error {
+	_, err := db.InsertMail(msg)
+	return err
+}
+
+// Pool is a set of workers sending mail from the outbox
+type Pool struct {
+	DB       repository.DatabaseRepo
+	Mailer   Mailer
+	Workers  int
+	ErrorLog *log.Logger
+}
+
+// New creates a pool of workers that send through m
+func New(a *config.AppConfig, db repository.DatabaseRepo, m Mailer, workers int) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	p := &Pool{
+		DB:       db,
+		Mailer:   m,
+		Workers:  workers,
+		ErrorLog: a.ErrorLog,
+	}
+	if p.ErrorLog == nil {
+		p.ErrorLog = log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+	}
+	return p
+}
+
+// Start sends due mail every interval in the background
+func (p *Pool) Start(interval time.Duration) {
+	go func() {
+		for {
+			p.SendDue()
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// SendDue claims due messages in batches and sends them with the workers,
+// returning once the outbox has nothing more that is due
+func (p *Pool) SendDue() {
+	for {
+		mail, err := p.DB.ClaimDueMail(batchSize)
+		if err != nil {
+			p.ErrorLog.Println("can't load mail outbox:", err)
+			return
+		}
+
+		jobs := make(chan models.OutboxMail)
+		var wg sync.WaitGroup
+		for i := 0; i < p.Workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for msg := range jobs {
+					p.Deliver(msg)
+				}
+			}()
+		}
+		for _, msg := range mail {
+			jobs <- msg
+		}
+		close(jobs)
+		wg.Wait()
+
+		if len(mail) < batchSize {
+			return
+		}
+	}
+}
+
+// Deliver makes one attempt at sending a message and records the outcome,
+// scheduling a retry with backoff or marking it failed once Retry is exhausted
+func (p *Pool) Deliver(msg models.OutboxMail) models.OutboxMail {
+	msg.Attempts++
+
+	err := p.Mailer.Send(msg.Mail)
+	if err == nil {
+		msg.Status = StatusSent
+		msg.LastError = ""
+		msg.SentAt = time.Now()
+	} else {
+		p.ErrorLog.Printf("can't send mail %d to %s: %s", msg.ID, msg.Mail.To, err)
+		msg.LastError = err.Error()
+		if Retry.Exhausted(msg.Attempts) {
+			msg.Status = StatusFailed
+		} else {
+			msg.Status = StatusPending
+			msg.NextAttemptAt = time.Now().Add(Retry.Backoff(msg.Attempts))
+		}
+	}
+
+	if uerr := p.DB.UpdateMail(msg); uerr != nil {
+		p.ErrorLog.Println(uerr)
+		return msg
+	}
+
+	aerr := audit.System(p.DB, "send", models.AuditMail, msg.ID, nil, attempt{
+		To:        msg.Mail.To,
+		Subject:   msg.Mail.Subject,
+		Status:    msg.Status,
+		Attempts:  msg.Attempts,
+		LastError: msg.LastError,
+	})
+	if aerr != nil {
+		p.ErrorLog.Println(aerr)
+	}
+
+	return msg
+}
+
+// attempt is what the audit log keeps of an attempt at sending a message
+type attempt struct {
+	To        string
+	Subject   string
+	Status    string
+	Attempts  int
+	LastError string
+}
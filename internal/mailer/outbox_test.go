@@ -0,0 +1,99 @@
+package mailer
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DmitryZzz/bookings/internal/config"
+	"github.com/DmitryZzz/bookings/internal/models"
+	"github.com/DmitryZzz/bookings/internal/repository"
+)
+
+// fakeRepo keeps the outbox in memory; methods the pool doesn't use are left
+// to the embedded nil interface
+type fakeRepo struct {
+	repository.DatabaseRepo
+	mu      sync.Mutex
+	due     []models.OutboxMail
+	updated map[int]models.OutboxMail
+	audited []models.AuditEntry
+}
+
+func (f *fakeRepo) ClaimDueMail(limit int) ([]models.OutboxMail, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if limit > len(f.due) {
+		limit = len(f.due)
+	}
+	out := f.due[:limit]
+	f.due = f.due[limit:]
+	return out, nil
+}
+
+func (f *fakeRepo) UpdateMail(msg models.OutboxMail) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.updated[msg.ID] = msg
+	return nil
+}
+
+func (f *fakeRepo) InsertAuditEntry(e models.AuditEntry) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.audited = append(f.audited, e)
+	return len(f.audited), nil
+}
+
+type failingMailer struct{}
+
+func (failingMailer) Send(m models.MailData) error {
+	return errors.New("connection refused")
+}
+
+func TestPool_SendDue(t *testing.T) {
+	repo := &fakeRepo{updated: make(map[int]models.OutboxMail)}
+	for i := 1; i <= batchSize+5; i++ {
+		repo.due = append(repo.due, models.OutboxMail{ID: i, Status: StatusSending, Mail: models.MailData{To: "john@smith.com"}})
+	}
+
+	m := &Memory{}
+	p := New(&config.AppConfig{}, repo, m, 3)
+	p.SendDue()
+
+	if len(m.Sent()) != batchSize+5 {
+		t.Errorf("expected %d messages sent, got %d", batchSize+5, len(m.Sent()))
+	}
+	for id, msg := range repo.updated {
+		if msg.Status != StatusSent || msg.Attempts != 1 || msg.SentAt.IsZero() {
+			t.Errorf("message %d not recorded as sent: %+v", id, msg)
+		}
+	}
+}
+
+func TestPool_Deliver(t *testing.T) {
+	repo := &fakeRepo{updated: make(map[int]models.OutboxMail)}
+	p := New(&config.AppConfig{}, repo, failingMailer{}, 1)
+
+	msg := p.Deliver(models.OutboxMail{ID: 1, Status: StatusSending})
+	if msg.Status != StatusPending || msg.LastError != "connection refused" || !msg.NextAttemptAt.After(time.Now()) {
+		t.Errorf("expected retry to be scheduled, got %+v", msg)
+	}
+
+	msg = p.Deliver(models.OutboxMail{ID: 1, Status: StatusSending, Attempts: Retry.MaxAttempts - 1})
+	if msg.Status != StatusFailed {
+		t.Errorf("expected failed after %d attempts, got %s", Retry.MaxAttempts, msg.Status)
+	}
+
+	if repo.updated[1].Status != StatusFailed {
+		t.Error("expected the outcome to be recorded")
+	}
+	if len(repo.audited) != 2 || repo.audited[1].Entity != models.AuditMail || !strings.Contains(repo.audited[1].After, StatusFailed) {
+		t.Errorf("expected both attempts to be audited, got %+v", repo.audited)
+	}
+}
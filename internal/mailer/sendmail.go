@@ -0,0 +1,37 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/DmitryZzz/bookings/internal/models"
+)
+
+// Sendmail hands messages to a sendmail compatible binary
+type Sendmail struct {
+	Path string
+}
+
+// Send pipes a message to sendmail
+func (s *Sendmail) Send(m models.MailData) error {
+	msg, err := buildMessage(m)
+	if err != nil {
+		return err
+	}
+	from, to, err := envelope(m)
+	if err != nil {
+		return err
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(s.Path, "-i", "-f", from, "--", to)
+	cmd.Stdin = bytes.NewReader(msg)
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("sendmail: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package mailer
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+
+	"github.com/DmitryZzz/bookings/internal/models"
+)
+
+// SMTP encryption modes
+const (
+	EncryptionNone     = "none"
+	EncryptionSTARTTLS = "starttls"
+	EncryptionTLS      = "tls"
+)
+
+// ErrNoSTARTTLS is returned when STARTTLS is required but the server doesn't offer it
+var ErrNoSTARTTLS = errors.New("smtp server does not support STARTTLS")
+
+// SMTP sends mail through an SMTP server. A new connection is made per message.
+type SMTP struct {
+	Host       string
+	Port       int
+	Username   string
+	Password   string
+	Encryption string
+	Timeout    time.Duration
+	// TLSConfig overrides the default, which verifies the certificate against Host
+	TLSConfig *tls.Config
+}
+
+// Send delivers a message to the server
+func (s *SMTP) Send(m models.MailData) error {
+	msg, err := buildMessage(m)
+	if err != nil {
+		return err
+	}
+	from, to, err := envelope(m)
+	if err != nil {
+		return err
+	}
+
+	c, err := s.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if s.Username != "" {
+		// PlainAuth refuses to send credentials over an unencrypted
+		// connection to anything but localhost
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("smtp server does not support AUTH")
+		}
+		if err := c.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+func (s *SMTP) dial() (*smtp.Client, error) {
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	dialer := &net.Dialer{Timeout: s.Timeout}
+
+	tlsConfig := s.TLSConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{ServerName: s.Host}
+	}
+
+	var conn net.Conn
+	var err error
+	if s.Encryption == EncryptionTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// bound the whole conversation, not just the connect
+	if s.Timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(s.Timeout))
+	}
+
+	c, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if s.Encryption == EncryptionSTARTTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			c.Close()
+			return nil, ErrNoSTARTTLS
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("starttls: %w", err)
+		}
+	}
+
+	return c, nil
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"fmt"
 	"time"
 )
 
@@ -16,23 +17,72 @@ type User struct {
 	UpdatedAt   time.Time
 }
 
-// Room is the room model
+// Room is the room model. NightlyRate is in cents.
 type Room struct {
+	ID           int
+	RoomName     string
+	Slug         string
+	Description  string
+	Capacity     int
+	Amenities    []string
+	DisplayOrder int
+	Image        string
+	NightlyRate  int
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// PriceFor returns the price in cents of staying in the room from start to end
+func (r Room) PriceFor(start, end time.Time) int {
+	nights := int(end.Sub(start).Hours()/24 + 0.5)
+	if nights < 0 {
+		return 0
+	}
+	return nights * r.NightlyRate
+}
+
+// RoomPhoto is an uploaded photo of a room. Its files are kept in photo
+// storage under Key.
+type RoomPhoto struct {
 	ID        int
-	RoomName  string
+	RoomID    int
+	Key       string
+	Width     int
+	Height    int
+	Position  int
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
-// Restriction is the restriction model
+// Codes of the restriction types the application makes restrictions of itself
+const (
+	ReservationRestriction = "reservation"
+	OwnerRestriction       = "owner"
+	ExternalRestriction    = "external"
+)
+
+// Restriction is the restriction model: a type of room restriction, such as a
+// reservation, an owner block or maintenance. Code is set on the types the
+// application relies on, which can't be deleted.
 type Restriction struct {
-	ID              int
-	RestrictionName string
-	CreatedAt       time.Time
-	UpdatedAt       time.Time
+	ID                 int
+	RestrictionName    string
+	Code               string
+	Color              string
+	BlocksAvailability bool
+	CountsOccupied     bool
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
 }
 
-// Reservation is the reservation model
+// System reports whether the application relies on the restriction type
+func (r Restriction) System() bool {
+	return r.Code != ""
+}
+
+// Reservation is the reservation model. Price is the total for the stay in
+// cents. Version goes up each time the reservation is saved, so that a save
+// based on an older copy can be refused.
 type Reservation struct {
 	ID        int
 	FirstName string
@@ -46,6 +96,25 @@ type Reservation struct {
 	UpdatedAt time.Time
 	Room      Room
 	Processed int
+	Price     int
+	Version   int
+	Deleted   Deletion
+	// Tags are free-form labels staff put on the reservation, like "VIP"
+	Tags []string
+	// GuestID is the guest the reservation was matched to, or 0 when there is none
+	GuestID int
+}
+
+// ReservationNote is an internal note a member of staff wrote on a reservation;
+// guests never see them
+type ReservationNote struct {
+	ID            int
+	ReservationID int
+	UserID        int
+	Body          string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	User          User
 }
 
 // RoomRestriction is the room restriction model
@@ -56,18 +125,357 @@ type RoomRestriction struct {
 	RoomID        int
 	ReservationID int
 	RestrictionID int
+	ICalFeedID    int
+	ExternalUID   string
+	BlockID       int
+	Version       int
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
+	Deleted       Deletion
 	Room          Room
 	Reservation   Reservation
 	Restriction   Restriction
+	Block         Block
+}
+
+// Deletion records when a record was moved to the trash and by whom; At is
+// zero for records that haven't been
+type Deletion struct {
+	At time.Time
+	By User
+}
+
+// PurgedTrash lists the ids of the records deleted for good when the trash was
+// purged. Restrictions purged along with their reservation or block aren't listed.
+type PurgedTrash struct {
+	Reservations     []int
+	Blocks           []int
+	RoomRestrictions []int
+}
+
+// Block is an owner block closing one or more rooms for a range of nights; the
+// room restrictions made for it have its BlockID. A block with Weekdays repeats: only those days of the week in the range are
+// blocked, e.g. every Monday for maintenance.
+type Block struct {
+	ID            int
+	Reason        string
+	RestrictionID int
+	StartDate     time.Time
+	// EndDate is the day after the last night blocked
+	EndDate     time.Time
+	Weekdays    []time.Weekday
+	RoomIDs     []int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Deleted     Deletion
+	Rooms       []Room
+	Restriction Restriction
+	// Restrictions are the nights actually blocked, which leave out nights
+	// that were already booked when the block was saved
+	Restrictions []RoomRestriction
+}
+
+// LastNight is the last night the block covers
+func (b Block) LastNight() time.Time {
+	return b.EndDate.AddDate(0, 0, -1)
+}
+
+// Repeats reports whether the block only covers some days of the week
+func (b Block) Repeats() bool {
+	return len(b.Weekdays) > 0
 }
 
-// MailData holds an email message
+// ICalFeed is an external calendar imported into a room's restrictions
+type ICalFeed struct {
+	ID           int
+	RoomID       int
+	URL          string
+	LastSyncedAt time.Time
+	LastStatus   string
+	LastError    string
+	Conflicts    int
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Room         Room
+}
+
+// WebhookEndpoint is a url that receives reservation lifecycle events
+type WebhookEndpoint struct {
+	ID        int
+	URL       string
+	Secret    string
+	Events    []string
+	Active    bool
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// WebhookDelivery is one event queued for delivery to an endpoint
+type WebhookDelivery struct {
+	ID            int
+	EndpointID    int
+	Event         string
+	Payload       string
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time
+	ResponseCode  int
+	LastError     string
+	DeliveredAt   time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	Endpoint      WebhookEndpoint
+}
+
+// MailData holds an email message. Content is the html part and Text the
+// plain text alternative.
 type MailData struct {
-	To       string
-	From     string
-	Subject  string
-	Content  string
-	Template string
+	To          string
+	From        string
+	Subject     string
+	Content     string
+	Text        string
+	Attachments []Attachment
+}
+
+// Attachment is a file attached to an email
+type Attachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// OutboxMail is an email waiting in, or sent from, the mail outbox
+type OutboxMail struct {
+	ID            int
+	Mail          MailData
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	SentAt        time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// EmailTemplate is an owner's override of the wording of an email
+type EmailTemplate struct {
+	ID        int
+	Name      string
+	Subject   string
+	Body      string
+	Text      string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// MessageSchedule sends a message to every guest a number of days before or
+// after they arrive or leave
+type MessageSchedule struct {
+	ID int
+	// Message is the name of the email template sent
+	Message string
+	// Anchor is "arrival" or "departure"
+	Anchor string
+	// Days is how long after the anchor date the message is sent; negative days are before it
+	Days      int
+	Active    bool
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// When describes when the message is sent, e.g. "3 days before arrival"
+func (s MessageSchedule) When() string {
+	days := s.Days
+	rel := "after"
+	if days < 0 {
+		days, rel = -days, "before"
+	}
+
+	switch days {
+	case 0:
+		return "on the day of " + s.Anchor
+	case 1:
+		return fmt.Sprintf("1 day %s %s", rel, s.Anchor)
+	default:
+		return fmt.Sprintf("%d days %s %s", days, rel, s.Anchor)
+	}
+}
+
+// ScheduledMessage is a scheduled message due to be sent for a reservation
+type ScheduledMessage struct {
+	Schedule    MessageSchedule
+	Reservation Reservation
+}
+
+// ScheduledSend records that a scheduled message was queued for a reservation
+type ScheduledSend struct {
+	ID            int
+	ScheduleID    int
+	ReservationID int
+	MailID        int
+	CreatedAt     time.Time
+	Schedule      MessageSchedule
+	Reservation   Reservation
+}
+
+// Review is a guest's rating and description of their stay
+type Review struct {
+	ID            int
+	ReservationID int
+	RoomID        int
+	GuestName     string
+	Rating        int
+	Body          string
+	Status        string
+	Reply         string
+	RepliedAt     time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	Room          Room
+}
+
+// Enquiry is a message sent through the contact form
+type Enquiry struct {
+	ID       int
+	Name     string
+	Email    string
+	Phone    string
+	Message  string
+	Read     bool
+	Archived bool
+	// ReservationID is the booking the enquiry is about, or 0 when it isn't linked to one
+	ReservationID int
+	RepliedAt     time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// EnquiryReply is an answer to an enquiry, sent by email
+type EnquiryReply struct {
+	ID        int
+	EnquiryID int
+	Body      string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Guest is a person who has booked, matched across their reservations by email
+// address or phone number
+type Guest struct {
+	ID        int
+	FirstName string
+	LastName  string
+	Email     string
+	Phone     string
+	// Notes are staff's notes about the guest, kept across stays
+	Notes     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Stats     GuestStats
+}
+
+// GuestStats sums up a guest's reservations that aren't in the trash. Revenue
+// is in cents and LastStay is the arrival of the latest one.
+type GuestStats struct {
+	Stays    int
+	Nights   int
+	Revenue  int
+	LastStay time.Time
+}
+
+// GuestMatch is two guests that look like the same person, By their "email" or
+// "phone"
+type GuestMatch struct {
+	Guest Guest
+	Other Guest
+	By    string
+}
+
+// GuestAccount is a guest's login to see and manage their own bookings, kept
+// apart from staff users. Its contact details are those of its Guest.
+type GuestAccount struct {
+	ID         int
+	GuestID    int
+	Email      string
+	Password   string
+	VerifiedAt time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Guest      Guest
+}
+
+// Verified reports whether the account's email address has been confirmed
+func (a GuestAccount) Verified() bool {
+	return !a.VerifiedAt.IsZero()
+}
+
+// LoginLink is a one-time link emailed to log in without a password, to a staff
+// user or a guest account depending on Kind. Browser is a hash of the key kept
+// in the session of the browser it was requested from, when it only works there.
+type LoginLink struct {
+	ID        int
+	Kind      string
+	AccountID int
+	Browser   string
+	ExpiresAt time.Time
+	UsedAt    time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// The kinds of record the audit log tracks
+const (
+	AuditReservation     = "reservation"
+	AuditRoom            = "room"
+	AuditRoomPhoto       = "room_photo"
+	AuditRoomRestriction = "room_restriction"
+	AuditBlock           = "block"
+	AuditRestriction     = "restriction"
+	AuditICalFeed        = "ical_feed"
+	AuditCalendarFeed    = "calendar_feed"
+	AuditWebhook         = "webhook"
+	AuditWebhookDelivery = "webhook_delivery"
+	AuditMail            = "mail"
+	AuditEmailTemplate   = "email_template"
+	AuditMessageSchedule = "message_schedule"
+	AuditReview          = "review"
+	AuditEnquiry         = "enquiry"
+	AuditGuest           = "guest"
+	AuditGuestAccount    = "guest_account"
+	AuditUser            = "user"
+)
+
+// The people who make changes, and the system for the background jobs
+const (
+	ActorStaff  = "staff"
+	ActorGuest  = "guest"
+	ActorSystem = "system"
+)
+
+// AuditEntry records a change to a record. Before and After are the record as
+// json on either side of the change, empty when it didn't exist. UserID is the
+// member of staff who made the change, or 0 when it wasn't a logged in user.
+type AuditEntry struct {
+	ID        int
+	UserID    int
+	Actor     string
+	Action    string
+	Entity    string
+	EntityID  int
+	Before    string
+	After     string
+	IP        string
+	CreatedAt time.Time
+	User      User
+}
+
+// AuditFilter narrows down the audit log. Zero fields match everything, and To
+// is the day after the last one wanted.
+type AuditFilter struct {
+	UserID   int
+	Entity   string
+	EntityID int
+	From     time.Time
+	To       time.Time
 }
@@ -0,0 +1,189 @@
+package photos
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+
+	// decoders for the formats in AllowedTypes
+	_ "image/gif"
+	_ "image/png"
+
+	"golang.org/x/image/draw"
+)
+
+// MaxUploadSize is the largest photo file accepted, in bytes
+const MaxUploadSize = 10 << 20
+
+// maxPixels guards against small files that decode into huge images
+const maxPixels = 50_000_000
+
+const jpegQuality = 85
+
+// AllowedTypes are the content types accepted for upload, sniffed from the file
+// itself rather than trusted from the browser
+var AllowedTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// ErrUnsupportedType is returned for uploads that aren't JPEG, PNG or GIF images
+var ErrUnsupportedType = errors.New("photos must be JPEG, PNG or GIF images")
+
+// ErrTooLarge is returned for images with too many pixels to resize
+var ErrTooLarge = errors.New("photo is too large")
+
+// Variant is a resized copy of each photo
+type Variant struct {
+	Name string
+	// MaxSize is the longest side in pixels. Smaller photos are not enlarged.
+	MaxSize int
+}
+
+// Variants are the sizes stored for each photo, largest first
+var Variants = []Variant{
+	{Name: "large", MaxSize: 1600},
+	{Name: "medium", MaxSize: 800},
+	{Name: "thumb", MaxSize: 320},
+}
+
+// Processed is an uploaded photo ready to store
+type Processed struct {
+	// Width and Height are the size of the large variant
+	Width  int
+	Height int
+	// Variants holds the JPEG encoding of each variant, by name
+	Variants map[string][]byte
+}
+
+// Process checks that data is a supported image, turns it the right way up
+// and encodes its variants. The variants are encoded from pixels only, so
+// EXIF data such as the camera's GPS position is not kept.
+func Process(data []byte) (Processed, error) {
+	var p Processed
+
+	if !AllowedTypes[http.DetectContentType(data)] {
+		return p, ErrUnsupportedType
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return p, ErrUnsupportedType
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return p, ErrTooLarge
+	}
+
+	src, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return p, ErrUnsupportedType
+	}
+
+	img := flatten(src)
+	if format == "jpeg" {
+		img = orient(img, exifOrientation(data))
+	}
+
+	p.Variants = make(map[string][]byte)
+	for i, v := range Variants {
+		img = resize(img, v.MaxSize)
+		if i == 0 {
+			p.Width = img.Bounds().Dx()
+			p.Height = img.Bounds().Dy()
+		}
+
+		var buf bytes.Buffer
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+		if err != nil {
+			return p, err
+		}
+		p.Variants[v.Name] = buf.Bytes()
+	}
+
+	return p, nil
+}
+
+// flatten copies img onto a white background, as JPEG has no transparency
+func flatten(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Over)
+	return dst
+}
+
+// resize scales img down so its longest side is at most size
+func resize(img *image.RGBA, size int) *image.RGBA {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	if w <= size && h <= size {
+		return img
+	}
+
+	if w >= h {
+		h = max(1, h*size/w)
+		w = size
+	} else {
+		w = max(1, w*size/h)
+		h = size
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+	return dst
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// NewKey returns a new, unguessable storage key for a photo of a room
+func NewKey(roomID int) (string, error) {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("rooms/%d/%s", roomID, hex.EncodeToString(b)), nil
+}
+
+// FileName is the name a variant of the photo with key is stored under
+func FileName(key, variant string) string {
+	return fmt.Sprintf("%s-%s.jpg", key, variant)
+}
+
+// Save stores every variant of p under key. If one fails, the ones already
+// stored are removed.
+func Save(s Storage, key string, p Processed) error {
+	for i, v := range Variants {
+		err := s.Put(FileName(key, v.Name), p.Variants[v.Name])
+		if err != nil {
+			for _, done := range Variants[:i] {
+				_ = s.Delete(FileName(key, done.Name))
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove deletes every variant of the photo with key
+func Remove(s Storage, key string) error {
+	var firstErr error
+	for _, v := range Variants {
+		err := s.Delete(FileName(key, v.Name))
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,259 @@
+package photos
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testJPEG encodes a w by h JPEG, red on the left half and blue on the right,
+// with an EXIF orientation and GPS tag when orientation is above 0
+func testJPEG(t *testing.T, w, h, orientation int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if x < w/2 {
+				img.Set(x, y, color.RGBA{R: 255, A: 255})
+			} else {
+				img.Set(x, y, color.RGBA{B: 255, A: 255})
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, img, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if orientation == 0 {
+		return buf.Bytes()
+	}
+
+	tiff := []byte{
+		'M', 'M', 0x00, 0x2A, 0x00, 0x00, 0x00, 0x08,
+		0x00, 0x02,
+		// orientation, SHORT, 1 value
+		0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, byte(orientation), 0x00, 0x00,
+		// GPS IFD pointer, LONG, 1 value
+		0x88, 0x25, 0x00, 0x04, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+	}
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	length := len(segment) + 2
+
+	out := []byte{0xFF, 0xD8, 0xFF, 0xE1, byte(length >> 8), byte(length)}
+	out = append(out, segment...)
+	return append(out, buf.Bytes()[2:]...)
+}
+
+func TestExifOrientation(t *testing.T) {
+	for _, o := range []int{1, 3, 6, 8} {
+		if got := exifOrientation(testJPEG(t, 4, 2, o)); got != o {
+			t.Errorf("orientation %d: got %d", o, got)
+		}
+	}
+
+	if got := exifOrientation(testJPEG(t, 4, 2, 0)); got != 1 {
+		t.Errorf("no exif: got %d, wanted 1", got)
+	}
+	if got := exifOrientation([]byte("not a jpeg")); got != 1 {
+		t.Errorf("not a jpeg: got %d, wanted 1", got)
+	}
+}
+
+func TestProcess(t *testing.T) {
+	p, err := Process(testJPEG(t, 2000, 1000, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Width != 1600 || p.Height != 800 {
+		t.Errorf("got %dx%d, wanted 1600x800", p.Width, p.Height)
+	}
+
+	for _, v := range Variants {
+		data, ok := p.Variants[v.Name]
+		if !ok {
+			t.Fatalf("missing %s variant", v.Name)
+		}
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: %s", v.Name, err)
+		}
+		if cfg.Width != v.MaxSize {
+			t.Errorf("%s: width %d, wanted %d", v.Name, cfg.Width, v.MaxSize)
+		}
+	}
+}
+
+func TestProcessDoesNotEnlarge(t *testing.T) {
+	p, err := Process(testJPEG(t, 400, 200, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Width != 400 || p.Height != 200 {
+		t.Errorf("got %dx%d, wanted 400x200", p.Width, p.Height)
+	}
+}
+
+func TestProcessRotatesAndStripsExif(t *testing.T) {
+	// 6 means the camera was turned a quarter turn and the photo goes clockwise
+	p, err := Process(testJPEG(t, 400, 200, 6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Width != 200 || p.Height != 400 {
+		t.Fatalf("got %dx%d, wanted 200x400", p.Width, p.Height)
+	}
+
+	large := p.Variants["large"]
+	if bytes.Contains(large, []byte("Exif")) {
+		t.Error("exif was kept")
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(large))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the red left half ends up on top
+	r, _, b, _ := img.At(100, 50).RGBA()
+	if r < b {
+		t.Error("expected red at the top after rotating")
+	}
+}
+
+func TestProcessPNG(t *testing.T) {
+	// transparent pixels become white
+	img := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := Process(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := jpeg.Decode(bytes.NewReader(p.Variants["thumb"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, g, b, _ := out.At(5, 5).RGBA()
+	if r < 0xF000 || g < 0xF000 || b < 0xF000 {
+		t.Errorf("expected white, got %d %d %d", r, g, b)
+	}
+}
+
+func TestProcessRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"text", []byte("hello, world")},
+		{"html", []byte("<html><body><img src=x onerror=alert(1)></body></html>")},
+		{"truncated jpeg", testJPEG(t, 10, 10, 0)[:20]},
+	}
+
+	for _, tt := range tests {
+		_, err := Process(tt.data)
+		if err != ErrUnsupportedType {
+			t.Errorf("%s: got %v, wanted %v", tt.name, err, ErrUnsupportedType)
+		}
+	}
+}
+
+func TestDisk(t *testing.T) {
+	dir := t.TempDir()
+	d := NewDisk(dir, "/uploads/")
+
+	key := "rooms/1/abc"
+	p, err := Process(testJPEG(t, 10, 10, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Save(d, key, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range Variants {
+		_, err := os.Stat(filepath.Join(dir, "rooms", "1", "abc-"+v.Name+".jpg"))
+		if err != nil {
+			t.Errorf("%s: %s", v.Name, err)
+		}
+	}
+
+	if got := d.URL(FileName(key, "thumb")); got != "/uploads/rooms/1/abc-thumb.jpg" {
+		t.Errorf("got url %s", got)
+	}
+
+	err = Remove(d, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = os.Stat(filepath.Join(dir, "rooms", "1", "abc-thumb.jpg"))
+	if !os.IsNotExist(err) {
+		t.Error("expected the file to be removed")
+	}
+
+	// removing again is fine
+	err = Remove(d, key)
+	if err != nil {
+		t.Error(err)
+	}
+
+	for _, name := range []string{"../escape.jpg", "rooms/../../escape.jpg", "/etc/passwd", ""} {
+		if err := d.Put(name, []byte("x")); err != ErrBadName {
+			t.Errorf("%q: got %v, wanted %v", name, err, ErrBadName)
+		}
+	}
+}
+
+func TestFileServer(t *testing.T) {
+	dir := t.TempDir()
+	d := NewDisk(dir, URLPrefix)
+	err := d.Put("rooms/1/abc-thumb.jpg", []byte("photo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := FileServer(dir)
+	tests := []struct {
+		path string
+		code int
+	}{
+		{"/rooms/1/abc-thumb.jpg", http.StatusOK},
+		{"/rooms/1/missing.jpg", http.StatusNotFound},
+		{"/rooms/1/", http.StatusNotFound},
+		{"/rooms/", http.StatusNotFound},
+		{"/", http.StatusNotFound},
+	}
+	for _, e := range tests {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", e.path, nil))
+		if rr.Code != e.code {
+			t.Errorf("%s: got code %d, wanted %d", e.path, rr.Code, e.code)
+		}
+	}
+}
+
+func TestNewKey(t *testing.T) {
+	a, err := NewKey(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := NewKey(3)
+	if a == b {
+		t.Error("expected different keys")
+	}
+	if len(a) != len("rooms/3/")+16 {
+		t.Errorf("unexpected key %s", a)
+	}
+}
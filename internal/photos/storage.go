@@ -0,0 +1,134 @@
+package photos
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// URLPrefix is where the site serves photos stored on local disk
+const URLPrefix = "/uploads"
+
+// Storage keeps photo files. Local disk is the default; anything that can store
+// a file under a name and give back its address, such as an object store
+// behind a CDN, can be used instead.
+type Storage interface {
+	// Put stores data under name, replacing any file already there
+	Put(name string, data []byte) error
+	// Delete removes the file with name. Deleting a missing file is not an error.
+	Delete(name string) error
+	// URL returns the address the browser loads the file with name from
+	URL(name string) string
+}
+
+// ErrBadName is returned for file names that would escape the storage
+var ErrBadName = errors.New("invalid photo file name")
+
+// Disk stores photos in a directory that the site serves at URLPrefix
+type Disk struct {
+	Dir       string
+	URLPrefix string
+}
+
+// NewDisk returns storage in dir, served at urlPrefix
+func NewDisk(dir, urlPrefix string) *Disk {
+	return &Disk{
+		Dir:       dir,
+		URLPrefix: strings.TrimRight(urlPrefix, "/"),
+	}
+}
+
+func (d *Disk) path(name string) (string, error) {
+	clean := path.Clean("/" + name)
+	if clean == "/" || clean != "/"+name {
+		return "", ErrBadName
+	}
+	return filepath.Join(d.Dir, filepath.FromSlash(clean)), nil
+}
+
+// Put writes data to a temporary file and moves it into place, so a
+// half-written photo is never served
+func (d *Disk) Put(name string, data []byte) error {
+	p, err := d.path(name)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(p), 0755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(tmp.Name(), 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), p)
+}
+
+// Delete removes the file with name
+func (d *Disk) Delete(name string) error {
+	p, err := d.path(name)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// URL returns the address of the file with name under URLPrefix
+func (d *Disk) URL(name string) string {
+	return d.URLPrefix + "/" + name
+}
+
+// FileServer serves the photos stored by Disk in dir. Unlike http.FileServer
+// it doesn't list directories, so the photos of a room can't be browsed.
+func FileServer(dir string) http.Handler {
+	return http.FileServer(filesOnly{http.Dir(dir)})
+}
+
+// filesOnly is a file system that won't open directories
+type filesOnly struct {
+	fs http.FileSystem
+}
+
+func (f filesOnly) Open(name string) (http.File, error) {
+	file, err := f.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		file.Close()
+		return nil, os.ErrNotExist
+	}
+	return file, nil
+}
@@ -8,17 +8,22 @@ import (
 	"log"
 	"net/http"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/DmitryZzz/bookings/internal/config"
+	"github.com/DmitryZzz/bookings/internal/helpers"
 	"github.com/DmitryZzz/bookings/internal/models"
 	"github.com/justinas/nosurf"
 )
 
-var functions = template.FuncMap{
+// Functions are the template functions available to pages and emails
+var Functions = template.FuncMap{
 	"humanDate":  HumanDate,
 	"formatDate": FormatDate,
 	"iterate":    Iterate,
+	"stars":      Stars,
+	"money":      Money,
 }
 
 var app *config.AppConfig
@@ -34,6 +39,22 @@ func Iterate(count int) []int {
 	return items
 }
 
+// Stars returns a rating out of five as filled and empty stars
+func Stars(rating int) string {
+	if rating < 0 {
+		rating = 0
+	}
+	if rating > 5 {
+		rating = 5
+	}
+	return strings.Repeat("★", rating) + strings.Repeat("☆", 5-rating)
+}
+
+// Money returns an amount in cents as a decimal, e.g. 12050 as 120.50
+func Money(cents int) string {
+	return fmt.Sprintf("%d.%02d", cents/100, cents%100)
+}
+
 // NewRenderer sets the config for the template package
 func NewRenderer(a *config.AppConfig) {
 	app = a
@@ -58,6 +79,9 @@ func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateDa
 	if app.Session.Exists(r.Context(), "user_id") {
 		td.IsAuthenticated = 1
 	}
+	if helpers.IsGuestAuthenticated(r) {
+		td.IsGuestAuthenticated = 1
+	}
 	return td
 }
 
@@ -105,7 +129,7 @@ func CreateTemplateCache() (map[string]*template.Template, error) {
 
 	for _, page := range pages {
 		name := filepath.Base(page)
-		ts, err := template.New(name).Funcs(functions).ParseFiles(page)
+		ts, err := template.New(name).Funcs(Functions).ParseFiles(page)
 		if err != nil {
 			return myCache, err
 		}
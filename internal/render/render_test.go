@@ -74,3 +74,32 @@ func TestCreateTemplateCache(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestStars(t *testing.T) {
+	tests := map[int]string{
+		0:  "☆☆☆☆☆",
+		3:  "★★★☆☆",
+		5:  "★★★★★",
+		9:  "★★★★★",
+		-1: "☆☆☆☆☆",
+	}
+	for rating, expected := range tests {
+		if got := Stars(rating); got != expected {
+			t.Errorf("Stars(%d) = %s, expected %s", rating, got, expected)
+		}
+	}
+}
+
+func TestMoney(t *testing.T) {
+	tests := map[int]string{
+		0:     "0.00",
+		5:     "0.05",
+		12050: "120.50",
+		30000: "300.00",
+	}
+	for cents, expected := range tests {
+		if got := Money(cents); got != expected {
+			t.Errorf("Money(%d) = %s, expected %s", cents, got, expected)
+		}
+	}
+}
@@ -2,11 +2,20 @@ package dbrepo
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/DmitryZzz/bookings/internal/blocks"
+	"github.com/DmitryZzz/bookings/internal/guests"
 	"github.com/DmitryZzz/bookings/internal/models"
+	"github.com/DmitryZzz/bookings/internal/repository"
+	"github.com/jackc/pgconn"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -14,17 +23,31 @@ func (m *postgresDBRepo) AllUsers() bool {
 	return true
 }
 
-// InsertReservation inserts a reservation into the database
-func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error) {
+// InsertReservation inserts a reservation into the database, linked to the
+// guest it's for, with the room restriction holding its nights. It returns
+// repository.ErrUnavailable, and saves nothing, when the room is taken for any
+// of its nights.
+func (m *postgresDBRepo) InsertReservation(res models.Reservation, restrictionID int) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	guestID, err := guestFor(ctx, tx, res)
+	if err != nil {
+		return 0, err
+	}
+
 	var newID int
 	stmt := `insert into reservations (first_name, last_name, email, phone, start_date,
-			end_date, room_id, created_at, updated_at)
-			values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+			end_date, room_id, price, guest_id, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) returning id`
 
-	err := m.DB.QueryRowContext(ctx, stmt,
+	err = tx.QueryRowContext(ctx, stmt,
 		res.FirstName,
 		res.LastName,
 		res.Email,
@@ -32,6 +55,8 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 		res.StartDate,
 		res.EndDate,
 		res.RoomID,
+		res.Price,
+		guestID,
 		time.Now(),
 		time.Now(),
 	).Scan(&newID)
@@ -39,19 +64,104 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 		return 0, err
 	}
 
-	return newID, nil
+	err = insertRoomRestriction(ctx, tx, models.RoomRestriction{
+		StartDate:     res.StartDate,
+		EndDate:       res.EndDate,
+		RoomID:        res.RoomID,
+		ReservationID: newID,
+		RestrictionID: restrictionID,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, tx.Commit()
+}
+
+// guestFor returns the id of the guest a reservation is for inside tx, which is
+// its GuestID when set and otherwise matched by email address and then phone
+// number. A guest missing either gets it from
+// the reservation, and a new guest is added when none match.
+func guestFor(ctx context.Context, tx *sql.Tx, res models.Reservation) (int, error) {
+	// booked by a guest logged in to their account
+	if res.GuestID > 0 {
+		return res.GuestID, nil
+	}
+
+	emailKey, phoneKey := guests.EmailKey(res.Email), guests.PhoneKey(res.Phone)
+	now := time.Now()
+
+	var id int
+	err := tx.QueryRowContext(ctx, `
+		select id from guests
+		where ($1 <> '' and email_key = $1) or ($2 <> '' and phone_key = $2)
+		order by email_key = $1 desc, id
+		limit 1`, emailKey, phoneKey).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = tx.QueryRowContext(ctx, `
+			insert into guests (first_name, last_name, email, phone, email_key, phone_key, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`,
+			res.FirstName, res.LastName, res.Email, res.Phone, emailKey, phoneKey, now, now).Scan(&id)
+		return id, err
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		update guests set
+		email = case when email_key = '' then $1 else email end,
+		email_key = case when email_key = '' then $2 else email_key end,
+		phone = case when phone_key = '' then $3 else phone end,
+		phone_key = case when phone_key = '' then $4 else phone_key end,
+		updated_at = $5
+		where id = $6 and ((email_key = '' and $2 <> '') or (phone_key = '' and $4 <> ''))`,
+		res.Email, emailKey, res.Phone, phoneKey, now, id)
+	return id, err
 }
 
-// InsertRoomRestriction inserts a room restriction into the database
+// InsertRoomRestriction inserts a room restriction for a reservation into the
+// database. It returns repository.ErrUnavailable when the room is taken for any
+// of its nights.
 func (m *postgresDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertRoomRestriction(ctx, tx, r)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertRoomRestriction inserts a reservation's room restriction inside tx,
+// after checking, with the room locked, that its nights are free
+func insertRoomRestriction(ctx context.Context, tx *sql.Tx, r models.RoomRestriction) error {
+	err := lockRooms(ctx, tx, r.RoomID)
+	if err != nil {
+		return err
+	}
+
+	var taken int
+	err = tx.QueryRowContext(ctx, takenNights, r.RoomID, r.StartDate, r.EndDate, r.ReservationID).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken > 0 {
+		return repository.ErrUnavailable
+	}
+
 	stmt := `insert into room_restrictions (start_date, end_date, room_id, reservation_id,
 			restriction_id, created_at, updated_at)
 			values ($1, $2, $3, $4, $5, $6, $7)`
 
-	_, err := m.DB.ExecContext(ctx, stmt,
+	_, err = tx.ExecContext(ctx, stmt,
 		r.StartDate,
 		r.EndDate,
 		r.RoomID,
@@ -60,12 +170,38 @@ func (m *postgresDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
 		time.Now(),
 		time.Now(),
 	)
-	if err != nil {
-		return err
+	return err
+}
+
+// roomLock is the first key of the advisory locks taken on rooms, so they
+// can't clash with locks taken for anything else
+const roomLock = 1
+
+// lockRooms holds the rooms until tx ends, so nobody else can take their
+// nights between checking that they are free and saving. Rooms are locked in
+// order of id, so two writers can't deadlock.
+func lockRooms(ctx context.Context, tx *sql.Tx, roomIDs ...int) error {
+	ids := append([]int(nil), roomIDs...)
+	sort.Ints(ids)
+
+	for i, id := range ids {
+		if i > 0 && id == ids[i-1] {
+			continue
+		}
+		_, err := tx.ExecContext(ctx, `select pg_advisory_xact_lock($1, $2)`, roomLock, id)
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+// reservedNights counts the live reservations of a room that share a night
+// with the range from $2 up to $3
+const reservedNights = `
+	select count(*) from room_restrictions
+	where room_id = $1 and reservation_id > 0 and deleted_at is null and start_date < $3 and $2 < end_date`
+
 // SearchAvailabilityByDates returns true if availability exists for roomID, and false if no availability exists
 func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomId int) (bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -75,10 +211,11 @@ func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time,
 		select
 			count(id)
 		from
-			room_restrictions
+			room_restrictions rr
+			join restrictions r on (rr.restriction_id = r.id)
 		where
-			room_id = $1
-			and $2 < end_date and $3 > start_date;`
+			rr.room_id = $1 and r.blocks_availability and rr.deleted_at is null
+			and $2 < rr.end_date and $3 > rr.start_date;`
 
 	var numRows int
 	row := m.DB.QueryRowContext(ctx, query, roomId, start, end)
@@ -106,7 +243,10 @@ func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]
 			rooms r
 		where
 			r.id not in 
-			(select rr.room_id from room_restrictions rr where $1 < rr.end_date and $2 > rr.start_date);`
+			(select rr.room_id from room_restrictions rr
+			join restrictions rs on (rr.restriction_id = rs.id)
+			where rs.blocks_availability and rr.deleted_at is null
+			and $1 < rr.end_date and $2 > rr.start_date);`
 
 	rows, err := m.DB.QueryContext(ctx, query, start, end)
 	if err != nil {
@@ -131,29 +271,335 @@ func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]
 	return rooms, nil
 }
 
+const roomColumns = `
+		id, room_name, slug, description, capacity, amenities, display_order, image,
+		nightly_rate, created_at, updated_at
+`
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanRoom(row rowScanner) (models.Room, error) {
+	var room models.Room
+	var amenities string
+	err := row.Scan(
+		&room.ID,
+		&room.RoomName,
+		&room.Slug,
+		&room.Description,
+		&room.Capacity,
+		&amenities,
+		&room.DisplayOrder,
+		&room.Image,
+		&room.NightlyRate,
+		&room.CreatedAt,
+		&room.UpdatedAt,
+	)
+	if err != nil {
+		return room, err
+	}
+	if amenities != "" {
+		room.Amenities = strings.Split(amenities, "\n")
+	}
+	return room, nil
+}
+
 // GetRoomByID gets a room by id
 func (m *postgresDBRepo) GetRoomByID(id int) (models.Room, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	var room models.Room
+	query := `select` + roomColumns + `from rooms where id = $1`
+
+	return scanRoom(m.DB.QueryRowContext(ctx, query, id))
+}
 
-	query := `select id, room_name, created_at, updated_at from rooms where id = $1`
+// GetRoomBySlug gets a room by the slug in its address; the ID is 0 when there is none
+func (m *postgresDBRepo) GetRoomBySlug(slug string) (models.Room, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
 
-	row := m.DB.QueryRowContext(ctx, query, id)
+	query := `select` + roomColumns + `from rooms where slug = $1`
+
+	room, err := scanRoom(m.DB.QueryRowContext(ctx, query, slug))
+	if err == sql.ErrNoRows {
+		return models.Room{}, nil
+	}
+	return room, err
+}
+
+// InsertRoom adds a room
+func (m *postgresDBRepo) InsertRoom(r models.Room) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into rooms (room_name, slug, description, capacity, amenities, display_order,
+			image, nightly_rate, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $9) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		r.RoomName,
+		r.Slug,
+		r.Description,
+		r.Capacity,
+		strings.Join(r.Amenities, "\n"),
+		r.DisplayOrder,
+		r.Image,
+		r.NightlyRate,
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateRoom updates a room
+func (m *postgresDBRepo) UpdateRoom(r models.Room) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		update rooms set room_name = $1, slug = $2, description = $3, capacity = $4,
+		amenities = $5, display_order = $6, image = $7, nightly_rate = $8, updated_at = $9
+		where id = $10
+	`
+
+	_, err := m.DB.ExecContext(ctx, query,
+		r.RoomName,
+		r.Slug,
+		r.Description,
+		r.Capacity,
+		strings.Join(r.Amenities, "\n"),
+		r.DisplayOrder,
+		r.Image,
+		r.NightlyRate,
+		time.Now(),
+		r.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteRoom deletes a room, with its restrictions
+func (m *postgresDBRepo) DeleteRoom(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from rooms where id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CountReservationsForRoom returns how many reservations a room has, past and future
+func (m *postgresDBRepo) CountReservationsForRoom(id int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+	err := m.DB.QueryRowContext(ctx, `select count(id) from reservations where room_id = $1`, id).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
 
+// scanRoomPhoto reads a row of room_photos columns, in table order
+func scanRoomPhoto(row rowScanner) (models.RoomPhoto, error) {
+	var p models.RoomPhoto
 	err := row.Scan(
-		&room.ID,
-		&room.RoomName,
-		&room.CreatedAt,
-		&room.UpdatedAt,
+		&p.ID,
+		&p.RoomID,
+		&p.Key,
+		&p.Width,
+		&p.Height,
+		&p.Position,
+		&p.CreatedAt,
+		&p.UpdatedAt,
 	)
+	return p, err
+}
 
+// PhotosForRoom returns the photos of a room, in the order they are shown
+func (m *postgresDBRepo) PhotosForRoom(roomID int) ([]models.RoomPhoto, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var photos []models.RoomPhoto
+
+	query := `select id, room_id, key, width, height, position, created_at, updated_at
+			from room_photos where room_id = $1 order by position, id`
+
+	rows, err := m.DB.QueryContext(ctx, query, roomID)
 	if err != nil {
-		return room, err
+		return photos, err
 	}
+	defer rows.Close()
 
-	return room, nil
+	for rows.Next() {
+		p, err := scanRoomPhoto(rows)
+		if err != nil {
+			return photos, err
+		}
+		photos = append(photos, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return photos, err
+	}
+
+	return photos, nil
+}
+
+// CoverPhotos returns the first photo of each room that has photos, by room id
+func (m *postgresDBRepo) CoverPhotos() (map[int]models.RoomPhoto, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	covers := make(map[int]models.RoomPhoto)
+
+	query := `select distinct on (room_id) id, room_id, key, width, height, position, created_at, updated_at
+			from room_photos order by room_id, position, id`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return covers, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanRoomPhoto(rows)
+		if err != nil {
+			return covers, err
+		}
+		covers[p.RoomID] = p
+	}
+
+	if err = rows.Err(); err != nil {
+		return covers, err
+	}
+
+	return covers, nil
+}
+
+// GetRoomPhoto returns a room photo by id, with ID 0 if there is none
+func (m *postgresDBRepo) GetRoomPhoto(id int) (models.RoomPhoto, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select id, room_id, key, width, height, position, created_at, updated_at
+			from room_photos where id = $1`
+
+	p, err := scanRoomPhoto(m.DB.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return models.RoomPhoto{}, nil
+	}
+	if err != nil {
+		return p, err
+	}
+
+	return p, nil
+}
+
+// InsertRoomPhoto adds a photo after the room's other photos and returns its id
+func (m *postgresDBRepo) InsertRoomPhoto(p models.RoomPhoto) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into room_photos (room_id, key, width, height, position, created_at, updated_at)
+			values ($1, $2, $3, $4,
+				(select coalesce(max(position), 0) + 1 from room_photos where room_id = $1),
+				$5, $5)
+			returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		p.RoomID,
+		p.Key,
+		p.Width,
+		p.Height,
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// DeleteRoomPhoto deletes a room photo. Its files are removed by the caller.
+func (m *postgresDBRepo) DeleteRoomPhoto(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from room_photos where id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SetRoomPhotoOrder numbers a room's photos in the order of ids. Ids of other
+// rooms' photos are ignored.
+func (m *postgresDBRepo) SetRoomPhotoOrder(roomID int, ids []int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `update room_photos set position = $1, updated_at = $2 where id = $3 and room_id = $4`
+
+	for i, id := range ids {
+		_, err = tx.ExecContext(ctx, stmt, i+1, time.Now(), id, roomID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetUserByEmail returns the user with an email address, ignoring case, with
+// ID 0 if there is none
+func (m *postgresDBRepo) GetUserByEmail(email string) (models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select id, first_name, last_name, email, access_level, created_at, updated_at
+		from users where lower(email) = lower($1)`
+
+	var u models.User
+	err := m.DB.QueryRowContext(ctx, query, strings.TrimSpace(email)).Scan(
+		&u.ID,
+		&u.FirstName,
+		&u.LastName,
+		&u.Email,
+		&u.AccessLevel,
+		&u.CreatedAt,
+		&u.UpdatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.User{}, nil
+	}
+	return u, err
 }
 
 // GetUserByID returns a user by id
@@ -232,8 +678,14 @@ func (m *postgresDBRepo) Authenticate(email, testPassword string) (int, string,
 	return id, hashedPassword, nil
 }
 
-// AllReservations returns a slice of all reservations
-func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
+// tagFilter matches the reservations r tagged with the first argument, ignoring
+// case, or all of them when it's empty
+const tagFilter = `($1 = '' or exists (select 1 from reservation_tags t
+	where t.reservation_id = r.id and lower(t.tag) = lower($1)))`
+
+// AllReservations returns a slice of all reservations, only those tagged tag
+// unless it's empty
+func (m *postgresDBRepo) AllReservations(tag string) ([]models.Reservation, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
@@ -242,12 +694,13 @@ func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
 	query := `
 		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
 		r.end_date, r.room_id, r.created_at, r.updated_at, r.processed,
-		rm.id, rm.room_name
+		rm.id, rm.room_name, coalesce((select string_agg(t.tag, ',' order by t.tag) from reservation_tags t where t.reservation_id = r.id), '')
 		from reservations r
 		left join rooms rm on (r.room_id = rm.id)
+		where r.deleted_at is null and ` + tagFilter + `
 		order by r.start_date asc`
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.DB.QueryContext(ctx, query, tag)
 	if err != nil {
 		return reservations, err
 	}
@@ -256,6 +709,7 @@ func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
 
 	for rows.Next() {
 		var i models.Reservation
+		var tags string
 
 		err := rows.Scan(
 			&i.ID,
@@ -271,10 +725,14 @@ func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
 			&i.Processed,
 			&i.Room.ID,
 			&i.Room.RoomName,
+			&tags,
 		)
 		if err != nil {
 			return reservations, err
 		}
+		if tags != "" {
+			i.Tags = strings.Split(tags, ",")
+		}
 
 		reservations = append(reservations, i)
 	}
@@ -286,8 +744,9 @@ func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
 	return reservations, nil
 }
 
-// AllNewRweservations returns a slice of new reservations
-func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
+// AllNewRweservations returns a slice of new reservations, only those tagged
+// tag unless it's empty
+func (m *postgresDBRepo) AllNewReservations(tag string) ([]models.Reservation, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
@@ -296,13 +755,13 @@ func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
 	query := `
 		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
 		r.end_date, r.room_id, r.created_at, r.updated_at,
-		rm.id, rm.room_name
+		rm.id, rm.room_name, coalesce((select string_agg(t.tag, ',' order by t.tag) from reservation_tags t where t.reservation_id = r.id), '')
 		from reservations r
 		left join rooms rm on (r.room_id = rm.id)
-		where r.processed = 0
+		where r.processed = 0 and r.deleted_at is null and ` + tagFilter + `
 		order by r.start_date asc`
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.DB.QueryContext(ctx, query, tag)
 	if err != nil {
 		return reservations, err
 	}
@@ -311,6 +770,7 @@ func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
 
 	for rows.Next() {
 		var i models.Reservation
+		var tags string
 
 		err := rows.Scan(
 			&i.ID,
@@ -325,10 +785,14 @@ func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
 			&i.UpdatedAt,
 			&i.Room.ID,
 			&i.Room.RoomName,
+			&tags,
 		)
 		if err != nil {
 			return reservations, err
 		}
+		if tags != "" {
+			i.Tags = strings.Split(tags, ",")
+		}
 
 		reservations = append(reservations, i)
 	}
@@ -349,12 +813,13 @@ func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error)
 
 	query := `
 		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
-		r.end_date, r.room_id, r.created_at, r.updated_at, r.processed,
-		rm.id, rm.room_name
+		r.end_date, r.room_id, r.created_at, r.updated_at, r.processed, r.price, r.version,
+		coalesce(r.guest_id, 0), rm.id, rm.room_name, coalesce((select string_agg(t.tag, ',' order by t.tag) from reservation_tags t where t.reservation_id = r.id), '')
 		from reservations r
 		left join rooms rm on (r.room_id = rm.id)
-		where r.id = $1`
+		where r.id = $1 and r.deleted_at is null`
 
+	var tags string
 	row := m.DB.QueryRowContext(ctx, query, id)
 	err := row.Scan(
 		&res.ID,
@@ -368,56 +833,442 @@ func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error)
 		&res.CreatedAt,
 		&res.UpdatedAt,
 		&res.Processed,
+		&res.Price,
+		&res.Version,
+		&res.GuestID,
 		&res.Room.ID,
 		&res.Room.RoomName,
+		&tags,
 	)
 	if err != nil {
 		return res, err
 	}
+	if tags != "" {
+		res.Tags = strings.Split(tags, ",")
+	}
 
 	return res, nil
 }
 
-// UpdateReservation updates a reservation in the database
-func (m *postgresDBRepo) UpdateReservation(r models.Reservation) error {
+// SetReservationTags replaces the tags of a reservation
+func (m *postgresDBRepo) SetReservationTags(id int, tags []string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `
-		update reservations set first_name = $1, last_name = $2, email = $3, phone = $4, updated_at = $5
-		where id = $6
-	`
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	_, err := m.DB.ExecContext(ctx, query,
+	_, err = tx.ExecContext(ctx, `delete from reservation_tags where reservation_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, tag := range tags {
+		_, err = tx.ExecContext(ctx, `insert into reservation_tags (reservation_id, tag, created_at, updated_at)
+			values ($1, $2, $3, $4)`, id, tag, now, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ReservationTags returns every tag in use on a reservation, in order
+func (m *postgresDBRepo) ReservationTags() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var tags []string
+
+	query := `
+		select distinct t.tag
+		from reservation_tags t
+		join reservations r on (t.reservation_id = r.id)
+		where r.deleted_at is null
+		order by t.tag`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// ReservationNotes returns the notes on a reservation with their authors,
+// oldest first
+func (m *postgresDBRepo) ReservationNotes(reservationID int) ([]models.ReservationNote, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var notes []models.ReservationNote
+
+	query := `
+		select n.id, n.reservation_id, n.user_id, n.body, n.created_at, n.updated_at,
+		coalesce(u.first_name, ''), coalesce(u.last_name, '')
+		from reservation_notes n
+		left join users u on (n.user_id = u.id)
+		where n.reservation_id = $1
+		order by n.created_at, n.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, reservationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var n models.ReservationNote
+		err := rows.Scan(
+			&n.ID,
+			&n.ReservationID,
+			&n.UserID,
+			&n.Body,
+			&n.CreatedAt,
+			&n.UpdatedAt,
+			&n.User.FirstName,
+			&n.User.LastName,
+		)
+		if err != nil {
+			return nil, err
+		}
+		n.User.ID = n.UserID
+
+		notes = append(notes, n)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return notes, nil
+}
+
+// InsertReservationNote adds a note to a reservation and returns its id
+func (m *postgresDBRepo) InsertReservationNote(n models.ReservationNote) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into reservation_notes (reservation_id, user_id, body, created_at, updated_at)
+		values ($1, $2, $3, $4, $5) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt, n.ReservationID, n.UserID, n.Body, time.Now(), time.Now()).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// DeleteReservationNote deletes a note from a reservation
+func (m *postgresDBRepo) DeleteReservationNote(id, reservationID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from reservation_notes where id = $1 and reservation_id = $2`, id, reservationID)
+	return err
+}
+
+// takenNights counts the live restrictions, other than those of reservation $4,
+// that make a room unavailable for any night from $2 up to $3
+const takenNights = `
+	select count(*) from room_restrictions rr
+	join restrictions rs on (rr.restriction_id = rs.id)
+	where rr.room_id = $1 and rr.deleted_at is null and rs.blocks_availability
+	and rr.start_date < $3 and $2 < rr.end_date and coalesce(rr.reservation_id, 0) <> $4`
+
+// UpdateReservation updates a reservation in the database, moving the room
+// restriction that holds its nights along with its room and dates. It returns
+// repository.ErrStale when the reservation was saved since r was read, and
+// repository.ErrUnavailable when its new room is taken for any of its nights.
+func (m *postgresDBRepo) UpdateReservation(r models.Reservation) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockRooms(ctx, tx, r.RoomID)
+	if err != nil {
+		return err
+	}
+
+	// only a new room or dates are checked, so the details of a reservation
+	// that was let overlap something can still be edited
+	var current models.Reservation
+	err = tx.QueryRowContext(ctx, `select room_id, start_date, end_date from reservations where id = $1`, r.ID).
+		Scan(&current.RoomID, &current.StartDate, &current.EndDate)
+	if err == sql.ErrNoRows {
+		return repository.ErrStale
+	}
+	if err != nil {
+		return err
+	}
+	if current.RoomID != r.RoomID || !current.StartDate.Equal(r.StartDate) || !current.EndDate.Equal(r.EndDate) {
+		var taken int
+		err = tx.QueryRowContext(ctx, takenNights, r.RoomID, r.StartDate, r.EndDate, r.ID).Scan(&taken)
+		if err != nil {
+			return err
+		}
+		if taken > 0 {
+			return repository.ErrUnavailable
+		}
+	}
+
+	now := time.Now()
+
+	query := `
+		update reservations set first_name = $1, last_name = $2, email = $3, phone = $4,
+		room_id = $5, start_date = $6, end_date = $7, price = $8, updated_at = $9,
+		version = version + 1
+		where id = $10 and version = $11 and deleted_at is null
+	`
+
+	result, err := tx.ExecContext(ctx, query,
 		r.FirstName,
 		r.LastName,
 		r.Email,
 		r.Phone,
-		time.Now(),
+		r.RoomID,
+		r.StartDate,
+		r.EndDate,
+		r.Price,
+		now,
 		r.ID,
+		r.Version,
 	)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return repository.ErrStale
+	}
 
+	stmt := `update room_restrictions set room_id = $1, start_date = $2, end_date = $3, updated_at = $4,
+		version = version + 1
+		where reservation_id = $5`
+	_, err = tx.ExecContext(ctx, stmt, r.RoomID, r.StartDate, r.EndDate, now, r.ID)
 	if err != nil {
 		return err
 	}
 
+	return tx.Commit()
+}
+
+// deletedOne returns repository.ErrNotFound when the update moving a record to
+// the trash found nothing to move
+func deletedOne(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return repository.ErrNotFound
+	}
 	return nil
 }
 
-// DeleteReservation deletes one reservation by id
-func (m *postgresDBRepo) DeleteReservation(id int) error {
+// DeleteReservation moves a reservation and the restriction holding its nights
+// to the trash, freeing the nights. It returns repository.ErrNotFound when the
+// reservation doesn't exist or is in the trash already.
+func (m *postgresDBRepo) DeleteReservation(id, deletedBy int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `delete from reservations where id = $1`
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	_, err := m.DB.ExecContext(ctx, query, id)
+	now := time.Now()
 
+	query := `update reservations set deleted_at = $1, deleted_by = $2 where id = $3 and deleted_at is null`
+	result, err := tx.ExecContext(ctx, query, now, deletedBy, id)
+	if err != nil {
+		return err
+	}
+	err = deletedOne(result)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	stmt := `update room_restrictions set deleted_at = $1, deleted_by = $2 where reservation_id = $3 and deleted_at is null`
+	_, err = tx.ExecContext(ctx, stmt, now, deletedBy, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeletedReservations returns the reservations moved to the trash since a
+// time, most recently deleted first
+func (m *postgresDBRepo) DeletedReservations(since time.Time) ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var reservations []models.Reservation
+
+	query := `
+		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
+		r.end_date, r.room_id, r.price, r.deleted_at, r.deleted_by,
+		coalesce(u.first_name, ''), coalesce(u.last_name, ''),
+		rm.id, rm.room_name
+		from reservations r
+		left join rooms rm on (r.room_id = rm.id)
+		left join users u on (r.deleted_by = u.id)
+		where r.deleted_at >= $1
+		order by r.deleted_at desc, r.id desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, since)
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var i models.Reservation
+		err := rows.Scan(
+			&i.ID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.StartDate,
+			&i.EndDate,
+			&i.RoomID,
+			&i.Price,
+			&i.Deleted.At,
+			&i.Deleted.By.ID,
+			&i.Deleted.By.FirstName,
+			&i.Deleted.By.LastName,
+			&i.Room.ID,
+			&i.Room.RoomName,
+		)
+		if err != nil {
+			return reservations, err
+		}
+		reservations = append(reservations, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+
+	return reservations, nil
+}
+
+// restoreConflicts counts the live restrictions that take the nights of the
+// deleted restrictions matched by where, which refers to them as mine
+const restoreConflicts = `
+	select count(*)
+	from room_restrictions mine
+	join room_restrictions rr on (rr.room_id = mine.room_id and rr.id <> mine.id
+		and rr.start_date < mine.end_date and mine.start_date < rr.end_date)
+	join restrictions rs on (rr.restriction_id = rs.id)
+	where rr.deleted_at is null and rs.blocks_availability and mine.deleted_at is not null and `
+
+// restore takes the restrictions matched by where out of the trash inside tx,
+// after checking, with their rooms locked, that their nights are still free
+func restore(ctx context.Context, tx *sql.Tx, where string, id int) error {
+	rows, err := tx.QueryContext(ctx, `select distinct mine.room_id from room_restrictions mine
+		where mine.deleted_at is not null and `+where, id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var roomIDs []int
+	for rows.Next() {
+		var roomID int
+		err := rows.Scan(&roomID)
+		if err != nil {
+			return err
+		}
+		roomIDs = append(roomIDs, roomID)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	err = lockRooms(ctx, tx, roomIDs...)
+	if err != nil {
+		return err
+	}
+
+	var conflicts int
+	err = tx.QueryRowContext(ctx, restoreConflicts+where, id).Scan(&conflicts)
+	if err != nil {
+		return err
+	}
+	if conflicts > 0 {
+		return repository.ErrUnavailable
+	}
+
+	_, err = tx.ExecContext(ctx, `update room_restrictions as mine set deleted_at = null, deleted_by = 0
+		where mine.deleted_at is not null and `+where, id)
+	return err
+}
+
+// RestoreReservation takes a reservation deleted since a time out of the trash,
+// with the restriction holding its nights. It returns repository.ErrUnavailable,
+// and leaves it in the trash, if any of the nights have been taken since.
+func (m *postgresDBRepo) RestoreReservation(id int, since time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `update reservations set deleted_at = null, deleted_by = 0, updated_at = $1
+		where id = $2 and deleted_at >= $3`, time.Now(), id, since)
+	if err != nil {
+		return err
+	}
+	restored, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if restored == 0 {
+		return repository.ErrNotInTrash
+	}
+
+	err = restore(ctx, tx, `mine.reservation_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 // UpdateProcessedForReservation updates processed for a reservation by id
@@ -438,16 +1289,14 @@ func (m *postgresDBRepo) UpdateProcessedForReservation(id, processed int) error
 	return nil
 }
 
-// AllRooms returns all rooms
+// AllRooms returns all rooms in display order
 func (m *postgresDBRepo) AllRooms() ([]models.Room, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	var rooms []models.Room
 
-	query := `
-		select id, room_name, created_at, updated_at from rooms order by room_name
-		`
+	query := `select` + roomColumns + `from rooms order by display_order, room_name`
 
 	rows, err := m.DB.QueryContext(ctx, query)
 	if err != nil {
@@ -456,15 +1305,7 @@ func (m *postgresDBRepo) AllRooms() ([]models.Room, error) {
 	defer rows.Close()
 
 	for rows.Next() {
-		var rm models.Room
-
-		err := rows.Scan(
-			&rm.ID,
-			&rm.RoomName,
-			&rm.CreatedAt,
-			&rm.UpdatedAt,
-		)
-
+		rm, err := scanRoom(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -479,20 +1320,23 @@ func (m *postgresDBRepo) AllRooms() ([]models.Room, error) {
 	return rooms, nil
 }
 
-// GetRestrictionsForRoomByDay returns restrictions for a room by date range
-func (m *postgresDBRepo) GetRestrictionsForRoomByDate(roomId int, start, end time.Time) ([]models.RoomRestriction, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+// roomRestrictionQuery selects room restrictions with their guest's name, their
+// block's reason and their restriction type
+const roomRestrictionQuery = `
+	select rr.id, coalesce(rr.reservation_id, 0), rr.restriction_id, rr.room_id, rr.start_date, rr.end_date,
+	rr.updated_at, rr.version, coalesce(rr.block_id, 0), coalesce(b.reason, ''), coalesce(rr.ical_feed_id, 0),
+	rs.restriction_name, rs.code, rs.color, rs.blocks_availability, rs.counts_occupied,
+	coalesce(res.first_name, ''), coalesce(res.last_name, ''), coalesce(res.version, 0), coalesce((select string_agg(t.tag, ',' order by t.tag) from reservation_tags t where t.reservation_id = rr.reservation_id), '')
+	from room_restrictions rr
+	left join reservations res on (rr.reservation_id = res.id)
+	left join blocks b on (rr.block_id = b.id)
+	left join restrictions rs on (rr.restriction_id = rs.id)`
 
+// queryRoomRestrictions runs roomRestrictionQuery with a where clause
+func (m *postgresDBRepo) queryRoomRestrictions(ctx context.Context, where string, args ...interface{}) ([]models.RoomRestriction, error) {
 	var restrictions []models.RoomRestriction
 
-	query := `
-		select id, coalesce(reservation_id, 0), restriction_id, room_id, start_date, end_date
-		from room_restrictions where $1 < end_date and $2 >= start_date
-		and room_id = $3
-	`
-
-	rows, err := m.DB.QueryContext(ctx, query, start, end, roomId)
+	rows, err := m.DB.QueryContext(ctx, roomRestrictionQuery+" "+where, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -500,6 +1344,7 @@ func (m *postgresDBRepo) GetRestrictionsForRoomByDate(roomId int, start, end tim
 
 	for rows.Next() {
 		var r models.RoomRestriction
+		var tags string
 		err := rows.Scan(
 			&r.ID,
 			&r.ReservationID,
@@ -507,10 +1352,30 @@ func (m *postgresDBRepo) GetRestrictionsForRoomByDate(roomId int, start, end tim
 			&r.RoomID,
 			&r.StartDate,
 			&r.EndDate,
+			&r.UpdatedAt,
+			&r.Version,
+			&r.BlockID,
+			&r.Block.Reason,
+			&r.ICalFeedID,
+			&r.Restriction.RestrictionName,
+			&r.Restriction.Code,
+			&r.Restriction.Color,
+			&r.Restriction.BlocksAvailability,
+			&r.Restriction.CountsOccupied,
+			&r.Reservation.FirstName,
+			&r.Reservation.LastName,
+			&r.Reservation.Version,
+			&tags,
 		)
 		if err != nil {
 			return nil, err
 		}
+		if tags != "" {
+			r.Reservation.Tags = strings.Split(tags, ",")
+		}
+		r.Block.ID = r.BlockID
+		r.Restriction.ID = r.RestrictionID
+		r.Reservation.ID = r.ReservationID
 
 		restrictions = append(restrictions, r)
 	}
@@ -521,35 +1386,2832 @@ func (m *postgresDBRepo) GetRestrictionsForRoomByDate(roomId int, start, end tim
 	return restrictions, nil
 }
 
-// InsertBlockForRoom inserts a room restriction
-func (m *postgresDBRepo) InsertBlockForRoom(id int, startDate time.Time) error {
+// GetRestrictionsForRoomByDay returns restrictions for a room by date range
+func (m *postgresDBRepo) GetRestrictionsForRoomByDate(roomId int, start, end time.Time) ([]models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.queryRoomRestrictions(ctx, `where rr.deleted_at is null and $1 < rr.end_date and $2 >= rr.start_date
+		and rr.room_id = $3`,
+		start, end, roomId)
+}
+
+// GetRestrictionsByDate returns the restrictions of every room that take any of
+// the nights from start up to end, in one query
+func (m *postgresDBRepo) GetRestrictionsByDate(start, end time.Time) ([]models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.queryRoomRestrictions(ctx, `where rr.deleted_at is null and $1 < rr.end_date and $2 > rr.start_date
+		order by rr.room_id, rr.start_date`, start, end)
+}
+
+// GetRoomRestrictionByID returns a room restriction that isn't in the trash, with
+// ID 0 if there is none
+func (m *postgresDBRepo) GetRoomRestrictionByID(id int) (models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	restrictions, err := m.queryRoomRestrictions(ctx, `where rr.deleted_at is null and rr.id = $1`, id)
+	if err != nil || len(restrictions) == 0 {
+		return models.RoomRestriction{}, err
+	}
+	return restrictions[0], nil
+}
+
+// InsertBlockForRoom inserts a one night room restriction of a type and returns its id
+func (m *postgresDBRepo) InsertBlockForRoom(id, restrictionID int, startDate time.Time) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	var newID int
 	query := `insert into room_restrictions (start_date, end_date, room_id, restriction_id,
-				created_at, updated_at) values ($1, $2, $3, $4, $5, $6)`
+				created_at, updated_at) values ($1, $2, $3, $4, $5, $6) returning id`
 
-	_, err := m.DB.ExecContext(ctx, query, startDate, startDate.AddDate(0, 0, 1), id, 2, time.Now(), time.Now())
+	err := m.DB.QueryRowContext(ctx, query, startDate, startDate.AddDate(0, 0, 1), id, restrictionID, time.Now(), time.Now()).Scan(&newID)
 	if err != nil {
 		log.Println(err)
-		return err
+		return 0, err
 	}
 
-	return nil
+	return newID, nil
 }
 
-// DeleteBlockById deletes a room restriction
-func (m *postgresDBRepo) DeleteBlockById(id int) error {
+// DeleteBlockById moves a room restriction to the trash. It returns
+// repository.ErrNotFound when the restriction doesn't exist or is in the trash
+// already.
+func (m *postgresDBRepo) DeleteBlockById(id, deletedBy int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `delete from room_restrictions where id = $1`
+	query := `update room_restrictions set deleted_at = $1, deleted_by = $2 where id = $3 and deleted_at is null`
 
-	_, err := m.DB.ExecContext(ctx, query, id)
+	result, err := m.DB.ExecContext(ctx, query, time.Now(), deletedBy, id)
 	if err != nil {
 		log.Println(err)
 		return err
 	}
 
-	return nil
+	return deletedOne(result)
+}
+
+// DeletedRoomRestrictions returns the nights blocked from the calendar, rather
+// than by a reservation, feed or block, that were moved to the trash since a
+// time, most recently deleted first
+func (m *postgresDBRepo) DeletedRoomRestrictions(since time.Time) ([]models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var restrictions []models.RoomRestriction
+
+	query := `
+		select rr.id, rr.room_id, rr.restriction_id, rr.start_date, rr.end_date, rr.deleted_at, rr.deleted_by,
+		coalesce(u.first_name, ''), coalesce(u.last_name, ''),
+		coalesce(rm.room_name, ''), coalesce(rs.restriction_name, '')
+		from room_restrictions rr
+		left join rooms rm on (rr.room_id = rm.id)
+		left join restrictions rs on (rr.restriction_id = rs.id)
+		left join users u on (rr.deleted_by = u.id)
+		where rr.deleted_at >= $1 and rr.reservation_id is null and rr.block_id is null
+			and rr.ical_feed_id is null
+		order by rr.deleted_at desc, rr.id desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, since)
+	if err != nil {
+		return restrictions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.RoomRestriction
+		err := rows.Scan(
+			&r.ID,
+			&r.RoomID,
+			&r.RestrictionID,
+			&r.StartDate,
+			&r.EndDate,
+			&r.Deleted.At,
+			&r.Deleted.By.ID,
+			&r.Deleted.By.FirstName,
+			&r.Deleted.By.LastName,
+			&r.Room.RoomName,
+			&r.Restriction.RestrictionName,
+		)
+		if err != nil {
+			return restrictions, err
+		}
+		r.Room.ID = r.RoomID
+		r.Restriction.ID = r.RestrictionID
+		restrictions = append(restrictions, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return restrictions, err
+	}
+
+	return restrictions, nil
+}
+
+// RestoreRoomRestriction takes a night blocked from the calendar, deleted since
+// a time, out of the trash. It returns repository.ErrUnavailable, and leaves it
+// in the trash, if the night has been taken since.
+func (m *postgresDBRepo) RestoreRoomRestriction(id int, since time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var found int
+	query := `select count(id) from room_restrictions
+		where id = $1 and deleted_at >= $2 and reservation_id is null and block_id is null`
+	err = tx.QueryRowContext(ctx, query, id, since).Scan(&found)
+	if err != nil {
+		return err
+	}
+	if found == 0 {
+		return repository.ErrNotInTrash
+	}
+
+	err = restore(ctx, tx, `mine.id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// AllBlocks returns the owner blocks with their rooms, latest first
+func (m *postgresDBRepo) AllBlocks() ([]models.Block, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var all []models.Block
+
+	query := blockQuery + ` where b.deleted_at is null order by b.start_date desc, b.id desc`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return all, err
+	}
+	defer rows.Close()
+
+	index := make(map[int]int)
+	for rows.Next() {
+		b, err := scanBlock(rows)
+		if err != nil {
+			return all, err
+		}
+		index[b.ID] = len(all)
+		all = append(all, b)
+	}
+	if err = rows.Err(); err != nil {
+		return all, err
+	}
+
+	query = `select br.block_id, r.id, r.room_name
+		from block_rooms br
+		left join rooms r on (br.room_id = r.id)
+		order by r.display_order, r.room_name`
+
+	roomRows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return all, err
+	}
+	defer roomRows.Close()
+
+	for roomRows.Next() {
+		var blockID int
+		var room models.Room
+		err := roomRows.Scan(&blockID, &room.ID, &room.RoomName)
+		if err != nil {
+			return all, err
+		}
+		if i, ok := index[blockID]; ok {
+			all[i].RoomIDs = append(all[i].RoomIDs, room.ID)
+			all[i].Rooms = append(all[i].Rooms, room)
+		}
+	}
+
+	if err = roomRows.Err(); err != nil {
+		return all, err
+	}
+
+	return all, nil
+}
+
+const blockQuery = `
+	select b.id, b.reason, coalesce(b.restriction_id, 0), b.start_date, b.end_date, b.weekdays,
+	b.created_at, b.updated_at, coalesce(rs.restriction_name, ''), coalesce(rs.color, '')
+	from blocks b
+	left join restrictions rs on (b.restriction_id = rs.id)`
+
+// scanBlock reads a row selected with blockQuery
+func scanBlock(row rowScanner) (models.Block, error) {
+	var b models.Block
+	var weekdays string
+	err := row.Scan(
+		&b.ID,
+		&b.Reason,
+		&b.RestrictionID,
+		&b.StartDate,
+		&b.EndDate,
+		&weekdays,
+		&b.CreatedAt,
+		&b.UpdatedAt,
+		&b.Restriction.RestrictionName,
+		&b.Restriction.Color,
+	)
+	b.Restriction.ID = b.RestrictionID
+	b.Weekdays = blocks.ParseWeekdays(weekdays)
+	return b, err
+}
+
+// GetBlockByID returns an owner block with its rooms and the nights it blocks,
+// with ID 0 if there is none
+func (m *postgresDBRepo) GetBlockByID(id int) (models.Block, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := blockQuery + ` where b.id = $1 and b.deleted_at is null`
+
+	b, err := scanBlock(m.DB.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return models.Block{}, nil
+	}
+	if err != nil {
+		return b, err
+	}
+
+	query = `select r.id, r.room_name
+		from block_rooms br
+		left join rooms r on (br.room_id = r.id)
+		where br.block_id = $1
+		order by r.display_order, r.room_name`
+
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return b, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var room models.Room
+		err := rows.Scan(&room.ID, &room.RoomName)
+		if err != nil {
+			return b, err
+		}
+		b.RoomIDs = append(b.RoomIDs, room.ID)
+		b.Rooms = append(b.Rooms, room)
+	}
+	if err = rows.Err(); err != nil {
+		return b, err
+	}
+
+	query = `select id, room_id, start_date, end_date
+		from room_restrictions where block_id = $1 and deleted_at is null
+		order by start_date, room_id`
+
+	restrictionRows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return b, err
+	}
+	defer restrictionRows.Close()
+
+	for restrictionRows.Next() {
+		r := models.RoomRestriction{BlockID: id, RestrictionID: b.RestrictionID}
+		err := restrictionRows.Scan(&r.ID, &r.RoomID, &r.StartDate, &r.EndDate)
+		if err != nil {
+			return b, err
+		}
+		b.Restrictions = append(b.Restrictions, r)
+	}
+
+	if err = restrictionRows.Err(); err != nil {
+		return b, err
+	}
+
+	return b, nil
+}
+
+// SaveBlock adds an owner block, or replaces one when b.ID is set, along with
+// the room restrictions that block its nights. It returns the block's id and
+// the ids of the restrictions, or repository.ErrUnavailable, saving nothing, if
+// a reservation has taken any of the nights since they were planned.
+func (m *postgresDBRepo) SaveBlock(b models.Block, restrictions []models.RoomRestriction) (int, []int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	// the nights were checked before the block was planned; check again with
+	// the rooms locked, in case they were booked since
+	err = lockRooms(ctx, tx, b.RoomIDs...)
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, r := range restrictions {
+		var reserved int
+		err = tx.QueryRowContext(ctx, reservedNights, r.RoomID, r.StartDate, r.EndDate).Scan(&reserved)
+		if err != nil {
+			return 0, nil, err
+		}
+		if reserved > 0 {
+			return 0, nil, repository.ErrUnavailable
+		}
+	}
+
+	now := time.Now()
+	id := b.ID
+
+	if id == 0 {
+		stmt := `insert into blocks (reason, restriction_id, start_date, end_date, weekdays, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $6) returning id`
+		err = tx.QueryRowContext(ctx, stmt, b.Reason, b.RestrictionID, b.StartDate, b.EndDate,
+			blocks.FormatWeekdays(b.Weekdays), now).Scan(&id)
+	} else {
+		stmt := `update blocks set reason = $1, restriction_id = $2, start_date = $3, end_date = $4, weekdays = $5,
+			updated_at = $6 where id = $7`
+		_, err = tx.ExecContext(ctx, stmt, b.Reason, b.RestrictionID, b.StartDate, b.EndDate,
+			blocks.FormatWeekdays(b.Weekdays), now, id)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from block_rooms where block_id = $1`, id)
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, roomID := range b.RoomIDs {
+		stmt := `insert into block_rooms (block_id, room_id, created_at, updated_at) values ($1, $2, $3, $3)`
+		_, err = tx.ExecContext(ctx, stmt, id, roomID, now)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from room_restrictions where block_id = $1`, id)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var ids []int
+	for _, r := range restrictions {
+		var restrictionID int
+		stmt := `insert into room_restrictions (start_date, end_date, room_id, restriction_id, block_id,
+			created_at, updated_at) values ($1, $2, $3, $4, $5, $6, $6) returning id`
+		err = tx.QueryRowContext(ctx, stmt, r.StartDate, r.EndDate, r.RoomID, r.RestrictionID, id, now).Scan(&restrictionID)
+		if err != nil {
+			return 0, nil, err
+		}
+		ids = append(ids, restrictionID)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, nil, err
+	}
+	return id, ids, nil
+}
+
+// DeleteBlock moves an owner block and the room restrictions made for it to
+// the trash. It returns repository.ErrNotFound when the block doesn't exist or
+// is in the trash already.
+func (m *postgresDBRepo) DeleteBlock(id, deletedBy int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+
+	result, err := tx.ExecContext(ctx, `update blocks set deleted_at = $1, deleted_by = $2 where id = $3 and deleted_at is null`,
+		now, deletedBy, id)
+	if err != nil {
+		return err
+	}
+	err = deletedOne(result)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `update room_restrictions set deleted_at = $1, deleted_by = $2
+		where block_id = $3 and deleted_at is null`, now, deletedBy, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeletedBlocks returns the owner blocks moved to the trash since a time, most
+// recently deleted first, with the names of their rooms
+func (m *postgresDBRepo) DeletedBlocks(since time.Time) ([]models.Block, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var deleted []models.Block
+
+	query := `
+		select b.id, b.reason, b.start_date, b.end_date, b.deleted_at, b.deleted_by,
+		coalesce(u.first_name, ''), coalesce(u.last_name, ''), coalesce(rs.restriction_name, ''),
+		coalesce((select string_agg(r.room_name, ', ' order by r.display_order, r.room_name)
+			from block_rooms br join rooms r on (br.room_id = r.id) where br.block_id = b.id), '')
+		from blocks b
+		left join restrictions rs on (b.restriction_id = rs.id)
+		left join users u on (b.deleted_by = u.id)
+		where b.deleted_at >= $1
+		order by b.deleted_at desc, b.id desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, since)
+	if err != nil {
+		return deleted, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var b models.Block
+		var rooms string
+		err := rows.Scan(
+			&b.ID,
+			&b.Reason,
+			&b.StartDate,
+			&b.EndDate,
+			&b.Deleted.At,
+			&b.Deleted.By.ID,
+			&b.Deleted.By.FirstName,
+			&b.Deleted.By.LastName,
+			&b.Restriction.RestrictionName,
+			&rooms,
+		)
+		if err != nil {
+			return deleted, err
+		}
+		if rooms != "" {
+			for _, name := range strings.Split(rooms, ", ") {
+				b.Rooms = append(b.Rooms, models.Room{RoomName: name})
+			}
+		}
+		deleted = append(deleted, b)
+	}
+
+	if err = rows.Err(); err != nil {
+		return deleted, err
+	}
+
+	return deleted, nil
+}
+
+// RestoreBlock takes an owner block deleted since a time out of the trash, with
+// the nights it blocked. It returns repository.ErrUnavailable, and leaves it in
+// the trash, if any of the nights have been taken since.
+func (m *postgresDBRepo) RestoreBlock(id int, since time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `update blocks set deleted_at = null, deleted_by = 0, updated_at = $1
+		where id = $2 and deleted_at >= $3`, time.Now(), id, since)
+	if err != nil {
+		return err
+	}
+	restored, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if restored == 0 {
+		return repository.ErrNotInTrash
+	}
+
+	err = restore(ctx, tx, `mine.block_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// purged deletes the rows of a table that went into the trash before a time, and
+// returns their ids
+func purged(ctx context.Context, tx *sql.Tx, table string, before time.Time) ([]int, error) {
+	rows, err := tx.QueryContext(ctx, `delete from `+table+` where deleted_at < $1 returning id`, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// PurgeTrash deletes for good the reservations, blocks and blocked dates that
+// went into the trash before a time. The restrictions, notes, tags and other
+// records belonging to a purged reservation or block go with it.
+func (m *postgresDBRepo) PurgeTrash(before time.Time) (models.PurgedTrash, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var p models.PurgedTrash
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return p, err
+	}
+	defer tx.Rollback()
+
+	p.Reservations, err = purged(ctx, tx, "reservations", before)
+	if err != nil {
+		return p, err
+	}
+	p.Blocks, err = purged(ctx, tx, "blocks", before)
+	if err != nil {
+		return p, err
+	}
+	p.RoomRestrictions, err = purged(ctx, tx, "room_restrictions", before)
+	if err != nil {
+		return p, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return models.PurgedTrash{}, err
+	}
+	return p, nil
+}
+
+const restrictionColumns = `id, restriction_name, code, color, blocks_availability, counts_occupied,
+	created_at, updated_at`
+
+func scanRestriction(row rowScanner) (models.Restriction, error) {
+	var r models.Restriction
+	err := row.Scan(
+		&r.ID,
+		&r.RestrictionName,
+		&r.Code,
+		&r.Color,
+		&r.BlocksAvailability,
+		&r.CountsOccupied,
+		&r.CreatedAt,
+		&r.UpdatedAt,
+	)
+	return r, err
+}
+
+// AllRestrictions returns the restriction types, in the order they were added
+func (m *postgresDBRepo) AllRestrictions() ([]models.Restriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var restrictions []models.Restriction
+
+	rows, err := m.DB.QueryContext(ctx, `select `+restrictionColumns+` from restrictions order by id`)
+	if err != nil {
+		return restrictions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r, err := scanRestriction(rows)
+		if err != nil {
+			return restrictions, err
+		}
+		restrictions = append(restrictions, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return restrictions, err
+	}
+	return restrictions, nil
+}
+
+// GetRestrictionByID returns a restriction type, with ID 0 if there is none
+func (m *postgresDBRepo) GetRestrictionByID(id int) (models.Restriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	r, err := scanRestriction(m.DB.QueryRowContext(ctx, `select `+restrictionColumns+` from restrictions where id = $1`, id))
+	if err == sql.ErrNoRows {
+		return models.Restriction{}, nil
+	}
+	return r, err
+}
+
+// GetRestrictionByCode returns the restriction type with a code, with ID 0 if there is none
+func (m *postgresDBRepo) GetRestrictionByCode(code string) (models.Restriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	r, err := scanRestriction(m.DB.QueryRowContext(ctx, `select `+restrictionColumns+` from restrictions where code = $1`, code))
+	if err == sql.ErrNoRows {
+		return models.Restriction{}, nil
+	}
+	return r, err
+}
+
+// InsertRestriction adds a restriction type and returns its id
+func (m *postgresDBRepo) InsertRestriction(r models.Restriction) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into restrictions (restriction_name, color, blocks_availability, counts_occupied,
+			created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $5) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		r.RestrictionName,
+		r.Color,
+		r.BlocksAvailability,
+		r.CountsOccupied,
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateRestriction updates a restriction type; its code can't be changed
+func (m *postgresDBRepo) UpdateRestriction(r models.Restriction) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		update restrictions set restriction_name = $1, color = $2, blocks_availability = $3,
+		counts_occupied = $4, updated_at = $5
+		where id = $6
+	`
+
+	_, err := m.DB.ExecContext(ctx, query,
+		r.RestrictionName,
+		r.Color,
+		r.BlocksAvailability,
+		r.CountsOccupied,
+		time.Now(),
+		r.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// foreignKeyViolation is the code postgres fails with when a delete would
+// leave rows referring to one that's gone
+const foreignKeyViolation = "23503"
+
+// DeleteRestriction deletes a restriction type the application doesn't rely on.
+// It returns repository.ErrInUse when any nights or blocks are of the type.
+func (m *postgresDBRepo) DeleteRestriction(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from restrictions where id = $1 and code = ''`, id)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation {
+		return repository.ErrInUse
+	}
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CountRoomRestrictionsForRestriction returns how many room restrictions are of a type
+func (m *postgresDBRepo) CountRoomRestrictionsForRestriction(id int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+	err := m.DB.QueryRowContext(ctx, `select count(id) from room_restrictions where restriction_id = $1`, id).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// CountBlocksForRestriction returns how many owner blocks, including those in
+// the trash, are of a type
+func (m *postgresDBRepo) CountBlocksForRestriction(id int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+	err := m.DB.QueryRowContext(ctx, `select count(id) from blocks where restriction_id = $1`, id).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// OccupiedNights returns, by room id, how many nights from start up to end are
+// taken by restrictions of a type that counts as occupied. A night is counted
+// once even when restrictions overlap.
+func (m *postgresDBRepo) OccupiedNights(start, end time.Time) (map[int]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	nights := make(map[int]int)
+
+	query := `
+		select rr.room_id, count(distinct d.night)
+		from room_restrictions rr
+		join restrictions rs on (rr.restriction_id = rs.id)
+		cross join lateral generate_series(greatest(rr.start_date, $1::date),
+			least(rr.end_date, $2::date) - 1, interval '1 day') as d(night)
+		where rs.counts_occupied and rr.deleted_at is null and rr.start_date < $2 and rr.end_date > $1
+		group by rr.room_id
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, start, end)
+	if err != nil {
+		return nights, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var roomID, count int
+		err := rows.Scan(&roomID, &count)
+		if err != nil {
+			return nights, err
+		}
+		nights[roomID] = count
+	}
+
+	if err = rows.Err(); err != nil {
+		return nights, err
+	}
+	return nights, nil
+}
+
+// AllICalFeeds returns all imported calendar feeds
+func (m *postgresDBRepo) AllICalFeeds() ([]models.ICalFeed, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var feeds []models.ICalFeed
+
+	query := `
+		select f.id, f.room_id, f.url, f.last_synced_at, f.last_status, f.last_error,
+		f.conflicts, f.created_at, f.updated_at, rm.id, rm.room_name
+		from room_ical_feeds f
+		left join rooms rm on (f.room_id = rm.id)
+		order by rm.room_name, f.id
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var f models.ICalFeed
+		var lastSynced sql.NullTime
+		err := rows.Scan(
+			&f.ID,
+			&f.RoomID,
+			&f.URL,
+			&lastSynced,
+			&f.LastStatus,
+			&f.LastError,
+			&f.Conflicts,
+			&f.CreatedAt,
+			&f.UpdatedAt,
+			&f.Room.ID,
+			&f.Room.RoomName,
+		)
+		if err != nil {
+			return nil, err
+		}
+		f.LastSyncedAt = lastSynced.Time
+
+		feeds = append(feeds, f)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return feeds, nil
+}
+
+// InsertICalFeed adds a calendar feed to import for a room
+func (m *postgresDBRepo) InsertICalFeed(f models.ICalFeed) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into room_ical_feeds (room_id, url, created_at, updated_at)
+			values ($1, $2, $3, $4) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt, f.RoomID, f.URL, time.Now(), time.Now()).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// DeleteICalFeed deletes a calendar feed along with the restrictions it imported
+func (m *postgresDBRepo) DeleteICalFeed(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from room_ical_feeds where id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateICalFeedStatus records the outcome of the last sync of a calendar feed
+func (m *postgresDBRepo) UpdateICalFeedStatus(f models.ICalFeed) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		update room_ical_feeds set last_synced_at = $1, last_status = $2, last_error = $3,
+		conflicts = $4, updated_at = $5
+		where id = $6
+	`
+
+	_, err := m.DB.ExecContext(ctx, query,
+		f.LastSyncedAt,
+		f.LastStatus,
+		f.LastError,
+		f.Conflicts,
+		time.Now(),
+		f.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetExternalRestrictionsForFeed returns the restrictions imported from a calendar feed
+func (m *postgresDBRepo) GetExternalRestrictionsForFeed(feedID int) ([]models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var restrictions []models.RoomRestriction
+
+	query := `
+		select id, restriction_id, room_id, start_date, end_date, ical_feed_id, external_uid
+		from room_restrictions where ical_feed_id = $1 and deleted_at is null
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, feedID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.RoomRestriction
+		err := rows.Scan(
+			&r.ID,
+			&r.RestrictionID,
+			&r.RoomID,
+			&r.StartDate,
+			&r.EndDate,
+			&r.ICalFeedID,
+			&r.ExternalUID,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		restrictions = append(restrictions, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return restrictions, nil
+}
+
+// UpsertExternalRestriction inserts or updates a restriction imported from a calendar feed,
+// keyed by the feed and the event uid. An event that comes back after being
+// dropped from the feed takes its old row out of the trash. It returns the id
+// of the restriction when it was added or changed, and 0 when it was up to date.
+func (m *postgresDBRepo) UpsertExternalRestriction(r models.RoomRestriction) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `
+		insert into room_restrictions (start_date, end_date, room_id, restriction_id,
+			ical_feed_id, external_uid, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8)
+		on conflict (ical_feed_id, external_uid) do update
+		set start_date = excluded.start_date, end_date = excluded.end_date,
+			room_id = excluded.room_id, updated_at = excluded.updated_at,
+			deleted_at = null, deleted_by = 0
+		where room_restrictions.start_date <> excluded.start_date
+			or room_restrictions.end_date <> excluded.end_date
+			or room_restrictions.room_id <> excluded.room_id
+			or room_restrictions.deleted_at is not null
+		returning id
+	`
+
+	var id int
+	err := m.DB.QueryRowContext(ctx, stmt,
+		r.StartDate,
+		r.EndDate,
+		r.RoomID,
+		r.RestrictionID,
+		r.ICalFeedID,
+		r.ExternalUID,
+		time.Now(),
+		time.Now(),
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		// nothing to change
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// CountConflictsForFeed returns how many current or future restrictions imported from a feed
+// overlap a reservation, block or another feed's restriction for the same room
+func (m *postgresDBRepo) CountConflictsForFeed(feedID int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		select count(distinct e.id)
+		from room_restrictions e
+		join room_restrictions o on (o.room_id = e.room_id and o.id <> e.id
+			and e.start_date < o.end_date and e.end_date > o.start_date
+			and o.ical_feed_id is distinct from e.ical_feed_id and o.deleted_at is null)
+		where e.ical_feed_id = $1 and e.end_date >= current_date
+	`
+
+	var count int
+	err := m.DB.QueryRowContext(ctx, query, feedID).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// AllWebhookEndpoints returns all registered webhook endpoints
+func (m *postgresDBRepo) AllWebhookEndpoints() ([]models.WebhookEndpoint, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		select id, url, secret, events, active, created_at, updated_at
+		from webhook_endpoints order by id
+	`
+
+	return m.queryWebhookEndpoints(ctx, query)
+}
+
+// WebhookEndpointsForEvent returns the active endpoints subscribed to an event type
+func (m *postgresDBRepo) WebhookEndpointsForEvent(event string) ([]models.WebhookEndpoint, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		select id, url, secret, events, active, created_at, updated_at
+		from webhook_endpoints
+		where active = true and (',' || events || ',') like ('%,' || $1 || ',%')
+		order by id
+	`
+
+	return m.queryWebhookEndpoints(ctx, query, event)
+}
+
+func (m *postgresDBRepo) queryWebhookEndpoints(ctx context.Context, query string, args ...interface{}) ([]models.WebhookEndpoint, error) {
+	var endpoints []models.WebhookEndpoint
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.WebhookEndpoint
+		var events string
+		err := rows.Scan(
+			&e.ID,
+			&e.URL,
+			&e.Secret,
+			&events,
+			&e.Active,
+			&e.CreatedAt,
+			&e.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if events != "" {
+			e.Events = strings.Split(events, ",")
+		}
+
+		endpoints = append(endpoints, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return endpoints, nil
+}
+
+// InsertWebhookEndpoint registers a webhook endpoint
+func (m *postgresDBRepo) InsertWebhookEndpoint(e models.WebhookEndpoint) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into webhook_endpoints (url, secret, events, active, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		e.URL,
+		e.Secret,
+		strings.Join(e.Events, ","),
+		e.Active,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// DeleteWebhookEndpoint deletes a webhook endpoint and its delivery log
+func (m *postgresDBRepo) DeleteWebhookEndpoint(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from webhook_endpoints where id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// InsertWebhookDelivery queues an event for delivery to an endpoint
+func (m *postgresDBRepo) InsertWebhookDelivery(d models.WebhookDelivery) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into webhook_deliveries (endpoint_id, event, payload, status, attempts,
+			next_attempt_at, created_at, updated_at)
+			values ($1, $2, $3, $4, 0, $5, $6, $7) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		d.EndpointID,
+		d.Event,
+		d.Payload,
+		d.Status,
+		d.NextAttemptAt,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+const webhookDeliverySelect = `
+		select d.id, d.endpoint_id, d.event, d.payload, d.status, d.attempts, d.next_attempt_at,
+		d.response_code, d.last_error, d.delivered_at, d.created_at, d.updated_at,
+		e.id, e.url, e.secret
+		from webhook_deliveries d
+		left join webhook_endpoints e on (d.endpoint_id = e.id)
+`
+
+// DueWebhookDeliveries returns pending deliveries whose next attempt is due, oldest first
+func (m *postgresDBRepo) DueWebhookDeliveries(limit int) ([]models.WebhookDelivery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := webhookDeliverySelect + `
+		where d.status = 'pending' and d.next_attempt_at <= $1
+		order by d.next_attempt_at, d.id
+		limit $2
+	`
+
+	return m.queryWebhookDeliveries(ctx, query, time.Now(), limit)
+}
+
+// RecentWebhookDeliveries returns the most recent deliveries for the delivery log
+func (m *postgresDBRepo) RecentWebhookDeliveries(limit int) ([]models.WebhookDelivery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := webhookDeliverySelect + `
+		order by d.created_at desc, d.id desc
+		limit $1
+	`
+
+	return m.queryWebhookDeliveries(ctx, query, limit)
+}
+
+func (m *postgresDBRepo) queryWebhookDeliveries(ctx context.Context, query string, args ...interface{}) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d models.WebhookDelivery
+		var deliveredAt sql.NullTime
+		err := rows.Scan(
+			&d.ID,
+			&d.EndpointID,
+			&d.Event,
+			&d.Payload,
+			&d.Status,
+			&d.Attempts,
+			&d.NextAttemptAt,
+			&d.ResponseCode,
+			&d.LastError,
+			&deliveredAt,
+			&d.CreatedAt,
+			&d.UpdatedAt,
+			&d.Endpoint.ID,
+			&d.Endpoint.URL,
+			&d.Endpoint.Secret,
+		)
+		if err != nil {
+			return nil, err
+		}
+		d.DeliveredAt = deliveredAt.Time
+
+		deliveries = append(deliveries, d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// UpdateWebhookDelivery records the outcome of a delivery attempt
+func (m *postgresDBRepo) UpdateWebhookDelivery(d models.WebhookDelivery) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var deliveredAt sql.NullTime
+	if !d.DeliveredAt.IsZero() {
+		deliveredAt = sql.NullTime{Time: d.DeliveredAt, Valid: true}
+	}
+
+	query := `
+		update webhook_deliveries set status = $1, attempts = $2, next_attempt_at = $3,
+		response_code = $4, last_error = $5, delivered_at = $6, updated_at = $7
+		where id = $8
+	`
+
+	_, err := m.DB.ExecContext(ctx, query,
+		d.Status,
+		d.Attempts,
+		d.NextAttemptAt,
+		d.ResponseCode,
+		d.LastError,
+		deliveredAt,
+		time.Now(),
+		d.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RetryWebhookDelivery queues a delivery to be sent again straight away
+func (m *postgresDBRepo) RetryWebhookDelivery(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		update webhook_deliveries set status = 'pending', attempts = 0, next_attempt_at = $1,
+		updated_at = $1
+		where id = $2
+	`
+
+	_, err := m.DB.ExecContext(ctx, query, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// InsertMail adds a message to the mail outbox, due straight away
+func (m *postgresDBRepo) InsertMail(msg models.MailData) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return insertMail(ctx, m.DB, msg)
+}
+
+// rowQuerier is satisfied by both *sql.DB and *sql.Tx
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func insertMail(ctx context.Context, q rowQuerier, msg models.MailData) (int, error) {
+	var newID int
+	attachments, err := json.Marshal(msg.Attachments)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `insert into mail_outbox (to_address, from_address, subject, content, text_content,
+			attachments, status, attempts, next_attempt_at, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, 'pending', 0, $7, $7, $7) returning id`
+
+	err = q.QueryRowContext(ctx, stmt,
+		msg.To,
+		msg.From,
+		msg.Subject,
+		msg.Content,
+		msg.Text,
+		string(attachments),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// mailClaimTimeout is how long a message may stay in sending before it is
+// considered abandoned, e.g. by a crashed worker, and claimed again
+const mailClaimTimeout = 10 * time.Minute
+
+const mailOutboxColumns = `
+		id, to_address, from_address, subject, content, text_content, attachments, status, attempts,
+		next_attempt_at, last_error, sent_at, created_at, updated_at
+`
+
+// ClaimDueMail marks up to limit due messages as sending and returns them. Rows
+// locked by another worker are skipped, so no message is handed out twice.
+func (m *postgresDBRepo) ClaimDueMail(limit int) ([]models.OutboxMail, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	query := `
+		update mail_outbox set status = 'sending', updated_at = $1
+		where id in (
+			select id from mail_outbox
+			where (status = 'pending' and next_attempt_at <= $1)
+			or (status = 'sending' and updated_at <= $2)
+			order by next_attempt_at, id
+			limit $3
+			for update skip locked
+		)
+		returning` + mailOutboxColumns
+
+	return m.queryMail(ctx, query, now, now.Add(-mailClaimTimeout), limit)
+}
+
+// RecentMail returns the most recent messages in the outbox
+func (m *postgresDBRepo) RecentMail(limit int) ([]models.OutboxMail, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select` + mailOutboxColumns + `
+		from mail_outbox
+		order by created_at desc, id desc
+		limit $1
+	`
+
+	return m.queryMail(ctx, query, limit)
+}
+
+func (m *postgresDBRepo) queryMail(ctx context.Context, query string, args ...interface{}) ([]models.OutboxMail, error) {
+	var mail []models.OutboxMail
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var msg models.OutboxMail
+		var sentAt sql.NullTime
+		var attachments string
+		err := rows.Scan(
+			&msg.ID,
+			&msg.Mail.To,
+			&msg.Mail.From,
+			&msg.Mail.Subject,
+			&msg.Mail.Content,
+			&msg.Mail.Text,
+			&attachments,
+			&msg.Status,
+			&msg.Attempts,
+			&msg.NextAttemptAt,
+			&msg.LastError,
+			&sentAt,
+			&msg.CreatedAt,
+			&msg.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		msg.SentAt = sentAt.Time
+		if attachments != "" {
+			err = json.Unmarshal([]byte(attachments), &msg.Mail.Attachments)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		mail = append(mail, msg)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return mail, nil
+}
+
+// UpdateMail records the outcome of a send attempt
+func (m *postgresDBRepo) UpdateMail(msg models.OutboxMail) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var sentAt sql.NullTime
+	if !msg.SentAt.IsZero() {
+		sentAt = sql.NullTime{Time: msg.SentAt, Valid: true}
+	}
+
+	query := `
+		update mail_outbox set status = $1, attempts = $2, next_attempt_at = $3,
+		last_error = $4, sent_at = $5, updated_at = $6
+		where id = $7
+	`
+
+	_, err := m.DB.ExecContext(ctx, query,
+		msg.Status,
+		msg.Attempts,
+		msg.NextAttemptAt,
+		msg.LastError,
+		sentAt,
+		time.Now(),
+		msg.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ResendMail queues a message to be sent again straight away
+func (m *postgresDBRepo) ResendMail(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		update mail_outbox set status = 'pending', attempts = 0, next_attempt_at = $1,
+		updated_at = $1
+		where id = $2
+	`
+
+	_, err := m.DB.ExecContext(ctx, query, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AllEmailTemplates returns every email template override
+func (m *postgresDBRepo) AllEmailTemplates() ([]models.EmailTemplate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var templates []models.EmailTemplate
+
+	query := `select id, name, subject, body, text_content, created_at, updated_at
+		from email_templates order by name`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return templates, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t models.EmailTemplate
+		err := rows.Scan(
+			&t.ID,
+			&t.Name,
+			&t.Subject,
+			&t.Body,
+			&t.Text,
+			&t.CreatedAt,
+			&t.UpdatedAt,
+		)
+		if err != nil {
+			return templates, err
+		}
+		templates = append(templates, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return templates, err
+	}
+	return templates, nil
+}
+
+// GetEmailTemplate returns the override for an email; the ID is 0 when there is none
+func (m *postgresDBRepo) GetEmailTemplate(name string) (models.EmailTemplate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var t models.EmailTemplate
+
+	query := `select id, name, subject, body, text_content, created_at, updated_at
+		from email_templates where name = $1`
+
+	err := m.DB.QueryRowContext(ctx, query, name).Scan(
+		&t.ID,
+		&t.Name,
+		&t.Subject,
+		&t.Body,
+		&t.Text,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return models.EmailTemplate{}, nil
+	}
+	if err != nil {
+		return t, err
+	}
+
+	return t, nil
+}
+
+// SaveEmailTemplate inserts or replaces the override for an email
+func (m *postgresDBRepo) SaveEmailTemplate(t models.EmailTemplate) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `
+		insert into email_templates (name, subject, body, text_content, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $5)
+		on conflict (name) do update set subject = excluded.subject, body = excluded.body,
+		text_content = excluded.text_content, updated_at = excluded.updated_at
+	`
+
+	_, err := m.DB.ExecContext(ctx, stmt, t.Name, t.Subject, t.Body, t.Text, time.Now())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteEmailTemplate removes the override for an email, restoring the default
+func (m *postgresDBRepo) DeleteEmailTemplate(name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from email_templates where name = $1`, name)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AllMessageSchedules returns every guest message schedule
+func (m *postgresDBRepo) AllMessageSchedules() ([]models.MessageSchedule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var schedules []models.MessageSchedule
+
+	query := `select id, message, anchor, days, active, created_at, updated_at
+		from message_schedules order by anchor, days, id`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return schedules, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s models.MessageSchedule
+		err := rows.Scan(
+			&s.ID,
+			&s.Message,
+			&s.Anchor,
+			&s.Days,
+			&s.Active,
+			&s.CreatedAt,
+			&s.UpdatedAt,
+		)
+		if err != nil {
+			return schedules, err
+		}
+		schedules = append(schedules, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return schedules, err
+	}
+	return schedules, nil
+}
+
+// InsertMessageSchedule adds a guest message schedule
+func (m *postgresDBRepo) InsertMessageSchedule(s models.MessageSchedule) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into message_schedules (message, anchor, days, active, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $5) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		s.Message,
+		s.Anchor,
+		s.Days,
+		s.Active,
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// SetMessageScheduleActive pauses or resumes a guest message schedule
+func (m *postgresDBRepo) SetMessageScheduleActive(id int, active bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update message_schedules set active = $1, updated_at = $2 where id = $3`
+
+	_, err := m.DB.ExecContext(ctx, query, active, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteMessageSchedule deletes a guest message schedule and the record of what it sent
+func (m *postgresDBRepo) DeleteMessageSchedule(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from message_schedules where id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DueScheduledMessages returns the messages of active schedules that fell due
+// for a reservation between catchUp days before today and today, and haven't been
+// sent for it. Messages that fell due before the reservation was made are skipped,
+// so a late booking doesn't get a reminder meant for days earlier.
+func (m *postgresDBRepo) DueScheduledMessages(today time.Time, catchUp int) ([]models.ScheduledMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var due []models.ScheduledMessage
+
+	query := `
+		select s.id, s.message, s.anchor, s.days, s.active,
+			r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date,
+			r.room_id, rm.id, rm.room_name
+		from message_schedules s
+		cross join reservations r
+		cross join lateral (
+			select (case when s.anchor = 'departure' then r.end_date else r.start_date end) + s.days as send_on
+		) d
+		left join rooms rm on (r.room_id = rm.id)
+		where s.active = true
+		and r.deleted_at is null
+		and r.email <> ''
+		and d.send_on <= $1 and d.send_on >= $2
+		and d.send_on >= r.created_at::date
+		and not exists (
+			select 1 from scheduled_message_sends ss
+			where ss.schedule_id = s.id and ss.reservation_id = r.id
+		)
+		order by d.send_on, r.id, s.id
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, today, today.AddDate(0, 0, -catchUp))
+	if err != nil {
+		return due, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d models.ScheduledMessage
+		err := rows.Scan(
+			&d.Schedule.ID,
+			&d.Schedule.Message,
+			&d.Schedule.Anchor,
+			&d.Schedule.Days,
+			&d.Schedule.Active,
+			&d.Reservation.ID,
+			&d.Reservation.FirstName,
+			&d.Reservation.LastName,
+			&d.Reservation.Email,
+			&d.Reservation.Phone,
+			&d.Reservation.StartDate,
+			&d.Reservation.EndDate,
+			&d.Reservation.RoomID,
+			&d.Reservation.Room.ID,
+			&d.Reservation.Room.RoomName,
+		)
+		if err != nil {
+			return due, err
+		}
+		due = append(due, d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return due, err
+	}
+	return due, nil
+}
+
+// InsertScheduledMail records that a schedule's message was sent for a reservation
+// and queues it, in one transaction. It returns false, queueing nothing, when the
+// message has already been sent for the reservation.
+func (m *postgresDBRepo) InsertScheduledMail(scheduleID, reservationID int, msg models.MailData) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var sendID int
+	stmt := `insert into scheduled_message_sends (schedule_id, reservation_id, created_at, updated_at)
+			values ($1, $2, $3, $3)
+			on conflict (schedule_id, reservation_id) do nothing
+			returning id`
+
+	err = tx.QueryRowContext(ctx, stmt, scheduleID, reservationID, time.Now()).Scan(&sendID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	mailID, err := insertMail(ctx, tx, msg)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx, `update scheduled_message_sends set mail_id = $1 where id = $2`, mailID, sendID)
+	if err != nil {
+		return false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RecentScheduledSends returns the latest scheduled messages sent, newest first
+func (m *postgresDBRepo) RecentScheduledSends(limit int) ([]models.ScheduledSend, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var sends []models.ScheduledSend
+
+	query := `
+		select ss.id, ss.schedule_id, ss.reservation_id, coalesce(ss.mail_id, 0), ss.created_at,
+			s.id, s.message, s.anchor, s.days, s.active,
+			r.id, r.first_name, r.last_name, r.email, r.start_date, r.end_date
+		from scheduled_message_sends ss
+		join message_schedules s on (s.id = ss.schedule_id)
+		join reservations r on (r.id = ss.reservation_id)
+		order by ss.created_at desc, ss.id desc
+		limit $1
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, limit)
+	if err != nil {
+		return sends, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ss models.ScheduledSend
+		err := rows.Scan(
+			&ss.ID,
+			&ss.ScheduleID,
+			&ss.ReservationID,
+			&ss.MailID,
+			&ss.CreatedAt,
+			&ss.Schedule.ID,
+			&ss.Schedule.Message,
+			&ss.Schedule.Anchor,
+			&ss.Schedule.Days,
+			&ss.Schedule.Active,
+			&ss.Reservation.ID,
+			&ss.Reservation.FirstName,
+			&ss.Reservation.LastName,
+			&ss.Reservation.Email,
+			&ss.Reservation.StartDate,
+			&ss.Reservation.EndDate,
+		)
+		if err != nil {
+			return sends, err
+		}
+		sends = append(sends, ss)
+	}
+
+	if err = rows.Err(); err != nil {
+		return sends, err
+	}
+	return sends, nil
+}
+
+// InsertReview adds a guest's review of their stay. It returns 0, adding
+// nothing, when the reservation has already been reviewed.
+func (m *postgresDBRepo) InsertReview(r models.Review) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into reviews (reservation_id, room_id, guest_name, rating, body, status,
+			created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $7)
+			on conflict (reservation_id) do nothing
+			returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		r.ReservationID,
+		r.RoomID,
+		r.GuestName,
+		r.Rating,
+		r.Body,
+		r.Status,
+		time.Now(),
+	).Scan(&newID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+const reviewColumns = `
+		v.id, v.reservation_id, v.room_id, v.guest_name, v.rating, v.body, v.status, v.reply,
+		v.replied_at, v.created_at, v.updated_at, rm.id, rm.room_name
+`
+
+// GetReviewByReservationID returns the review of a reservation; the ID is 0 when there is none
+func (m *postgresDBRepo) GetReviewByReservationID(id int) (models.Review, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select` + reviewColumns + `
+		from reviews v
+		left join rooms rm on (v.room_id = rm.id)
+		where v.reservation_id = $1`
+
+	reviews, err := m.queryReviews(ctx, query, id)
+	if err != nil || len(reviews) == 0 {
+		return models.Review{}, err
+	}
+	return reviews[0], nil
+}
+
+// AllReviews returns every review, newest first
+func (m *postgresDBRepo) AllReviews() ([]models.Review, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select` + reviewColumns + `
+		from reviews v
+		left join rooms rm on (v.room_id = rm.id)
+		order by v.created_at desc, v.id desc`
+
+	return m.queryReviews(ctx, query)
+}
+
+// ApprovedReviewsForRoom returns the reviews of a room shown to the public, newest first
+func (m *postgresDBRepo) ApprovedReviewsForRoom(roomID int) ([]models.Review, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select` + reviewColumns + `
+		from reviews v
+		left join rooms rm on (v.room_id = rm.id)
+		where v.room_id = $1 and v.status = 'approved'
+		order by v.created_at desc, v.id desc`
+
+	return m.queryReviews(ctx, query, roomID)
+}
+
+func (m *postgresDBRepo) queryReviews(ctx context.Context, query string, args ...interface{}) ([]models.Review, error) {
+	var reviews []models.Review
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return reviews, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var v models.Review
+		var repliedAt sql.NullTime
+		err := rows.Scan(
+			&v.ID,
+			&v.ReservationID,
+			&v.RoomID,
+			&v.GuestName,
+			&v.Rating,
+			&v.Body,
+			&v.Status,
+			&v.Reply,
+			&repliedAt,
+			&v.CreatedAt,
+			&v.UpdatedAt,
+			&v.Room.ID,
+			&v.Room.RoomName,
+		)
+		if err != nil {
+			return reviews, err
+		}
+		v.RepliedAt = repliedAt.Time
+		reviews = append(reviews, v)
+	}
+
+	if err = rows.Err(); err != nil {
+		return reviews, err
+	}
+	return reviews, nil
+}
+
+// UpdateReviewStatus approves, hides or unhides a review
+func (m *postgresDBRepo) UpdateReviewStatus(id int, status string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update reviews set status = $1, updated_at = $2 where id = $3`
+
+	_, err := m.DB.ExecContext(ctx, query, status, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ReplyToReview sets the owner's public reply to a review; an empty reply removes it
+func (m *postgresDBRepo) ReplyToReview(id int, reply string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		update reviews set reply = $1,
+		replied_at = case when $1 = '' then null else $2::timestamp end, updated_at = $2
+		where id = $3
+	`
+
+	_, err := m.DB.ExecContext(ctx, query, reply, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// InsertEnquiry saves a contact form enquiry and returns its id
+func (m *postgresDBRepo) InsertEnquiry(e models.Enquiry) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into enquiries (name, email, phone, message, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $5) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		e.Name,
+		e.Email,
+		e.Phone,
+		e.Message,
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+const enquiryColumns = ` id, name, email, phone, message, read, archived, coalesce(reservation_id, 0),
+		replied_at, created_at, updated_at`
+
+func scanEnquiry(row rowScanner) (models.Enquiry, error) {
+	var e models.Enquiry
+	var repliedAt sql.NullTime
+	err := row.Scan(
+		&e.ID,
+		&e.Name,
+		&e.Email,
+		&e.Phone,
+		&e.Message,
+		&e.Read,
+		&e.Archived,
+		&e.ReservationID,
+		&repliedAt,
+		&e.CreatedAt,
+		&e.UpdatedAt,
+	)
+	e.RepliedAt = repliedAt.Time
+	return e, err
+}
+
+// AllEnquiries returns the enquiries in the inbox, or the archived ones, newest first
+func (m *postgresDBRepo) AllEnquiries(archived bool) ([]models.Enquiry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var enquiries []models.Enquiry
+
+	query := `select` + enquiryColumns + ` from enquiries where archived = $1 order by created_at desc, id desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, archived)
+	if err != nil {
+		return enquiries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanEnquiry(rows)
+		if err != nil {
+			return enquiries, err
+		}
+		enquiries = append(enquiries, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return enquiries, err
+	}
+
+	return enquiries, nil
+}
+
+// GetEnquiryByID returns an enquiry by id, with ID 0 if there is none
+func (m *postgresDBRepo) GetEnquiryByID(id int) (models.Enquiry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select` + enquiryColumns + ` from enquiries where id = $1`
+
+	e, err := scanEnquiry(m.DB.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return models.Enquiry{}, nil
+	}
+	if err != nil {
+		return e, err
+	}
+
+	return e, nil
+}
+
+// MarkEnquiryRead marks an enquiry as read
+func (m *postgresDBRepo) MarkEnquiryRead(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `update enquiries set read = true, updated_at = $1 where id = $2`, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SetEnquiryArchived moves an enquiry out of the inbox, or back into it
+func (m *postgresDBRepo) SetEnquiryArchived(id int, archived bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `update enquiries set archived = $1, updated_at = $2 where id = $3`, archived, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// LinkEnquiryToReservation records the booking an enquiry is about; 0 removes the link
+func (m *postgresDBRepo) LinkEnquiryToReservation(id, reservationID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var link sql.NullInt64
+	if reservationID > 0 {
+		link = sql.NullInt64{Int64: int64(reservationID), Valid: true}
+	}
+
+	_, err := m.DB.ExecContext(ctx, `update enquiries set reservation_id = $1, updated_at = $2 where id = $3`, link, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// InsertEnquiryReply saves a reply to an enquiry and queues msg, the email
+// carrying it, together, so the thread only shows replies that were sent
+func (m *postgresDBRepo) InsertEnquiryReply(reply models.EnquiryReply, msg models.MailData) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+
+	var newID int
+	stmt := `insert into enquiry_replies (enquiry_id, body, created_at, updated_at)
+			values ($1, $2, $3, $3) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt, reply.EnquiryID, reply.Body, now).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `update enquiries set read = true, replied_at = $1, updated_at = $1 where id = $2`, now, reply.EnquiryID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = insertMail(ctx, tx, msg)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// RepliesForEnquiry returns the replies sent to an enquiry, oldest first
+func (m *postgresDBRepo) RepliesForEnquiry(enquiryID int) ([]models.EnquiryReply, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var replies []models.EnquiryReply
+
+	query := `select id, enquiry_id, body, created_at, updated_at
+			from enquiry_replies where enquiry_id = $1 order by created_at, id`
+
+	rows, err := m.DB.QueryContext(ctx, query, enquiryID)
+	if err != nil {
+		return replies, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.EnquiryReply
+		err := rows.Scan(
+			&r.ID,
+			&r.EnquiryID,
+			&r.Body,
+			&r.CreatedAt,
+			&r.UpdatedAt,
+		)
+		if err != nil {
+			return replies, err
+		}
+		replies = append(replies, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return replies, err
+	}
+
+	return replies, nil
+}
+
+// InsertAuditEntry appends an entry to the audit log
+func (m *postgresDBRepo) InsertAuditEntry(e models.AuditEntry) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into audit_log (user_id, actor, action, entity, entity_id, before, after, ip, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		e.UserID,
+		e.Actor,
+		e.Action,
+		e.Entity,
+		e.EntityID,
+		e.Before,
+		e.After,
+		e.IP,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// AuditLog returns the newest entries of the audit log that match a filter
+func (m *postgresDBRepo) AuditLog(f models.AuditFilter, limit int) ([]models.AuditEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var entries []models.AuditEntry
+
+	var where []string
+	var args []interface{}
+	add := func(clause string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(clause, len(args)))
+	}
+	if f.UserID > 0 {
+		add("a.user_id = $%d", f.UserID)
+	}
+	if f.Entity != "" {
+		add("a.entity = $%d", f.Entity)
+	}
+	if f.EntityID > 0 {
+		add("a.entity_id = $%d", f.EntityID)
+	}
+	if !f.From.IsZero() {
+		add("a.created_at >= $%d", f.From)
+	}
+	if !f.To.IsZero() {
+		add("a.created_at < $%d", f.To)
+	}
+
+	query := `select a.id, a.user_id, a.actor, a.action, a.entity, a.entity_id, a.before, a.after, a.ip, a.created_at,
+			coalesce(u.first_name, ''), coalesce(u.last_name, ''), coalesce(u.email, '')
+		from audit_log a
+		left join users u on (u.id = a.user_id)`
+	if len(where) > 0 {
+		query += ` where ` + strings.Join(where, " and ")
+	}
+	args = append(args, limit)
+	query += fmt.Sprintf(` order by a.created_at desc, a.id desc limit $%d`, len(args))
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return entries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.AuditEntry
+		err := rows.Scan(
+			&e.ID,
+			&e.UserID,
+			&e.Actor,
+			&e.Action,
+			&e.Entity,
+			&e.EntityID,
+			&e.Before,
+			&e.After,
+			&e.IP,
+			&e.CreatedAt,
+			&e.User.FirstName,
+			&e.User.LastName,
+			&e.User.Email,
+		)
+		if err != nil {
+			return entries, err
+		}
+		e.User.ID = e.UserID
+		entries = append(entries, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return entries, err
+	}
+
+	return entries, nil
+}
+
+// AuditUsers returns the members of staff who appear in the audit log
+func (m *postgresDBRepo) AuditUsers() ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var users []models.User
+
+	query := `select id, first_name, last_name, email from users
+		where id in (select distinct user_id from audit_log)
+		order by last_name, first_name`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return users, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u models.User
+		err := rows.Scan(&u.ID, &u.FirstName, &u.LastName, &u.Email)
+		if err != nil {
+			return users, err
+		}
+		users = append(users, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return users, err
+	}
+
+	return users, nil
+}
+
+// guestQuery selects guests with their stats, which guestRows reads
+const guestQuery = `
+	select g.id, g.first_name, g.last_name, g.email, g.phone, g.notes, g.created_at, g.updated_at,
+	coalesce(s.stays, 0), coalesce(s.nights, 0), coalesce(s.revenue, 0), s.last_stay
+	from guests g
+	left join (
+		select guest_id, count(*) as stays, sum(end_date - start_date) as nights,
+		sum(price) as revenue, max(start_date) as last_stay
+		from reservations
+		where deleted_at is null and guest_id is not null
+		group by guest_id
+	) s on (s.guest_id = g.id)`
+
+// guestRows reads the guests selected by guestQuery
+func guestRows(rows *sql.Rows) ([]models.Guest, error) {
+	var all []models.Guest
+	for rows.Next() {
+		var g models.Guest
+		var lastStay sql.NullTime
+		err := rows.Scan(
+			&g.ID,
+			&g.FirstName,
+			&g.LastName,
+			&g.Email,
+			&g.Phone,
+			&g.Notes,
+			&g.CreatedAt,
+			&g.UpdatedAt,
+			&g.Stats.Stays,
+			&g.Stats.Nights,
+			&g.Stats.Revenue,
+			&lastStay,
+		)
+		if err != nil {
+			return nil, err
+		}
+		g.Stats.LastStay = lastStay.Time
+
+		all = append(all, g)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+// AllGuests returns every guest with their stats, by name
+func (m *postgresDBRepo) AllGuests() ([]models.Guest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, guestQuery+` order by lower(g.last_name), lower(g.first_name), g.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return guestRows(rows)
+}
+
+// GetGuestByID returns a guest with their stats, with ID 0 if there is none
+func (m *postgresDBRepo) GetGuestByID(id int) (models.Guest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, guestQuery+` where g.id = $1`, id)
+	if err != nil {
+		return models.Guest{}, err
+	}
+	defer rows.Close()
+
+	found, err := guestRows(rows)
+	if err != nil || len(found) == 0 {
+		return models.Guest{}, err
+	}
+	return found[0], nil
+}
+
+// GuestReservations returns a guest's reservations that aren't in the trash,
+// latest first
+func (m *postgresDBRepo) GuestReservations(guestID int) ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var reservations []models.Reservation
+
+	query := `
+		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
+		r.end_date, r.room_id, r.processed, r.price, r.guest_id, rm.id, rm.room_name
+		from reservations r
+		left join rooms rm on (r.room_id = rm.id)
+		where r.guest_id = $1 and r.deleted_at is null
+		order by r.start_date desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, guestID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var i models.Reservation
+		err := rows.Scan(
+			&i.ID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.StartDate,
+			&i.EndDate,
+			&i.RoomID,
+			&i.Processed,
+			&i.Price,
+			&i.GuestID,
+			&i.Room.ID,
+			&i.Room.RoomName,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		reservations = append(reservations, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return reservations, nil
+}
+
+// UpdateGuest saves a guest's details and notes
+func (m *postgresDBRepo) UpdateGuest(g models.Guest) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return updateGuest(ctx, m.DB, g)
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// updateGuest saves a guest's details and notes, with the keys they're matched by
+func updateGuest(ctx context.Context, db execer, g models.Guest) error {
+	_, err := db.ExecContext(ctx, `
+		update guests set first_name = $1, last_name = $2, email = $3, phone = $4,
+		email_key = $5, phone_key = $6, notes = $7, updated_at = $8
+		where id = $9`,
+		g.FirstName,
+		g.LastName,
+		g.Email,
+		g.Phone,
+		guests.EmailKey(g.Email),
+		guests.PhoneKey(g.Phone),
+		g.Notes,
+		time.Now(),
+		g.ID,
+	)
+	return err
+}
+
+// GuestMatches returns the pairs of guests with the same email address or phone
+// number, which are likely to be the same person
+func (m *postgresDBRepo) GuestMatches() ([]models.GuestMatch, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var matches []models.GuestMatch
+
+	query := `
+		select a.id, a.first_name, a.last_name, a.email, a.phone,
+		b.id, b.first_name, b.last_name, b.email, b.phone,
+		case when a.email_key <> '' and a.email_key = b.email_key then 'email' else 'phone' end
+		from guests a
+		join guests b on (a.id < b.id and ((a.email_key <> '' and a.email_key = b.email_key)
+			or (a.phone_key <> '' and a.phone_key = b.phone_key)))
+		order by a.id, b.id`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var match models.GuestMatch
+		err := rows.Scan(
+			&match.Guest.ID,
+			&match.Guest.FirstName,
+			&match.Guest.LastName,
+			&match.Guest.Email,
+			&match.Guest.Phone,
+			&match.Other.ID,
+			&match.Other.FirstName,
+			&match.Other.LastName,
+			&match.Other.Email,
+			&match.Other.Phone,
+			&match.By,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		matches = append(matches, match)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
+
+// MergeGuests moves the reservations of the guest mergeID over to keep, saves
+// keep as given and deletes the merged guest
+func (m *postgresDBRepo) MergeGuests(keep models.Guest, mergeID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `update reservations set guest_id = $1 where guest_id = $2`, keep.ID, mergeID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `update guest_accounts set guest_id = $1 where guest_id = $2`, keep.ID, mergeID)
+	if err != nil {
+		return err
+	}
+
+	err = updateGuest(ctx, tx, keep)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from guests where id = $1`, mergeID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// InsertGuestAccount adds an account with a password for a guest, linking it to
+// the guest with the same email address or adding one from a.Guest. It returns
+// repository.ErrAccountExists when the email address already has an account.
+func (m *postgresDBRepo) InsertGuestAccount(a models.GuestAccount, password string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	emailKey := guests.EmailKey(a.Email)
+	now := time.Now()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, `select exists (select 1 from guest_accounts where email_key = $1)`, emailKey).Scan(&exists)
+	if err != nil {
+		return 0, err
+	}
+	if exists {
+		return 0, repository.ErrAccountExists
+	}
+
+	// only the email address links an account to a guest, so that nobody
+	// sees the bookings of someone who shares their phone number
+	var guestID int
+	err = tx.QueryRowContext(ctx, `select id from guests where email_key = $1 order by id limit 1`, emailKey).Scan(&guestID)
+	if errors.Is(err, sql.ErrNoRows) {
+		g := a.Guest
+		err = tx.QueryRowContext(ctx, `
+			insert into guests (first_name, last_name, email, phone, email_key, phone_key, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`,
+			g.FirstName, g.LastName, a.Email, g.Phone, emailKey, guests.PhoneKey(g.Phone), now, now).Scan(&guestID)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var newID int
+	err = tx.QueryRowContext(ctx, `
+		insert into guest_accounts (guest_id, email, email_key, password, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6) returning id`,
+		guestID, a.Email, emailKey, string(hash), now, now).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, tx.Commit()
+}
+
+// guestAccountQuery selects guest accounts with their guest, which
+// guestAccountRow reads
+const guestAccountQuery = `
+	select a.id, a.guest_id, a.email, a.verified_at, a.created_at, a.updated_at,
+	g.id, g.first_name, g.last_name, g.email, g.phone
+	from guest_accounts a
+	left join guests g on (g.id = a.guest_id)`
+
+// guestAccountRow reads an account selected by guestAccountQuery, with ID 0 if
+// there is none
+func guestAccountRow(row rowScanner) (models.GuestAccount, error) {
+	var a models.GuestAccount
+	var verifiedAt sql.NullTime
+	err := row.Scan(
+		&a.ID,
+		&a.GuestID,
+		&a.Email,
+		&verifiedAt,
+		&a.CreatedAt,
+		&a.UpdatedAt,
+		&a.Guest.ID,
+		&a.Guest.FirstName,
+		&a.Guest.LastName,
+		&a.Guest.Email,
+		&a.Guest.Phone,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.GuestAccount{}, nil
+	}
+	a.VerifiedAt = verifiedAt.Time
+	return a, err
+}
+
+// GetGuestAccountByID returns a guest account, with ID 0 if there is none
+func (m *postgresDBRepo) GetGuestAccountByID(id int) (models.GuestAccount, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return guestAccountRow(m.DB.QueryRowContext(ctx, guestAccountQuery+` where a.id = $1`, id))
+}
+
+// GetGuestAccountByEmail returns the guest account for an email address, with
+// ID 0 if there is none
+func (m *postgresDBRepo) GetGuestAccountByEmail(email string) (models.GuestAccount, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return guestAccountRow(m.DB.QueryRowContext(ctx, guestAccountQuery+` where a.email_key = $1`, guests.EmailKey(email)))
+}
+
+// AuthenticateGuest returns the id of the guest account with an email address
+// and password
+func (m *postgresDBRepo) AuthenticateGuest(email, testPassword string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int
+	var hashedPassword string
+
+	row := m.DB.QueryRowContext(ctx, "select id, password from guest_accounts where email_key = $1", guests.EmailKey(email))
+	err := row.Scan(&id, &hashedPassword)
+	if err != nil {
+		return 0, err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(testPassword))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return 0, errors.New("incorrect password")
+	} else if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// VerifyGuestAccount records that a guest account's email address has been
+// confirmed, the first time it is
+func (m *postgresDBRepo) VerifyGuestAccount(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `update guest_accounts set verified_at = $1, updated_at = $1
+		where id = $2 and verified_at is null`, time.Now(), id)
+	return err
+}
+
+// InsertLoginLink adds a login link and returns its id
+func (m *postgresDBRepo) InsertLoginLink(l models.LoginLink) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into login_links (kind, account_id, browser, expires_at, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt, l.Kind, l.AccountID, l.Browser, l.ExpiresAt, time.Now(), time.Now()).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// GetLoginLink returns a login link, with ID 0 if there is none
+func (m *postgresDBRepo) GetLoginLink(id int) (models.LoginLink, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select id, kind, account_id, browser, expires_at, used_at, created_at, updated_at
+		from login_links where id = $1`
+
+	var l models.LoginLink
+	var usedAt sql.NullTime
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&l.ID,
+		&l.Kind,
+		&l.AccountID,
+		&l.Browser,
+		&l.ExpiresAt,
+		&usedAt,
+		&l.CreatedAt,
+		&l.UpdatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.LoginLink{}, nil
+	}
+	l.UsedAt = usedAt.Time
+	return l, err
+}
+
+// UseLoginLink marks a login link used, so that it can't log anyone in again.
+// It returns repository.ErrLinkUsed when the link has been used already or has
+// expired, including by a request racing this one.
+func (m *postgresDBRepo) UseLoginLink(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	result, err := m.DB.ExecContext(ctx, `update login_links set used_at = $1, updated_at = $1
+		where id = $2 and used_at is null and expires_at > $1`, now, id)
+	if err != nil {
+		return err
+	}
+
+	used, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if used == 0 {
+		return repository.ErrLinkUsed
+	}
+	return nil
+}
+
+// CalendarFeedKeys returns the keys of the calendar feeds that have been given
+// one, by scope
+func (m *postgresDBRepo) CalendarFeedKeys() (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `select scope, key from calendar_feed_keys`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make(map[string]string)
+	for rows.Next() {
+		var scope, key string
+		err := rows.Scan(&scope, &key)
+		if err != nil {
+			return nil, err
+		}
+		keys[scope] = key
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// GetCalendarFeedKey returns the key of a calendar feed, or "" if it was never given one
+func (m *postgresDBRepo) GetCalendarFeedKey(scope string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var key string
+	err := m.DB.QueryRowContext(ctx, `select key from calendar_feed_keys where scope = $1`, scope).Scan(&key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return key, err
+}
+
+// SetCalendarFeedKey gives a calendar feed a new key
+func (m *postgresDBRepo) SetCalendarFeedKey(scope, key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into calendar_feed_keys (scope, key, created_at, updated_at) values ($1, $2, $3, $3)
+		on conflict (scope) do update set key = excluded.key, updated_at = excluded.updated_at`
+
+	_, err := m.DB.ExecContext(ctx, stmt, scope, key, time.Now())
+	return err
 }
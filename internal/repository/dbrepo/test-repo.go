@@ -1,23 +1,31 @@
 package dbrepo
 
 import (
+	"database/sql"
 	"errors"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/DmitryZzz/bookings/internal/models"
+	"github.com/DmitryZzz/bookings/internal/repository"
 )
 
 func (m *testDBRepo) AllUsers() bool {
 	return true
 }
 
-// InsertReservation inserts a reservation into the database
-func (m *testDBRepo) InsertReservation(res models.Reservation) (int, error) {
+// InsertReservation inserts a reservation into the database, with the room
+// restriction holding its nights
+func (m *testDBRepo) InsertReservation(res models.Reservation, restrictionID int) (int, error) {
 	// if the room id is 2, then fail; otherwise, pass
 	if res.RoomID == 2 {
 		return 0, errors.New("some error")
 	}
+	if res.StartDate.Year() == 2051 {
+		// taken after the guest searched
+		return 0, repository.ErrUnavailable
+	}
 	return 1, nil
 }
 
@@ -101,16 +109,154 @@ func (m *testDBRepo) GetRoomByID(id int) (models.Room, error) {
 	if id > 2 {
 		return room, errors.New("some error")
 	}
-	room = models.Room{ID: 1, RoomName: "General`s Quarters"}
+	room = testRooms[0]
 	return room, nil
 }
 
+var testRooms = []models.Room{
+	{
+		ID:           1,
+		RoomName:     "General`s Quarters",
+		Slug:         "generals-quarters",
+		Description:  "Set on majestic waters.",
+		Capacity:     2,
+		NightlyRate:  10000,
+		Amenities:    []string{"Queen bed", "Ocean view"},
+		DisplayOrder: 1,
+		Image:        "/static/images/generals-quarters.png",
+	},
+	{
+		ID:           2,
+		RoomName:     "Major`s Suite",
+		Slug:         "majors-suite",
+		Capacity:     4,
+		NightlyRate:  15000,
+		DisplayOrder: 2,
+	},
+}
+
+// GetRoomBySlug gets a room by the slug in its address; the ID is 0 when there is none
+func (m *testDBRepo) GetRoomBySlug(slug string) (models.Room, error) {
+	if slug == "fail" {
+		return models.Room{}, errors.New("some error")
+	}
+	for _, r := range testRooms {
+		if r.Slug == slug {
+			return r, nil
+		}
+	}
+	return models.Room{}, nil
+}
+
+// InsertRoom adds a room
+func (m *testDBRepo) InsertRoom(r models.Room) (int, error) {
+	if r.RoomName == "fail" {
+		return 0, errors.New("some error")
+	}
+	return 3, nil
+}
+
+// UpdateRoom updates a room
+func (m *testDBRepo) UpdateRoom(r models.Room) error {
+	if r.RoomName == "fail" {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// DeleteRoom deletes a room, with its restrictions
+func (m *testDBRepo) DeleteRoom(id int) error {
+	return nil
+}
+
+// CountReservationsForRoom returns how many reservations a room has, past and future
+func (m *testDBRepo) CountReservationsForRoom(id int) (int, error) {
+	if id == 1 {
+		return 5, nil
+	}
+	return 0, nil
+}
+
+var testRoomPhotos = []models.RoomPhoto{
+	{ID: 1, RoomID: 1, Key: "rooms/1/aaaaaaaaaaaaaaaa", Width: 1600, Height: 1067, Position: 1},
+	{ID: 2, RoomID: 1, Key: "rooms/1/bbbbbbbbbbbbbbbb", Width: 1600, Height: 1067, Position: 2},
+	{ID: 3, RoomID: 2, Key: "rooms/2/cccccccccccccccc", Width: 1067, Height: 1600, Position: 1},
+}
+
+// PhotosForRoom returns the photos of a room, in the order they are shown
+func (m *testDBRepo) PhotosForRoom(roomID int) ([]models.RoomPhoto, error) {
+	var photos []models.RoomPhoto
+	for _, p := range testRoomPhotos {
+		if p.RoomID == roomID {
+			photos = append(photos, p)
+		}
+	}
+	return photos, nil
+}
+
+// CoverPhotos returns the first photo of each room that has photos, by room id
+func (m *testDBRepo) CoverPhotos() (map[int]models.RoomPhoto, error) {
+	covers := make(map[int]models.RoomPhoto)
+	for _, p := range testRoomPhotos {
+		if _, ok := covers[p.RoomID]; !ok {
+			covers[p.RoomID] = p
+		}
+	}
+	return covers, nil
+}
+
+// GetRoomPhoto returns a room photo by id, with ID 0 if there is none
+func (m *testDBRepo) GetRoomPhoto(id int) (models.RoomPhoto, error) {
+	if id > 1000 {
+		return models.RoomPhoto{}, errors.New("some error")
+	}
+	for _, p := range testRoomPhotos {
+		if p.ID == id {
+			return p, nil
+		}
+	}
+	return models.RoomPhoto{}, nil
+}
+
+// InsertRoomPhoto adds a photo after the room's other photos and returns its id
+func (m *testDBRepo) InsertRoomPhoto(p models.RoomPhoto) (int, error) {
+	if p.RoomID == 2 {
+		return 0, errors.New("some error")
+	}
+	return 4, nil
+}
+
+// DeleteRoomPhoto deletes a room photo
+func (m *testDBRepo) DeleteRoomPhoto(id int) error {
+	return nil
+}
+
+// SetRoomPhotoOrder numbers a room's photos in the order of ids
+func (m *testDBRepo) SetRoomPhotoOrder(roomID int, ids []int) error {
+	if roomID == 2 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
 // GetUserByID returns a user by id
 func (m *testDBRepo) GetUserByID(id int) (models.User, error) {
 	var u models.User
 	return u, nil
 }
 
+// GetUserByEmail returns the user with an email address, with ID 0 if there is
+// none; "fail@here.ca" fails
+func (m *testDBRepo) GetUserByEmail(email string) (models.User, error) {
+	switch strings.ToLower(email) {
+	case "me@here.ca":
+		return models.User{ID: 1, FirstName: "Admin", LastName: "User", Email: "me@here.ca"}, nil
+	case "fail@here.ca":
+		return models.User{}, errors.New("some error")
+	}
+	return models.User{}, nil
+}
+
 // UpdateUser updates a user in the database
 func (m *testDBRepo) UpdateUser(u models.User) error {
 	return nil
@@ -126,33 +272,219 @@ func (m *testDBRepo) Authenticate(email, testPassword string) (int, string, erro
 }
 
 // AllReservations returns a slice of all reservations
-func (m *testDBRepo) AllReservations() ([]models.Reservation, error) {
-	var reservations []models.Reservation
-	return reservations, nil
+func (m *testDBRepo) AllReservations(tag string) ([]models.Reservation, error) {
+	return testTagged(tag), nil
 }
 
 // AllNewRweservations returns a slice of new reservations
-func (m *testDBRepo) AllNewReservations() ([]models.Reservation, error) {
+func (m *testDBRepo) AllNewReservations(tag string) ([]models.Reservation, error) {
+	return testTagged(tag), nil
+}
+
+var testReservations = []models.Reservation{
+	{ID: 3, FirstName: "John", LastName: "Future", RoomID: 1, Room: testRooms[0], Tags: []string{"late arrival", "VIP"},
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC)},
+	{ID: 5, FirstName: "Ann", LastName: "Untagged", RoomID: 1, Room: testRooms[0],
+		StartDate: time.Date(2050, 1, 5, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 1, 6, 0, 0, 0, 0, time.UTC)},
+}
+
+// testTagged returns the test reservations tagged tag, or all of them
+func testTagged(tag string) []models.Reservation {
 	var reservations []models.Reservation
-	return reservations, nil
+	for _, r := range testReservations {
+		for _, t := range r.Tags {
+			if strings.EqualFold(t, tag) {
+				reservations = append(reservations, r)
+				break
+			}
+		}
+		if tag == "" {
+			reservations = append(reservations, r)
+		}
+	}
+	return reservations
 }
 
 // GetReservationByID returns a reservation by id
 func (m *testDBRepo) GetReservationByID(id int) (models.Reservation, error) {
 	var res models.Reservation
+	switch {
+	case id == 2 || id == 4:
+		// a past stay, reviewed when id is 4
+		res = models.Reservation{
+			ID:        id,
+			FirstName: "John",
+			LastName:  "Smith",
+			Email:     "john@smith.com",
+			StartDate: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2020, 1, 3, 0, 0, 0, 0, time.UTC),
+			RoomID:    1,
+		}
+	case id == 3:
+		// a stay that hasn't happened yet
+		res = models.Reservation{
+			ID:        id,
+			FirstName: "John",
+			Email:     "jane@example.com",
+			StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+			RoomID:    1,
+			Price:     20000,
+			Version:   2,
+			Tags:      []string{"late arrival", "VIP"},
+			GuestID:   1,
+		}
+	case id == 6:
+		// guest 1's other stay that hasn't happened yet, in a room that's free
+		res = models.Reservation{
+			ID:        id,
+			FirstName: "Jane",
+			LastName:  "Doe",
+			Email:     "jane@example.com",
+			StartDate: time.Date(2050, 3, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2050, 3, 3, 0, 0, 0, 0, time.UTC),
+			RoomID:    2,
+			Price:     20000,
+			Version:   1,
+			GuestID:   1,
+		}
+	case id == 8:
+		// guest 1's past stay
+		res = models.Reservation{
+			ID:        id,
+			FirstName: "Jane",
+			LastName:  "Doe",
+			Email:     "jane@example.com",
+			StartDate: time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2020, 6, 3, 0, 0, 0, 0, time.UTC),
+			RoomID:    1,
+			Price:     20000,
+			GuestID:   1,
+		}
+	case id == 11:
+		// someone else's stay linked to guest 1 by the phone number alone
+		res = models.Reservation{
+			ID:        id,
+			FirstName: "Jim",
+			LastName:  "Doe",
+			Email:     "jim@example.com",
+			StartDate: time.Date(2050, 4, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2050, 4, 3, 0, 0, 0, 0, time.UTC),
+			RoomID:    2,
+			Price:     20000,
+			Version:   1,
+			GuestID:   1,
+		}
+	case id == 9:
+		// cancelled, so it's in the trash
+		return res, sql.ErrNoRows
+	case id > 1000:
+		return res, errors.New("some error")
+	}
 	return res, nil
 }
 
-// UpdateReservation updates a reservation in the database
+// SetReservationTags replaces the tags of a reservation
+func (m *testDBRepo) SetReservationTags(id int, tags []string) error {
+	if id > 1000 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// ReservationTags returns every tag in use on a reservation
+func (m *testDBRepo) ReservationTags() ([]string, error) {
+	return []string{"late arrival", "VIP"}, nil
+}
+
+// ReservationNotes returns the notes on a reservation; 3 has one
+func (m *testDBRepo) ReservationNotes(reservationID int) ([]models.ReservationNote, error) {
+	if reservationID != 3 {
+		return nil, nil
+	}
+	return []models.ReservationNote{
+		{
+			ID: 1, ReservationID: 3, UserID: 1, Body: "Arriving after midnight, leave the key in the box",
+			CreatedAt: time.Date(2049, 12, 20, 9, 30, 0, 0, time.UTC),
+			User:      models.User{ID: 1, FirstName: "Admin", LastName: "User"},
+		},
+	}, nil
+}
+
+// InsertReservationNote adds a note to a reservation; it fails for the body "fail"
+func (m *testDBRepo) InsertReservationNote(n models.ReservationNote) (int, error) {
+	if n.Body == "fail" {
+		return 0, errors.New("some error")
+	}
+	return 2, nil
+}
+
+// DeleteReservationNote deletes a note from a reservation
+func (m *testDBRepo) DeleteReservationNote(id, reservationID int) error {
+	if id > 1000 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// UpdateReservation updates a reservation in the database; it is stale unless
+// it has the version GetReservationByID returns
 func (m *testDBRepo) UpdateReservation(r models.Reservation) error {
+	current, _ := m.GetReservationByID(r.ID)
+	if r.Version != current.Version {
+		return repository.ErrStale
+	}
+	if r.StartDate.Year() == 2051 {
+		// taken after the handler checked the nights
+		return repository.ErrUnavailable
+	}
 	return nil
 }
 
-// DeleteReservation deletes one reservation by id
-func (m *testDBRepo) DeleteReservation(id int) error {
+// DeleteReservation deletes one reservation by id; 9 is in the trash already
+func (m *testDBRepo) DeleteReservation(id, deletedBy int) error {
+	if id == 9 {
+		return repository.ErrNotFound
+	}
 	return nil
 }
 
+// testDeletedBy is the member of staff who deleted everything in the test trash
+var testDeletedBy = models.Deletion{
+	At: time.Date(2050, 1, 2, 10, 0, 0, 0, time.UTC),
+	By: models.User{ID: 1, FirstName: "Admin", LastName: "User"},
+}
+
+// DeletedReservations returns the reservations moved to the trash since a time
+func (m *testDBRepo) DeletedReservations(since time.Time) ([]models.Reservation, error) {
+	return []models.Reservation{
+		{
+			ID: 7, FirstName: "Sam", LastName: "Trash", Email: "sam@trash.com", RoomID: 1, Price: 20000,
+			StartDate: time.Date(2050, 2, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 2, 3, 0, 0, 0, 0, time.UTC),
+			Room: testRooms[0], Deleted: testDeletedBy,
+		},
+	}, nil
+}
+
+// testRestore fails restoring 2, whose nights have been taken, and anything
+// over 1000; only 1 and 7 are in the trash
+func testRestore(id int) error {
+	switch {
+	case id == 1 || id == 7:
+		return nil
+	case id == 2:
+		return repository.ErrUnavailable
+	case id > 1000:
+		return errors.New("some error")
+	}
+	return repository.ErrNotInTrash
+}
+
+// RestoreReservation takes a reservation out of the trash
+func (m *testDBRepo) RestoreReservation(id int, since time.Time) error {
+	return testRestore(id)
+}
+
 // UpdateProcessedForReservation updates processed for a reservation by id
 func (m *testDBRepo) UpdateProcessedForReservation(id, processed int) error {
 	return nil
@@ -161,21 +493,871 @@ func (m *testDBRepo) UpdateProcessedForReservation(id, processed int) error {
 // AllRooms returns all rooms
 func (m *testDBRepo) AllRooms() ([]models.Room, error) {
 	var rooms []models.Room
+	rooms = append(rooms, testRooms[0])
 	return rooms, nil
 }
 
 // GetRestrictionsForRoomByDay returns restrictions for a room by date range
 func (m *testDBRepo) GetRestrictionsForRoomByDate(roomId int, start, end time.Time) ([]models.RoomRestriction, error) {
+	var restrictions []models.RoomRestriction
+	if roomId == 1 {
+		// one reservation, one single night owner block, a block range and a night
+		// of staff use, which doesn't block availability, in the requested range
+		restrictions = append(restrictions,
+			models.RoomRestriction{ID: 1, StartDate: start, EndDate: start.AddDate(0, 0, 2), RoomID: 1, ReservationID: 1, RestrictionID: 1,
+				Reservation: models.Reservation{ID: 1, FirstName: "Jane", LastName: "Doe", Tags: []string{"VIP"}, Version: 4}, Restriction: testRestrictions[0]},
+			models.RoomRestriction{ID: 2, StartDate: start.AddDate(0, 0, 3), EndDate: start.AddDate(0, 0, 4), RoomID: 1, RestrictionID: 2,
+				Restriction: testRestrictions[1]},
+			models.RoomRestriction{ID: 3, StartDate: start.AddDate(0, 0, 5), EndDate: start.AddDate(0, 0, 8), RoomID: 1, RestrictionID: 2,
+				BlockID: 1, Block: models.Block{ID: 1, Reason: "Painting"}, Restriction: testRestrictions[1]},
+			models.RoomRestriction{ID: 4, StartDate: start.AddDate(0, 0, 10), EndDate: start.AddDate(0, 0, 11), RoomID: 1, RestrictionID: 5,
+				Restriction: testRestrictions[4]},
+		)
+	}
+	return restrictions, nil
+}
+
+// GetRestrictionsByDate returns the restrictions of every room in a date range
+func (m *testDBRepo) GetRestrictionsByDate(start, end time.Time) ([]models.RoomRestriction, error) {
+	if start.Year() == 1999 {
+		return nil, errors.New("some error")
+	}
+	return m.GetRestrictionsForRoomByDate(1, start, end)
+}
+
+// InsertBlockForRoom inserts a one night room restriction of a type and returns its id
+func (m *testDBRepo) InsertBlockForRoom(id, restrictionID int, startDate time.Time) (int, error) {
+	if startDate.Day() == 31 {
+		return 0, errors.New("some error")
+	}
+	return 1, nil
+}
+
+// GetRoomRestrictionByID returns a room restriction that isn't in the trash, with
+// ID 0 if there is none
+func (m *testDBRepo) GetRoomRestrictionByID(id int) (models.RoomRestriction, error) {
+	if id > 1000 {
+		return models.RoomRestriction{}, errors.New("some error")
+	}
+	return models.RoomRestriction{
+		ID:            id,
+		RoomID:        1,
+		RestrictionID: 2,
+		StartDate:     time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC),
+		EndDate:       time.Date(2050, 1, 11, 0, 0, 0, 0, time.UTC),
+	}, nil
+}
+
+// DeleteBlockById deletes a room restriction; 9 is in the trash already
+func (m *testDBRepo) DeleteBlockById(id, deletedBy int) error {
+	if id == 9 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+// DeletedRoomRestrictions returns the nights blocked from the calendar that were
+// moved to the trash since a time
+func (m *testDBRepo) DeletedRoomRestrictions(since time.Time) ([]models.RoomRestriction, error) {
+	return []models.RoomRestriction{
+		{
+			ID: 7, RoomID: 1, RestrictionID: 2, Room: testRooms[0], Restriction: testRestrictions[1],
+			StartDate: time.Date(2050, 2, 10, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 2, 11, 0, 0, 0, 0, time.UTC),
+			Deleted: testDeletedBy,
+		},
+	}, nil
+}
+
+// RestoreRoomRestriction takes a night blocked from the calendar out of the trash
+func (m *testDBRepo) RestoreRoomRestriction(id int, since time.Time) error {
+	return testRestore(id)
+}
+
+var testBlocks = []models.Block{
+	{
+		ID:            1,
+		Reason:        "Painting",
+		RestrictionID: 2,
+		Restriction:   models.Restriction{ID: 2, RestrictionName: "Owner Block", Color: "#6c757d"},
+		StartDate:     time.Date(2050, 1, 6, 0, 0, 0, 0, time.UTC),
+		EndDate:       time.Date(2050, 1, 9, 0, 0, 0, 0, time.UTC),
+		RoomIDs:       []int{1},
+		Rooms:         []models.Room{{ID: 1, RoomName: "General`s Quarters"}},
+		Restrictions: []models.RoomRestriction{
+			{ID: 3, RoomID: 1, StartDate: time.Date(2050, 1, 6, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 1, 9, 0, 0, 0, 0, time.UTC), RestrictionID: 2, BlockID: 1},
+		},
+	},
+	{
+		ID:            2,
+		Reason:        "Boiler service",
+		RestrictionID: 4,
+		Restriction:   models.Restriction{ID: 4, RestrictionName: "Maintenance", Color: "#ffc107"},
+		StartDate:     time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:       time.Date(2050, 3, 1, 0, 0, 0, 0, time.UTC),
+		Weekdays:      []time.Weekday{time.Monday},
+		RoomIDs:       []int{1, 2},
+		Rooms:         []models.Room{{ID: 1, RoomName: "General`s Quarters"}, {ID: 2, RoomName: "Major`s Suite"}},
+	},
+}
+
+// AllBlocks returns the owner blocks with their rooms, latest first
+func (m *testDBRepo) AllBlocks() ([]models.Block, error) {
+	return testBlocks, nil
+}
+
+// GetBlockByID returns an owner block, with ID 0 if there is none
+func (m *testDBRepo) GetBlockByID(id int) (models.Block, error) {
+	if id > 1000 {
+		return models.Block{}, errors.New("some error")
+	}
+	for _, b := range testBlocks {
+		if b.ID == id {
+			return b, nil
+		}
+	}
+	return models.Block{}, nil
+}
+
+// SaveBlock adds or replaces an owner block and its room restrictions
+func (m *testDBRepo) SaveBlock(b models.Block, restrictions []models.RoomRestriction) (int, []int, error) {
+	if b.Reason == "fail" {
+		return 0, nil, errors.New("some error")
+	}
+	if b.Reason == "taken" {
+		return 0, nil, repository.ErrUnavailable
+	}
+	id := b.ID
+	if id == 0 {
+		id = 3
+	}
+	var ids []int
+	for i := range restrictions {
+		ids = append(ids, 100+i)
+	}
+	return id, ids, nil
+}
+
+// DeleteBlock deletes an owner block and its room restrictions; 9 is in the
+// trash already
+func (m *testDBRepo) DeleteBlock(id, deletedBy int) error {
+	if id == 9 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+// DeletedBlocks returns the owner blocks moved to the trash since a time
+func (m *testDBRepo) DeletedBlocks(since time.Time) ([]models.Block, error) {
+	b := testBlocks[0]
+	b.ID = 7
+	b.Reason = "Roof repair"
+	b.Deleted = testDeletedBy
+	return []models.Block{b}, nil
+}
+
+// RestoreBlock takes an owner block out of the trash
+func (m *testDBRepo) RestoreBlock(id int, since time.Time) error {
+	return testRestore(id)
+}
+
+func (m *testDBRepo) PurgeTrash(before time.Time) (models.PurgedTrash, error) {
+	return models.PurgedTrash{Reservations: []int{7}, Blocks: []int{3}, RoomRestrictions: []int{12}}, nil
+}
+
+var testRestrictions = []models.Restriction{
+	{ID: 1, RestrictionName: "Reservation", Code: models.ReservationRestriction, Color: "#dc3545", BlocksAvailability: true, CountsOccupied: true},
+	{ID: 2, RestrictionName: "Owner Block", Code: models.OwnerRestriction, Color: "#6c757d", BlocksAvailability: true},
+	{ID: 3, RestrictionName: "External", Code: models.ExternalRestriction, Color: "#17a2b8", BlocksAvailability: true, CountsOccupied: true},
+	{ID: 4, RestrictionName: "Maintenance", Color: "#ffc107", BlocksAvailability: true},
+	{ID: 5, RestrictionName: "Staff use", Color: "#28a745", CountsOccupied: true},
+	{ID: 6, RestrictionName: "Deep clean", Color: "#6f42c1", BlocksAvailability: true},
+	{ID: 7, RestrictionName: "Painting", Color: "#fd7e14", BlocksAvailability: true},
+}
+
+// AllRestrictions returns the restriction types, in the order they were added
+func (m *testDBRepo) AllRestrictions() ([]models.Restriction, error) {
+	return testRestrictions, nil
+}
+
+// GetRestrictionByID returns a restriction type, with ID 0 if there is none
+func (m *testDBRepo) GetRestrictionByID(id int) (models.Restriction, error) {
+	if id > 1000 {
+		return models.Restriction{}, errors.New("some error")
+	}
+	for _, r := range testRestrictions {
+		if r.ID == id {
+			return r, nil
+		}
+	}
+	return models.Restriction{}, nil
+}
+
+// GetRestrictionByCode returns the restriction type with a code, with ID 0 if there is none
+func (m *testDBRepo) GetRestrictionByCode(code string) (models.Restriction, error) {
+	for _, r := range testRestrictions {
+		if r.Code == code {
+			return r, nil
+		}
+	}
+	return models.Restriction{}, nil
+}
+
+// InsertRestriction adds a restriction type and returns its id
+func (m *testDBRepo) InsertRestriction(r models.Restriction) (int, error) {
+	if r.RestrictionName == "fail" {
+		return 0, errors.New("some error")
+	}
+	return 6, nil
+}
+
+// UpdateRestriction updates a restriction type
+func (m *testDBRepo) UpdateRestriction(r models.Restriction) error {
+	if r.RestrictionName == "fail" {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// DeleteRestriction deletes a restriction type the application doesn't rely on
+func (m *testDBRepo) DeleteRestriction(id int) error {
+	if id == 7 {
+		// used since it was counted
+		return repository.ErrInUse
+	}
+	return nil
+}
+
+// CountRoomRestrictionsForRestriction returns how many room restrictions are of a type
+func (m *testDBRepo) CountRoomRestrictionsForRestriction(id int) (int, error) {
+	if id == 5 {
+		return 2, nil
+	}
+	return 0, nil
+}
+
+// CountBlocksForRestriction returns how many owner blocks are of a type
+func (m *testDBRepo) CountBlocksForRestriction(id int) (int, error) {
+	if id == 6 {
+		return 2, nil
+	}
+	return 0, nil
+}
+
+// OccupiedNights returns, by room id, how many nights in a range count as occupied
+func (m *testDBRepo) OccupiedNights(start, end time.Time) (map[int]int, error) {
+	return map[int]int{1: 3}, nil
+}
+
+// AllICalFeeds returns all imported calendar feeds
+func (m *testDBRepo) AllICalFeeds() ([]models.ICalFeed, error) {
+	var feeds []models.ICalFeed
+	feeds = append(feeds, models.ICalFeed{
+		ID:         1,
+		RoomID:     1,
+		URL:        "https://www.airbnb.com/calendar/ical/1.ics",
+		LastStatus: "error",
+		LastError:  "unexpected response 404 Not Found",
+		Conflicts:  1,
+		Room:       models.Room{ID: 1, RoomName: "General`s Quarters"},
+	})
+	return feeds, nil
+}
+
+// InsertICalFeed adds a calendar feed to import for a room
+func (m *testDBRepo) InsertICalFeed(f models.ICalFeed) (int, error) {
+	if f.RoomID == 2 {
+		return 0, errors.New("some error")
+	}
+	return 1, nil
+}
+
+// DeleteICalFeed deletes a calendar feed along with the restrictions it imported
+func (m *testDBRepo) DeleteICalFeed(id int) error {
+	return nil
+}
+
+// UpdateICalFeedStatus records the outcome of the last sync of a calendar feed
+func (m *testDBRepo) UpdateICalFeedStatus(f models.ICalFeed) error {
+	return nil
+}
+
+// GetExternalRestrictionsForFeed returns the restrictions imported from a calendar feed
+func (m *testDBRepo) GetExternalRestrictionsForFeed(feedID int) ([]models.RoomRestriction, error) {
 	var restrictions []models.RoomRestriction
 	return restrictions, nil
 }
 
-// InsertBlockForRoom inserts a room restriction
-func (m *testDBRepo) InsertBlockForRoom(id int, startDate time.Time) error {
+// UpsertExternalRestriction inserts or updates a restriction imported from a calendar feed
+func (m *testDBRepo) UpsertExternalRestriction(r models.RoomRestriction) (int, error) {
+	return 0, nil
+}
+
+// CountConflictsForFeed returns how many restrictions imported from a feed overlap others
+func (m *testDBRepo) CountConflictsForFeed(feedID int) (int, error) {
+	return 0, nil
+}
+
+// AllWebhookEndpoints returns all registered webhook endpoints
+func (m *testDBRepo) AllWebhookEndpoints() ([]models.WebhookEndpoint, error) {
+	var endpoints []models.WebhookEndpoint
+	endpoints = append(endpoints, models.WebhookEndpoint{
+		ID:     1,
+		URL:    "https://example.com/hooks",
+		Secret: "secret",
+		Events: []string{"reservation.created"},
+		Active: true,
+	})
+	return endpoints, nil
+}
+
+// WebhookEndpointsForEvent returns the active endpoints subscribed to an event type
+func (m *testDBRepo) WebhookEndpointsForEvent(event string) ([]models.WebhookEndpoint, error) {
+	var endpoints []models.WebhookEndpoint
+	return endpoints, nil
+}
+
+// InsertWebhookEndpoint registers a webhook endpoint
+func (m *testDBRepo) InsertWebhookEndpoint(e models.WebhookEndpoint) (int, error) {
+	if e.URL == "https://example.com/fail" {
+		return 0, errors.New("some error")
+	}
+	return 1, nil
+}
+
+// DeleteWebhookEndpoint deletes a webhook endpoint and its delivery log
+func (m *testDBRepo) DeleteWebhookEndpoint(id int) error {
 	return nil
 }
 
-// DeleteBlockById deletes a room restriction
-func (m *testDBRepo) DeleteBlockById(id int) error {
+// InsertWebhookDelivery queues an event for delivery to an endpoint
+func (m *testDBRepo) InsertWebhookDelivery(d models.WebhookDelivery) (int, error) {
+	return 1, nil
+}
+
+// DueWebhookDeliveries returns pending deliveries whose next attempt is due
+func (m *testDBRepo) DueWebhookDeliveries(limit int) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	return deliveries, nil
+}
+
+// RecentWebhookDeliveries returns the most recent deliveries for the delivery log
+func (m *testDBRepo) RecentWebhookDeliveries(limit int) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	deliveries = append(deliveries, models.WebhookDelivery{
+		ID:           1,
+		EndpointID:   1,
+		Event:        "reservation.created",
+		Payload:      "{}",
+		Status:       "failed",
+		Attempts:     8,
+		ResponseCode: 500,
+		LastError:    "unexpected response 500 Internal Server Error",
+		Endpoint:     models.WebhookEndpoint{ID: 1, URL: "https://example.com/hooks"},
+	})
+	return deliveries, nil
+}
+
+// UpdateWebhookDelivery records the outcome of a delivery attempt
+func (m *testDBRepo) UpdateWebhookDelivery(d models.WebhookDelivery) error {
+	return nil
+}
+
+// RetryWebhookDelivery queues a delivery to be sent again straight away
+func (m *testDBRepo) RetryWebhookDelivery(id int) error {
+	return nil
+}
+
+// InsertMail adds a message to the mail outbox
+func (m *testDBRepo) InsertMail(msg models.MailData) (int, error) {
+	if msg.To == "fail@here.com" {
+		return 0, errors.New("some error")
+	}
+	return 1, nil
+}
+
+// ClaimDueMail marks due messages as sending and returns them
+func (m *testDBRepo) ClaimDueMail(limit int) ([]models.OutboxMail, error) {
+	var mail []models.OutboxMail
+	return mail, nil
+}
+
+// UpdateMail records the outcome of a send attempt
+func (m *testDBRepo) UpdateMail(msg models.OutboxMail) error {
+	return nil
+}
+
+// RecentMail returns the most recent messages in the outbox
+func (m *testDBRepo) RecentMail(limit int) ([]models.OutboxMail, error) {
+	var mail []models.OutboxMail
+	mail = append(mail, models.OutboxMail{
+		ID: 1,
+		Mail: models.MailData{
+			To:      "john@smith.com",
+			From:    "me@here.com",
+			Subject: "Reservation Confirmation",
+			Content: "<strong>Reservation Confirmation</strong>",
+		},
+		Status:    "failed",
+		Attempts:  6,
+		LastError: "dial tcp 127.0.0.1:1025: connect: connection refused",
+		CreatedAt: time.Now(),
+	})
+	return mail, nil
+}
+
+// ResendMail queues a message to be sent again straight away
+func (m *testDBRepo) ResendMail(id int) error {
+	return nil
+}
+
+// AllEmailTemplates returns every email template override
+func (m *testDBRepo) AllEmailTemplates() ([]models.EmailTemplate, error) {
+	t, _ := m.GetEmailTemplate("reservation-notification")
+	return []models.EmailTemplate{t}, nil
+}
+
+// GetEmailTemplate returns the override for an email; the ID is 0 when there is none
+func (m *testDBRepo) GetEmailTemplate(name string) (models.EmailTemplate, error) {
+	if name != "reservation-notification" {
+		return models.EmailTemplate{}, nil
+	}
+	return models.EmailTemplate{
+		ID:      1,
+		Name:    name,
+		Subject: "Booked: {{.Reservation.Room.RoomName}}",
+		Body:    "<p>{{.Reservation.FirstName}} booked {{.Reservation.Room.RoomName}}.</p>",
+	}, nil
+}
+
+// SaveEmailTemplate inserts or replaces the override for an email
+func (m *testDBRepo) SaveEmailTemplate(t models.EmailTemplate) error {
+	if t.Subject == "fail" {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// DeleteEmailTemplate removes the override for an email, restoring the default
+func (m *testDBRepo) DeleteEmailTemplate(name string) error {
+	return nil
+}
+
+// AllMessageSchedules returns every guest message schedule
+func (m *testDBRepo) AllMessageSchedules() ([]models.MessageSchedule, error) {
+	return []models.MessageSchedule{
+		{ID: 1, Message: "reservation-reminder", Anchor: "arrival", Days: -3, Active: true},
+		{ID: 2, Message: "reservation-thank-you", Anchor: "departure", Days: 1},
+	}, nil
+}
+
+// InsertMessageSchedule adds a guest message schedule
+func (m *testDBRepo) InsertMessageSchedule(s models.MessageSchedule) (int, error) {
+	if s.Days == 99 {
+		return 0, errors.New("some error")
+	}
+	return 1, nil
+}
+
+// SetMessageScheduleActive pauses or resumes a guest message schedule
+func (m *testDBRepo) SetMessageScheduleActive(id int, active bool) error {
+	return nil
+}
+
+// DeleteMessageSchedule deletes a guest message schedule and the record of what it sent
+func (m *testDBRepo) DeleteMessageSchedule(id int) error {
+	return nil
+}
+
+// DueScheduledMessages returns the scheduled messages due to be sent
+func (m *testDBRepo) DueScheduledMessages(today time.Time, catchUp int) ([]models.ScheduledMessage, error) {
+	var due []models.ScheduledMessage
+	return due, nil
+}
+
+// InsertScheduledMail records that a schedule's message was sent for a reservation and queues it
+func (m *testDBRepo) InsertScheduledMail(scheduleID, reservationID int, msg models.MailData) (bool, error) {
+	return true, nil
+}
+
+// RecentScheduledSends returns the latest scheduled messages sent, newest first
+func (m *testDBRepo) RecentScheduledSends(limit int) ([]models.ScheduledSend, error) {
+	return []models.ScheduledSend{
+		{
+			ID:            1,
+			ScheduleID:    1,
+			ReservationID: 1,
+			MailID:        1,
+			Schedule:      models.MessageSchedule{ID: 1, Message: "reservation-reminder", Anchor: "arrival", Days: -3},
+			Reservation:   models.Reservation{ID: 1, FirstName: "John", LastName: "Smith"},
+		},
+	}, nil
+}
+
+// InsertReview adds a guest's review of their stay
+func (m *testDBRepo) InsertReview(r models.Review) (int, error) {
+	if r.Body == "fail" {
+		return 0, errors.New("some error")
+	}
+	return 1, nil
+}
+
+// GetReviewByReservationID returns the review of a reservation; the ID is 0 when there is none
+func (m *testDBRepo) GetReviewByReservationID(id int) (models.Review, error) {
+	if id != 4 {
+		return models.Review{}, nil
+	}
+	return models.Review{ID: 1, ReservationID: id, RoomID: 1, Rating: 5, Status: "pending"}, nil
+}
+
+// AllReviews returns every review, newest first
+func (m *testDBRepo) AllReviews() ([]models.Review, error) {
+	return []models.Review{
+		{ID: 1, ReservationID: 4, RoomID: 1, GuestName: "John S.", Rating: 4, Body: "Lovely view", Status: "pending",
+			Room: models.Room{ID: 1, RoomName: "General's Quarters"}},
+		{ID: 2, ReservationID: 5, RoomID: 1, GuestName: "Jane D.", Rating: 5, Body: "Perfect", Status: "approved",
+			Reply: "Thank you!", Room: models.Room{ID: 1, RoomName: "General's Quarters"}},
+	}, nil
+}
+
+// ApprovedReviewsForRoom returns the reviews of a room shown to the public, newest first
+func (m *testDBRepo) ApprovedReviewsForRoom(roomID int) ([]models.Review, error) {
+	if roomID > 2 {
+		return nil, errors.New("some error")
+	}
+	return []models.Review{
+		{ID: 2, RoomID: roomID, GuestName: "Jane D.", Rating: 5, Body: "Perfect", Status: "approved", Reply: "Thank you!"},
+		{ID: 3, RoomID: roomID, GuestName: "Bob K.", Rating: 4, Body: "Quiet and comfortable", Status: "approved"},
+	}, nil
+}
+
+// UpdateReviewStatus approves, hides or unhides a review
+func (m *testDBRepo) UpdateReviewStatus(id int, status string) error {
+	if id > 1000 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// ReplyToReview sets the owner's public reply to a review
+func (m *testDBRepo) ReplyToReview(id int, reply string) error {
+	if reply == "fail" {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+var testEnquiries = []models.Enquiry{
+	{ID: 1, Name: "John Smith", Email: "john@smith.com", Message: "Do you allow dogs?"},
+	{ID: 2, Name: "Jane Doe", Email: "jane@doe.com", Message: "Can we arrive late?", Read: true, ReservationID: 2},
+	{ID: 3, Name: "Bob King", Email: "bob@king.com", Message: "Thanks!", Read: true, Archived: true},
+}
+
+// InsertEnquiry saves a contact form enquiry and returns its id
+func (m *testDBRepo) InsertEnquiry(e models.Enquiry) (int, error) {
+	if e.Name == "fail" {
+		return 0, errors.New("some error")
+	}
+	return 4, nil
+}
+
+// AllEnquiries returns the enquiries in the inbox, or the archived ones
+func (m *testDBRepo) AllEnquiries(archived bool) ([]models.Enquiry, error) {
+	var enquiries []models.Enquiry
+	for _, e := range testEnquiries {
+		if e.Archived == archived {
+			enquiries = append(enquiries, e)
+		}
+	}
+	return enquiries, nil
+}
+
+// GetEnquiryByID returns an enquiry by id, with ID 0 if there is none
+func (m *testDBRepo) GetEnquiryByID(id int) (models.Enquiry, error) {
+	if id > 1000 {
+		return models.Enquiry{}, errors.New("some error")
+	}
+	for _, e := range testEnquiries {
+		if e.ID == id {
+			return e, nil
+		}
+	}
+	return models.Enquiry{}, nil
+}
+
+// MarkEnquiryRead marks an enquiry as read
+func (m *testDBRepo) MarkEnquiryRead(id int) error {
+	return nil
+}
+
+// SetEnquiryArchived moves an enquiry out of the inbox, or back into it
+func (m *testDBRepo) SetEnquiryArchived(id int, archived bool) error {
+	return nil
+}
+
+// LinkEnquiryToReservation records the booking an enquiry is about
+func (m *testDBRepo) LinkEnquiryToReservation(id, reservationID int) error {
+	return nil
+}
+
+// InsertEnquiryReply saves a reply to an enquiry and queues the email carrying it
+func (m *testDBRepo) InsertEnquiryReply(reply models.EnquiryReply, msg models.MailData) (int, error) {
+	if reply.Body == "fail" {
+		return 0, errors.New("some error")
+	}
+	return 1, nil
+}
+
+// RepliesForEnquiry returns the replies sent to an enquiry, oldest first
+func (m *testDBRepo) RepliesForEnquiry(enquiryID int) ([]models.EnquiryReply, error) {
+	if enquiryID == 2 {
+		return []models.EnquiryReply{
+			{ID: 1, EnquiryID: 2, Body: "Of course, the door code is in your confirmation."},
+		}, nil
+	}
+	return nil, nil
+}
+
+// InsertAuditEntry appends an entry to the audit log
+func (m *testDBRepo) InsertAuditEntry(e models.AuditEntry) (int, error) {
+	return 1, nil
+}
+
+var testAuditLog = []models.AuditEntry{
+	{
+		ID: 2, UserID: 1, Actor: models.ActorStaff, Action: "update", Entity: models.AuditReservation, EntityID: 3,
+		Before: `{"FirstName":"John"}`, After: `{"FirstName":"Johnny"}`, IP: "10.0.0.1",
+		User: models.User{ID: 1, FirstName: "Admin", LastName: "User", Email: "admin@here.com"},
+	},
+	{
+		ID: 1, Actor: models.ActorGuest, Action: "create", Entity: models.AuditReservation, EntityID: 3,
+		After: `{"FirstName":"John"}`, IP: "192.0.2.7",
+	},
+	{
+		ID: 3, UserID: 1, Actor: models.ActorStaff, Action: "delete", Entity: models.AuditRoom, EntityID: 9,
+		Before: `{"RoomName":"Attic"}`, IP: "10.0.0.1",
+		User: models.User{ID: 1, FirstName: "Admin", LastName: "User", Email: "admin@here.com"},
+	},
+}
+
+// AuditLog returns the newest entries of the audit log that match a filter
+func (m *testDBRepo) AuditLog(f models.AuditFilter, limit int) ([]models.AuditEntry, error) {
+	if f.Entity == "fail" {
+		return nil, errors.New("some error")
+	}
+	var entries []models.AuditEntry
+	for _, e := range testAuditLog {
+		if f.UserID > 0 && e.UserID != f.UserID ||
+			f.Entity != "" && e.Entity != f.Entity ||
+			f.EntityID > 0 && e.EntityID != f.EntityID {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// AuditUsers returns the members of staff who appear in the audit log
+func (m *testDBRepo) AuditUsers() ([]models.User, error) {
+	return []models.User{{ID: 1, FirstName: "Admin", LastName: "User", Email: "admin@here.com"}}, nil
+}
+
+// testGuests are a returning guest, a copy of her made with a different case
+// email address and a guest with one stay
+var testGuests = []models.Guest{
+	{
+		ID: 1, FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Phone: "555 010 2030",
+		Notes: "Prefers a ground floor room",
+		Stats: models.GuestStats{Stays: 2, Nights: 5, Revenue: 50000, LastStay: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)},
+	},
+	{
+		ID: 2, FirstName: "J", LastName: "Doe", Email: "JANE@example.com",
+		Stats: models.GuestStats{Stays: 1, Nights: 1, Revenue: 10000, LastStay: time.Date(2049, 6, 1, 0, 0, 0, 0, time.UTC)},
+	},
+	{
+		ID: 3, FirstName: "John", LastName: "Smith", Email: "john@smith.com",
+		Stats: models.GuestStats{Stays: 1, Nights: 2, Revenue: 20000, LastStay: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+	},
+}
+
+// AllGuests returns every guest with their stats
+func (m *testDBRepo) AllGuests() ([]models.Guest, error) {
+	return testGuests, nil
+}
+
+// GetGuestByID returns a guest with their stats, with ID 0 if there is none
+func (m *testDBRepo) GetGuestByID(id int) (models.Guest, error) {
+	if id > 1000 {
+		return models.Guest{}, errors.New("some error")
+	}
+	for _, g := range testGuests {
+		if g.ID == id {
+			return g, nil
+		}
+	}
+	return models.Guest{}, nil
+}
+
+// GuestReservations returns a guest's reservations, latest first; 1 has
+// reservations 11, 6, 3 and 8
+func (m *testDBRepo) GuestReservations(guestID int) ([]models.Reservation, error) {
+	if guestID != 1 {
+		return nil, nil
+	}
+	var reservations []models.Reservation
+	for _, id := range []int{11, 6, 3, 8} {
+		res, _ := m.GetReservationByID(id)
+		res.Room = testRooms[0]
+		reservations = append(reservations, res)
+	}
+	return reservations, nil
+}
+
+// UpdateGuest saves a guest; it fails for the last name "fail"
+func (m *testDBRepo) UpdateGuest(g models.Guest) error {
+	if g.LastName == "fail" {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// GuestMatches returns the pairs of guests that look like the same person
+func (m *testDBRepo) GuestMatches() ([]models.GuestMatch, error) {
+	return []models.GuestMatch{{Guest: testGuests[0], Other: testGuests[1], By: "email"}}, nil
+}
+
+// MergeGuests merges one guest into another; it fails merging guest 3
+func (m *testDBRepo) MergeGuests(keep models.Guest, mergeID int) error {
+	if mergeID == 3 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// testGuestAccounts are Jane Doe's verified account and one nobody has
+// verified yet
+var testGuestAccounts = []models.GuestAccount{
+	{ID: 1, GuestID: 1, Email: "jane@example.com", VerifiedAt: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), Guest: testGuests[0]},
+	{ID: 2, GuestID: 3, Email: "john@smith.com", Guest: testGuests[2]},
+}
+
+// InsertGuestAccount adds a guest account; jane@example.com already has one
+// and registering "fail@here.ca" fails
+func (m *testDBRepo) InsertGuestAccount(a models.GuestAccount, password string) (int, error) {
+	if a.Email == "fail@here.ca" {
+		return 0, errors.New("some error")
+	}
+	if strings.EqualFold(a.Email, "jane@example.com") {
+		return 0, repository.ErrAccountExists
+	}
+	return 3, nil
+}
+
+// GetGuestAccountByID returns a guest account, with ID 0 if there is none
+func (m *testDBRepo) GetGuestAccountByID(id int) (models.GuestAccount, error) {
+	if id > 1000 {
+		return models.GuestAccount{}, errors.New("some error")
+	}
+	for _, a := range testGuestAccounts {
+		if a.ID == id {
+			return a, nil
+		}
+	}
+	return models.GuestAccount{}, nil
+}
+
+// GetGuestAccountByEmail returns the guest account for an email address, with
+// ID 0 if there is none
+func (m *testDBRepo) GetGuestAccountByEmail(email string) (models.GuestAccount, error) {
+	for _, a := range testGuestAccounts {
+		if strings.EqualFold(a.Email, email) {
+			return a, nil
+		}
+	}
+	return models.GuestAccount{}, nil
+}
+
+// AuthenticateGuest accepts any account's email address with the password
+// "secret123"
+func (m *testDBRepo) AuthenticateGuest(email, testPassword string) (int, error) {
+	a, _ := m.GetGuestAccountByEmail(email)
+	if a.ID == 0 || testPassword != "secret123" {
+		return 0, errors.New("incorrect password")
+	}
+	return a.ID, nil
+}
+
+// VerifyGuestAccount confirms an account's email address
+func (m *testDBRepo) VerifyGuestAccount(id int) error {
+	if id > 1000 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// testLinkExpiry is when the test login links that haven't expired expire
+var testLinkExpiry = time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// testLoginLinks are staff login links that work (1), that have expired (3),
+// been used (4), that only work in the browser with the key "test-browser" (5)
+// and that are used by another request at the same time (7), and guest links
+// for a verified (2) and an unverified (6) account
+var testLoginLinks = []models.LoginLink{
+	{ID: 1, Kind: "staff", AccountID: 1, ExpiresAt: testLinkExpiry},
+	{ID: 2, Kind: "guest", AccountID: 1, ExpiresAt: testLinkExpiry},
+	{ID: 3, Kind: "staff", AccountID: 1, ExpiresAt: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+	{ID: 4, Kind: "staff", AccountID: 1, ExpiresAt: testLinkExpiry, UsedAt: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+	{ID: 5, Kind: "staff", AccountID: 1, ExpiresAt: testLinkExpiry,
+		Browser: "84c364d178b952600ee70706735c0c4f18320feac925ecb33e3ffefb84632063"},
+	{ID: 6, Kind: "guest", AccountID: 2, ExpiresAt: testLinkExpiry},
+	{ID: 7, Kind: "staff", AccountID: 1, ExpiresAt: testLinkExpiry},
+}
+
+// InsertLoginLink adds a login link; it fails for account 1001
+func (m *testDBRepo) InsertLoginLink(l models.LoginLink) (int, error) {
+	if l.AccountID > 1000 {
+		return 0, errors.New("some error")
+	}
+	return 8, nil
+}
+
+// GetLoginLink returns a login link, with ID 0 if there is none
+func (m *testDBRepo) GetLoginLink(id int) (models.LoginLink, error) {
+	if id > 1000 {
+		return models.LoginLink{}, errors.New("some error")
+	}
+	for _, l := range testLoginLinks {
+		if l.ID == id {
+			return l, nil
+		}
+	}
+	return models.LoginLink{}, nil
+}
+
+// UseLoginLink marks a login link used; link 7 has just been used by another
+// request
+func (m *testDBRepo) UseLoginLink(id int) error {
+	if id == 7 {
+		return repository.ErrLinkUsed
+	}
+	return nil
+}
+
+// CalendarFeedKeys returns the keys of the calendar feeds that have been given
+// one; room 2's feed has been reset
+func (m *testDBRepo) CalendarFeedKeys() (map[string]string, error) {
+	return map[string]string{"ical:room:2": "reset"}, nil
+}
+
+// GetCalendarFeedKey returns the key of a calendar feed, or "" if it was never given one
+func (m *testDBRepo) GetCalendarFeedKey(scope string) (string, error) {
+	keys, _ := m.CalendarFeedKeys()
+	return keys[scope], nil
+}
+
+// SetCalendarFeedKey gives a calendar feed a new key; it fails for room 1001
+func (m *testDBRepo) SetCalendarFeedKey(scope, key string) error {
+	if scope == "ical:room:1001" {
+		return errors.New("some error")
+	}
 	return nil
 }
@@ -1,32 +1,182 @@
 package repository
 
 import (
+	"errors"
 	"time"
 
 	"github.com/DmitryZzz/bookings/internal/models"
 )
 
+// TrashRetention is how long deleted reservations, blocks and blocked dates stay
+// in the trash, where they can be restored, before they are purged for good
+const TrashRetention = 30 * 24 * time.Hour
+
+// ErrStale is returned when a record has been saved by someone else since the
+// copy being saved was read
+var ErrStale = errors.New("the record was changed by someone else")
+
+// ErrNotFound is returned when deleting a record that doesn't exist or is in
+// the trash already
+var ErrNotFound = errors.New("the record doesn't exist or has been deleted already")
+
+// ErrNotInTrash is returned when restoring a record that isn't in the trash,
+// or was deleted too long ago to be restored
+var ErrNotInTrash = errors.New("the record is not in the trash")
+
+// ErrUnavailable is returned when restoring a record whose nights have been
+// taken since it was deleted, or saving one whose nights were taken since they
+// were checked
+var ErrUnavailable = errors.New("the nights have been taken")
+
+// ErrInUse is returned when deleting a record that others still refer to
+var ErrInUse = errors.New("the record is still in use")
+
+// ErrAccountExists is returned when registering an email address that already
+// has a guest account
+var ErrAccountExists = errors.New("there is already an account for this email address")
+
+// ErrLinkUsed is returned when using a login link that has been used already,
+// or has expired
+var ErrLinkUsed = errors.New("the login link has been used or has expired")
+
 type DatabaseRepo interface {
 	AllUsers() bool
-	InsertReservation(res models.Reservation) (int, error)
+	InsertReservation(res models.Reservation, restrictionID int) (int, error)
 	InsertRoomRestriction(r models.RoomRestriction) error
 	SearchAvailabilityByDatesByRoomID(start, end time.Time, roomId int) (bool, error)
 	SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error)
 	GetRoomByID(id int) (models.Room, error)
+	GetRoomBySlug(slug string) (models.Room, error)
+	InsertRoom(r models.Room) (int, error)
+	UpdateRoom(r models.Room) error
+	DeleteRoom(id int) error
+	CountReservationsForRoom(id int) (int, error)
+	PhotosForRoom(roomID int) ([]models.RoomPhoto, error)
+	CoverPhotos() (map[int]models.RoomPhoto, error)
+	GetRoomPhoto(id int) (models.RoomPhoto, error)
+	InsertRoomPhoto(p models.RoomPhoto) (int, error)
+	DeleteRoomPhoto(id int) error
+	SetRoomPhotoOrder(roomID int, ids []int) error
 
 	GetUserByID(id int) (models.User, error)
+	GetUserByEmail(email string) (models.User, error)
 	UpdateUser(u models.User) error
 	Authenticate(email, testPassword string) (int, string, error)
 
-	AllReservations() ([]models.Reservation, error)
-	AllNewReservations() ([]models.Reservation, error)
+	AllReservations(tag string) ([]models.Reservation, error)
+	AllNewReservations(tag string) ([]models.Reservation, error)
 	GetReservationByID(id int) (models.Reservation, error)
+	SetReservationTags(id int, tags []string) error
+	ReservationTags() ([]string, error)
+	ReservationNotes(reservationID int) ([]models.ReservationNote, error)
+	InsertReservationNote(n models.ReservationNote) (int, error)
+	DeleteReservationNote(id, reservationID int) error
 	UpdateReservation(r models.Reservation) error
-	DeleteReservation(id int) error
+	DeleteReservation(id, deletedBy int) error
+	DeletedReservations(since time.Time) ([]models.Reservation, error)
+	RestoreReservation(id int, since time.Time) error
 	UpdateProcessedForReservation(id, processed int) error
 	AllRooms() ([]models.Room, error)
 	GetRestrictionsForRoomByDate(roomId int, start, end time.Time) ([]models.RoomRestriction, error)
+	GetRestrictionsByDate(start, end time.Time) ([]models.RoomRestriction, error)
+	GetRoomRestrictionByID(id int) (models.RoomRestriction, error)
+
+	InsertBlockForRoom(id, restrictionID int, startDate time.Time) (int, error)
+	DeleteBlockById(id, deletedBy int) error
+	DeletedRoomRestrictions(since time.Time) ([]models.RoomRestriction, error)
+	RestoreRoomRestriction(id int, since time.Time) error
+	AllBlocks() ([]models.Block, error)
+	GetBlockByID(id int) (models.Block, error)
+	SaveBlock(b models.Block, restrictions []models.RoomRestriction) (int, []int, error)
+	DeleteBlock(id, deletedBy int) error
+	DeletedBlocks(since time.Time) ([]models.Block, error)
+	RestoreBlock(id int, since time.Time) error
+	PurgeTrash(before time.Time) (models.PurgedTrash, error)
+
+	AllRestrictions() ([]models.Restriction, error)
+	GetRestrictionByID(id int) (models.Restriction, error)
+	GetRestrictionByCode(code string) (models.Restriction, error)
+	InsertRestriction(r models.Restriction) (int, error)
+	UpdateRestriction(r models.Restriction) error
+	DeleteRestriction(id int) error
+	CountRoomRestrictionsForRestriction(id int) (int, error)
+	CountBlocksForRestriction(id int) (int, error)
+	OccupiedNights(start, end time.Time) (map[int]int, error)
+
+	AllICalFeeds() ([]models.ICalFeed, error)
+	InsertICalFeed(f models.ICalFeed) (int, error)
+	DeleteICalFeed(id int) error
+	UpdateICalFeedStatus(f models.ICalFeed) error
+	CalendarFeedKeys() (map[string]string, error)
+	GetCalendarFeedKey(scope string) (string, error)
+	SetCalendarFeedKey(scope, key string) error
+	GetExternalRestrictionsForFeed(feedID int) ([]models.RoomRestriction, error)
+	UpsertExternalRestriction(r models.RoomRestriction) (int, error)
+	CountConflictsForFeed(feedID int) (int, error)
+
+	AllWebhookEndpoints() ([]models.WebhookEndpoint, error)
+	InsertWebhookEndpoint(e models.WebhookEndpoint) (int, error)
+	DeleteWebhookEndpoint(id int) error
+	WebhookEndpointsForEvent(event string) ([]models.WebhookEndpoint, error)
+	InsertWebhookDelivery(d models.WebhookDelivery) (int, error)
+	DueWebhookDeliveries(limit int) ([]models.WebhookDelivery, error)
+	UpdateWebhookDelivery(d models.WebhookDelivery) error
+	RecentWebhookDeliveries(limit int) ([]models.WebhookDelivery, error)
+	RetryWebhookDelivery(id int) error
+
+	InsertMail(msg models.MailData) (int, error)
+	ClaimDueMail(limit int) ([]models.OutboxMail, error)
+	UpdateMail(msg models.OutboxMail) error
+	RecentMail(limit int) ([]models.OutboxMail, error)
+	ResendMail(id int) error
+
+	AllEmailTemplates() ([]models.EmailTemplate, error)
+	GetEmailTemplate(name string) (models.EmailTemplate, error)
+	SaveEmailTemplate(t models.EmailTemplate) error
+	DeleteEmailTemplate(name string) error
+
+	AllMessageSchedules() ([]models.MessageSchedule, error)
+	InsertMessageSchedule(s models.MessageSchedule) (int, error)
+	SetMessageScheduleActive(id int, active bool) error
+	DeleteMessageSchedule(id int) error
+	DueScheduledMessages(today time.Time, catchUp int) ([]models.ScheduledMessage, error)
+	InsertScheduledMail(scheduleID, reservationID int, msg models.MailData) (bool, error)
+	RecentScheduledSends(limit int) ([]models.ScheduledSend, error)
+
+	InsertReview(r models.Review) (int, error)
+	GetReviewByReservationID(id int) (models.Review, error)
+	AllReviews() ([]models.Review, error)
+	ApprovedReviewsForRoom(roomID int) ([]models.Review, error)
+	UpdateReviewStatus(id int, status string) error
+	ReplyToReview(id int, reply string) error
+
+	InsertEnquiry(e models.Enquiry) (int, error)
+	AllEnquiries(archived bool) ([]models.Enquiry, error)
+	GetEnquiryByID(id int) (models.Enquiry, error)
+	MarkEnquiryRead(id int) error
+	SetEnquiryArchived(id int, archived bool) error
+	LinkEnquiryToReservation(id, reservationID int) error
+	InsertEnquiryReply(reply models.EnquiryReply, msg models.MailData) (int, error)
+	RepliesForEnquiry(enquiryID int) ([]models.EnquiryReply, error)
+
+	AllGuests() ([]models.Guest, error)
+	GetGuestByID(id int) (models.Guest, error)
+	GuestReservations(guestID int) ([]models.Reservation, error)
+	UpdateGuest(g models.Guest) error
+	GuestMatches() ([]models.GuestMatch, error)
+	MergeGuests(keep models.Guest, mergeID int) error
+
+	InsertGuestAccount(a models.GuestAccount, password string) (int, error)
+	GetGuestAccountByID(id int) (models.GuestAccount, error)
+	GetGuestAccountByEmail(email string) (models.GuestAccount, error)
+	AuthenticateGuest(email, testPassword string) (int, error)
+	VerifyGuestAccount(id int) error
+
+	InsertLoginLink(l models.LoginLink) (int, error)
+	GetLoginLink(id int) (models.LoginLink, error)
+	UseLoginLink(id int) error
 
-	InsertBlockForRoom(id int, startDate time.Time) error 
-	DeleteBlockById(id int) error
+	InsertAuditEntry(e models.AuditEntry) (int, error)
+	AuditLog(f models.AuditFilter, limit int) ([]models.AuditEntry, error)
+	AuditUsers() ([]models.User, error)
 }
@@ -0,0 +1,29 @@
+package retry
+
+import "time"
+
+// Policy says how something that failed in the background is tried again:
+// first after Base, doubling each time up to Max, and MaxAttempts times in all
+type Policy struct {
+	MaxAttempts int
+	Base        time.Duration
+	Max         time.Duration
+}
+
+// Backoff returns how long to wait before the next attempt after attempts tries
+func (p Policy) Backoff(attempts int) time.Duration {
+	d := p.Base
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= p.Max {
+			return p.Max
+		}
+	}
+	return d
+}
+
+// Exhausted reports whether attempts tries have used up the policy, so the
+// thing should be marked failed
+func (p Policy) Exhausted(attempts int) bool {
+	return attempts >= p.MaxAttempts
+}
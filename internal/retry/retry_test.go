@@ -0,0 +1,28 @@
+package retry
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPolicy_Backoff(t *testing.T) {
+	p := Policy{MaxAttempts: 5, Base: 30 * time.Second, Max: time.Hour}
+
+	if p.Backoff(1) != 30*time.Second || p.Backoff(2) != time.Minute || p.Backoff(3) != 2*time.Minute {
+		t.Errorf("unexpected backoff %s, %s, %s", p.Backoff(1), p.Backoff(2), p.Backoff(3))
+	}
+	if p.Backoff(100) != time.Hour {
+		t.Errorf("backoff should be capped at %s, got %s", time.Hour, p.Backoff(100))
+	}
+}
+
+func TestPolicy_Exhausted(t *testing.T) {
+	p := Policy{MaxAttempts: 5, Base: time.Minute, Max: time.Hour}
+
+	if p.Exhausted(4) {
+		t.Error("expected a fifth attempt")
+	}
+	if !p.Exhausted(5) {
+		t.Error("expected to give up after five attempts")
+	}
+}
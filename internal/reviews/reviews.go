@@ -0,0 +1,78 @@
+package reviews
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/DmitryZzz/bookings/internal/helpers"
+	"github.com/DmitryZzz/bookings/internal/models"
+)
+
+// Moderation statuses. New reviews wait for the owner; only approved reviews are shown.
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusHidden   = "hidden"
+)
+
+// MaxRating is the best rating a guest can give
+const MaxRating = 5
+
+// LinkDays is how many days after checkout a guest's review link works
+const LinkDays = 60
+
+// Scope is what a reservation's review link is signed for
+func Scope(reservationID int) string {
+	return fmt.Sprintf("review-%d", reservationID)
+}
+
+// Link returns the link a guest follows to review their stay. It works until
+// the review is submitted, or LinkDays after they leave.
+func Link(siteURL string, res models.Reservation) string {
+	expires := res.EndDate.AddDate(0, 0, LinkDays)
+	return fmt.Sprintf("%s/reviews/%d?token=%s", siteURL, res.ID, helpers.ExpiringToken(Scope(res.ID), expires))
+}
+
+// StayOver reports whether a reservation's stay has ended by now, so the guest
+// can review it
+func StayOver(res models.Reservation, now time.Time) bool {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	return !res.EndDate.After(today)
+}
+
+// GuestName is how a guest is shown on a review: their first name and the
+// initial of their last name
+func GuestName(res models.Reservation) string {
+	name := strings.TrimSpace(res.FirstName)
+	if last := []rune(strings.TrimSpace(res.LastName)); len(last) > 0 {
+		name += " " + string(last[0]) + "."
+	}
+	return name
+}
+
+// Summary is the number of reviews and their average rating
+type Summary struct {
+	Count   int     `json:"count"`
+	Average float64 `json:"average"`
+}
+
+// Rounded returns the average rating to the nearest whole star
+func (s Summary) Rounded() int {
+	return int(math.Round(s.Average))
+}
+
+// Summarize returns the summary of reviews, averaged to one decimal place
+func Summarize(reviews []models.Review) Summary {
+	var s Summary
+	total := 0
+	for _, r := range reviews {
+		total += r.Rating
+		s.Count++
+	}
+	if s.Count > 0 {
+		s.Average = math.Round(float64(total)/float64(s.Count)*10) / 10
+	}
+	return s
+}
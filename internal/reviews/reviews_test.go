@@ -0,0 +1,65 @@
+package reviews
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DmitryZzz/bookings/internal/config"
+	"github.com/DmitryZzz/bookings/internal/helpers"
+	"github.com/DmitryZzz/bookings/internal/models"
+)
+
+func TestLink(t *testing.T) {
+	helpers.NewHelpers(&config.AppConfig{Secret: "test-secret"})
+
+	res := models.Reservation{ID: 12, EndDate: time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC)}
+	link := Link("https://example.com", res)
+	token := link[len("https://example.com/reviews/12?token="):]
+	if !helpers.ValidExpiringToken(Scope(12), token, res.EndDate.AddDate(0, 0, LinkDays-1)) {
+		t.Errorf("expected %s to carry a valid token", link)
+	}
+	if helpers.ValidExpiringToken(Scope(13), token, res.EndDate) {
+		t.Error("a review link must not work for another reservation")
+	}
+	if helpers.ValidExpiringToken(Scope(12), token, res.EndDate.AddDate(0, 0, LinkDays)) {
+		t.Errorf("a review link must stop working %d days after checkout", LinkDays)
+	}
+}
+
+func TestGuestName(t *testing.T) {
+	tests := []struct {
+		first, last, expected string
+	}{
+		{"John", "Smith", "John S."},
+		{" Élodie ", "Ünal", "Élodie Ü."},
+		{"Cher", "", "Cher"},
+	}
+	for _, e := range tests {
+		got := GuestName(models.Reservation{FirstName: e.first, LastName: e.last})
+		if got != e.expected {
+			t.Errorf("GuestName(%q, %q) = %q, expected %q", e.first, e.last, got, e.expected)
+		}
+	}
+}
+
+func TestStayOver(t *testing.T) {
+	res := models.Reservation{EndDate: time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC)}
+
+	if StayOver(res, time.Date(2050, 1, 2, 23, 0, 0, 0, time.Local)) {
+		t.Error("the stay is not over the day before departure")
+	}
+	if !StayOver(res, time.Date(2050, 1, 3, 9, 0, 0, 0, time.Local)) {
+		t.Error("the stay is over on the day of departure")
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	s := Summarize([]models.Review{{Rating: 5}, {Rating: 4}, {Rating: 4}})
+	if s.Count != 3 || s.Average != 4.3 || s.Rounded() != 4 {
+		t.Errorf("unexpected summary %+v", s)
+	}
+
+	if s := Summarize(nil); s.Count != 0 || s.Average != 0 {
+		t.Errorf("expected an empty summary, got %+v", s)
+	}
+}
@@ -0,0 +1,149 @@
+package scheduler
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/DmitryZzz/bookings/internal/audit"
+	"github.com/DmitryZzz/bookings/internal/config"
+	"github.com/DmitryZzz/bookings/internal/emails"
+	"github.com/DmitryZzz/bookings/internal/models"
+	"github.com/DmitryZzz/bookings/internal/repository"
+	"github.com/DmitryZzz/bookings/internal/reviews"
+)
+
+// The dates a schedule can be relative to
+const (
+	AnchorArrival   = "arrival"
+	AnchorDeparture = "departure"
+)
+
+// Anchors lists the dates a schedule can be relative to
+var Anchors = []string{AnchorArrival, AnchorDeparture}
+
+// CatchUpDays is how many days late a message may still be sent, e.g. after the
+// site was down on the day it fell due
+const CatchUpDays = 2
+
+// Scheduler queues the messages set up in the guest message schedules, and
+// purges the trash of what was deleted longer ago than it can be restored
+type Scheduler struct {
+	DB       repository.DatabaseRepo
+	From     string
+	SiteURL  string
+	ErrorLog *log.Logger
+}
+
+// New creates a scheduler for the application
+func New(a *config.AppConfig, db repository.DatabaseRepo) *Scheduler {
+	s := &Scheduler{
+		DB:       db,
+		From:     a.MailFrom,
+		SiteURL:  a.SiteURL,
+		ErrorLog: a.ErrorLog,
+	}
+	if s.ErrorLog == nil {
+		s.ErrorLog = log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+	}
+	return s
+}
+
+// Start queues due messages and purges the trash every interval in the
+// background
+func (s *Scheduler) Start(interval time.Duration) {
+	go func() {
+		for {
+			s.SendDue(time.Now())
+			s.PurgeTrash(time.Now())
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// SendDue queues every message that has fallen due by now. Each message is sent
+// at most once per reservation, however often this runs and across restarts, and
+// cancelled reservations, which are deleted, get nothing.
+func (s *Scheduler) SendDue(now time.Time) {
+	due, err := s.DB.DueScheduledMessages(Today(now), CatchUpDays)
+	if err != nil {
+		s.ErrorLog.Println("can't load scheduled messages:", err)
+		return
+	}
+
+	for _, d := range due {
+		err := s.send(d)
+		if err != nil {
+			s.ErrorLog.Printf("scheduled message %s for reservation %d: %s", d.Schedule.Message, d.Reservation.ID, err)
+		}
+	}
+}
+
+// queued is what the audit log keeps of a scheduled message
+type queued struct {
+	ScheduleID int
+	Message    string
+	To         string
+	Subject    string
+}
+
+// send renders a message and queues it, recording that it was sent
+func (s *Scheduler) send(d models.ScheduledMessage) error {
+	email, err := emails.Render(d.Schedule.Message, emails.ReservationData{
+		Reservation: d.Reservation,
+		ReviewURL:   reviews.Link(s.SiteURL, d.Reservation),
+	})
+	if err != nil {
+		return err
+	}
+
+	msg := email.Mail(s.From, d.Reservation.Email)
+	sent, err := s.DB.InsertScheduledMail(d.Schedule.ID, d.Reservation.ID, msg)
+	if err != nil || !sent {
+		return err
+	}
+
+	err = audit.System(s.DB, "send message", models.AuditReservation, d.Reservation.ID, nil, queued{
+		ScheduleID: d.Schedule.ID,
+		Message:    d.Schedule.Message,
+		To:         msg.To,
+		Subject:    msg.Subject,
+	})
+	if err != nil {
+		s.ErrorLog.Println(err)
+	}
+	return nil
+}
+
+// PurgeTrash deletes for good what went into the trash longer than the
+// retention period before now, recording each purged record
+func (s *Scheduler) PurgeTrash(now time.Time) {
+	p, err := s.DB.PurgeTrash(now.Add(-repository.TrashRetention))
+	if err != nil {
+		s.ErrorLog.Println("can't purge the trash:", err)
+		return
+	}
+
+	purged := []struct {
+		entity string
+		ids    []int
+	}{
+		{models.AuditReservation, p.Reservations},
+		{models.AuditBlock, p.Blocks},
+		{models.AuditRoomRestriction, p.RoomRestrictions},
+	}
+	for _, purge := range purged {
+		for _, id := range purge.ids {
+			err := audit.System(s.DB, "purge", purge.entity, id, nil, nil)
+			if err != nil {
+				s.ErrorLog.Println(err)
+			}
+		}
+	}
+}
+
+// Today returns the calendar date of t as a date like the reservation dates, at
+// midnight UTC
+func Today(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
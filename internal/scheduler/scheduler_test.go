@@ -0,0 +1,179 @@
+package scheduler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DmitryZzz/bookings/internal/config"
+	"github.com/DmitryZzz/bookings/internal/emails"
+	"github.com/DmitryZzz/bookings/internal/helpers"
+	"github.com/DmitryZzz/bookings/internal/models"
+	"github.com/DmitryZzz/bookings/internal/repository"
+	"github.com/DmitryZzz/bookings/internal/reviews"
+)
+
+var testApp = config.AppConfig{Secret: "test-secret", SiteURL: "https://fort-smythe.example", MailFrom: "me@here.com"}
+
+func init() {
+	emails.TemplateDir = "./../../email-templates"
+	helpers.NewHelpers(&testApp)
+}
+
+// fakeRepo hands out due messages and records what was queued, refusing a
+// second send of a message for a reservation as the unique index does; methods
+// the package doesn't use are left to the embedded nil interface
+type fakeRepo struct {
+	repository.DatabaseRepo
+	due     []models.ScheduledMessage
+	today   time.Time
+	catchUp int
+	sent    map[string]models.MailData
+	audited []models.AuditEntry
+	purged  models.PurgedTrash
+	before  time.Time
+	fail    bool
+}
+
+func (f *fakeRepo) DueScheduledMessages(today time.Time, catchUp int) ([]models.ScheduledMessage, error) {
+	f.today, f.catchUp = today, catchUp
+	return f.due, nil
+}
+
+func (f *fakeRepo) InsertScheduledMail(scheduleID, reservationID int, msg models.MailData) (bool, error) {
+	key := fmt.Sprintf("%d/%d", scheduleID, reservationID)
+	if _, ok := f.sent[key]; ok {
+		return false, nil
+	}
+	f.sent[key] = msg
+	return true, nil
+}
+
+func (f *fakeRepo) PurgeTrash(before time.Time) (models.PurgedTrash, error) {
+	f.before = before
+	if f.fail {
+		return models.PurgedTrash{}, errors.New("database is down")
+	}
+	return f.purged, nil
+}
+
+func (f *fakeRepo) InsertAuditEntry(e models.AuditEntry) (int, error) {
+	f.audited = append(f.audited, e)
+	return len(f.audited), nil
+}
+
+func newTestScheduler(repo *fakeRepo) (*Scheduler, *bytes.Buffer) {
+	var logs bytes.Buffer
+	s := New(&testApp, repo)
+	s.ErrorLog = log.New(&logs, "", 0)
+	return s, &logs
+}
+
+func TestSendDue(t *testing.T) {
+	res := models.Reservation{
+		ID:        7,
+		FirstName: "John",
+		Email:     "john@smith.com",
+		StartDate: time.Date(2050, 1, 4, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 6, 0, 0, 0, 0, time.UTC),
+		Room:      models.Room{RoomName: "General's Quarters"},
+	}
+	repo := &fakeRepo{
+		due: []models.ScheduledMessage{
+			{Schedule: models.MessageSchedule{ID: 1, Message: "reservation-reminder", Anchor: AnchorArrival, Days: -3}, Reservation: res},
+			{Schedule: models.MessageSchedule{ID: 2, Message: "no-such-message", Anchor: AnchorArrival}, Reservation: res},
+			{Schedule: models.MessageSchedule{ID: 3, Message: "reservation-checkin", Anchor: AnchorArrival}, Reservation: res},
+			{Schedule: models.MessageSchedule{ID: 4, Message: "reservation-thank-you", Anchor: AnchorDeparture, Days: 1}, Reservation: res},
+		},
+		sent: make(map[string]models.MailData),
+	}
+	s, logs := newTestScheduler(repo)
+
+	now := time.Date(2050, 1, 1, 18, 30, 0, 0, time.Local)
+	s.SendDue(now)
+
+	if !repo.today.Equal(time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)) || repo.catchUp != CatchUpDays {
+		t.Errorf("unexpected due query for %s, %d days", repo.today, repo.catchUp)
+	}
+
+	if len(repo.sent) != 3 {
+		t.Fatalf("expected 3 messages queued, got %d", len(repo.sent))
+	}
+
+	reminder := repo.sent["1/7"]
+	if reminder.To != "john@smith.com" || reminder.From != testApp.MailFrom {
+		t.Errorf("unexpected addresses %q, %q", reminder.To, reminder.From)
+	}
+	if reminder.Subject != "See you soon at Fort Smythe" || !strings.Contains(reminder.Text, "Getting here") {
+		t.Errorf("expected the reminder with directions, got %q:\n%s", reminder.Subject, reminder.Text)
+	}
+	if repo.sent["3/7"].Subject != "Check-in instructions for today" {
+		t.Errorf("unexpected check-in subject %q", repo.sent["3/7"].Subject)
+	}
+
+	link := reviews.Link(testApp.SiteURL, res)
+	if !strings.HasPrefix(link, "https://fort-smythe.example/reviews/7?token=") || !strings.Contains(repo.sent["4/7"].Content, link) {
+		t.Errorf("expected the thank-you to link to %s, got:\n%s", link, repo.sent["4/7"].Content)
+	}
+
+	if !strings.Contains(logs.String(), "no-such-message for reservation 7") {
+		t.Errorf("expected the failed message to be logged, got %q", logs.String())
+	}
+
+	// running again, or in a second process, queues nothing new
+	s.SendDue(now)
+	if len(repo.sent) != 3 {
+		t.Errorf("expected no duplicates, got %d messages", len(repo.sent))
+	}
+
+	if len(repo.audited) != 3 {
+		t.Fatalf("expected the 3 messages to be audited once, got %d entries", len(repo.audited))
+	}
+	if e := repo.audited[0]; e.Actor != models.ActorSystem || e.Entity != models.AuditReservation || e.EntityID != 7 ||
+		!strings.Contains(e.After, "reservation-reminder") {
+		t.Errorf("unexpected audit entry %+v", e)
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	repo := &fakeRepo{purged: models.PurgedTrash{Reservations: []int{7, 8}, Blocks: []int{3}, RoomRestrictions: []int{12}}}
+	s, logs := newTestScheduler(repo)
+
+	now := time.Date(2050, 3, 1, 12, 0, 0, 0, time.UTC)
+	s.PurgeTrash(now)
+
+	if !repo.before.Equal(now.Add(-repository.TrashRetention)) {
+		t.Errorf("expected the trash purged before %s, got %s", now.Add(-repository.TrashRetention), repo.before)
+	}
+
+	var got []string
+	for _, e := range repo.audited {
+		if e.Actor != models.ActorSystem || e.Action != "purge" {
+			t.Errorf("unexpected audit entry %+v", e)
+		}
+		got = append(got, fmt.Sprintf("%s %d", e.Entity, e.EntityID))
+	}
+	want := "reservation 7, reservation 8, block 3, room_restriction 12"
+	if strings.Join(got, ", ") != want {
+		t.Errorf("expected %s audited, got %s", want, strings.Join(got, ", "))
+	}
+
+	repo = &fakeRepo{fail: true}
+	s, logs = newTestScheduler(repo)
+	s.PurgeTrash(now)
+	if !strings.Contains(logs.String(), "can't purge the trash: database is down") || len(repo.audited) != 0 {
+		t.Errorf("expected the failure to be logged and nothing audited, got %q", logs.String())
+	}
+}
+
+func TestToday(t *testing.T) {
+	loc := time.FixedZone("UTC-5", -5*60*60)
+	got := Today(time.Date(2050, 3, 9, 23, 15, 0, 0, loc))
+	if !got.Equal(time.Date(2050, 3, 9, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the local calendar date, got %s", got)
+	}
+}
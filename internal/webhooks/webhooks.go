@@ -0,0 +1,304 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/DmitryZzz/bookings/internal/audit"
+	"github.com/DmitryZzz/bookings/internal/config"
+	"github.com/DmitryZzz/bookings/internal/models"
+	"github.com/DmitryZzz/bookings/internal/repository"
+	"github.com/DmitryZzz/bookings/internal/retry"
+)
+
+// Event types sent to webhook endpoints
+const (
+	ReservationCreated   = "reservation.created"
+	ReservationUpdated   = "reservation.updated"
+	ReservationCancelled = "reservation.cancelled"
+	ReservationProcessed = "reservation.processed"
+	ReservationRestored  = "reservation.restored"
+	BlockCreated         = "block.created"
+	BlockDeleted         = "block.deleted"
+	BlockRestored        = "block.restored"
+)
+
+// Events lists every event type an endpoint can subscribe to
+var Events = []string{
+	ReservationCreated,
+	ReservationUpdated,
+	ReservationCancelled,
+	ReservationProcessed,
+	ReservationRestored,
+	BlockCreated,
+	BlockDeleted,
+	BlockRestored,
+}
+
+// Delivery statuses
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+// Retry is how failed deliveries are tried again. Receivers can be down for a
+// while, for a deploy or an outage, so retries go on for over a day.
+var Retry = retry.Policy{MaxAttempts: 8, Base: 30 * time.Second, Max: 6 * time.Hour}
+
+// batchSize is how many due deliveries are loaded at a time
+const batchSize = 50
+
+// Envelope is the JSON body posted to endpoints
+type Envelope struct {
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// Reservation is the payload for reservation events. Only booking fields are sent.
+type Reservation struct {
+	ID        int    `json:"id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Phone     string `json:"phone"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	RoomID    int    `json:"room_id"`
+	RoomName  string `json:"room_name"`
+	Processed int    `json:"processed"`
+}
+
+// Block is the payload for owner block events
+type Block struct {
+	ID        int    `json:"id"`
+	RoomID    int    `json:"room_id"`
+	StartDate string `json:"start_date,omitempty"`
+	EndDate   string `json:"end_date,omitempty"`
+}
+
+// NewReservation builds a reservation payload
+func NewReservation(r models.Reservation) Reservation {
+	return Reservation{
+		ID:        r.ID,
+		FirstName: r.FirstName,
+		LastName:  r.LastName,
+		Email:     r.Email,
+		Phone:     r.Phone,
+		StartDate: r.StartDate.Format("2006-01-02"),
+		EndDate:   r.EndDate.Format("2006-01-02"),
+		RoomID:    r.RoomID,
+		RoomName:  r.Room.RoomName,
+		Processed: r.Processed,
+	}
+}
+
+// NewBlock builds an owner block payload
+func NewBlock(id, roomID int, start, end time.Time) Block {
+	b := Block{ID: id, RoomID: roomID}
+	if !start.IsZero() {
+		b.StartDate = start.Format("2006-01-02")
+		b.EndDate = end.Format("2006-01-02")
+	}
+	return b
+}
+
+// Enqueue records a delivery of event for every endpoint subscribed to it.
+// The dispatcher sends them in the background.
+func Enqueue(db repository.DatabaseRepo, event string, data interface{}) error {
+	endpoints, err := db.WebhookEndpointsForEvent(event)
+	if err != nil {
+		return err
+	}
+	if len(endpoints) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(Envelope{
+		Event:     event,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, e := range endpoints {
+		_, err := db.InsertWebhookDelivery(models.WebhookDelivery{
+			EndpointID:    e.ID,
+			Event:         event,
+			Payload:       string(payload),
+			Status:        StatusPending,
+			NextAttemptAt: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// NewSecret returns a random signing secret for an endpoint
+func NewSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Sign returns the signature of a delivery: the hex HMAC-SHA256 of
+// "<timestamp>.<body>" keyed with the endpoint secret
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher sends queued deliveries to their endpoints
+type Dispatcher struct {
+	DB       repository.DatabaseRepo
+	Client   *http.Client
+	ErrorLog *log.Logger
+}
+
+// New creates a dispatcher for the application
+func New(a *config.AppConfig, db repository.DatabaseRepo) *Dispatcher {
+	d := &Dispatcher{
+		DB:       db,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+		ErrorLog: a.ErrorLog,
+	}
+	if d.ErrorLog == nil {
+		d.ErrorLog = log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+	}
+	return d
+}
+
+// Start sends due deliveries every interval in the background
+func (d *Dispatcher) Start(interval time.Duration) {
+	go func() {
+		for {
+			d.DeliverDue()
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// DeliverDue attempts every pending delivery whose next attempt is due
+func (d *Dispatcher) DeliverDue() {
+	for {
+		deliveries, err := d.DB.DueWebhookDeliveries(batchSize)
+		if err != nil {
+			d.ErrorLog.Println("can't load webhook deliveries:", err)
+			return
+		}
+
+		for _, del := range deliveries {
+			_, err := d.Deliver(del)
+			if err != nil {
+				// the delivery is still due, so the next batch would bring it
+				// straight back; leave it for the next run
+				d.ErrorLog.Println("can't record webhook delivery:", err)
+				return
+			}
+		}
+
+		if len(deliveries) < batchSize {
+			return
+		}
+	}
+}
+
+// Deliver makes one attempt at a delivery and records the outcome, scheduling a
+// retry with backoff or marking it failed once Retry is exhausted. The error is set
+// when the outcome couldn't be recorded.
+func (d *Dispatcher) Deliver(del models.WebhookDelivery) (models.WebhookDelivery, error) {
+	del.Attempts++
+
+	code, err := d.post(del)
+	del.ResponseCode = code
+	if err == nil {
+		del.Status = StatusDelivered
+		del.LastError = ""
+		del.DeliveredAt = time.Now()
+	} else {
+		del.LastError = err.Error()
+		if Retry.Exhausted(del.Attempts) {
+			del.Status = StatusFailed
+		} else {
+			del.Status = StatusPending
+			del.NextAttemptAt = time.Now().Add(Retry.Backoff(del.Attempts))
+		}
+	}
+
+	err = d.DB.UpdateWebhookDelivery(del)
+	if err != nil {
+		return del, err
+	}
+
+	aerr := audit.System(d.DB, "deliver", models.AuditWebhookDelivery, del.ID, nil, attempt{
+		Event:        del.Event,
+		Status:       del.Status,
+		Attempts:     del.Attempts,
+		ResponseCode: del.ResponseCode,
+		LastError:    del.LastError,
+	})
+	if aerr != nil {
+		d.ErrorLog.Println(aerr)
+	}
+
+	return del, nil
+}
+
+// attempt is what the audit log keeps of an attempt at a delivery
+type attempt struct {
+	Event        string
+	Status       string
+	Attempts     int
+	ResponseCode int
+	LastError    string
+}
+
+func (d *Dispatcher) post(del models.WebhookDelivery) (int, error) {
+	body := []byte(del.Payload)
+	timestamp := time.Now().Unix()
+
+	req, err := http.NewRequest("POST", del.Endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Bookings-Webhooks/1.0")
+	req.Header.Set("X-Webhook-Event", del.Event)
+	req.Header.Set("X-Webhook-Delivery", strconv.Itoa(del.ID))
+	req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Webhook-Signature", "sha256="+Sign(del.Endpoint.Secret, timestamp, body))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response %s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,192 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DmitryZzz/bookings/internal/config"
+	"github.com/DmitryZzz/bookings/internal/models"
+	"github.com/DmitryZzz/bookings/internal/repository"
+)
+
+// fakeRepo records deliveries in memory; methods the package doesn't use are left
+// to the embedded nil interface
+type fakeRepo struct {
+	repository.DatabaseRepo
+	endpoints  []models.WebhookEndpoint
+	deliveries []models.WebhookDelivery
+	updated    []models.WebhookDelivery
+	due        []models.WebhookDelivery
+	updateErr  error
+	audited    []models.AuditEntry
+}
+
+func (f *fakeRepo) WebhookEndpointsForEvent(event string) ([]models.WebhookEndpoint, error) {
+	var out []models.WebhookEndpoint
+	for _, e := range f.endpoints {
+		for _, x := range e.Events {
+			if x == event {
+				out = append(out, e)
+			}
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeRepo) InsertWebhookDelivery(d models.WebhookDelivery) (int, error) {
+	d.ID = len(f.deliveries) + 1
+	f.deliveries = append(f.deliveries, d)
+	return d.ID, nil
+}
+
+func (f *fakeRepo) UpdateWebhookDelivery(d models.WebhookDelivery) error {
+	f.updated = append(f.updated, d)
+	return f.updateErr
+}
+
+func (f *fakeRepo) InsertAuditEntry(e models.AuditEntry) (int, error) {
+	f.audited = append(f.audited, e)
+	return len(f.audited), nil
+}
+
+func (f *fakeRepo) DueWebhookDeliveries(limit int) ([]models.WebhookDelivery, error) {
+	return f.due, nil
+}
+
+func TestEnqueue(t *testing.T) {
+	repo := &fakeRepo{
+		endpoints: []models.WebhookEndpoint{
+			{ID: 1, Events: []string{ReservationCreated, ReservationCancelled}},
+			{ID: 2, Events: []string{BlockCreated}},
+		},
+	}
+
+	res := models.Reservation{
+		ID:        5,
+		FirstName: "John",
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+	}
+
+	err := Enqueue(repo, ReservationCreated, NewReservation(res))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(repo.deliveries) != 1 || repo.deliveries[0].EndpointID != 1 {
+		t.Fatalf("expected one delivery for endpoint 1, got %+v", repo.deliveries)
+	}
+
+	var env struct {
+		Event string      `json:"event"`
+		Data  Reservation `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(repo.deliveries[0].Payload), &env); err != nil {
+		t.Fatal(err)
+	}
+	if env.Event != ReservationCreated || env.Data.ID != 5 || env.Data.StartDate != "2050-01-01" {
+		t.Errorf("unexpected payload %s", repo.deliveries[0].Payload)
+	}
+}
+
+func TestDispatcher_Deliver(t *testing.T) {
+	fail := false
+	var gotSignature, gotTimestamp, gotBody string
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		gotBody = string(b)
+		gotSignature = r.Header.Get("X-Webhook-Signature")
+		gotTimestamp = r.Header.Get("X-Webhook-Timestamp")
+		if fail {
+			http.Error(w, "boom", http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	repo := &fakeRepo{}
+	d := New(&config.AppConfig{}, repo)
+
+	del := models.WebhookDelivery{
+		ID:       1,
+		Event:    ReservationCreated,
+		Payload:  `{"event":"reservation.created"}`,
+		Status:   StatusPending,
+		Endpoint: models.WebhookEndpoint{URL: ts.URL, Secret: "s3cret"},
+	}
+
+	out, _ := d.Deliver(del)
+	if out.Status != StatusDelivered || out.Attempts != 1 || out.ResponseCode != 200 {
+		t.Errorf("expected delivered, got %+v", out)
+	}
+
+	timestamp, _ := strconv.ParseInt(gotTimestamp, 10, 64)
+	if gotSignature != "sha256="+Sign("s3cret", timestamp, []byte(gotBody)) {
+		t.Error("signature header does not match the body")
+	}
+
+	fail = true
+	out, _ = d.Deliver(del)
+	if out.Status != StatusPending || out.ResponseCode != 500 || !out.NextAttemptAt.After(time.Now()) {
+		t.Errorf("expected retry to be scheduled, got %+v", out)
+	}
+
+	del.Attempts = Retry.MaxAttempts - 1
+	out, _ = d.Deliver(del)
+	if out.Status != StatusFailed {
+		t.Errorf("expected failed after %d attempts, got %s", Retry.MaxAttempts, out.Status)
+	}
+
+	if len(repo.updated) != 3 {
+		t.Errorf("expected every attempt to be recorded, got %d", len(repo.updated))
+	}
+	if len(repo.audited) != 3 || repo.audited[2].Actor != models.ActorSystem || repo.audited[2].EntityID != 1 ||
+		!strings.Contains(repo.audited[2].After, StatusFailed) {
+		t.Errorf("expected every attempt to be audited, got %+v", repo.audited)
+	}
+	for _, e := range repo.audited {
+		if strings.Contains(e.After, "s3cret") {
+			t.Error("the endpoint secret must not be audited")
+		}
+	}
+}
+
+func TestDeliverDue_UpdateFails(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	// a full batch that stays due because its outcome can't be recorded
+	repo := &fakeRepo{updateErr: errors.New("database is down")}
+	for i := 0; i < batchSize; i++ {
+		repo.due = append(repo.due, models.WebhookDelivery{ID: i + 1, Endpoint: models.WebhookEndpoint{URL: ts.URL}})
+	}
+	d := New(&config.AppConfig{}, repo)
+	d.ErrorLog = log.New(io.Discard, "", 0)
+
+	done := make(chan bool)
+	go func() {
+		d.DeliverDue()
+		done <- true
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected DeliverDue to give up when deliveries can't be recorded")
+	}
+
+	if len(repo.updated) != 1 {
+		t.Errorf("expected to stop at the first delivery that couldn't be recorded, got %d attempts", len(repo.updated))
+	}
+	if len(repo.audited) != 0 {
+		t.Errorf("expected nothing audited when the outcome wasn't recorded, got %+v", repo.audited)
+	}
+}
@@ -0,0 +1 @@
+delete from message_schedules;
\ No newline at end of file
@@ -0,0 +1,4 @@
+delete from message_schedules;INSERT INTO public.message_schedules (message,anchor,days,active,created_at,updated_at) VALUES
+	 ('reservation-reminder','arrival',-3,true,'2026-10-18 00:00:00.000','2026-10-18 00:00:00.000'),
+	 ('reservation-checkin','arrival',0,true,'2026-10-18 00:00:00.000','2026-10-18 00:00:00.000'),
+	 ('reservation-thank-you','departure',1,true,'2026-10-18 00:00:00.000','2026-10-18 00:00:00.000');
\ No newline at end of file
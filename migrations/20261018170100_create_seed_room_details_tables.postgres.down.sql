@@ -0,0 +1 @@
+update rooms set slug = '', description = '', amenities = '', display_order = 0, image = '';
\ No newline at end of file
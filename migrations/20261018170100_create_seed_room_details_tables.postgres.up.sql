@@ -0,0 +1,12 @@
+update rooms set slug = 'generals-quarters', capacity = 2, display_order = 1, image = '/static/images/generals-quarters.png',
+	amenities = 'Queen bed
+Private bathroom
+Ocean view
+Free wifi',
+	description = 'Your home away from home, set on majestic waters of the Atlantic Ocean, this will be a vacation to remember.' where room_name = 'General`s Quarters';update rooms set slug = 'majors-suite', capacity = 4, display_order = 2, image = '/static/images/marjors-suite.png',
+	amenities = 'King bed
+Sofa bed
+Private bathroom
+Sitting room
+Free wifi',
+	description = 'Your home away from home, set on majestic waters of the Atlantic Ocean, this will be a vacation to remember.' where room_name = 'Major`s Suite';
\ No newline at end of file
@@ -0,0 +1,3 @@
+drop index if exists restrictions_code_idx;
+update blocks set restriction_id = null;
+update restrictions set code = '', color = '#6c757d', blocks_availability = true, counts_occupied = false;
\ No newline at end of file
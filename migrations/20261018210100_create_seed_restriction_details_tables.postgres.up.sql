@@ -0,0 +1,5 @@
+update restrictions set code = 'reservation', color = '#dc3545', counts_occupied = true where restriction_name = 'Reservation';
+update restrictions set code = 'owner', color = '#6c757d' where restriction_name = 'Owner Block';
+update restrictions set code = 'external', color = '#17a2b8', counts_occupied = true where restriction_name = 'External';
+update blocks set restriction_id = (select id from restrictions where code = 'owner');
+create unique index restrictions_code_idx on restrictions (code) where code <> '';
\ No newline at end of file
@@ -0,0 +1,39 @@
+-- each reservation is linked to a guest the way one booked today would be, in
+-- the order they were made: by email address, then by phone number
+do $$
+declare
+    r record;
+    ek text;
+    pk text;
+    gid integer;
+begin
+    for r in select id, first_name, last_name, email, phone from reservations order by created_at, id loop
+        ek := lower(trim(r.email));
+        pk := regexp_replace(r.phone, '[^0-9]', '', 'g');
+        if length(pk) < 7 then
+            pk := '';
+        end if;
+
+        select id into gid from guests
+        where (ek <> '' and email_key = ek) or (pk <> '' and phone_key = pk)
+        order by email_key = ek desc, id
+        limit 1;
+
+        if not found then
+            insert into guests (first_name, last_name, email, phone, email_key, phone_key, created_at, updated_at)
+            values (r.first_name, r.last_name, r.email, r.phone, ek, pk, now(), now())
+            returning id into gid;
+        else
+            update guests set
+                email = case when email_key = '' then r.email else email end,
+                email_key = case when email_key = '' then ek else email_key end,
+                phone = case when phone_key = '' then r.phone else phone end,
+                phone_key = case when phone_key = '' then pk else phone_key end,
+                updated_at = now()
+            where id = gid and ((email_key = '' and ek <> '') or (phone_key = '' and pk <> ''));
+        end if;
+
+        update reservations set guest_id = gid where id = r.id;
+    end loop;
+end
+$$;
\ No newline at end of file